@@ -2,10 +2,10 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
-	"encoding/gob"
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -16,23 +16,33 @@ type Block struct {
 	PrevBlockHash []byte         // Hash of the previous block (creates the chain link)
 	Hash          []byte         // Hash of the current block (the block's fingerprint)
 	Nonce         int            // Number used in Proof of Work mining
+	HashAlgo      HashAlgo       // Algorithm the block is hashed with
+	Bits          int            // Target bits of the proof of work; 0 on blocks from before blocks recorded them
+
+	prunedRoot []byte // Merkle root of the discarded transactions; set only on pruned blocks
 }
 
-// NewBlock creates and returns a new Block
+// NewBlock creates a new Block at the current targetBits, hashed with the algorithm of defaultHasher, and seals it with sealer
 // Similar to Geth's miner.worker.commitNewWork() + Seal()
-func NewBlock(transactions []*Transaction, prevBlockHash []byte) *Block {
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, sealer Sealer) *Block {
+	return newBlockAt(transactions, prevBlockHash, time.Now().Unix(), defaultHasher.Algo(), targetBits, sealer)
+}
+
+// newBlockAt creates a new Block with the given timestamp and target bits, hashed with algo, and seals it with sealer
+func newBlockAt(transactions []*Transaction, prevBlockHash []byte, timestamp int64, algo HashAlgo, bits int, sealer Sealer) *Block {
 	block := &Block{
-		Timestamp:     time.Now().Unix(),
+		Timestamp:     timestamp,
 		Transactions:  transactions,
 		PrevBlockHash: prevBlockHash,
-		Hash:          []byte{}, // Will be calculated by PoW
-		Nonce:         0,        // Will be found by PoW
+		Hash:          []byte{}, // Will be calculated by the sealer
+		Nonce:         0,        // Will be found by the sealer
+		HashAlgo:      algo,
+		Bits:          bits,
 	}
 
-	// Run Proof of Work to mine the block
+	// Run Proof of Work (or another sealer) to mine the block
 	// This is similar to consensus.Engine.Seal() in Geth
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
+	nonce, hash := sealer.Seal(block)
 
 	block.Hash = hash
 	block.Nonce = nonce
@@ -40,35 +50,69 @@ func NewBlock(transactions []*Transaction, prevBlockHash []byte) *Block {
 	return block
 }
 
+// orderTransactions returns txs in canonical order: coinbase first, then by ascending ID,
+// except that a transaction spending another one of the set always comes after it
+// Nodes building a block from the same transactions thus get the same merkle root
+func orderTransactions(txs []*Transaction) []*Transaction {
+	ordered := make([]*Transaction, 0, len(txs))
+	byID := make(map[string]*Transaction)
+	var rest []*Transaction
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			ordered = append(ordered, tx)
+			continue
+		}
+		byID[hex.EncodeToString(tx.ID)] = tx
+		rest = append(rest, tx)
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return bytes.Compare(rest[i].ID, rest[j].ID) < 0
+	})
+
+	// Repeatedly take the lowest ID whose parents in the set are already placed
+	placed := make(map[string]bool)
+	for len(rest) > 0 {
+		next := -1
+		for i, tx := range rest {
+			ready := true
+			for _, vin := range tx.Vin {
+				parent := hex.EncodeToString(vin.Txid)
+				if byID[parent] != nil && !placed[parent] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// A dependency cycle can't be valid; keep the remaining order by ID
+			return append(ordered, rest...)
+		}
+
+		placed[hex.EncodeToString(rest[next].ID)] = true
+		ordered = append(ordered, rest[next])
+		rest = append(rest[:next], rest[next+1:]...)
+	}
+
+	return ordered
+}
+
 // CalculateHash calculates the hash of the block
 func (b *Block) CalculateHash() []byte {
 	// Combine all the block headers into one byte array
 	headers := b.PrepareData()
 
-	// Calculate SHA-256 hash
-	hash := sha256.Sum256(headers)
-
-	return hash[:]
+	// Hash with the block's algorithm, SHA-256 by default
+	return hashWith(b.HashAlgo, headers)
 }
 
 // PrepareData prepares the block data for hashing
-// This is where we convert all headers to bytes
+// This is where we convert all headers to bytes: PrevBlockHash + TxHashes + Timestamp + Nonce
 func (b *Block) PrepareData() []byte {
-	// Hash all transactions
-	txHashes := b.HashTransactions()
-
-	// We'll concatenate: PrevBlockHash + TxHashes + Timestamp + Nonce
-	data := bytes.Join(
-		[][]byte{
-			b.PrevBlockHash,
-			txHashes,
-			IntToHex(b.Timestamp),
-			IntToHex(int64(b.Nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	return BlockHeader{PrevBlockHash: b.PrevBlockHash, MerkleRoot: b.MerkleRoot(), Timestamp: b.Timestamp, Difficulty: b.Bits, Nonce: b.Nonce, HashAlgo: b.HashAlgo}.PrepareData()
 }
 
 // IntToHex converts an int64 to a byte array
@@ -89,9 +133,22 @@ func (b *Block) HashTransactions() []byte {
 	for _, tx := range b.Transactions {
 		transactions = append(transactions, tx.Serialize())
 	}
-	txHash := sha256.Sum256(bytes.Join(transactions, []byte{}))
+	return hashWith(b.HashAlgo, bytes.Join(transactions, []byte{}))
+}
+
+// MerkleRoot returns the hash committing to the block's transactions
+// Pruned blocks no longer have their transactions, so the root stored at pruning time is used
+func (b *Block) MerkleRoot() []byte {
+	if b.prunedRoot != nil {
+		return b.prunedRoot
+	}
 
-	return txHash[:]
+	return b.HashTransactions()
+}
+
+// IsPruned reports whether the block's transactions were discarded by pruning
+func (b *Block) IsPruned() bool {
+	return b.prunedRoot != nil
 }
 
 // String returns a human-readable representation of the block
@@ -110,30 +167,24 @@ func (b *Block) String() string {
 	)
 }
 
-// Serialize serializes the block for storage
+// Serialize serializes the block for storage with the versioned codec, compressed if the chain stores blocks compressed
 // Similar to Geth's RLP encoding (rlp.EncodeToBytes)
 func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
-
-	err := encoder.Encode(b)
-	if err != nil {
-		panic(err)
+	data := EncodeBlock(b)
+	if compressBlocks {
+		return compressBlock(data)
 	}
 
-	return result.Bytes()
+	return data
 }
 
-// DeserializeBlock deserializes a block from bytes
+// DeserializeBlock deserializes a block from bytes (codec or legacy gob)
 // Similar to Geth's RLP decoding (rlp.DecodeBytes)
 func DeserializeBlock(d []byte) *Block {
-	var block Block
-
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
+	block, err := DecodeBlock(d)
 	if err != nil {
 		panic(err)
 	}
 
-	return &block
+	return block
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOrderTransactionsIsCanonical(t *testing.T) {
+	to := string(NewWallet().GetAddress())
+	coinbase := NewCoinbaseTX(to, "", 0, 2)
+	var spends []*Transaction
+	for i := 0; i < 3; i++ {
+		tx, _ := signedSpend(t, NewWallet(), to, 5, defaultChainID)
+		spends = append(spends, tx)
+	}
+	// A child sorting ahead of its parent by ID, so the ID order alone would put it first
+	parent := spends[0]
+	var child *Transaction
+	for value := 1; child == nil || bytes.Compare(child.ID, parent.ID) > 0; value++ {
+		child = &Transaction{nil, []TXInput{{parent.ID, 0, nil, nil, nil}}, []TXOutput{*NewTXOutput(value, to)}, false, nil}
+		child.ID = child.Hash()
+	}
+	txs := append([]*Transaction{coinbase, child}, spends...)
+
+	var root []byte
+	for _, perm := range permutations(txs) {
+		ordered := orderTransactions(perm)
+		if len(ordered) != len(txs) || ordered[0] != coinbase {
+			t.Fatalf("ordered %d transaction(s) without the coinbase first", len(ordered))
+		}
+		placed := make(map[*Transaction]bool)
+		for i, tx := range ordered[1:] {
+			if tx == child && !placed[parent] {
+				t.Fatal("child placed ahead of its parent")
+			}
+			if i > 0 && tx != child && ordered[i] != child && bytes.Compare(ordered[i].ID, tx.ID) > 0 {
+				t.Fatalf("%x placed after %x", tx.ID, ordered[i].ID)
+			}
+			placed[tx] = true
+		}
+
+		block := newBlockAt(ordered, make([]byte, 32), 1, hashSHA256, 4, InstantSealer{})
+		if root == nil {
+			root = block.MerkleRoot()
+		} else if !bytes.Equal(block.MerkleRoot(), root) {
+			t.Fatalf("merkle root %x, want %x from another order", block.MerkleRoot(), root)
+		}
+	}
+}
+
+// permutations returns every order of txs
+func permutations(txs []*Transaction) [][]*Transaction {
+	if len(txs) <= 1 {
+		return [][]*Transaction{txs}
+	}
+
+	var perms [][]*Transaction
+	for i, first := range txs {
+		rest := append(append([]*Transaction{}, txs[:i]...), txs[i+1:]...)
+		for _, perm := range permutations(rest) {
+			perms = append(perms, append([]*Transaction{first}, perm...))
+		}
+	}
+
+	return perms
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultBlockCacheSize is how many decoded blocks a chain keeps in memory by default
+const defaultBlockCacheSize = 256
+
+// blockCacheSize is the number of blocks cached by chains opened from now on; 0 disables the cache
+// Set with -blockcache
+var blockCacheSize = defaultBlockCacheSize
+
+// blockCache keeps the most recently read blocks, decoded, so repeated lookups skip the DB and the decoder
+// Cached blocks are shared between callers and must not be modified
+// A nil blockCache caches nothing
+// Similar to Geth's core.BlockChain blockCache
+type blockCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List               // Entries, most recently used first
+	entries map[string]*list.Element // Elements of order, by block hash
+}
+
+// blockCacheEntry is a cached block and the hash it's cached under
+type blockCacheEntry struct {
+	hash  string
+	block *Block
+}
+
+// newBlockCache returns a cache of up to size blocks, or nil if size isn't positive
+func newBlockCache(size int) *blockCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &blockCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the cached block with the given hash, if any
+func (c *blockCache) Get(hash []byte) (*Block, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[string(hash)]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*blockCacheEntry).block, true
+}
+
+// Add caches a block under its hash, evicting the least recently used block if the cache is full
+func (c *blockCache) Add(hash []byte, block *Block) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[string(hash)]; ok {
+		elem.Value.(*blockCacheEntry).block = block
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[string(hash)] = c.order.PushFront(&blockCacheEntry{string(hash), block})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*blockCacheEntry).hash)
+	}
+}
+
+// Remove drops a block from the cache, e.g. after its stored form changed
+func (c *blockCache) Remove(hash []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, string(hash))
+	}
+}
+
+// Purge empties the cache
+func (c *blockCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBlockCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		ops    []string // "add X" or "get X"
+		cached string   // Blocks left in the cache
+	}{
+		{"within size", 3, []string{"add a", "add b", "add c"}, "abc"},
+		{"oldest evicted", 2, []string{"add a", "add b", "add c"}, "bc"},
+		{"read keeps a block", 2, []string{"add a", "add b", "get a", "add c"}, "ac"},
+		{"re-adding keeps a block", 2, []string{"add a", "add b", "add a", "add c"}, "ac"},
+		{"missing read changes nothing", 2, []string{"add a", "add b", "get c", "add d"}, "bd"},
+		{"disabled", 0, []string{"add a", "get a"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newBlockCache(tt.size)
+			for _, op := range tt.ops {
+				var action, hash string
+				fmt.Sscan(op, &action, &hash)
+				if action == "add" {
+					cache.Add([]byte(hash), &Block{Hash: []byte(hash)})
+				} else {
+					cache.Get([]byte(hash))
+				}
+			}
+
+			cached := ""
+			for _, hash := range "abcd" {
+				if block, ok := cache.Get([]byte(string(hash))); ok && string(block.Hash) == string(hash) {
+					cached += string(hash)
+				}
+			}
+			if cached != tt.cached {
+				t.Fatalf("cached %q, want %q", cached, tt.cached)
+			}
+		})
+	}
+}
+
+func TestBlockCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, bc *Blockchain, w *Wallet) []byte // Returns the hash of a block read before it changed
+		wantErr error                                                // nil means the block must be gone
+	}{
+		{"block of a reorganization rolled back", func(t *testing.T, bc *Blockchain, w *Wallet) []byte {
+			address := string(w.GetAddress())
+			genesis := bc.Tip()
+			first := spendCoinbase(t, bc, w, address, 1)
+			second := spendCoinbase(t, bc, w, address, 2)
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "main", 0, 2))); err != nil {
+				t.Fatal(err)
+			}
+			side := blockOn(t, bc, genesis, NewCoinbaseTX(address, "side", 1, 2), first)
+			if err := bc.AddBlock(side); err != nil {
+				t.Fatal(err)
+			}
+
+			// Connecting the bad block reads it while rebuilding the UTXO set, before the rollback deletes it
+			bad := blockOn(t, bc, side.Hash, NewCoinbaseTX(address, "side", 2, 3), second)
+			if err := bc.AddBlock(bad); err == nil {
+				t.Fatal("branch spending an output twice was accepted")
+			}
+			return bad.Hash
+		}, nil},
+		{"pruned block", func(t *testing.T, bc *Blockchain, w *Wallet) []byte {
+			blocks := chainBlocks(t, bc, coinbaseBlocks(bc, string(w.GetAddress()), 3)...)
+			if err := bc.AddBlocks(blocks); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bc.GetBlock(blocks[0].Hash); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bc.Prune(1); err != nil {
+				t.Fatal(err)
+			}
+			return blocks[0].Hash
+		}, errBlockPruned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			hash := tt.setup(t, bc, w)
+
+			_, err := bc.GetBlock(hash)
+			if tt.wantErr == nil && err == nil {
+				t.Fatal("deleted block is still returned")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetBlock = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// BenchmarkGetBlock reads the same recent blocks over and over, as repeated queries do, with and without the cache
+func BenchmarkGetBlock(b *testing.B) {
+	bc, w := newTestChain(b)
+	blocks := chainBlocks(b, bc, coinbaseBlocks(bc, string(w.GetAddress()), 50)...)
+	if err := bc.AddBlocks(blocks); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{0, defaultBlockCacheSize} {
+		b.Run(fmt.Sprintf("cache %d", size), func(b *testing.B) {
+			bc.blocks = newBlockCache(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.GetBlock(blocks[i%len(blocks)].Hash); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
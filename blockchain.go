@@ -3,12 +3,15 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"encoding/gob"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"sync"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -16,19 +19,48 @@ import (
 const dbFile = "blockchain_%s.db"
 const blocksBucket = "blocks"
 const mempoolBucket = "mempool"
+const metaBucket = "meta"
+const chainIDKey = "chainid"
+
+// defaultChainID is used for chains created without an explicit chain ID
+const defaultChainID = 1
+
+// readOnlyOpenTimeout bounds how long a read-only open waits for a writer to release the DB
+const readOnlyOpenTimeout = time.Second
+
+// errNoBlockchain is returned when opening a chain that was never created
+var errNoBlockchain = errors.New("No existing blockchain found. Please create one first using 'createblockchain'.")
+
+// errNoBlocks is returned when opening a chain whose DB exists but holds no blocks, e.g. after an interrupted create
+var errNoBlocks = errors.New("The blockchain has no blocks yet.")
 
 // Blockchain represents the blockchain with database persistence
 // Similar to Geth's core.BlockChain
+// Blocks may be mined and received concurrently (e.g. by a miner and the network goroutines):
+// writes to the chain are serialized and the tip is only read through Tip
 type Blockchain struct {
-	tip []byte    // Hash of the last block in the chain (the "tip")
-	db  *bbolt.DB // Database connection
+	tip     []byte      // Hash of the last block in the chain (the "tip"), guarded by tipMu
+	db      Store       // Database connection
+	chainID int64       // Chain ID mixed into transaction signatures (replay protection)
+	sealer  Sealer      // Seals mined blocks and verifies received ones
+	blocks  *blockCache // Recently read blocks
+
+	consensus HashAlgo // Algorithm every block of the chain is hashed with
+
+	unspentMu    sync.Mutex               // Guards unspentTip and unspentCache
+	unspentTip   []byte                   // Tip the memoized unspent transactions were found at
+	unspentCache map[string][]Transaction // FindUnspentTransactions results by pubkey hash
+
+	writeMu sync.Mutex   // Serializes MineBlock and AddBlock
+	tipMu   sync.RWMutex // Guards tip
 }
 
 // BlockchainIterator is used to iterate over blockchain blocks
 // Similar to Geth's iterator pattern
 type BlockchainIterator struct {
 	currentHash []byte
-	db          *bbolt.DB
+	db          Store
+	cache       *blockCache
 }
 
 // MineBlock mines a new block with the provided transactions
@@ -36,15 +68,19 @@ type BlockchainIterator struct {
 func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 	var lastHash []byte
 
+	bc.writeMu.Lock()
+	defer bc.writeMu.Unlock()
+
 	// Verify all transactions
 	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
+		err := bc.VerifyTransaction(tx)
+		if err != nil {
+			log.Panicf("ERROR: Invalid transaction %x: %s", tx.ID, err)
 		}
 	}
 
 	// Read the last block hash from the database
-	err := bc.db.View(func(tx *bbolt.Tx) error {
+	err := bc.db.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		lastHash = b.Get([]byte("l"))
 		return nil
@@ -53,11 +89,28 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		log.Panic(err)
 	}
 
-	// Create and mine new block
-	newBlock := NewBlock(transactions, lastHash)
+	// Create and mine new block, with the transactions in canonical order
+	// Its timestamp must come after the median time past, even if blocks are mined within a second
+	timestamp := time.Now().Unix()
+	medianTime, err := bc.MedianTimePast(lastHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	if timestamp <= medianTime {
+		timestamp = medianTime + 1
+	}
+	bits, err := bc.NextTargetBits(lastHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	newBlock := newBlockAt(orderTransactions(transactions), lastHash, timestamp, bc.consensus, bits, bc.sealer)
+	height, err := bc.blockHeight(lastHash)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	// Save the new block to database
-	err = bc.db.Update(func(tx *bbolt.Tx) error {
+	err = bc.db.Update(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		err := b.Put(newBlock.Hash, newBlock.Serialize())
 		if err != nil {
@@ -69,21 +122,65 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 			log.Panic(err)
 		}
 
-		bc.tip = newBlock.Hash
-		return nil
+		// Keep the work behind the block for getmininginfo
+		if stats, ok := LastMiningStats(); ok && bytes.Equal(stats.Hash, newBlock.Hash) {
+			if err := putMiningStats(tx, stats); err != nil {
+				return err
+			}
+		}
+
+		err = connectBlockUTXO(tx, newBlock, height+1)
+		if err != nil {
+			return err
+		}
+
+		return connectBlockTxIndex(tx, newBlock)
 	})
 	if err != nil {
 		log.Panic(err)
 	}
+	// Only a committed block becomes the tip, so readers never see one the DB doesn't hold
+	bc.setTip(newBlock.Hash)
+	blocksMinedTotal.Add(1)
+	eventBus.Publish(Event{Kind: EventNewBlock, Block: newBlock})
 
 	return newBlock
 }
 
 // FindUnspentTransactions returns a list of transactions containing unspent outputs
+// Results are memoized until the tip changes, so repeated queries at the same height skip the chain scan;
+// the returned slice is shared and must not be modified
 func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
+	tip := bc.Tip()
+	key := string(pubKeyHash)
+
+	bc.unspentMu.Lock()
+	if !bytes.Equal(bc.unspentTip, tip) {
+		bc.unspentTip = tip
+		bc.unspentCache = make(map[string][]Transaction)
+	}
+	unspentTXs, ok := bc.unspentCache[key]
+	bc.unspentMu.Unlock()
+	if ok {
+		return unspentTXs
+	}
+
+	unspentTXs = bc.findUnspentTransactions(tip, pubKeyHash)
+
+	bc.unspentMu.Lock()
+	if bytes.Equal(bc.unspentTip, tip) {
+		bc.unspentCache[key] = unspentTXs
+	}
+	bc.unspentMu.Unlock()
+
+	return unspentTXs
+}
+
+// findUnspentTransactions scans the chain ending at tip for the transactions with outputs unspent by pubKeyHash
+func (bc *Blockchain) findUnspentTransactions(tip, pubKeyHash []byte) []Transaction {
 	var unspentTXs []Transaction
 	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
+	bci := &BlockchainIterator{tip, bc.db, bc.blocks}
 
 	for {
 		block := bci.Next()
@@ -126,7 +223,12 @@ func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
 }
 
 // FindUTXO finds all unspent transaction outputs
+// Uses the UTXO set, falling back to scanning the chain if the set is stale (e.g. opened read-only)
 func (bc *Blockchain) FindUTXO(pubKeyHash []byte) []TXOutput {
+	if utxos := (UTXOSet{bc}); utxos.IsCurrent() {
+		return utxos.FindUTXO(pubKeyHash)
+	}
+
 	var UTXOs []TXOutput
 	unspentTransactions := bc.FindUnspentTransactions(pubKeyHash)
 
@@ -141,14 +243,75 @@ func (bc *Blockchain) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return UTXOs
 }
 
+// Balance returns the value of the unspent outputs locked with pubKeyHash that have at least minConf confirmations
+// With minConf 0, unconfirmed outputs of the mempool count too and outputs the mempool spends don't
+// Counting confirmations needs an up-to-date UTXO set; without one only minConf 1 can be answered
+// Similar to Bitcoin's getbalance minconf argument
+func (bc *Blockchain) Balance(pubKeyHash []byte, minConf int) (int, error) {
+	utxos := UTXOSet{bc}
+	if !utxos.IsCurrent() {
+		if minConf != 1 {
+			return 0, errors.New("The UTXO set is out of date; run rescan first")
+		}
+
+		balance := 0
+		for _, out := range bc.FindUTXO(pubKeyHash) {
+			balance += out.Value
+		}
+		return balance, nil
+	}
+
+	entries, err := utxos.ListUnspent(pubKeyHash)
+	if err != nil {
+		return 0, err
+	}
+
+	spent := make(map[string]bool)
+	if minConf == 0 {
+		spent = bc.mempoolSpends()
+	}
+
+	balance := 0
+	for _, entry := range entries {
+		if entry.Confirmations >= minConf && !spent[fmt.Sprintf("%x:%d", entry.TxID, entry.Vout)] {
+			balance += entry.Output.Value
+		}
+	}
+
+	if minConf == 0 {
+		for _, tx := range bc.GetMempool() {
+			for outIdx, out := range tx.Vout {
+				if out.IsLockedWithKey(pubKeyHash) && !spent[fmt.Sprintf("%x:%d", tx.ID, outIdx)] {
+					balance += out.Value
+				}
+			}
+		}
+	}
+
+	return balance, nil
+}
+
 // AddToMempool adds a transaction to the mempool
-func (bc *Blockchain) AddToMempool(tx *Transaction) {
-	err := bc.db.Update(func(txn *bbolt.Tx) error {
+// Transactions paying less than minRelayFee or creating dust outputs are rejected,
+// as are double spends of outputs spent on chain or by another mempool transaction
+func (bc *Blockchain) AddToMempool(tx *Transaction) error {
+	evicted, err := bc.CheckMempoolAccept(tx)
+	if err != nil {
+		return err
+	}
+
+	err = bc.db.Update(func(txn StoreTx) error {
 		b := txn.Bucket([]byte(mempoolBucket))
 		if b == nil {
 			return errors.New("Mempool bucket does not exist")
 		}
 
+		for _, replaced := range evicted {
+			if err := b.Delete(replaced.ID); err != nil {
+				return err
+			}
+		}
+
 		key := tx.ID
 		value := tx.Serialize()
 
@@ -156,25 +319,180 @@ func (bc *Blockchain) AddToMempool(tx *Transaction) {
 		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	for _, replaced := range evicted {
+		logger.Infof("Transaction %x replaced by %x", replaced.ID, tx.ID)
+	}
+	mempoolAcceptedTotal.Add(1)
+	eventBus.Publish(Event{Kind: EventNewTx, Tx: tx})
+
+	return nil
+}
+
+// CheckMempoolAccept runs the checks of AddToMempool without changing the mempool
+// Returns the mempool transactions tx would replace
+// Similar to Bitcoin's testmempoolaccept RPC
+func (bc *Blockchain) CheckMempoolAccept(tx *Transaction) ([]*Transaction, error) {
+	err := tx.ValidateInputs()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bc.checkRelayPolicy(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if utxos := (UTXOSet{bc}); utxos.IsCurrent() {
+		err = utxos.CheckInputsUnspent(tx, bc.GetMempool())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bc.checkReplacement(tx, bc.findMempoolConflicts(tx))
+}
+
+// checkReplacement allows tx into the mempool in place of the transactions it conflicts with
+// only if they all signal replaceability and tx pays strictly more than their combined fees
+// Returns the mempool transactions to evict: the conflicts and every transaction spending their outputs
+// Similar to Bitcoin's BIP 125 replacement rules
+func (bc *Blockchain) checkReplacement(tx *Transaction, conflicts []*Transaction) ([]*Transaction, error) {
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+	for _, conflict := range conflicts {
+		if !conflict.Replaceable {
+			return nil, fmt.Errorf("spends the same output as mempool transaction %x", conflict.ID)
+		}
+	}
+	evicted := append(conflicts, bc.mempoolDescendants(conflicts)...)
+
+	replacedFees := 0
+	for _, replaced := range evicted {
+		fee, err := bc.TransactionFee(replaced)
+		if err != nil {
+			return nil, err
+		}
+		replacedFees, err = addValue(replacedFees, fee)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fee, err := bc.TransactionFee(tx)
+	if err != nil {
+		return nil, err
+	}
+	if fee <= replacedFees {
+		return nil, fmt.Errorf("replacement fee %d must exceed the %d paid by the transactions it replaces", fee, replacedFees)
+	}
+
+	return evicted, nil
+}
+
+// mempoolDescendants returns the mempool transactions spending outputs of txs,
+// directly or through other mempool transactions
+func (bc *Blockchain) mempoolDescendants(txs []*Transaction) []*Transaction {
+	var descendants []*Transaction
+	ancestors := make(map[string]bool)
+	for _, tx := range txs {
+		ancestors[hex.EncodeToString(tx.ID)] = true
+	}
+
+	// Parents come before children in canonical order, so one pass finds every generation
+	for _, pooled := range orderTransactions(bc.GetMempool()) {
+		if ancestors[hex.EncodeToString(pooled.ID)] {
+			continue
+		}
+		for _, vin := range pooled.Vin {
+			if ancestors[hex.EncodeToString(vin.Txid)] {
+				ancestors[hex.EncodeToString(pooled.ID)] = true
+				descendants = append(descendants, pooled)
+				break
+			}
+		}
+	}
+
+	return descendants
+}
+
+// SubmitRawTransaction decodes a fully signed, serialized transaction and adds it to the mempool
+// The transaction must verify against the chain and pass the same checks as AddToMempool
+// Similar to Bitcoin's sendrawtransaction RPC
+func (bc *Blockchain) SubmitRawTransaction(raw []byte) (*Transaction, error) {
+	tx, err := DecodeTransaction(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed transaction: %s", err)
+	}
+	if tx.IsCoinbase() {
+		return nil, errors.New("coinbase transactions can only be mined")
+	}
+	err = bc.VerifyTransaction(tx)
+	if err != nil {
+		return nil, fmt.Errorf("transaction failed verification: %s", err)
+	}
+
+	err = bc.AddToMempool(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// mempoolSpends returns the outpoints ("txid:index") spent by mempool transactions
+func (bc *Blockchain) mempoolSpends() map[string]bool {
+	spends := make(map[string]bool)
+
+	for _, tx := range bc.GetMempool() {
+		for _, vin := range tx.Vin {
+			spends[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+	}
+
+	return spends
+}
+
+// findMempoolConflicts returns the mempool transactions spending any of tx's outpoints
+func (bc *Blockchain) findMempoolConflicts(tx *Transaction) []*Transaction {
+	spends := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		spends[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+	}
+
+	var conflicts []*Transaction
+	for _, pooled := range bc.GetMempool() {
+		if bytes.Equal(pooled.ID, tx.ID) {
+			continue
+		}
+		for _, vin := range pooled.Vin {
+			if spends[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] {
+				conflicts = append(conflicts, pooled)
+				break
+			}
+		}
 	}
+
+	return conflicts
 }
 
 // GetMempool returns all transactions in the mempool
+// A DB without a mempool bucket has an empty mempool
 func (bc *Blockchain) GetMempool() []*Transaction {
 	var txs []*Transaction
 
-	err := bc.db.View(func(txn *bbolt.Tx) error {
+	err := bc.db.View(func(txn StoreTx) error {
 		b := txn.Bucket([]byte(mempoolBucket))
+		if b == nil {
+			// DBs opened read-only are never migrated, and may predate the mempool
+			return nil
+		}
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var tx Transaction
-			decoder := gob.NewDecoder(bytes.NewReader(v))
-			err := decoder.Decode(&tx)
-			if err != nil {
-				log.Panic(err)
-			}
+			tx := DeserializeTransaction(v)
 			txs = append(txs, &tx)
 		}
 		return nil
@@ -186,9 +504,50 @@ func (bc *Blockchain) GetMempool() []*Transaction {
 	return txs
 }
 
+// RemoveFromMempool deletes a transaction from the mempool along with the mempool transactions spending its outputs,
+// directly or not, which could otherwise never be mined. Returns those descendants
+func (bc *Blockchain) RemoveFromMempool(txID []byte) ([]*Transaction, error) {
+	tx, err := bc.findMempoolTransaction(txID)
+	if err != nil {
+		return nil, fmt.Errorf("Transaction %x is not in the mempool", txID)
+	}
+	descendants := bc.mempoolDescendants([]*Transaction{&tx})
+
+	err = bc.removeMempoolTransactions(append([]*Transaction{&tx}, descendants...))
+	if err != nil {
+		return nil, err
+	}
+
+	return descendants, nil
+}
+
+// RemoveMinedFromMempool deletes the transactions of a mined block from the mempool
+// Unlike RemoveFromMempool it keeps their descendants, whose inputs are now on chain
+func (bc *Blockchain) RemoveMinedFromMempool(txs []*Transaction) error {
+	return bc.removeMempoolTransactions(txs)
+}
+
+// removeMempoolTransactions deletes txs from the mempool in one DB update
+func (bc *Blockchain) removeMempoolTransactions(txs []*Transaction) error {
+	return bc.db.Update(func(txn StoreTx) error {
+		b := txn.Bucket([]byte(mempoolBucket))
+		if b == nil {
+			return errors.New("Mempool bucket does not exist")
+		}
+
+		for _, tx := range txs {
+			if err := b.Delete(tx.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 // ClearMempool wipes the mempool
 func (bc *Blockchain) ClearMempool() {
-	err := bc.db.Update(func(txn *bbolt.Tx) error {
+	err := bc.db.Update(func(txn StoreTx) error {
 		err := txn.DeleteBucket([]byte(mempoolBucket))
 		if err != nil {
 			return err
@@ -201,8 +560,71 @@ func (bc *Blockchain) ClearMempool() {
 	}
 }
 
+// RevalidateMempool checks the stored mempool against the current UTXO set and drops the transactions
+// that are no longer valid, e.g. because a block mined while the node was down spent their inputs,
+// along with those depending on them. Returns how many transactions were kept and dropped
+// Similar to Bitcoin's LoadMempool re-accepting each transaction of mempool.dat
+func (bc *Blockchain) RevalidateMempool() (int, int, error) {
+	utxos := UTXOSet{bc}
+	if !utxos.IsCurrent() {
+		return 0, 0, errors.New("the UTXO set is out of date")
+	}
+
+	var kept []*Transaction
+	var stale [][]byte
+	spent := make(map[string]bool)
+Mempool:
+	for _, tx := range orderTransactions(bc.GetMempool()) {
+		err := utxos.CheckInputsUnspent(tx, kept)
+		if err == nil {
+			err = bc.verifyTransactionInputs(tx)
+		}
+		if err != nil {
+			logger.Infof("Dropping mempool transaction %x: %s", tx.ID, err)
+			stale = append(stale, tx.ID)
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			if spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] {
+				logger.Infof("Dropping mempool transaction %x: it double-spends an input", tx.ID)
+				stale = append(stale, tx.ID)
+				continue Mempool
+			}
+		}
+		for _, vin := range tx.Vin {
+			spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+		kept = append(kept, tx)
+	}
+	if len(stale) == 0 {
+		return len(kept), 0, nil
+	}
+
+	err := bc.db.Update(func(txn StoreTx) error {
+		b := txn.Bucket([]byte(mempoolBucket))
+		for _, txID := range stale {
+			if err := b.Delete(txID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(kept), len(stale), nil
+}
+
 // FindSpendableOutputs finds and returns unspent outputs to reference in inputs
+// Uses the UTXO set, falling back to scanning the chain if the set is stale
+// Outputs already spent by mempool transactions are skipped, so a new spend doesn't conflict with them
 func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	if utxos := (UTXOSet{bc}); utxos.IsCurrent() {
+		return utxos.FindSpendableOutputs(pubKeyHash, amount, bc.mempoolSpends())
+	}
+
 	unspentOutputs := make(map[string][]int)
 	unspentTXs := bc.FindUnspentTransactions(pubKeyHash)
 	accumulated := 0
@@ -213,7 +635,12 @@ Work:
 
 		for outIdx, out := range tx.Vout {
 			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-				accumulated += out.Value
+				sum, err := addValue(accumulated, out.Value)
+				if err != nil {
+					// Already far beyond any requestable amount
+					sum = math.MaxInt
+				}
+				accumulated = sum
 				unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
 
 				if accumulated >= amount {
@@ -226,90 +653,321 @@ Work:
 	return accumulated, unspentOutputs
 }
 
-// FindTransaction finds a transaction by its ID
-func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
+// SelectOutputs checks the outpoints chosen for a spend (transaction ID in hex -> output indexes)
+// and returns their total value
+// Each must be an unspent output locked with pubKeyHash and not already spent by the mempool,
+// unless replace is set and the mempool transaction spending it signals replaceability
+// Similar to Bitcoin Core's coin control (CCoinControl::Select)
+func (bc *Blockchain) SelectOutputs(pubKeyHash []byte, outpoints map[string][]int, replace bool) (int, error) {
+	utxos := UTXOSet{bc}
+	if !utxos.IsCurrent() {
+		return 0, errors.New("the UTXO set is out of date; run rescan first")
+	}
+	mempoolSpends := make(map[string]bool)
+	for _, pooled := range bc.GetMempool() {
+		if replace && pooled.Replaceable {
+			continue
+		}
+		for _, vin := range pooled.Vin {
+			mempoolSpends[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+	}
+	seen := make(map[string]bool)
+	accumulated := 0
 
-	for {
-		block := bci.Next()
+	for txid, indexes := range outpoints {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			return 0, fmt.Errorf("invalid transaction ID %s", txid)
+		}
+		outs, _ := utxos.FindOutputs(txID)
 
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
+		for _, outIdx := range indexes {
+			outpoint := fmt.Sprintf("%s:%d", txid, outIdx)
+			if seen[outpoint] {
+				return 0, fmt.Errorf("output %s is selected twice", outpoint)
 			}
-		}
+			seen[outpoint] = true
 
-		if len(block.PrevBlockHash) == 0 {
-			break
+			out, ok := outs[outIdx]
+			if !ok {
+				return 0, fmt.Errorf("output %s is already spent or doesn't exist", outpoint)
+			}
+			if !out.IsLockedWithKey(pubKeyHash) {
+				return 0, fmt.Errorf("output %s doesn't belong to the sender", outpoint)
+			}
+			if mempoolSpends[outpoint] {
+				return 0, fmt.Errorf("output %s is already spent by a mempool transaction", outpoint)
+			}
+
+			accumulated, err = addValue(accumulated, out.Value)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	return accumulated, nil
 }
 
-// SignTransaction signs inputs of a Transaction
-func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
-
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
-	}
+// FindTransaction finds a transaction by its ID, in the blocks or else in the mempool
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	tx, _, err := bc.locateTransaction(ID)
 
-	tx.Sign(privKey, prevTXs)
+	return tx, err
 }
 
-// VerifyTransaction verifies transaction input signatures
-func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
+// GetRawTransaction returns the serialized form of a transaction and the hash of the block holding it
+// The block hash is nil if the transaction is still in the mempool
+// Similar to Bitcoin's getrawtransaction RPC
+func (bc *Blockchain) GetRawTransaction(txID []byte) ([]byte, []byte, error) {
+	tx, blockHash, err := bc.locateTransaction(txID)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	prevTXs := make(map[string]Transaction)
+	return tx.Serialize(), blockHash, nil
+}
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+// locateTransaction finds a transaction by its ID and returns the hash of the block holding it,
+// or a nil hash if it was found in the mempool
+func (bc *Blockchain) locateTransaction(ID []byte) (Transaction, []byte, error) {
+	if tx, blockHash, err := bc.findChainTransaction(ID); err == nil {
+		return tx, blockHash, nil
 	}
 
-	return tx.Verify(prevTXs)
-}
+	tx, err := bc.findMempoolTransaction(ID)
 
-// Iterator returns a BlockchainIterator
-func (bc *Blockchain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.db}
-	return bci
+	return tx, nil, err
 }
 
-// Next returns the next block starting from the tip
-func (i *BlockchainIterator) Next() *Block {
-	var block *Block
-
-	err := i.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		encodedBlock := b.Get(i.currentHash)
-		block = DeserializeBlock(encodedBlock)
+// findMempoolTransaction finds a transaction by its ID in the mempool only
+func (bc *Blockchain) findMempoolTransaction(ID []byte) (Transaction, error) {
+	var tx Transaction
+	found := false
+	err := bc.db.View(func(txn StoreTx) error {
+		b := txn.Bucket([]byte(mempoolBucket))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get(ID); data != nil {
+			tx = DeserializeTransaction(data)
+			found = true
+		}
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return Transaction{}, err
+	}
+	if !found {
+		return Transaction{}, errors.New("Transaction is not found")
 	}
 
-	i.currentHash = block.PrevBlockHash
-	return block
+	return tx, nil
 }
 
-// GetBestHeight returns the height of the latest block
-func (bc *Blockchain) GetBestHeight() int {
-	height := 0
-	bci := bc.Iterator()
-
+// findChainTransaction finds a transaction in the blocks of the chain and returns its block's hash
+// Unlike FindTransaction it ignores the mempool
+// The transaction index points straight at the block; without an up-to-date index the chain is scanned
+func (bc *Blockchain) findChainTransaction(ID []byte) (Transaction, []byte, error) {
+	blockHash, err := TxIndex{bc}.BlockHash(ID)
+	if errors.Is(err, errTxNotIndexed) {
+		return Transaction{}, nil, err
+	}
+	if err == nil {
+		block, err := bc.GetBlock(blockHash)
+		if err == nil {
+			for _, tx := range block.Transactions {
+				if bytes.Equal(tx.ID, ID) {
+					return *tx, block.Hash, nil
+				}
+			}
+		}
+	}
+
+	return bc.scanChainTransaction(ID)
+}
+
+// scanChainTransaction finds a transaction by walking the chain from the tip
+func (bc *Blockchain) scanChainTransaction(ID []byte) (Transaction, []byte, error) {
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, ID) {
+				return *tx, block.Hash, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return Transaction{}, nil, errors.New("Transaction is not found")
+}
+
+// GetTransactionConfirmations returns how many blocks deep a transaction is buried
+// The block containing the transaction counts as one confirmation (bestHeight - txBlockHeight + 1)
+// A transaction that is only in the mempool has 0 confirmations
+func (bc *Blockchain) GetTransactionConfirmations(txID []byte) (int, error) {
+	// The index and the headers still know transactions of pruned blocks; a missing or stale index falls back to a scan
+	blockHash, err := TxIndex{bc}.BlockHash(txID)
+	if err != nil && !errors.Is(err, errTxNotIndexed) {
+		_, blockHash, err = bc.scanChainTransaction(txID)
+	}
+	if err == nil {
+		height, err := bc.blockHeight(blockHash)
+		if err != nil {
+			return 0, err
+		}
+
+		return bc.GetBestHeight() - height + 1, nil
+	}
+
+	inMempool := false
+	err = bc.db.View(func(txn StoreTx) error {
+		b := txn.Bucket([]byte(mempoolBucket))
+		inMempool = b != nil && b.Get(txID) != nil
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if inMempool {
+		return 0, nil
+	}
+
+	return 0, errors.New("Transaction is not found")
+}
+
+// SignTransaction signs inputs of a Transaction
+// Fails if an input references a transaction that can't be found
+func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs := make(map[string]Transaction)
+
+	for _, vin := range tx.Vin {
+		prevTX, err := bc.findPrevTransaction(vin.Txid)
+		if err != nil {
+			return fmt.Errorf("referenced input %x:%d not found", vin.Txid, vin.Vout)
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	return tx.Sign(privKey, prevTXs, bc.chainID)
+}
+
+// VerifyTransaction verifies the transaction ID and input signatures against this chain's ID
+// Fails if an input references a transaction that can't be found, or an output that is already spent,
+// or if the outputs are worth more than the inputs. A failing input is reported with an InputError
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) error {
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return errors.New("transaction ID does not match its contents")
+	}
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	if utxos := (UTXOSet{bc}); utxos.IsCurrent() {
+		err := utxos.CheckInputsUnspent(tx, bc.GetMempool())
+		if err != nil {
+			return err
+		}
+	}
+
+	return bc.verifyTransactionInputs(tx)
+}
+
+// verifyTransactionInputs checks the signatures of tx's inputs and that its outputs don't exceed them,
+// without checking that the inputs are still unspent, e.g. for a transaction already in a block
+func (bc *Blockchain) verifyTransactionInputs(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	prevTXs := make(map[string]Transaction)
+
+	for inID, vin := range tx.Vin {
+		prevTX, err := bc.findPrevTransaction(vin.Txid)
+		if err != nil {
+			return tx.inputError(inID, errMissingPrevTx)
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	if err := tx.Verify(prevTXs, bc.chainID); err != nil {
+		return err
+	}
+	if err := tx.checkValueConservation(prevTXs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ChainID returns the chain ID transactions on this chain are signed for
+func (bc *Blockchain) ChainID() int64 {
+	return bc.chainID
+}
+
+// Tip returns the hash of the last block in the chain
+func (bc *Blockchain) Tip() []byte {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	return bc.tip
+}
+
+// setTip records a new last block
+func (bc *Blockchain) setTip(hash []byte) {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	bc.tip = hash
+}
+
+// Iterator returns a BlockchainIterator
+func (bc *Blockchain) Iterator() *BlockchainIterator {
+	bci := &BlockchainIterator{bc.Tip(), bc.db, bc.blocks}
+	return bci
+}
+
+// Next returns the next block starting from the tip
+func (i *BlockchainIterator) Next() *Block {
+	block, ok := i.cache.Get(i.currentHash)
+	if ok {
+		i.currentHash = block.PrevBlockHash
+		return block
+	}
+
+	err := i.db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if b == nil {
+			return errNoBlocks
+		}
+		encodedBlock := b.Get(i.currentHash)
+		if encodedBlock == nil {
+			return fmt.Errorf("block %x is not found", i.currentHash)
+		}
+		block = DeserializeBlock(encodedBlock)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	i.cache.Add(i.currentHash, block)
+
+	i.currentHash = block.PrevBlockHash
+	return block
+}
+
+// GetBestHeight returns the height of the latest block
+func (bc *Blockchain) GetBestHeight() int {
+	height := 0
+	bci := bc.Iterator()
+
 	for {
 		block := bci.Next()
 		height++
@@ -338,94 +996,618 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
+// HasBlock reports whether the block is stored, pruned or not
+func (bc *Blockchain) HasBlock(blockHash []byte) bool {
+	found := false
+
+	err := bc.db.View(func(tx StoreTx) error {
+		found = tx.Bucket([]byte(blocksBucket)).Get(blockHash) != nil
+		return nil
+	})
+
+	return err == nil && found
+}
+
 // GetBlock finds a block by its hash and returns it
+// A pruned block is returned with its header fields only, along with an errBlockPruned error
 func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := bc.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+	if cached, ok := bc.blocks.Get(blockHash); ok {
+		block = *cached
+	} else {
+		err := bc.db.View(func(tx StoreTx) error {
+			b := tx.Bucket([]byte(blocksBucket))
+
+			blockData := b.Get(blockHash)
+
+			if blockData == nil {
+				return errors.New("Block is not found.")
+			}
+
+			decoded := DeserializeBlock(blockData)
+			bc.blocks.Add(blockHash, decoded)
+			block = *decoded
+
+			return nil
+		})
+		if err != nil {
+			return block, err
+		}
+	}
+
+	if block.IsPruned() {
+		return block, fmt.Errorf("block %x: %w", blockHash, errBlockPruned)
+	}
+
+	return block, nil
+}
+
+// AddBlock saves the block into the blockchain
+// A block whose parent is unknown is held in the orphan pool and errOrphanBlock is returned;
+// it's connected, along with its own orphaned descendants, once the parent is added.
+// Blocks that fail validation are rejected with an error
+func (bc *Blockchain) AddBlock(block *Block) error {
+	bc.writeMu.Lock()
+	defer bc.writeMu.Unlock()
+
+	if bc.HasBlock(block.Hash) || orphans.Has(block.Hash) {
+		return nil
+	}
+	if len(block.PrevBlockHash) > 0 && !bc.HasBlock(block.PrevBlockHash) {
+		// Nothing else can be checked without the parent, but the work can, so peers can't fill the pool for free.
+		// The bits the orphan needs aren't known either, so it may claim at most one retarget fewer than the tip's
+		tip, err := bc.GetBlock(bc.Tip())
+		if err != nil {
+			return err
+		}
+		minBits := tip.TargetBits() - maxRetargetBits
+		if !bytes.Equal(block.CalculateHash(), block.Hash) || block.TargetBits() < minBits || !bc.sealer.Verify(block) {
+			return errors.New("orphan block has invalid proof of work")
+		}
+		orphans.Add(block)
+		return errOrphanBlock
+	}
+
+	err := bc.connectBlock(block)
+	if err != nil {
+		return err
+	}
+
+	// Blocks that were waiting for this one can now be connected too
+	bc.connectOrphans(block.Hash)
+
+	return nil
+}
+
+// AddBlocks saves a batch of blocks, each the child of the one before it, into the blockchain
+// A batch extending the tip is validated and stored in a single DB transaction, updating the tip
+// and the UTXO set once; if any block fails validation, none of the batch is stored.
+// Other batches, e.g. of a side chain, are connected one block at a time
+// Similar to Bitcoin's ActivateBestChainStep() connecting several blocks per call
+func (bc *Blockchain) AddBlocks(blocks []*Block) error {
+	bc.writeMu.Lock()
+	defer bc.writeMu.Unlock()
+
+	if len(blocks) > 0 && len(blocks[0].PrevBlockHash) > 0 && !bc.HasBlock(blocks[0].PrevBlockHash) {
+		return errOrphanBlock
+	}
+
+	err := bc.connectBlocks(blocks)
+	if err != nil {
+		return err
+	}
 
-		blockData := b.Get(blockHash)
+	if len(blocks) > 0 {
+		bc.connectOrphans(blocks[len(blocks)-1].Hash)
+	}
+
+	return nil
+}
 
-		if blockData == nil {
-			return errors.New("Block is not found.")
+// connectOrphans connects the orphaned descendants of a newly added block
+// Each run of single children is connected as one batch; if the batch is rejected,
+// its blocks are retried one at a time so those before the invalid block are kept
+func (bc *Blockchain) connectOrphans(hash []byte) {
+	queue := orphans.TakeChildren(hash)
+	for len(queue) > 0 {
+		run := []*Block{queue[0]}
+		queue = queue[1:]
+		for {
+			children := orphans.TakeChildren(run[len(run)-1].Hash)
+			if len(children) == 0 {
+				break
+			}
+			run = append(run, children[0])
+			queue = append(queue, children[1:]...)
+		}
+
+		if err := bc.connectBlocks(run); err != nil {
+			logger.Debugf("Connecting %d orphan block(s) as a batch: %s", len(run), err)
+			for i, child := range run {
+				if err := bc.connectBlock(child); err != nil {
+					logger.Errorf("Rejecting orphan block %x and %d descendant(s): %s", child.Hash, len(run)-i-1, err)
+					run = run[:i]
+					break
+				}
+			}
+		}
+		for _, child := range run {
+			logger.Infof("Connected orphan block %x", child.Hash)
 		}
+	}
+}
 
-		block = *DeserializeBlock(blockData)
+// connectBlocks validates and stores blocks whose first parent is known, each the child of the one before it
+// See AddBlocks
+func (bc *Blockchain) connectBlocks(blocks []*Block) error {
+	var pending []*Block
+	for _, block := range blocks {
+		if !bc.HasBlock(block.Hash) {
+			pending = append(pending, block)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	for i := 1; i < len(pending); i++ {
+		if !bytes.Equal(pending[i].PrevBlockHash, pending[i-1].Hash) {
+			return fmt.Errorf("block %x doesn't follow block %x", pending[i].Hash, pending[i-1].Hash)
+		}
+	}
 
+	// Validating against the UTXO set inside the transaction only works on top of the tip it reflects
+	tip := bc.Tip()
+	if !bytes.Equal(pending[0].PrevBlockHash, tip) || !(UTXOSet{bc}).IsCurrent() {
+		for _, block := range pending {
+			if err := bc.connectBlock(block); err != nil {
+				return fmt.Errorf("block %x: %s", block.Hash, err)
+			}
+		}
 		return nil
+	}
+
+	height := bc.GetBestHeight()
+	err := bc.db.Update(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		prevOutput := utxoLookupInTx(tx)
+
+		timestamps, err := recentTimestamps(tx, tip)
+		if err != nil {
+			return err
+		}
+
+		for _, block := range pending {
+			height++
+			// Earlier blocks of the batch are already put, so their headers count towards a retarget
+			bits, err := nextTargetBitsInTx(tx, block.PrevBlockHash, height)
+			if err != nil {
+				return err
+			}
+			err = bc.validateBlockWith(block, height, blockContext{medianTimestamp(timestamps), bits, prevOutput})
+			if err != nil {
+				return fmt.Errorf("block %x: %s", block.Hash, err)
+			}
+
+			err = b.Put(block.Hash, block.Serialize())
+			if err != nil {
+				return err
+			}
+			err = connectBlockUTXO(tx, block, height)
+			if err != nil {
+				return fmt.Errorf("block %x: %s", block.Hash, err)
+			}
+			err = connectBlockTxIndex(tx, block)
+			if err != nil {
+				return err
+			}
+
+			timestamps = append([]int64{block.Timestamp}, timestamps...)
+			if len(timestamps) > medianTimeSpan {
+				timestamps = timestamps[:medianTimeSpan]
+			}
+		}
+
+		return b.Put([]byte("l"), pending[len(pending)-1].Hash)
 	})
 	if err != nil {
-		return block, err
+		return err
 	}
+	bc.setTip(pending[len(pending)-1].Hash)
 
-	return block, nil
+	logger.Infof("Connected %d block(s), new tip %x at height %d", len(pending), pending[len(pending)-1].Hash, height)
+	blocksConnectedTotal.Add(int64(len(pending)))
+	for _, block := range pending {
+		eventBus.Publish(Event{Kind: EventNewBlock, Block: block})
+	}
+
+	return nil
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.db.Update(func(tx *bbolt.Tx) error {
+// connectBlock validates and stores a block whose parent is known
+// The block becomes the tip if it makes its branch longer than the current chain;
+// switching to another branch is a reorganization, after which the UTXO set is rebuilt
+func (bc *Blockchain) connectBlock(block *Block) error {
+	height := 1
+	if len(block.PrevBlockHash) > 0 {
+		parentHeight, err := bc.blockHeight(block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+		height = parentHeight + 1
+	}
+
+	if err := bc.checkReorgDepth(block, height); err != nil {
+		return err
+	}
+	if err := bc.validateBlock(block, height); err != nil {
+		return err
+	}
+
+	bestHeight := bc.GetBestHeight()
+	oldTip := bc.Tip()
+
+	err := bc.db.Update(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
 
-		if blockInDb != nil {
+		err := b.Put(block.Hash, block.Serialize())
+		if err != nil {
+			return err
+		}
+
+		// Longest chain wins; on a tie the branch seen first stays
+		if height <= bestHeight {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		err = b.Put([]byte("l"), block.Hash)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		err = connectBlockUTXO(tx, block, height)
+		if err != nil {
+			return err
+		}
+
+		return connectBlockTxIndex(tx, block)
+	})
+	if err != nil {
+		return err
+	}
+	// Only once committed, as a block failing to connect leaves the DB unchanged
+	if height > bestHeight {
+		bc.setTip(block.Hash)
+	}
 
-		// For now, blindly update the tip.
-		// Ideally we should check if new block's work > current tip's work.
-		// But since we sync in order (Genesis -> Tip), the last added block should be the tip.
-		_ = lastBlock // suppress unused variable if needed, or remove lines above
+	var disconnected []*Transaction
+	if height <= bestHeight {
+		logger.Infof("Stored side-chain block %x at height %d", block.Hash, height)
+	} else if !bytes.Equal(block.PrevBlockHash, oldTip) {
+		logger.Infof("Chain reorganization: new tip %x at height %d", block.Hash, height)
+		bc.blocks.Purge()
+		disconnected = bc.disconnectedTransactions(oldTip, block.Hash)
+	}
 
-		err = b.Put([]byte("l"), block.Hash)
+	// A block that doesn't extend the previous tip leaves the UTXO set and the transaction index stale
+	// Side-chain blocks are validated without knowing what their branch spent, so rebuilding the UTXO set
+	// along the new branch is what catches a branch spending an output twice; the reorganization is then undone
+	if utxos := (UTXOSet{bc}); !utxos.IsCurrent() {
+		err := utxos.Reindex()
+		if err != nil && height > bestHeight && !bytes.Equal(block.PrevBlockHash, oldTip) {
+			if rollbackErr := bc.rollbackTip(block.Hash, oldTip); rollbackErr != nil {
+				log.Panicf("Rolling back to %x after an invalid branch: %s", oldTip, rollbackErr)
+			}
+			return fmt.Errorf("branch of block %x is invalid: %s", block.Hash, err)
+		}
 		if err != nil {
-			log.Panic(err)
+			logger.Errorf("Rebuilding UTXO set: %s", err)
+		}
+	}
+	if txIndex := (TxIndex{bc}); !txIndex.IsCurrent() {
+		if err := txIndex.Reindex(); err != nil {
+			logger.Errorf("Rebuilding transaction index: %s", err)
 		}
-		bc.tip = block.Hash
+	}
+	bc.reinjectTransactions(disconnected)
+	blocksConnectedTotal.Add(1)
+	eventBus.Publish(Event{Kind: EventNewBlock, Block: block})
 
-		return nil
+	return nil
+}
+
+// rollbackTip undoes a reorganization to the invalid block badTip, deleting it and restoring oldTip
+// The UTXO set is left at oldTip by its failed rebuild; the transaction index is rebuilt if it moved
+func (bc *Blockchain) rollbackTip(badTip, oldTip []byte) error {
+	err := bc.db.Update(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if err := b.Delete(badTip); err != nil {
+			return err
+		}
+
+		return b.Put([]byte("l"), oldTip)
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	bc.setTip(oldTip)
+	bc.blocks.Purge()
+
+	if txIndex := (TxIndex{bc}); !txIndex.IsCurrent() {
+		return txIndex.Reindex()
+	}
+
+	return nil
+}
+
+// disconnectedTransactions returns the transactions of the blocks a reorganization from oldTip to newTip
+// takes off the main chain, oldest block first, leaving out coinbases and transactions the new branch includes
+// Similar to Bitcoin's DisconnectedBlockTransactions
+func (bc *Blockchain) disconnectedTransactions(oldTip, newTip []byte) []*Transaction {
+	newChain := make(map[string]bool)
+	for bci := (&BlockchainIterator{newTip, bc.db, bc.blocks}); len(bci.currentHash) > 0; {
+		newChain[hex.EncodeToString(bci.currentHash)] = true
+		bci.Next()
+	}
+
+	// Walk the old branch back to the fork point
+	var oldBranch []*Block
+	for bci := (&BlockchainIterator{oldTip, bc.db, bc.blocks}); len(bci.currentHash) > 0 && !newChain[hex.EncodeToString(bci.currentHash)]; {
+		oldBranch = append(oldBranch, bci.Next())
+	}
+	if len(oldBranch) == 0 {
+		return nil
+	}
+	forkPoint := oldBranch[len(oldBranch)-1].PrevBlockHash
+
+	included := make(map[string]bool)
+	for bci := (&BlockchainIterator{newTip, bc.db, bc.blocks}); len(bci.currentHash) > 0 && !bytes.Equal(bci.currentHash, forkPoint); {
+		for _, tx := range bci.Next().Transactions {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	var txs []*Transaction
+	for i := len(oldBranch) - 1; i >= 0; i-- {
+		for _, tx := range oldBranch[i].Transactions {
+			if !tx.IsCoinbase() && !included[hex.EncodeToString(tx.ID)] {
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	return txs
+}
+
+// reinjectTransactions returns transactions of disconnected blocks to the mempool
+// Parents come before their children, so chains of spends are restored;
+// those the new chain made invalid, e.g. by spending their inputs, are dropped
+func (bc *Blockchain) reinjectTransactions(txs []*Transaction) {
+	restored := 0
+	for _, tx := range txs {
+		if err := bc.AddToMempool(tx); err != nil {
+			logger.Infof("Dropping transaction %x of a disconnected block: %s", tx.ID, err)
+			continue
+		}
+		restored++
+	}
+
+	if len(txs) > 0 {
+		logger.Infof("Returned %d of %d disconnected transaction(s) to the mempool", restored, len(txs))
+	}
+}
+
+// blockContext is what validating a block needs to know about the chain before it
+type blockContext struct {
+	medianTime int64        // Median time past of the block's parent
+	bits       int          // Target bits the block must have
+	prevOutput outputLookup // Finds the outputs the block's transactions spend
+}
+
+// validateBlock checks a block received from a peer, at the height it would take in the chain
+// Its context is read from the stored chain, so the block's parent must be stored
+func (bc *Blockchain) validateBlock(block *Block, height int) error {
+	ctx := blockContext{0, targetBits, bc.prevOutputLookup(block)}
+	if len(block.PrevBlockHash) > 0 {
+		medianTime, err := bc.MedianTimePast(block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+		ctx.medianTime = medianTime
+		ctx.bits, err = bc.NextTargetBits(block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return bc.validateBlockWith(block, height, ctx)
+}
+
+// validateBlockWith checks a block at the height it would take in the chain, given its context
+// The block must match any checkpoint at its height; its seal (proof of work), timestamp and coinbase reward
+// are only checked above the last checkpoint, since the checkpointed chain is already trusted
+// Similar to Geth's consensus.Engine.VerifyHeader()
+func (bc *Blockchain) validateBlockWith(block *Block, height int, ctx blockContext) error {
+	if err := checkpoints.Check(height, block.Hash); err != nil {
+		return err
+	}
+	if height <= checkpoints.LastHeight() {
+		return nil
+	}
+
+	if err := bc.checkConsensus(block.HashAlgo); err != nil {
+		return err
+	}
+	if !bytes.Equal(block.CalculateHash(), block.Hash) {
+		return errors.New("block hash doesn't match its contents")
+	}
+	if err := checkBlockTime(block, ctx.medianTime); err != nil {
+		return err
+	}
+	if bits := block.TargetBits(); bits != ctx.bits {
+		return fmt.Errorf("block has target bits %d, want %d", bits, ctx.bits)
+	}
+	for _, tx := range block.Transactions {
+		if err := tx.ValidateInputs(); err != nil {
+			return fmt.Errorf("transaction %x: %s", tx.ID, err)
+		}
+	}
+	if height > 1 {
+		if err := checkCoinbase(block, height, ctx.prevOutput); err != nil {
+			return err
+		}
+		if err := checkBlockTransactions(block, ctx.prevOutput, bc.chainID); err != nil {
+			return err
+		}
+	}
+	if !bc.sealer.Verify(block) {
+		return errors.New("invalid proof of work")
+	}
+
+	return nil
+}
+
+// checkBlockTransactions fails unless every transaction of the block hashes to its ID, no two share an ID,
+// and every other transaction than the coinbase is signed for chainID by the keys of the outputs it spends,
+// found among the block's earlier transactions or with prevOutput
+// Similar to Bitcoin's CheckInputScripts in ConnectBlock
+func checkBlockTransactions(block *Block, prevOutput outputLookup, chainID int64) error {
+	inBlock := make(map[string]*Transaction)
+	for _, tx := range block.Transactions {
+		id := hex.EncodeToString(tx.ID)
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fmt.Errorf("transaction %x: ID doesn't match its contents", tx.ID)
+		}
+		if inBlock[id] != nil {
+			return fmt.Errorf("transaction %x appears twice in the block", tx.ID)
+		}
+
+		if !tx.IsCoinbase() {
+			// Verify only reads the spent outputs, so one made up of those found is enough for outputs from before the block
+			prevTXs := make(map[string]Transaction)
+			for _, vin := range tx.Vin {
+				prevID := hex.EncodeToString(vin.Txid)
+				if prevTx := inBlock[prevID]; prevTx != nil {
+					prevTXs[prevID] = *prevTx
+					continue
+				}
+				prevOut := prevOutput(vin)
+				if prevOut == nil {
+					return fmt.Errorf("transaction %x: input %x:%d spends an unknown output", tx.ID, vin.Txid, vin.Vout)
+				}
+				prevTx := prevTXs[prevID]
+				prevTx.ID = vin.Txid
+				for len(prevTx.Vout) <= vin.Vout {
+					prevTx.Vout = append(prevTx.Vout, TXOutput{})
+				}
+				prevTx.Vout[vin.Vout] = *prevOut
+				prevTXs[prevID] = prevTx
+			}
+			if err := tx.Verify(prevTXs, chainID); err != nil {
+				return fmt.Errorf("transaction %x: %s", tx.ID, err)
+			}
+		}
+
+		inBlock[id] = tx
 	}
+
+	return nil
+}
+
+// blockHeight returns the height of a stored block by walking back to the genesis block
+func (bc *Blockchain) blockHeight(hash []byte) (int, error) {
+	height := 0
+
+	err := bc.db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		for current := hash; ; {
+			data := b.Get(current)
+			if data == nil {
+				return fmt.Errorf("block %x is not found", current)
+			}
+			block, err := DecodeBlock(data)
+			if err != nil {
+				return err
+			}
+			height++
+
+			if len(block.PrevBlockHash) == 0 {
+				return nil
+			}
+			current = block.PrevBlockHash
+		}
+	})
+
+	return height, err
 }
 
 // NewBlockchain creates a new Blockchain with genesis block
 // Similar to Geth's core.NewBlockChain()
 func NewBlockchain(address, nodeID string) *Blockchain {
-	var tip []byte
+	return CreateBlockchain(address, nodeID, defaultChainID)
+}
+
+// CreateBlockchain opens the blockchain, creating it for chainID if none exists yet
+// The genesis block rewards address; an empty address only opens an existing chain.
+// An existing chain keeps the chain ID it was created with
+func CreateBlockchain(address, nodeID string, chainID int64) *Blockchain {
+	var genesis *Genesis
+	if address != "" {
+		genesis = DefaultGenesis(address)
+	}
+
+	return CreateBlockchainFromGenesis(genesis, nodeID, chainID)
+}
 
+// CreateBlockchainFromGenesis opens the blockchain, creating it from genesis for chainID if none exists yet
+// A nil genesis only opens an existing chain. An existing chain keeps its own genesis and chain ID
+// Similar to Geth's core.SetupGenesisBlock()
+func CreateBlockchainFromGenesis(genesis *Genesis, nodeID string, chainID int64) *Blockchain {
 	// Open database
-	dbPath := fmt.Sprintf(dbFile, nodeID)
-	db, err := bbolt.Open(dbPath, 0600, nil)
+	dbPath := dataFilePath(dbFile, nodeID)
+	store, err := openBoltStore(dbPath, false, 0)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc, err := NewBlockchainWithStore(store, genesis, chainID)
+	if errors.Is(err, errNoBlockchain) {
+		store.Close()
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if errors.Is(err, errWrongChain) {
+		store.Close()
+		fmt.Printf("ERROR: %s: %s\n", dbPath, err)
+		os.Exit(1)
+	}
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.Update(func(tx *bbolt.Tx) error {
+	return bc
+}
+
+// NewBlockchainWithStore opens the blockchain kept in store, creating it from genesis for chainID if store is empty
+// A nil genesis only opens an existing chain, failing with errNoBlockchain if there is none
+// Passing NewMemoryStore() gives a chain that lives only as long as the process, e.g. for tests
+func NewBlockchainWithStore(store Store, genesis *Genesis, chainID int64) (*Blockchain, error) {
+	var tip []byte
+	var consensus HashAlgo
+
+	err := store.Update(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
 			// No blockchain exists
-			if address == "" {
-				fmt.Println("No existing blockchain found. Please create one first using 'createblockchain'.")
-				os.Exit(1)
+			if genesis == nil {
+				return errNoBlockchain
 			}
 
 			// Create genesis block
 			fmt.Println("No existing blockchain found. Creating a new one...")
-			cbtx := NewCoinbaseTX(address, "Genesis Block")
-			genesis := NewBlock([]*Transaction{cbtx}, []byte{})
+			genesisBlock := genesis.ToBlock(defaultSealer)
 
 			// Create bucket
 			b, err := tx.CreateBucket([]byte(blocksBucket))
@@ -434,13 +1616,13 @@ func NewBlockchain(address, nodeID string) *Blockchain {
 			}
 
 			// Store genesis block
-			err = b.Put(genesis.Hash, genesis.Serialize())
+			err = b.Put(genesisBlock.Hash, genesisBlock.Serialize())
 			if err != nil {
 				log.Panic(err)
 			}
 
 			// Store last block hash
-			err = b.Put([]byte("l"), genesis.Hash)
+			err = b.Put([]byte("l"), genesisBlock.Hash)
 			if err != nil {
 				log.Panic(err)
 			}
@@ -451,26 +1633,199 @@ func NewBlockchain(address, nodeID string) *Blockchain {
 				log.Panic(err)
 			}
 
-			tip = genesis.Hash
+			// Record the chain ID
+			meta, err := tx.CreateBucket([]byte(metaBucket))
+			if err != nil {
+				log.Panic(err)
+			}
+			err = meta.Put([]byte(chainIDKey), IntToHex(chainID))
+			if err != nil {
+				log.Panic(err)
+			}
+
+			// Record the genesis block and network, checked whenever the DB is opened
+			err = meta.Put([]byte(genesisKey), genesisBlock.Hash)
+			if err != nil {
+				log.Panic(err)
+			}
+			err = meta.Put([]byte(networkKey), []byte(activeNetwork.Name))
+			if err != nil {
+				log.Panic(err)
+			}
+			err = meta.Put([]byte(consensusKey), []byte(consensusName(genesisBlock.HashAlgo)))
+			if err != nil {
+				log.Panic(err)
+			}
+			if compressBlocks {
+				err = meta.Put([]byte(blockCompressionKey), []byte(blockCompressionGzip))
+				if err != nil {
+					log.Panic(err)
+				}
+			}
+
+			// Start the UTXO set with the genesis outputs
+			_, err = tx.CreateBucket([]byte(utxoBucket))
+			if err != nil {
+				log.Panic(err)
+			}
+			err = connectBlockUTXO(tx, genesisBlock, 1)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			// And the transaction index with the genesis transactions
+			_, err = tx.CreateBucket([]byte(txIndexBucket))
+			if err != nil {
+				log.Panic(err)
+			}
+			err = connectBlockTxIndex(tx, genesisBlock)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			tip = genesisBlock.Hash
+			consensus = genesisBlock.HashAlgo
 		} else {
 			// Blockchain exists, load the tip
-			tip = b.Get([]byte("l"))
+			tip = append([]byte{}, b.Get([]byte("l"))...)
 
 			// Ensure mempool bucket exists (migration for existing DBs)
 			if tx.Bucket([]byte(mempoolBucket)) == nil {
-				_, err = tx.CreateBucket([]byte(mempoolBucket))
+				_, err := tx.CreateBucket([]byte(mempoolBucket))
+				if err != nil {
+					log.Panic(err)
+				}
+			}
+
+			// Ensure meta bucket exists (migration for DBs created before chain IDs)
+			meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+			if err != nil {
+				log.Panic(err)
+			}
+			storedChainID := meta.Get([]byte(chainIDKey))
+			if storedChainID == nil {
+				err = meta.Put([]byte(chainIDKey), IntToHex(defaultChainID))
 				if err != nil {
 					log.Panic(err)
 				}
+				chainID = defaultChainID
+			} else {
+				chainID = int64(binary.BigEndian.Uint64(storedChainID))
 			}
+
+			err = checkChainIdentity(tx, tip)
+			if err != nil {
+				return err
+			}
+			blockCompressionInTx(tx)
+
+			consensus, err = chainConsensusInTx(tx, tip)
+			return err
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	bc := &Blockchain{tip: tip, db: store, chainID: chainID, sealer: defaultSealer, blocks: newBlockCache(blockCacheSize), consensus: consensus}
+
+	// DBs written before the UTXO set existed need it built once, and a set left behind the tip
+	// (e.g. by an older binary that wrote blocks without it) is rebuilt before any balance is served
+	if utxos := (UTXOSet{bc}); !utxos.IsCurrent() {
+		if indexed := utxos.IndexedTip(); indexed != nil {
+			logger.Warnf("UTXO set is indexed at block %x but the tip is %x; rebuilding", indexed, tip)
+		} else {
+			logger.Infof("Rebuilding UTXO set")
+		}
+		err = utxos.Reindex()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Likewise for the transaction index, which DBs written before it existed lack
+	if txIndex := (TxIndex{bc}); !txIndex.IsCurrent() {
+		logger.Infof("Rebuilding transaction index")
+		err = txIndex.Reindex()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bc, nil
+}
+
+// OpenBlockchainReadOnly opens an existing blockchain for queries only
+// bbolt lets several read-only handles share the DB, so queries don't lock each other out
+func OpenBlockchainReadOnly(nodeID string) *Blockchain {
+	bc, err := openBlockchainReadOnly(nodeID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return bc
+}
+
+// openBlockchainReadOnly is OpenBlockchainReadOnly returning its errors
+// It fails with errNoBlockchain if the DB doesn't exist and with errNoBlocks if it exists but holds no blocks
+func openBlockchainReadOnly(nodeID string) (*Blockchain, error) {
+	dbPath := dataFilePath(dbFile, nodeID)
+	info, err := os.Stat(dbPath)
+	if os.IsNotExist(err) {
+		return nil, errNoBlockchain
+	}
+	// bbolt can't open an empty file read-only; only a writer initializes it
+	if err == nil && info.Size() == 0 {
+		return nil, errNoBlocks
+	}
+
+	db, err := openBoltStore(dbPath, true, readOnlyOpenTimeout)
+	if err == bbolt.ErrTimeout {
+		return nil, fmt.Errorf("ERROR: %s is locked for writing by another process", dbPath)
+	}
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
-	return &bc
+	var tip []byte
+	var consensus HashAlgo
+	chainID := int64(defaultChainID)
+	err = db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if b == nil {
+			return errNoBlocks
+		}
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+		if len(tip) == 0 {
+			return errNoBlocks
+		}
+
+		// DBs created before chain IDs have no meta bucket and use the default
+		if meta := tx.Bucket([]byte(metaBucket)); meta != nil {
+			if storedChainID := meta.Get([]byte(chainIDKey)); storedChainID != nil {
+				chainID = int64(binary.BigEndian.Uint64(storedChainID))
+			}
+		}
+
+		if err := checkChainIdentity(tx, tip); err != nil {
+			return fmt.Errorf("ERROR: %s: %s", dbPath, err)
+		}
+
+		var err error
+		consensus, err = chainConsensusInTx(tx, tip)
+		if err != nil {
+			return fmt.Errorf("ERROR: %s: %s", dbPath, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Blockchain{tip: tip, db: db, chainID: chainID, sealer: defaultSealer, blocks: newBlockCache(blockCacheSize), consensus: consensus}, nil
 }
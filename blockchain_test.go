@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestChain returns an in-memory chain whose genesis block pays w, mined at a low difficulty
+func newTestChain(t testing.TB) (*Blockchain, *Wallet) {
+	t.Helper()
+
+	return newTestChainWithStore(t, NewMemoryStore())
+}
+
+// newTestChainWithStore returns a chain kept in store whose genesis block pays w, mined at a low difficulty
+func newTestChainWithStore(t testing.TB, store Store) (*Blockchain, *Wallet) {
+	t.Helper()
+
+	bits := targetBits
+	if err := SetTargetBits(4); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTargetBits(bits) })
+
+	w := NewWallet()
+	bc, err := NewBlockchainWithStore(store, DefaultGenesis(string(w.GetAddress())), defaultChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.db.Close() })
+
+	return bc, w
+}
+
+// spendCoinbase returns a transaction of w paying all but fee of the genesis coinbase to to, signed on bc
+func spendCoinbase(t *testing.T, bc *Blockchain, w *Wallet, to string, fee int) *Transaction {
+	t.Helper()
+
+	genesis, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return spendOutput(t, bc, w, genesis.Transactions[0], 0, to, fee, false)
+}
+
+// peerBlock mines a block on bc's tip the way a peer would, without storing it
+func peerBlock(t *testing.T, bc *Blockchain, txs ...*Transaction) *Block {
+	t.Helper()
+
+	return blockOn(t, bc, bc.Tip(), txs...)
+}
+
+// blockOn mines a block on the stored block parent, without storing it
+// Its timestamp follows the median time past, so blocks mined within the same second stay valid
+func blockOn(t *testing.T, bc *Blockchain, parent []byte, txs ...*Transaction) *Block {
+	t.Helper()
+
+	medianTime, err := bc.MedianTimePast(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newBlockAt(txs, parent, medianTime+1, bc.consensus, targetBits, bc.sealer)
+}
+
+// chainBlocks mines a branch on bc's tip, one block a second, with the given transactions in each block,
+// without storing it
+func chainBlocks(t testing.TB, bc *Blockchain, txs ...[]*Transaction) []*Block {
+	t.Helper()
+
+	return branchBlocks(t, bc, bc.Tip(), txs...)
+}
+
+// branchBlocks mines a branch on the stored block parentHash as chainBlocks does on the tip
+func branchBlocks(t testing.TB, bc *Blockchain, parentHash []byte, txs ...[]*Transaction) []*Block {
+	t.Helper()
+
+	parent, err := bc.GetBlock(parentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks []*Block
+	for i, blockTxs := range txs {
+		block := newBlockAt(blockTxs, parent.Hash, parent.Timestamp+1, bc.consensus, parent.TargetBits(), bc.sealer)
+		blocks = append(blocks, block)
+		parent = *blocks[i]
+	}
+
+	return blocks
+}
+
+// coinbaseBlocks returns the transactions of n blocks on bc's tip paying address, one coinbase each
+func coinbaseBlocks(bc *Blockchain, address string, n int) [][]*Transaction {
+	txs := make([][]*Transaction, n)
+	for i := range txs {
+		txs[i] = []*Transaction{NewCoinbaseTX(address, "", 0, bc.GetBestHeight()+i+1)}
+	}
+
+	return txs
+}
+
+func TestAddBlockChecksTransactions(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   func(bc *Blockchain, w *Wallet) *Block
+		wantErr string
+	}{
+		{"valid spend", func(bc *Blockchain, w *Wallet) *Block {
+			spend := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+			return peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 1, 2), spend)
+		}, ""},
+		{"bad signature", func(bc *Blockchain, w *Wallet) *Block {
+			spend := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+			spend.Vin[0].Signature[5] ^= 0xff
+			spend.ID = spend.Hash()
+			return peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 1, 2), spend)
+		}, "invalid signature"},
+		{"stolen output", func(bc *Blockchain, w *Wallet) *Block {
+			thief := NewWallet()
+			spend := spendCoinbase(t, bc, w, string(thief.GetAddress()), 1)
+			spend.Vin[0].PubKey = thief.PublicKey
+			spend.ID = spend.Hash()
+			return peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 1, 2), spend)
+		}, "invalid signature"},
+		{"ID not matching contents", func(bc *Blockchain, w *Wallet) *Block {
+			spend := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+			spend.Vout[0].Value--
+			return peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 2, 2), spend)
+		}, "ID doesn't match"},
+		{"duplicate transaction", func(bc *Blockchain, w *Wallet) *Block {
+			spend := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+			return peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 2, 2), spend, spend)
+		}, "appears twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			block := tt.block(bc, w)
+
+			err := bc.AddBlock(block)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("AddBlock: %s", err)
+				}
+				if bc.GetBestHeight() != 2 {
+					t.Fatalf("height %d after a valid block, want 2", bc.GetBestHeight())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("AddBlock = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if bc.HasBlock(block.Hash) {
+				t.Fatal("rejected block was stored")
+			}
+		})
+	}
+}
+
+func TestAddBlockRejectsOverwritingUnspentOutputs(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+
+	// A coinbase with the same recipient, data and height has the same ID
+	first := NewCoinbaseTX(address, "same", 0, 2)
+	if err := bc.AddBlock(peerBlock(t, bc, first)); err != nil {
+		t.Fatalf("AddBlock: %s", err)
+	}
+	repeat := NewCoinbaseTX(address, "same", 0, 2)
+	err := bc.AddBlock(peerBlock(t, bc, repeat))
+	if err == nil || !strings.Contains(err.Error(), "unspent outputs") {
+		t.Fatalf("AddBlock of a repeated coinbase = %v, want an error about unspent outputs", err)
+	}
+}
+
+func TestRejectsReusingSpentOutpoint(t *testing.T) {
+	tests := []struct {
+		name  string
+		reuse func(bc *Blockchain, w *Wallet, respend *Transaction) error
+	}{
+		{"mempool transaction", func(bc *Blockchain, w *Wallet, respend *Transaction) error {
+			return bc.AddToMempool(respend)
+		}},
+		{"block transaction", func(bc *Blockchain, w *Wallet, respend *Transaction) error {
+			return bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 2, 3), respend))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			genesis, err := bc.GetBlock(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			spend := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 1, 2), spend)); err != nil {
+				t.Fatal(err)
+			}
+			tip := bc.Tip()
+
+			// The genesis coinbase output, spent on chain, paid again to someone else
+			respend := spendOutput(t, bc, w, genesis.Transactions[0], 0, string(NewWallet().GetAddress()), 2, false)
+			if err := tt.reuse(bc, w, respend); err == nil {
+				t.Fatal("transaction reusing a spent outpoint was accepted")
+			}
+			if !bytes.Equal(bc.Tip(), tip) || len(bc.GetMempool()) != 0 {
+				t.Fatal("rejected transaction changed the tip or the mempool")
+			}
+		})
+	}
+}
+
+func TestVerifyTransactionChecksValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		fee     int // Negative to pay out more than the input holds
+		wantErr bool
+	}{
+		{"valid payment", 1, false},
+		{"no fee", 0, false},
+		{"inflation by one coin", -1, true},
+		{"inflation by many coins", -subsidy * 100, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			// The signatures are valid; only the values give the inflation away
+			tx := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), tt.fee)
+
+			if err := bc.VerifyTransaction(tx); (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyTransaction = %v, want error %t", err, tt.wantErr)
+			}
+			claimed := tt.fee
+			if claimed < 0 {
+				claimed = 0
+			}
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "", claimed, 2), tx)); (err != nil) != tt.wantErr {
+				t.Fatalf("AddBlock = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFailedBlockLeavesTip(t *testing.T) {
+	bc, w := newTestChain(t)
+	tip := bc.Tip()
+
+	// Both spends are valid alone; the second fails only once the first has spent the output
+	first := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+	second := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 2)
+	block := peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 3, 2), first, second)
+	if err := bc.AddBlock(block); err == nil {
+		t.Fatal("block double-spending an output was accepted")
+	}
+
+	if !bytes.Equal(bc.Tip(), tip) {
+		t.Fatalf("tip moved to %x after a rejected block", bc.Tip())
+	}
+	if height := bc.GetBestHeight(); height != 1 {
+		t.Fatalf("height %d after a rejected block, want 1", height)
+	}
+}
+
+// Run with -race: mining, accepting peer blocks and reading the tip happen on separate goroutines, as in a node
+func TestConcurrentMineAndAddBlock(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+	const blocks = 10
+
+	var writers sync.WaitGroup
+	var readers sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, 4*blocks)
+
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				bc.GetBestHeight()
+				if _, err := bc.GetBlock(bc.Tip()); err != nil {
+					errs <- fmt.Errorf("tip isn't stored: %s", err)
+					return
+				}
+			}
+		}()
+	}
+
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < blocks; i++ {
+			bc.MineBlock([]*Transaction{NewCoinbaseTX(address, fmt.Sprintf("mined %d", i), 0, bc.GetBestHeight()+1)})
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < blocks; i++ {
+			tip := bc.Tip()
+			medianTime, err := bc.MedianTimePast(tip)
+			if err != nil {
+				errs <- err
+				return
+			}
+			coinbase := NewCoinbaseTX(address, fmt.Sprintf("peer %d", i), 0, bc.GetBestHeight()+1)
+			block := newBlockAt([]*Transaction{coinbase}, tip, medianTime+1, bc.consensus, targetBits, bc.sealer)
+			// The miner may have moved the tip meanwhile, making this a side-chain block or an invalid one
+			bc.AddBlock(block)
+		}
+	}()
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if height := bc.GetBestHeight(); height < blocks+1 {
+		t.Fatalf("height %d after mining %d blocks, want at least %d", height, blocks, blocks+1)
+	}
+}
+
+func TestAddBlocksRollsBackFailedBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		bad  int // Index of the invalid block in a batch of four
+	}{
+		{"first block", 0},
+		{"middle block", 2},
+		{"last block", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			tip := bc.Tip()
+			supply, err := (UTXOSet{bc}).TotalSupply()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A coinbase claiming fees nobody paid makes its block invalid
+			txs := coinbaseBlocks(bc, address, 4)
+			txs[tt.bad] = []*Transaction{NewCoinbaseTX(address, "", 1000, bc.GetBestHeight()+tt.bad+1)}
+			blocks := chainBlocks(t, bc, txs...)
+
+			if err := bc.AddBlocks(blocks); err == nil {
+				t.Fatal("batch with an invalid block was accepted")
+			}
+			if !bytes.Equal(bc.Tip(), tip) || bc.GetBestHeight() != 1 {
+				t.Fatalf("tip %x at height %d after the failed batch, want %x at 1", bc.Tip(), bc.GetBestHeight(), tip)
+			}
+			for i, block := range blocks {
+				if bc.HasBlock(block.Hash) {
+					t.Fatalf("block %d of the failed batch was stored", i)
+				}
+			}
+			utxos := UTXOSet{bc}
+			if got, err := utxos.TotalSupply(); err != nil || got != supply || !utxos.IsCurrent() {
+				t.Fatalf("UTXO set supply %d (current %t, %v) after the failed batch, want %d", got, utxos.IsCurrent(), err, supply)
+			}
+
+			// The valid blocks before the bad one still connect as a batch afterwards
+			if err := bc.AddBlocks(blocks[:tt.bad]); err != nil {
+				t.Fatalf("AddBlocks of the valid prefix: %s", err)
+			}
+			if height := bc.GetBestHeight(); height != tt.bad+1 {
+				t.Fatalf("height %d after the valid prefix, want %d", height, tt.bad+1)
+			}
+		})
+	}
+}
+
+// BenchmarkAddBlocks compares connecting 100 blocks to a bbolt DB one DB transaction each and as a single batch
+func BenchmarkAddBlocks(b *testing.B) {
+	const blocks = 100
+
+	for _, batch := range []bool{false, true} {
+		name := "per block"
+		if batch {
+			name = "batch"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				store, err := openBoltStore(filepath.Join(b.TempDir(), "blockchain.db"), false, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				bc, w := newTestChainWithStore(b, store)
+				branch := chainBlocks(b, bc, coinbaseBlocks(bc, string(w.GetAddress()), blocks)...)
+				b.StartTimer()
+
+				if batch {
+					err = bc.AddBlocks(branch)
+				} else {
+					for _, block := range branch {
+						if err = bc.AddBlock(block); err != nil {
+							break
+						}
+					}
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFindUnspentTransactionsFollowsTip(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, bc *Blockchain, w *Wallet)
+		want   int // Unspent transactions of w afterwards; w starts with the genesis coinbase
+	}{
+		{"nothing happens", func(t *testing.T, bc *Blockchain, w *Wallet) {}, 1},
+		{"block paying the address", func(t *testing.T, bc *Blockchain, w *Wallet) {
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 0, 2))); err != nil {
+				t.Fatal(err)
+			}
+		}, 2},
+		{"block spending from the address", func(t *testing.T, bc *Blockchain, w *Wallet) {
+			other := string(NewWallet().GetAddress())
+			spend := spendCoinbase(t, bc, w, other, 1)
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(other, "", 1, 2), spend)); err != nil {
+				t.Fatal(err)
+			}
+		}, 0},
+		{"reorganization to a branch paying the address", func(t *testing.T, bc *Blockchain, w *Wallet) {
+			genesis := bc.Tip()
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(NewWallet().GetAddress()), "", 0, 2))); err != nil {
+				t.Fatal(err)
+			}
+			bc.FindUnspentTransactions(HashPubKey(w.PublicKey))
+
+			side := blockOn(t, bc, genesis, NewCoinbaseTX(string(w.GetAddress()), "side", 0, 2))
+			if err := bc.AddBlock(side); err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.AddBlock(blockOn(t, bc, side.Hash, NewCoinbaseTX(string(w.GetAddress()), "side", 0, 3))); err != nil {
+				t.Fatal(err)
+			}
+		}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			pubKeyHash := HashPubKey(w.PublicKey)
+			if got := len(bc.FindUnspentTransactions(pubKeyHash)); got != 1 {
+				t.Fatalf("%d unspent transactions at the genesis block, want 1", got)
+			}
+
+			tt.change(t, bc, w)
+			got := bc.FindUnspentTransactions(pubKeyHash)
+			if len(got) != tt.want {
+				t.Fatalf("%d unspent transactions, want %d", len(got), tt.want)
+			}
+			if scanned := bc.findUnspentTransactions(bc.Tip(), pubKeyHash); len(scanned) != len(got) {
+				t.Fatalf("memoized %d unspent transactions, a scan finds %d", len(got), len(scanned))
+			}
+		})
+	}
+}
+
+func TestFindUnspentTransactionsKeptForSideChainBlock(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+	genesis := bc.Tip()
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	before := bc.FindUnspentTransactions(HashPubKey(w.PublicKey))
+	if err := bc.AddBlock(blockOn(t, bc, genesis, NewCoinbaseTX(address, "side", 0, 2))); err != nil {
+		t.Fatal(err)
+	}
+	after := bc.FindUnspentTransactions(HashPubKey(w.PublicKey))
+	if len(before) == 0 || len(after) != len(before) || &after[0] != &before[0] {
+		t.Fatal("a side-chain block that leaves the tip discarded the memoized result")
+	}
+}
+
+// BenchmarkFindUnspentTransactions repeats a query at the same tip, memoized and scanning the chain each time
+func BenchmarkFindUnspentTransactions(b *testing.B) {
+	bc, w := newTestChain(b)
+	if err := bc.AddBlocks(chainBlocks(b, bc, coinbaseBlocks(bc, string(w.GetAddress()), 100)...)); err != nil {
+		b.Fatal(err)
+	}
+	pubKeyHash := HashPubKey(w.PublicKey)
+
+	b.Run("memoized", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bc.FindUnspentTransactions(pubKeyHash)
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bc.findUnspentTransactions(bc.Tip(), pubKeyHash)
+		}
+	})
+}
+
+// spendOutput returns a transaction paying output vout of prev, less fee, from w to to
+func spendOutput(t *testing.T, bc *Blockchain, w *Wallet, prev *Transaction, vout int, to string, fee int, replaceable bool) *Transaction {
+	t.Helper()
+
+	tx := &Transaction{nil, []TXInput{{prev.ID, vout, nil, w.PublicKey, nil}}, []TXOutput{*NewTXOutput(prev.Vout[vout].Value-fee, to)}, replaceable, nil}
+	tx.ID = tx.Hash()
+	if err := bc.SignTransaction(tx, w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestAddToMempoolReplacement(t *testing.T) {
+	tests := []struct {
+		name        string
+		replaceable bool // Whether the original payment signals replaceability
+		originalFee int
+		childFee    int // Fee of a mempool child of the original; 0 for none
+		fee         int // Of the replacement
+		wantReplace bool
+	}{
+		{"original not replaceable", false, 1, 0, 5, false},
+		{"higher fee", true, 1, 0, 2, true},
+		{"same fee", true, 2, 0, 2, false},
+		{"lower fee", true, 3, 0, 2, false},
+		{"higher fee evicts the child", true, 1, 1, 3, true},
+		{"fee below original and child", true, 1, 2, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			genesis, err := bc.GetBlock(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			coinbase := genesis.Transactions[0]
+
+			original := spendOutput(t, bc, w, coinbase, 0, address, tt.originalFee, tt.replaceable)
+			if err := bc.AddToMempool(original); err != nil {
+				t.Fatalf("original: %s", err)
+			}
+			pooled := []*Transaction{original}
+			if tt.childFee > 0 {
+				child := spendOutput(t, bc, w, original, 0, address, tt.childFee, false)
+				if err := bc.AddToMempool(child); err != nil {
+					t.Fatalf("child: %s", err)
+				}
+				pooled = append(pooled, child)
+			}
+
+			replacement := spendOutput(t, bc, w, coinbase, 0, string(NewWallet().GetAddress()), tt.fee, false)
+			evicted, err := bc.CheckMempoolAccept(replacement)
+			if (err == nil) != tt.wantReplace {
+				t.Fatalf("CheckMempoolAccept = %v, want replacement %t", err, tt.wantReplace)
+			}
+			if err := bc.AddToMempool(replacement); (err == nil) != tt.wantReplace {
+				t.Fatalf("AddToMempool = %v, want replacement %t", err, tt.wantReplace)
+			}
+
+			want := pooled
+			if tt.wantReplace {
+				if len(evicted) != len(pooled) {
+					t.Fatalf("%d transactions evicted, want %d", len(evicted), len(pooled))
+				}
+				want = []*Transaction{replacement}
+			}
+			mempool := bc.GetMempool()
+			if len(mempool) != len(want) {
+				t.Fatalf("mempool holds %d transactions, want %d", len(mempool), len(want))
+			}
+			for _, tx := range want {
+				if _, err := bc.findMempoolTransaction(tx.ID); err != nil {
+					t.Fatalf("transaction %x missing from the mempool", tx.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTransactionConfirmations(t *testing.T) {
+	tests := []struct {
+		name    string
+		above   int  // Blocks mined on top of the one holding the payment
+		prune   bool // Prune every block but the tip
+		reindex bool // Drop the transaction index, so lookups scan the chain
+		want    int
+	}{
+		{"in the tip", 0, false, false, 1},
+		{"one block deep", 1, false, false, 2},
+		{"several blocks deep", 4, false, false, 5},
+		{"in a pruned block", 2, true, false, 3},
+		{"without the index", 3, false, true, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			spend := spendCoinbase(t, bc, w, address, 1)
+			if err := bc.AddBlocks(chainBlocks(t, bc, []*Transaction{NewCoinbaseTX(address, "", 1, 2), spend})); err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.AddBlocks(chainBlocks(t, bc, coinbaseBlocks(bc, address, tt.above)...)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.prune {
+				if _, err := bc.Prune(1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.reindex {
+				if err := bc.db.Update(func(tx StoreTx) error { return tx.DeleteBucket([]byte(txIndexBucket)) }); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got, err := bc.GetTransactionConfirmations(spend.ID); err != nil || got != tt.want {
+				t.Fatalf("GetTransactionConfirmations = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTransactionConfirmationsOutsideTheChain(t *testing.T) {
+	bc, w := newTestChain(t)
+	spend := spendCoinbase(t, bc, w, string(w.GetAddress()), 1)
+	if err := bc.AddToMempool(spend); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := bc.GetTransactionConfirmations(spend.ID); err != nil || got != 0 {
+		t.Fatalf("mempool transaction: GetTransactionConfirmations = %d, %v, want 0", got, err)
+	}
+	if _, err := bc.GetTransactionConfirmations(NewWallet().PublicKey); err == nil {
+		t.Fatal("GetTransactionConfirmations of an unknown transaction succeeded")
+	}
+}
+
+func TestRemoveFromMempool(t *testing.T) {
+	tests := []struct {
+		name        string
+		remove      string
+		wantRemoved []string
+	}{
+		{"parent with its child", "parent", []string{"parent", "child", "grandchild"}},
+		{"child with its own child", "child", []string{"child", "grandchild"}},
+		{"transaction without children", "unrelated", []string{"unrelated"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			genesis, err := bc.GetBlock(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks := chainBlocks(t, bc, coinbaseBlocks(bc, address, 1)...)
+			if err := bc.AddBlocks(blocks); err != nil {
+				t.Fatal(err)
+			}
+
+			pooled := make(map[string]*Transaction)
+			pooled["parent"] = spendOutput(t, bc, w, genesis.Transactions[0], 0, address, 1, false)
+			pooled["unrelated"] = spendOutput(t, bc, w, blocks[0].Transactions[0], 0, address, 1, false)
+			for _, name := range []string{"parent", "unrelated"} {
+				if err := bc.AddToMempool(pooled[name]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pooled["child"] = spendOutput(t, bc, w, pooled["parent"], 0, address, 1, false)
+			if err := bc.AddToMempool(pooled["child"]); err != nil {
+				t.Fatal(err)
+			}
+			pooled["grandchild"] = spendOutput(t, bc, w, pooled["child"], 0, address, 1, false)
+			if err := bc.AddToMempool(pooled["grandchild"]); err != nil {
+				t.Fatal(err)
+			}
+
+			descendants, err := bc.RemoveFromMempool(pooled[tt.remove].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(descendants) != len(tt.wantRemoved)-1 {
+				t.Fatalf("%d descendants removed, want %d", len(descendants), len(tt.wantRemoved)-1)
+			}
+
+			removed := make(map[string]bool)
+			for _, name := range tt.wantRemoved {
+				removed[name] = true
+			}
+			for name, tx := range pooled {
+				_, err := bc.findMempoolTransaction(tx.ID)
+				if inMempool := err == nil; inMempool == removed[name] {
+					t.Errorf("%s in the mempool: %t, want %t", name, inMempool, !removed[name])
+				}
+			}
+			if _, err := bc.RemoveFromMempool(pooled[tt.remove].ID); err == nil {
+				t.Fatal("removing a transaction twice succeeded")
+			}
+		})
+	}
+}
+
+func TestRemoveMinedFromMempoolKeepsDescendants(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+	parent := spendCoinbase(t, bc, w, address, 1)
+	if err := bc.AddToMempool(parent); err != nil {
+		t.Fatal(err)
+	}
+	child := spendOutput(t, bc, w, parent, 0, address, 1, false)
+	if err := bc.AddToMempool(child); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the parent is mined, the child spends an output on chain and can go into the next block
+	if err := bc.RemoveMinedFromMempool([]*Transaction{parent}); err != nil {
+		t.Fatal(err)
+	}
+	mempool := bc.GetMempool()
+	if len(mempool) != 1 || !bytes.Equal(mempool[0].ID, child.ID) {
+		t.Fatalf("mempool holds %d transactions, want only the child", len(mempool))
+	}
+}
+
+func TestRevalidateMempoolDropsStale(t *testing.T) {
+	store := NewMemoryStore()
+	bc, w := newTestChainWithStore(t, store)
+	address := string(w.GetAddress())
+	spend := spendCoinbase(t, bc, w, address, 1)
+	if err := bc.AddToMempool(spend); err != nil {
+		t.Fatal(err)
+	}
+	child := spendOutput(t, bc, w, spend, 0, address, 1, false)
+	conflict := spendCoinbase(t, bc, w, address, 2)
+
+	// A block spends the input of the mempool transaction; a node that was down while it was mined
+	// still has its mempool stored as it was, so put it back the way AddBlocks would have evicted it
+	coinbase := NewCoinbaseTX(address, "", 2, 2)
+	if err := bc.AddBlocks(chainBlocks(t, bc, []*Transaction{coinbase, conflict})); err != nil {
+		t.Fatal(err)
+	}
+	valid := spendOutput(t, bc, w, coinbase, 0, address, 1, false)
+	err := bc.db.Update(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(mempoolBucket))
+		for _, stored := range []*Transaction{spend, child, valid} {
+			if err := b.Put(stored.ID, stored.Serialize()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewBlockchainWithStore(store, DefaultGenesis(address), defaultChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, dropped, err := reopened.RevalidateMempool()
+	if err != nil || kept != 1 || dropped != 2 {
+		t.Fatalf("kept %d, dropped %d, %v; want the double spend and its child dropped", kept, dropped, err)
+	}
+	if mempool := reopened.GetMempool(); len(mempool) != 1 || !bytes.Equal(mempool[0].ID, valid.ID) {
+		t.Fatalf("mempool holds %d transactions, want only the valid one", len(mempool))
+	}
+}
+
+func TestOpenBlockchainReadOnly(t *testing.T) {
+	useDataDir(t)
+	if _, err := openBlockchainReadOnly("3000"); err != errNoBlockchain {
+		t.Fatalf("opening a missing DB: %v, want %v", err, errNoBlockchain)
+	}
+	if err := os.WriteFile(dataFilePath(dbFile, "3001"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openBlockchainReadOnly("3001"); err != errNoBlocks {
+		t.Fatalf("opening an empty DB: %v, want %v", err, errNoBlocks)
+	}
+
+	bc, _ := newDiskTestChain(t, "3000")
+	tip := bc.Tip()
+	if _, err := openBlockchainReadOnly("3000"); err == nil || !strings.Contains(err.Error(), "locked for writing") {
+		t.Fatalf("opening a DB open for writing: %v, want it reported locked", err)
+	}
+	bc.db.Close()
+
+	// Queries share the DB
+	first, err := openBlockchainReadOnly("3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.db.Close()
+	second, err := openBlockchainReadOnly("3000")
+	if err != nil {
+		t.Fatalf("opening a DB already open read-only: %s", err)
+	}
+	defer second.db.Close()
+
+	for _, chain := range []*Blockchain{first, second} {
+		if !bytes.Equal(chain.Tip(), tip) || chain.GetBestHeight() != 1 {
+			t.Fatalf("read-only chain at %x, height %d, want the written chain", chain.Tip(), chain.GetBestHeight())
+		}
+	}
+}
+
+func TestCoinbasesToOneAddressAccumulate(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+
+	if err := bc.AddBlocks(chainBlocks(t, bc, coinbaseBlocks(bc, address, 2)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, hash := range bc.GetBlockHashes() {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := fmt.Sprintf("%x", block.Transactions[0].ID)
+		if seen[id] {
+			t.Fatalf("coinbase %s appears in two blocks", id)
+		}
+		seen[id] = true
+	}
+
+	for _, minConf := range []int{0, 1} {
+		balance, err := bc.Balance(HashPubKey(w.PublicKey), minConf)
+		if err != nil || balance != 3*subsidy {
+			t.Fatalf("Balance with minconf %d = %d, %v, want %d from three coinbases", minConf, balance, err, 3*subsidy)
+		}
+	}
+	if supply, err := (UTXOSet{bc}).TotalSupply(); err != nil || supply != 3*subsidy {
+		t.Fatalf("TotalSupply = %d, %v, want %d", supply, err, 3*subsidy)
+	}
+}
+
+func TestGetRawTransaction(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+
+	coinbase := NewCoinbaseTX(address, "", 1, 2)
+	mined := spendCoinbase(t, bc, w, address, 1)
+	block := peerBlock(t, bc, coinbase, mined)
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	pending := spendOutput(t, bc, w, coinbase, 0, address, 1, false)
+	if err := bc.AddToMempool(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tx        *Transaction
+		wantBlock []byte
+		wantErr   bool
+	}{
+		{"in a block", mined, block.Hash, false},
+		{"in the mempool", pending, nil, false},
+		{"not found", spendOutput(t, bc, w, pending, 0, address, 1, false), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, blockHash, err := bc.GetRawTransaction(tt.tx.ID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("GetRawTransaction found a transaction that was never submitted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(blockHash, tt.wantBlock) {
+				t.Fatalf("found in block %x, want %x", blockHash, tt.wantBlock)
+			}
+			decoded, err := DecodeTransaction(raw)
+			if err != nil || !bytes.Equal(decoded.ID, tt.tx.ID) || !bytes.Equal(raw, tt.tx.Serialize()) {
+				t.Fatalf("raw transaction doesn't decode to the original: %v", err)
+			}
+		})
+	}
+}
+
+func TestSubmitRawTransaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     func(bc *Blockchain, w *Wallet) []byte
+		wantErr string
+	}{
+		{"valid", func(bc *Blockchain, w *Wallet) []byte {
+			return spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1).Serialize()
+		}, ""},
+		{"truncated", func(bc *Blockchain, w *Wallet) []byte {
+			raw := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1).Serialize()
+			return raw[:len(raw)/2]
+		}, "malformed transaction"},
+		{"coinbase", func(bc *Blockchain, w *Wallet) []byte {
+			return NewCoinbaseTX(string(w.GetAddress()), "", 0, 2).Serialize()
+		}, "can only be mined"},
+		{"bad signature", func(bc *Blockchain, w *Wallet) []byte {
+			tx := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+			tx.Vin[0].Signature[3] ^= 0xff
+			tx.ID = tx.Hash()
+			return tx.Serialize()
+		}, "failed verification"},
+		{"below the relay fee", func(bc *Blockchain, w *Wallet) []byte {
+			return spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 0).Serialize()
+		}, "fee"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+
+			tx, err := bc.SubmitRawTransaction(tt.raw(bc, w))
+			pool := bc.GetMempool()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || len(pool) != 0 {
+					t.Fatalf("SubmitRawTransaction: %v with %d mempool transaction(s), want error %q", err, len(pool), tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pool) != 1 || !bytes.Equal(pool[0].ID, tx.ID) {
+				t.Fatal("submitted transaction not in the mempool")
+			}
+		})
+	}
+}
+
+func TestUnconfirmedParentAndChild(t *testing.T) {
+	bc, alice := newTestChain(t)
+	bob, carol := NewWallet(), NewWallet()
+	parent := spendCoinbase(t, bc, alice, string(bob.GetAddress()), 1)
+	if err := bc.AddToMempool(parent); err != nil {
+		t.Fatal(err)
+	}
+	child := spendOutput(t, bc, bob, parent, 0, string(carol.GetAddress()), 2, false)
+	coinbase := NewCoinbaseTX(string(alice.GetAddress()), "", 3, 2)
+
+	// Signing needs the parent pooled; without it the child spends an unknown output
+	if err := bc.RemoveMinedFromMempool([]*Transaction{parent}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddToMempool(child); err == nil {
+		t.Fatal("mempool accepted a child without its parent")
+	}
+	if err := bc.AddToMempool(parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddToMempool(child); err != nil {
+		t.Fatalf("mempool rejected a child of a mempool transaction: %s", err)
+	}
+	if err := bc.AddBlock(peerBlock(t, bc, coinbase, child, parent)); err == nil {
+		t.Fatal("accepted a block with a child ahead of its parent")
+	}
+
+	mined := bc.MineBlock([]*Transaction{coinbase, child, parent})
+	if len(mined.Transactions) != 3 || !bytes.Equal(mined.Transactions[1].ID, parent.ID) || !bytes.Equal(mined.Transactions[2].ID, child.ID) {
+		t.Fatal("mined block doesn't place the parent ahead of its child")
+	}
+	if err := bc.RemoveMinedFromMempool(mined.Transactions[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if pool := bc.GetMempool(); len(pool) != 0 {
+		t.Fatalf("%d transaction(s) left in the mempool", len(pool))
+	}
+
+	for w, want := range map[*Wallet]int{alice: subsidy + 3, bob: 0, carol: subsidy - 3} {
+		if balance, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != want {
+			t.Errorf("balance %d, %v, want %d", balance, err, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Checkpoints maps block heights to the block hashes the chain must have there
+// Heights count the genesis block as 1, matching GetBestHeight
+// Similar to Geth's params.TrustedCheckpoint and Bitcoin Core's checkpoint table
+type Checkpoints map[int][]byte
+
+// checkpoints are enforced when blocks are received from peers
+// There are no hardcoded ones, since every network starts from its own genesis block;
+// load them with startnode -checkpoints
+var checkpoints = Checkpoints{}
+
+// LoadCheckpoints reads a checkpoints file: one "HEIGHT HASH" pair per line
+// Blank lines and lines starting with # are ignored
+func LoadCheckpoints(path string) (Checkpoints, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	loaded := Checkpoints{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected HEIGHT HASH", path, lineNo)
+		}
+		height, err := strconv.Atoi(fields[0])
+		if err != nil || height < 1 {
+			return nil, fmt.Errorf("%s:%d: invalid height %q", path, lineNo, fields[0])
+		}
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil || len(hash) == 0 {
+			return nil, fmt.Errorf("%s:%d: invalid hash %q", path, lineNo, fields[1])
+		}
+		loaded[height] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return loaded, nil
+}
+
+// Check fails if there is a checkpoint at height and hash doesn't match it
+func (c Checkpoints) Check(height int, hash []byte) error {
+	expected, ok := c[height]
+	if ok && !bytes.Equal(expected, hash) {
+		return fmt.Errorf("block %x at height %d doesn't match checkpoint %x", hash, height, expected)
+	}
+
+	return nil
+}
+
+// LastHeight returns the height of the highest checkpoint, or 0 if there are none
+func (c Checkpoints) LastHeight() int {
+	last := 0
+	for height := range c {
+		if height > last {
+			last = height
+		}
+	}
+
+	return last
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useCheckpoints enforces c for the test
+func useCheckpoints(t *testing.T, c Checkpoints) {
+	t.Helper()
+
+	saved := checkpoints
+	checkpoints = c
+	t.Cleanup(func() { checkpoints = saved })
+}
+
+func TestAddBlockChecksCheckpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkpoint func(block *Block) []byte // Hash checkpointed at the block's height
+		wantErr    string
+	}{
+		{"matching", func(block *Block) []byte { return block.Hash }, ""},
+		{"mismatching", func(block *Block) []byte { return block.PrevBlockHash }, "doesn't match checkpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			tip := bc.Tip()
+			block := peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 0, 2))
+			useCheckpoints(t, Checkpoints{2: tt.checkpoint(block)})
+
+			err := bc.AddBlock(block)
+			if tt.wantErr == "" {
+				if err != nil || !bytes.Equal(bc.Tip(), block.Hash) {
+					t.Fatalf("AddBlock: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("AddBlock: %v, want error %q", err, tt.wantErr)
+			}
+			if !bytes.Equal(bc.Tip(), tip) || bc.HasBlock(block.Hash) {
+				t.Fatal("block off the checkpoint was stored")
+			}
+		})
+	}
+}
+
+func TestLoadCheckpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    Checkpoints
+		wantErr string
+	}{
+		{"heights and hashes", "# trusted\n1 00ab\n\n20 ff01\n", Checkpoints{1: {0x00, 0xab}, 20: {0xff, 0x01}}, ""},
+		{"missing hash", "5\n", nil, ":1: expected HEIGHT HASH"},
+		{"height zero", "0 00ab\n", nil, "invalid height"},
+		{"hash not hex", "\n3 xyz\n", nil, ":2: invalid hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoints.txt")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := LoadCheckpoints(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadCheckpoints: %v, want error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded) != len(tt.want) || loaded.LastHeight() != tt.want.LastHeight() {
+				t.Fatalf("loaded %x, want %x", loaded, tt.want)
+			}
+			for height, hash := range tt.want {
+				if !bytes.Equal(loaded[height], hash) {
+					t.Fatalf("checkpoint at %d is %x, want %x", height, loaded[height], hash)
+				}
+			}
+		})
+	}
+}
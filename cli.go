@@ -1,11 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/ripemd160"
 )
 
 // CLI handles command line interface
@@ -14,33 +25,198 @@ type CLI struct{}
 
 // printUsage prints usage information
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("Usage: [-datadir DIR] [-network mainnet|testnet|regtest] [-blockcache N] COMMAND")
+	fmt.Println("    Files are kept in DIR, which defaults to DATA_DIR env, then the current directory")
+	fmt.Println("    The network defaults to NETWORK env, then mainnet; testnet and regtest use their own files, addresses, seeds and difficulty")
+	fmt.Printf("    Up to N recently read blocks are kept decoded in memory (default %d, 0 disables)\n", defaultBlockCacheSize)
+	fmt.Println("Commands:")
+	fmt.Println("  activateaddress -address ADDRESS - Send change to an address deactivated with deactivateaddress again")
+	fmt.Println("  addnode -address HOST:PORT - Make the running node connect to the peer at HOST:PORT and add it to its known peers")
+	fmt.Println("  compact - Rewrite the blockchain DB without its free pages, e.g. after prune; the node must be stopped")
+	fmt.Println("  confirmations -id TXID - Print how many blocks deep transaction TXID is buried")
+	fmt.Println("  createblockchain -address ADDRESS [-chainid ID] [-consensus NAME] [-compress] - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  createblockchain -genesis FILE [-chainid ID] [-consensus NAME] [-compress] - Create a blockchain whose genesis block pays the allocations in FILE")
+	fmt.Printf("    The consensus algorithm (%s) defaults to the network's; every block of the chain must follow it\n", strings.Join(consensusNames(), ", "))
+	fmt.Println("    -compress stores the chain's blocks gzip-compressed")
+	fmt.Println("    The genesis file is JSON: {\"message\": TEXT, \"alloc\": {ADDRESS: AMOUNT, ...}}")
+	fmt.Printf("    On an existing DB the chain ID (default %d) and genesis file must match the ones it was created with\n", defaultChainID)
+	fmt.Println("  createmultisig -m M -addresses ADDR1,ADDR2,... - Create an M-of-N multisig address from wallet keys")
+	fmt.Println("  createmultisigwallet -m M -pubkeys KEY1,KEY2,... - Store an M-of-N redeem script in the wallet file and print its script hash address")
+	fmt.Println("    Each KEY is a hex public key or a wallet address; spending needs the stored script and M of the keys")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
-	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
-	fmt.Println("  mine -address ADDRESS - Mine a block with transactions from the mempool")
+	fmt.Println("  deactivateaddress -address ADDRESS - Stop sending change to a wallet address, e.g. after its key leaked; its funds and history stay visible")
+	fmt.Println("  decodeaddress -address ADDRESS - Print the version, network, pubkey hash and checksum of ADDRESS")
+	fmt.Println("  decodeblock -hex HEX - Print a serialized block in the format of printchain")
+	fmt.Println("  decodetx -hex HEX - Print a serialized transaction, such as the output of getrawtx")
+	fmt.Println("  disconnectnode -address HOST:PORT - Make the running node drop the peer at HOST:PORT from its known peers")
+	fmt.Println("  dumpprivkey -address ADDRESS - Print the private key of a wallet address (base58check)")
+	fmt.Println("  estimatefee [-size BYTES] [-parent TXID] - Estimate the fee a transaction of BYTES bytes (default 320) needs to be mined in the next block")
+	fmt.Println("    -parent estimates the fee of a child spending mempool transaction TXID that pays for it to be mined too")
+	fmt.Println("  estimatehashrate [-blocks N] - Estimate the network hashrate from the times and difficulties of the last N blocks (default 120)")
+	fmt.Println("  getbalance -address ADDRESS [-minconf N] - Get balance of ADDRESS, counting outputs with at least N confirmations (default 1)")
+	fmt.Println("    -minconf 0 also counts unconfirmed outputs of the mempool and leaves out outputs it spends")
+	fmt.Println("  getblockheader -hash HASH - Print the header of block HASH, without its transactions")
+	fmt.Println("  getblocktxs -hash HASH [-json] - List the IDs of the transactions in block HASH; -json prints the full transactions")
+	fmt.Println("  getchainid - Print the chain ID transactions are signed for")
+	fmt.Println("  getdifficulty - Print the proof-of-work target bits of the next block and the expected number of hashes per block")
+	fmt.Println("  getmininginfo - Print the difficulty and the hashes, time and hashrate spent mining the last block mined here")
+	fmt.Println("  getnewaddress [-label NAME] - Generate a key-pair, save it into the wallet file and print only its address")
+	fmt.Println("  getrawtx -id TXID - Print the serialized transaction TXID as hex, and the block holding it")
+	fmt.Println("  getutxostats - Print the number and total value of the unspent outputs, by value class (dust, small, large)")
+	fmt.Println("  history -address ADDRESS - List the transactions that affected ADDRESS, oldest first")
+	fmt.Println("  importaddress -address ADDRESS | -pubkeyhash HASH - Watch an address without its private key; its balance and history can be queried but not spent")
+	fmt.Println("  importprivkey -key KEY - Add the wallet of a private key printed by dumpprivkey to the wallet file")
+	fmt.Println("  info - Summarize the chain, mempool and wallet state")
+	fmt.Println("  listaddresses [-active] - Lists all addresses (and their labels) from the wallet file; -active leaves out inactive ones")
+	fmt.Println("  listunspent -address ADDRESS [-json] - List the unspent outputs of ADDRESS with their confirmations")
+	fmt.Println("  mempool [-json] - List the transactions waiting in the mempool")
+	fmt.Println("  mine -address ADDRESS [-coinbasedata TEXT] - Mine a block with transactions from the mempool")
+	fmt.Printf("    TEXT (up to %d bytes) goes into the coinbase, followed by an extranonce that keeps it unique\n", maxCoinbaseTextLen)
+	fmt.Println("  peers - List the peers known to the node, with last-seen time and reported height")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. -miner enables mining")
+	fmt.Println("  prune [-keep N] - Discard the transactions of all but the N most recent blocks, keeping their headers")
+	fmt.Println("  removetx -id TXID - Remove a transaction, and those spending its outputs, from the mempool")
+	fmt.Println("  rescan - Rebuild the UTXO set and the transaction index from the blocks")
+	fmt.Println("  sendmany -from FROM -file FILE [-fee FEE] - Pay every recipient in FILE from FROM in a single transaction")
+	fmt.Println("    The payments file is JSON: {ADDRESS: AMOUNT, ...}")
+	fmt.Println("  sendrawtx -hex HEX - Verify a signed transaction printed by getrawtx and add it to the mempool")
+	fmt.Println("  setlabel -address ADDRESS -label NAME - Label a wallet address (an empty NAME removes it)")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT [-fee FEE] [-data HEX] [-memo TEXT] [-inputs TXID:VOUT,...] [-replace] [-dryrun] [-changeaddr ADDR | -auto-change] - Send AMOUNT of coins from FROM address to TO, paying FEE to the miner")
+	fmt.Println("    -data attaches up to 80 bytes in an unspendable data output")
+	fmt.Println("    -memo stores a note of up to 256 bytes with the transaction")
+	fmt.Println("    -inputs spends exactly the given outputs of FROM instead of picking them automatically")
+	fmt.Println("    -replace marks the transaction replaceable; with -inputs it replaces a replaceable mempool transaction paying a lower fee")
+	fmt.Println("    -dryrun prints the signed transaction with its inputs, outputs, change and fee, without adding it to the mempool")
+	fmt.Println("    -changeaddr sends the change to ADDR, which must be an active address of this wallet, instead of back to FROM")
+	fmt.Println("    -auto-change sends the change to a new address, added to the wallet once the transaction is sent")
+	fmt.Println("  send -from-multi ADDR1,ADDR2,... -to TO -amount AMOUNT [-fee FEE] [-changeaddr ADDR | -auto-change] - Send AMOUNT combining the funds of several addresses; change goes to ADDR1 by default")
+	fmt.Println("  signmessage -address ADDRESS -message MESSAGE - Sign MESSAGE with the key of a wallet address")
+	fmt.Println("  startnode -miner ADDRESS [-mine-interval DURATION] [-seeds HOST:PORT,...] [-checkpoints FILE] [-maxreorg DEPTH] [-listen HOST:PORT] [-maxinbound N] [-maxconnrate N] [-metrics-addr HOST:PORT] - Start a node with ID specified in NODE_ID env. -miner enables mining")
+	fmt.Println("    -listen binds the server to HOST:PORT instead of localhost:NODE_ID; LISTEN_ADDR env sets the default")
+	fmt.Printf("    -maxinbound caps the connections handled at once (default %d), -maxconnrate those one IP may open per minute (default %d); 0 disables either\n", defaultMaxInbound, defaultMaxConnRate)
+	fmt.Println("    -metrics-addr serves Prometheus metrics on http://HOST:PORT/metrics")
+	fmt.Printf("    Blocks of branches forking more than DEPTH blocks below the tip are rejected (default %d, 0 disables)\n", defaultMaxReorgDepth)
+	fmt.Println("    -mine-interval mines a block every DURATION (e.g. 10s) while the node is synced")
+	fmt.Println("    Seed nodes default to SEED_NODES env (comma-separated), then the network's seed (" + defaultSeedNode + " on mainnet)")
+	fmt.Println("    The checkpoints file holds one HEIGHT HASH pair per line; received blocks must match them")
+	fmt.Println("  vanity -prefix PREFIX [-workers N] - Generate a key whose address starts with PREFIX and save it into the wallet file")
+	fmt.Println("    Every extra character makes the search about 58 times slower; it gives up after 10000000 keys")
+	fmt.Println("  verifymessage -address ADDRESS -message MESSAGE -signature SIG - Check that SIG was made by ADDRESS for MESSAGE")
+	fmt.Println("  verifytx -hex HEX | -id TXID - Check a transaction against the chain and print which input fails and why (missing previous transaction, bad signature, double spend)")
+}
+
+// parseGlobalFlags consumes the options given before the command name
+func (cli *CLI) parseGlobalFlags() {
+	globalCmd := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	globalCmd.Usage = cli.printUsage
+	globalDataDir := globalCmd.String("datadir", os.Getenv("DATA_DIR"), "Directory holding the blockchain DB, wallet and peers files")
+	globalNetwork := globalCmd.String("network", "", "Network to use: "+strings.Join(networkNames(), ", ")+" (defaults to NETWORK env, then mainnet)")
+	globalBlockCache := globalCmd.Int("blockcache", defaultBlockCacheSize, "Number of recently read blocks kept decoded in memory; 0 disables the cache")
+
+	err := globalCmd.Parse(os.Args[1:])
+	if err != nil {
+		log.Panic(err)
+	}
+
+	dataDir = *globalDataDir
+	if *globalBlockCache < 0 {
+		fmt.Println("ERROR: -blockcache must not be negative")
+		os.Exit(1)
+	}
+	blockCacheSize = *globalBlockCache
+	loadNetworkFromFlag(*globalNetwork)
+	os.Args = append([]string{os.Args[0]}, globalCmd.Args()...)
 }
 
 // validateArgs validates command line arguments
 func (cli *CLI) validateArgs() {
+	cli.parseGlobalFlags()
+
 	if len(os.Args) < 2 {
 		cli.printUsage()
 		os.Exit(1)
 	}
 }
 
+// compact reclaims the free space of the blockchain DB file
+func (cli *CLI) compact(nodeID string) {
+	dbPath := dataFilePath(dbFile, nodeID)
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fmt.Println("No existing blockchain found. Please create one first using 'createblockchain'.")
+		os.Exit(1)
+	}
+
+	before, after, err := CompactDB(dbPath)
+	if err == bbolt.ErrTimeout {
+		fmt.Printf("ERROR: %s is in use by another process; stop the node first\n", dbPath)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Compacted %s: %d -> %d bytes\n", dbPath, before, after)
+}
+
+// confirmations prints the number of confirmations of a transaction
+func (cli *CLI) confirmations(txID, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("ERROR: Transaction ID is not valid hex")
+	}
+
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	confirmations, err := bc.GetTransactionConfirmations(id)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Confirmations of %s: %d\n", txID, confirmations)
+}
+
 // createBlockchain creates a new blockchain DB
-func (cli *CLI) createBlockchain(address, nodeID string) {
-	if !ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+func (cli *CLI) createBlockchain(address, nodeID string, chainID int64) {
+	if err := CheckAddress(address); err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
 	}
-	bc := NewBlockchain(address, nodeID)
+	bc := CreateBlockchain(address, nodeID, chainID)
+	defer bc.db.Close()
+
+	// An existing DB is opened as is, so it must have been created for the same chain ID
+	if err := bc.CheckChainID(chainID); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Println("Done!")
+}
+
+// createBlockchainFromGenesis creates a new blockchain DB from a genesis file
+func (cli *CLI) createBlockchainFromGenesis(genesisFile, nodeID string, chainID int64) {
+	genesis, err := LoadGenesis(genesisFile)
+	if err != nil {
+		fmt.Printf("ERROR: Invalid genesis file: %s\n", err)
+		os.Exit(1)
+	}
+	bc := CreateBlockchainFromGenesis(genesis, nodeID, chainID)
 	defer bc.db.Close()
 
+	// An existing DB is opened as is, so it must have been created from the same file and for the same chain ID
+	err = bc.CheckGenesis(genesis)
+	if err == nil {
+		err = bc.CheckChainID(chainID)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
 	fmt.Println("Done!")
 }
 
@@ -53,258 +229,2077 @@ func (cli *CLI) createWallet(nodeID string) {
 	fmt.Printf("Your new address: %s\n", address)
 }
 
-// getBalance gets the balance for an address
-func (cli *CLI) getBalance(address, nodeID string) {
-	if !ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+// getNewAddress creates a wallet, optionally labelled, and prints just its address so scripts can capture it
+// Similar to Bitcoin's getnewaddress RPC
+func (cli *CLI) getNewAddress(label, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
 	}
-	bc := NewBlockchain(address, nodeID)
-	defer bc.db.Close()
-
-	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := bc.FindUTXO(pubKeyHash)
-
-	for _, out := range UTXOs {
-		balance += out.Value
+	address := wallets.CreateWallet()
+	if label != "" {
+		if err := wallets.SetLabel(address, label); err != nil {
+			log.Panic(err)
+		}
 	}
+	wallets.SaveToFile(nodeID)
 
-	fmt.Printf("Balance of '%s': %d\n", address, balance)
+	fmt.Println(address)
 }
 
-// listAddresses lists all addresses from the wallet file
-func (cli *CLI) listAddresses(nodeID string) {
-	wallets, err := NewWallets(nodeID)
+// decodeBlock prints a serialized block given as hex, in the format of printchain
+func (cli *CLI) decodeBlock(rawHex string) {
+	raw, err := hex.DecodeString(rawHex)
 	if err != nil {
-		log.Panic(err)
+		fmt.Println("ERROR: Block is not valid hex")
+		os.Exit(1)
 	}
-	addresses := wallets.GetAddresses()
 
-	for _, address := range addresses {
-		fmt.Println(address)
+	block, err := DecodeBlock(raw)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
 	}
-}
-
-// printChain prints all blocks in the blockchain
-func (cli *CLI) printChain(nodeID string) {
-	bc := NewBlockchain("", nodeID)
-	defer bc.db.Close()
-
-	bci := bc.Iterator()
 
-	for {
-		block := bci.Next()
-
-		fmt.Printf("============ Block %x ============\n", block.Hash)
-		fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
-		fmt.Printf("Timestamp: %d\n", block.Timestamp)
-		fmt.Printf("Nonce: %d\n", block.Nonce)
+	printBlock(block, defaultSealer)
+}
 
-		// Validate PoW
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+// decodeAddress prints the parts of a base58check address and whether it's valid on the active network
+func (cli *CLI) decodeAddress(address string) {
+	payload := Base58Decode([]byte(address))
+	if len(payload) < 1+addressChecksumLen {
+		fmt.Println("ERROR: Address is too short")
+		os.Exit(1)
+	}
+	version := payload[0]
 
-		// Print transactions
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
+	network := networkForVersion(version)
+	networkName := "unknown network"
+	if network != nil {
+		networkName = network.Name
+	}
+	fmt.Printf("Version:     %#02x (%s)\n", version, networkName)
+	if network != nil && version == network.ScriptHashVersion {
+		fmt.Printf("Script hash: %x\n", payload[1:len(payload)-addressChecksumLen])
+	} else if network != nil && version == network.MultisigVersion {
+		script, err := DeserializeMultisigScript(payload[1 : len(payload)-addressChecksumLen])
+		if err == nil {
+			fmt.Printf("Multisig:    %d-of-%d\n", script.M, len(script.PubKeys))
+			fmt.Printf("Script hash: %x\n", script.Hash())
 		}
-		fmt.Println()
+	} else {
+		fmt.Printf("PubKeyHash:  %x\n", payload[1:len(payload)-addressChecksumLen])
+	}
+	fmt.Printf("Checksum:    %x\n", payload[len(payload)-addressChecksumLen:])
 
-		// Stop when we reach genesis block
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	if err := CheckAddress(address); err != nil {
+		fmt.Printf("Valid:       no (%s)\n", err)
+	} else {
+		fmt.Println("Valid:       yes")
 	}
 }
 
-// send sends coins from one address to another (adds to mempool)
-func (cli *CLI) send(from, to string, amount int, nodeID string) {
-	if !ValidateAddress(from) {
-		log.Panic("ERROR: Sender address is not valid")
+// decodeTx prints a serialized transaction given as hex
+func (cli *CLI) decodeTx(rawHex string) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		fmt.Println("ERROR: Transaction is not valid hex")
+		os.Exit(1)
 	}
-	if !ValidateAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
+
+	tx, err := DecodeTransaction(raw)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
 	}
 
-	bc := NewBlockchain(from, nodeID)
-	defer bc.db.Close()
+	fmt.Println(tx)
+}
 
-	tx := NewUTXOTransaction(from, to, amount, bc)
-	bc.AddToMempool(tx)
+// dumpPrivKey prints the private key of a wallet address in base58check form
+func (cli *CLI) dumpPrivKey(address, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	fmt.Println("Success! Transaction added to Mempool.")
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("ERROR: Address %s is not in the wallet file", address)
+	}
+
+	fmt.Println(wallet.ExportPrivateKey())
 }
 
-// mine mines a block with transactions from the mempool
-func (cli *CLI) mine(address, nodeID string) {
-	if !ValidateAddress(address) {
-		log.Panic("ERROR: Miner address is not valid")
+// createMultisig creates an M-of-N multisig address from the keys of wallet addresses
+func (cli *CLI) createMultisig(m int, addresses []string, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
 	}
 
-	bc := NewBlockchain(address, nodeID)
-	defer bc.db.Close()
+	var pubKeys [][]byte
+	for _, address := range addresses {
+		wallet, ok := wallets.Wallets[address]
+		if !ok {
+			log.Panicf("ERROR: Address %s is not in the wallet file", address)
+		}
+		pubKeys = append(pubKeys, wallet.PublicKey)
+	}
 
-	var txs []*Transaction
+	script, err := NewMultisigScript(m, pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	// Get transactions from mempool
-	mempool := bc.GetMempool()
+	fmt.Printf("Your new %d-of-%d multisig address: %s\n", script.M, len(script.PubKeys), script.Address())
+}
 
-	// Verify transactions before mining
-	for _, tx := range mempool {
-		if bc.VerifyTransaction(tx) {
-			txs = append(txs, tx)
-		} else {
-			fmt.Println("ERROR: Invalid transaction found in mempool")
+// createMultisigWallet stores an M-of-N redeem script in the wallet file and prints its script hash address
+// Each key is a hex-encoded public key or the address of a wallet key; the same keys in the same order
+// always give the same address
+// Similar to Bitcoin's addmultisigaddress RPC
+func (cli *CLI) createMultisigWallet(m int, keys []string, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+
+	var pubKeys [][]byte
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if wallet, ok := wallets.Wallets[key]; ok {
+			pubKeys = append(pubKeys, wallet.PublicKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		if err != nil || len(pubKey) != pubKeyLen {
+			fmt.Printf("ERROR: %q is neither a wallet address nor a %d-byte hex public key\n", key, pubKeyLen)
+			os.Exit(1)
 		}
+		pubKeys = append(pubKeys, pubKey)
 	}
 
-	if len(txs) == 0 {
-		fmt.Println("No valid transactions in mempool. Mining new block with Coinbase only.")
+	script, err := NewMultisigScript(m, pubKeys)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
 	}
+	address := wallets.AddScript(script)
+	wallets.SaveToFile(nodeID)
 
-	// Add coinbase transaction
-	cbTx := NewCoinbaseTX(address, "")
-	txs = append([]*Transaction{cbTx}, txs...) // Coinbase first
+	fmt.Printf("Your new %d-of-%d multisig address: %s\n", script.M, len(script.PubKeys), address)
+	fmt.Printf("Redeem script: %x\n", script.Serialize())
+}
 
-	// Mine block
-	newBlock := bc.MineBlock(txs)
+// getBalance gets the balance for an address, counting outputs with at least minConf confirmations
+func (cli *CLI) getBalance(address string, minConf int, nodeID string) {
+	if err := CheckAddress(address); err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
+	}
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
 
-	// Clear mempool
-	bc.ClearMempool()
+	balance, err := bc.Balance(AddressToPubKeyHash(address), minConf)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
 
-	fmt.Printf("Success! Mined block: %x\n", newBlock.Hash)
+	fmt.Printf("Balance of '%s': %d\n", address, balance)
 }
 
-// startNode starts a node
-func (cli *CLI) startNode(nodeID, minerAddress string) {
-	fmt.Printf("Starting node %s\n", nodeID)
-	if len(minerAddress) > 0 {
-		if ValidateAddress(minerAddress) {
-			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
-		} else {
-			log.Panic("Wrong miner address!")
-		}
-	}
-	StartServer(nodeID, minerAddress)
+// getChainID prints the chain ID of the blockchain
+func (cli *CLI) getChainID(nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	fmt.Printf("Chain ID: %d\n", bc.ChainID())
 }
 
-// Run parses command line arguments and executes commands
-func (cli *CLI) Run() {
-	cli.validateArgs()
+// getDifficulty prints the proof-of-work difficulty of the next block
+func (cli *CLI) getDifficulty(nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
 
-	nodeID := os.Getenv("NODE_ID")
-	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!\n")
+	bits := cli.nextTargetBits(bc)
+	fmt.Printf("Target bits: %d\n", bits)
+	fmt.Printf("Difficulty:  %.0f (expected hashes per block)\n", Difficulty(bits))
+	if interval := activeNetwork.RetargetInterval; interval > 0 {
+		fmt.Printf("Retarget:    every %d blocks, for one block every %ds\n", interval, activeNetwork.TargetBlockInterval)
+	}
+}
+
+// nextTargetBits returns the target bits required of the next block on bc's tip
+func (cli *CLI) nextTargetBits(bc *Blockchain) int {
+	bits, err := bc.NextTargetBits(bc.Tip())
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
 		os.Exit(1)
 	}
 
-	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
-	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
-	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
-	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
-	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
-	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
-	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
-	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	return bits
+}
 
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	mineAddress := mineCmd.String("address", "", "The address to send mining rewards to")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
-	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+// getUTXOStats prints the size of the UTXO set and how its value is spread over dust, small and large outputs
+func (cli *CLI) getUTXOStats(nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
 
-	switch os.Args[1] {
-	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "mine":
-		err := mineCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "send":
-		err := sendCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "startnode":
-		err := startNodeCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	default:
-		cli.printUsage()
+	stats, err := bc.UTXOStats()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
 		os.Exit(1)
 	}
 
-	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
-			os.Exit(1)
+	if stats.Scanned {
+		fmt.Println("UTXO set is behind the tip; scanned the blocks instead")
+	}
+	fmt.Printf("Height:       %d\n", bc.GetBestHeight())
+	fmt.Printf("Outputs:      %d in %d transaction(s)\n", stats.Outputs, stats.Transactions)
+	fmt.Printf("Total value:  %d\n", stats.TotalValue)
+	for _, class := range stats.Classes {
+		valueRange := fmt.Sprintf("%d-%d", class.Min, class.Max)
+		if class.Max == 0 {
+			valueRange = fmt.Sprintf(">= %d", class.Min)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		fmt.Printf("  %-6s %-8s %d output(s), value %d\n", class.Name+":", "("+valueRange+")", class.Outputs, class.Value)
 	}
+}
 
-	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+// getMiningInfo prints the mining parameters and the work spent on the last block mined by this node
+// Similar to Bitcoin's getmininginfo RPC
+func (cli *CLI) getMiningInfo(nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	fmt.Printf("Blocks:         %d\n", bc.GetBestHeight())
+	bits := cli.nextTargetBits(bc)
+	fmt.Printf("Target bits:    %d\n", bits)
+	fmt.Printf("Difficulty:     %.0f\n", Difficulty(bits))
+	fmt.Printf("Consensus:      %s\n", consensusName(bc.consensus))
+	fmt.Printf("Mempool size:   %d\n", len(bc.GetMempool()))
+
+	stats, found, err := bc.StoredMiningStats()
+	if err != nil {
+		log.Panic(err)
 	}
+	if !found {
+		fmt.Println("No block has been mined by this node yet")
+		return
+	}
+	fmt.Printf("Last mined block %x\n", stats.Hash)
+	fmt.Printf("  Hashes tried: %d\n", stats.Hashes)
+	fmt.Printf("  Elapsed:      %s\n", stats.Elapsed)
+	fmt.Printf("  Nonce:        %d\n", stats.Nonce)
+	fmt.Printf("  Hashrate:     %.2f hashes/s\n", stats.HashRate())
+}
 
-	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			os.Exit(1)
+// estimateFee prints the fee a transaction of size bytes needs to be mined in the next block,
+// as a child paying for mempool transaction parentID if given
+func (cli *CLI) estimateFee(size int, parentID, nodeID string) {
+	var parent []byte
+	if parentID != "" {
+		var err error
+		parent, err = hex.DecodeString(parentID)
+		if err != nil {
+			log.Panic("ERROR: Transaction ID is not valid hex")
 		}
-		cli.getBalance(*getBalanceAddress, nodeID)
 	}
 
-	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
-	}
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
 
-	if mineCmd.Parsed() {
-		if *mineAddress == "" {
-			mineCmd.Usage()
-			os.Exit(1)
-		}
-		cli.mine(*mineAddress, nodeID)
+	fee, err := bc.EstimateFee(size, parent)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
 	}
 
-	if printChainCmd.Parsed() {
-		cli.printChain(nodeID)
+	if parent != nil {
+		fmt.Printf("Estimated fee: %d for a %d-byte child of %s to be mined in the next block with it\n", fee, size, parentID)
+		return
 	}
+	fmt.Printf("Estimated fee: %d for a %d-byte transaction to be mined in the next block (%.3f fee per byte)\n", fee, size, feeRate(fee, size))
+}
 
-	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+// estimateHashRate prints the hashrate that mined the last blocks
+func (cli *CLI) estimateHashRate(blocks int, nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	// One block more than requested, to measure the time the requested blocks took
+	headers := bc.RecentHeaders(blocks + 1)
+	hashRate, err := EstimateHashRate(headers)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Estimated hashrate: %.2f hashes/s over the last %d block(s)\n", hashRate, len(headers)-1)
+	bits := cli.nextTargetBits(bc)
+	interval := activeNetwork.TargetBlockInterval
+	fmt.Printf("Retargeted bits:    %d for one block every %ds at this hashrate (next block needs %d)\n", RetargetBits(bits, hashRate, interval), interval, bits)
+}
+
+// getBlockHeader prints the header of a block
+func (cli *CLI) getBlockHeader(blockHash, nodeID string) {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		log.Panic("ERROR: Block hash is not valid hex")
+	}
+
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	header, err := bc.GetBlockHeader(hash)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Print(header)
+	fmt.Printf("  Serialized:    %x\n", EncodeBlockHeader(header))
+}
+
+// blockTxEntry is the JSON form of a transaction in the getblocktxs listing
+type blockTxEntry struct {
+	ID       string          `json:"id"`
+	Coinbase bool            `json:"coinbase"`
+	Inputs   []string        `json:"inputs"`
+	Outputs  []mempoolOutput `json:"outputs"`
+	Size     int             `json:"size"`
+}
+
+// getBlockTxs prints the IDs, or with asJSON the full transactions, of a block
+func (cli *CLI) getBlockTxs(blockHash string, asJSON bool, nodeID string) {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		log.Panic("ERROR: Block hash is not valid hex")
+	}
+
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	block, err := bc.GetBlock(hash)
+	if errors.Is(err, errBlockPruned) {
+		fmt.Printf("ERROR: The transactions of block %x have been pruned\n", hash)
+		bc.db.Close()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	if !asJSON {
+		for _, tx := range block.Transactions {
+			fmt.Printf("%x\n", tx.ID)
+		}
+		return
+	}
+
+	entries := []blockTxEntry{}
+	for _, tx := range block.Transactions {
+		entry := blockTxEntry{ID: hex.EncodeToString(tx.ID), Coinbase: tx.IsCoinbase(), Inputs: []string{}, Size: tx.SerializedSize()}
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Vin {
+				entry.Inputs = append(entry.Inputs, fmt.Sprintf("%x:%d", in.Txid, in.Vout))
+			}
+		}
+		for _, out := range tx.Vout {
+			entry.Outputs = append(entry.Outputs, mempoolOutput{out.Value, hex.EncodeToString(out.PubKeyHash)})
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(string(data))
+}
+
+// getRawTx prints a serialized transaction as hex, and where it was found
+func (cli *CLI) getRawTx(txID, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("ERROR: Transaction ID is not valid hex")
+	}
+
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	raw, blockHash, err := bc.GetRawTransaction(id)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("%x\n", raw)
+	if blockHash == nil {
+		fmt.Println("Source: mempool")
+	} else {
+		fmt.Printf("Source: block %x\n", blockHash)
+	}
+}
+
+// history prints every transaction that affected an address, oldest first
+func (cli *CLI) history(address, nodeID string) {
+	if err := CheckAddress(address); err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
+	}
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	records := bc.FindTransactionsForAddress(AddressToPubKeyHash(address))
+	fmt.Printf("%d transaction(s) for '%s'\n", len(records), address)
+
+	balance := 0
+	for _, record := range records {
+		balance += record.Received - record.Sent
+
+		kind := "transfer"
+		if record.Coinbase {
+			kind = "coinbase"
+		}
+		fmt.Printf("Height %d  Block %x\n", record.Height, record.BlockHash)
+		fmt.Printf("  Tx %x (%s)\n", record.TxID, kind)
+		fmt.Printf("  Received: %d  Sent: %d  Balance: %d\n", record.Received, record.Sent, balance)
+		if len(record.Memo) > 0 {
+			fmt.Printf("  Memo: %q\n", record.Memo)
+		}
+	}
+}
+
+// info prints a summary of the chain, mempool and wallet state
+func (cli *CLI) info(nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	transactions, pruned := 0, 0
+	for _, hash := range bc.GetBlockHashes() {
+		block, err := bc.GetBlock(hash)
+		if errors.Is(err, errBlockPruned) {
+			pruned++
+			continue
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		transactions += len(block.Transactions)
+	}
+
+	addresses := 0
+	if wallets, err := NewWallets(nodeID); err == nil {
+		addresses = len(wallets.GetAddresses())
+	}
+
+	fmt.Printf("Best height:      %d\n", bc.GetBestHeight())
+	fmt.Printf("Tip hash:         %x\n", bc.Tip())
+	if headerHeight, blockHeight := bc.SyncProgress(); headerHeight > blockHeight {
+		fmt.Printf("Header height:    %d (%d block(s) still to download)\n", headerHeight, headerHeight-blockHeight)
+	}
+	fmt.Printf("Network:          %s\n", activeNetwork.Name)
+	fmt.Printf("Consensus:        %s\n", consensusName(bc.consensus))
+	fmt.Printf("Chain ID:         %d\n", bc.ChainID())
+	if pruned > 0 {
+		fmt.Printf("Transactions:     %d (not counting %d pruned block(s))\n", transactions, pruned)
+	} else {
+		fmt.Printf("Transactions:     %d\n", transactions)
+	}
+	fmt.Printf("Difficulty:       %d target bits\n", cli.nextTargetBits(bc))
+	fmt.Printf("Block interval:   %ds (target)\n", activeNetwork.TargetBlockInterval)
+	if interval := activeNetwork.RetargetInterval; interval > 0 {
+		fmt.Printf("Retarget:         every %d blocks\n", interval)
+	} else {
+		fmt.Printf("Retarget:         never\n")
+	}
+	if utxos := (UTXOSet{bc}); utxos.IsCurrent() {
+		supply, err := utxos.TotalSupply()
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Total supply:     %d\n", supply)
+	}
+	fmt.Printf("Mempool size:     %d\n", len(bc.GetMempool()))
+	fmt.Printf("Wallet addresses: %d\n", addresses)
+	if stat, err := os.Stat(bc.db.Path()); err == nil {
+		fmt.Printf("DB file size:     %d bytes\n", stat.Size())
+	}
+}
+
+// importPrivKey adds the wallet of a base58check private key to the wallet file
+func (cli *CLI) importPrivKey(key, nodeID string) {
+	wallet, err := ImportPrivateKey(key)
+	if err != nil {
+		fmt.Printf("ERROR: Invalid private key: %s\n", err)
+		os.Exit(1)
+	}
+
+	wallets, _ := NewWallets(nodeID)
+	address := wallets.AddWallet(wallet)
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Imported address: %s\n", address)
+}
+
+// listAddresses lists all addresses from the wallet file, leaving out inactive ones if activeOnly is set
+func (cli *CLI) listAddresses(activeOnly bool, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var notes []string
+	var addresses []string
+	for _, address := range wallets.GetAddresses() {
+		addresses = append(addresses, address)
+		notes = append(notes, "")
+	}
+	for _, address := range wallets.GetWatchOnlyAddresses() {
+		addresses = append(addresses, address)
+		notes = append(notes, "\t(watch-only)")
+	}
+	for _, address := range wallets.GetScriptAddresses() {
+		script, _ := wallets.GetScript(address)
+		addresses = append(addresses, address)
+		notes = append(notes, fmt.Sprintf("\t(%d-of-%d multisig)", script.M, len(script.PubKeys)))
+	}
+
+	for i, address := range addresses {
+		note := notes[i]
+		if wallets.IsInactive(address) {
+			if activeOnly {
+				continue
+			}
+			note += "\t(inactive)"
+		}
+		if label := wallets.GetLabel(address); label != "" {
+			fmt.Printf("%s\t%s%s\n", address, label, note)
+		} else {
+			fmt.Printf("%s%s\n", address, note)
+		}
+	}
+}
+
+// setAddressInactive marks a wallet address inactive, or active again
+// An inactive address stops receiving change but keeps its funds; they can still be spent or swept elsewhere
+func (cli *CLI) setAddressInactive(address string, inactive bool, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SetInactive(address, inactive); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	wallets.SaveToFile(nodeID)
+
+	if inactive {
+		fmt.Printf("%s is now inactive\n", address)
+	} else {
+		fmt.Printf("%s is active again\n", address)
+	}
+}
+
+// importAddress adds a watch-only address, given directly or as a hex pubkey hash, to the wallet file
+func (cli *CLI) importAddress(address, pubKeyHashHex, nodeID string) {
+	if pubKeyHashHex != "" {
+		pubKeyHash, err := hex.DecodeString(pubKeyHashHex)
+		if err != nil || len(pubKeyHash) != ripemd160.Size {
+			fmt.Printf("ERROR: -pubkeyhash must be %d hex-encoded bytes\n", ripemd160.Size)
+			os.Exit(1)
+		}
+		address = PubKeyHashToAddress(pubKeyHash)
+	}
+
+	wallets, _ := NewWallets(nodeID)
+	err := wallets.AddWatchOnly(address)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Watching address %s\n", address)
+}
+
+// sendRawTx submits a serialized, signed transaction to the mempool
+func (cli *CLI) sendRawTx(rawHex, nodeID string) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		fmt.Println("ERROR: Transaction is not valid hex")
+		os.Exit(1)
+	}
+
+	bc := NewBlockchain("", nodeID)
+	defer bc.db.Close()
+
+	tx, err := bc.SubmitRawTransaction(raw)
+	if err != nil {
+		fmt.Printf("ERROR: Transaction rejected: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success! Transaction %x added to Mempool.\n", tx.ID)
+}
+
+// setLabel attaches a human-readable label to a wallet address
+func (cli *CLI) setLabel(address, label, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = wallets.SetLabel(address, label)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Label of '%s' set to '%s'\n", address, label)
+}
+
+// unspentOutput is the JSON form of an unspent output in the listunspent listing
+type unspentOutput struct {
+	TxID          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Value         int    `json:"value"`
+	Confirmations int    `json:"confirmations"`
+}
+
+// listUnspent prints the unspent outputs of an address
+func (cli *CLI) listUnspent(address string, asJSON bool, nodeID string) {
+	if err := CheckAddress(address); err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
+	}
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	utxos := UTXOSet{bc}
+	if !utxos.IsCurrent() {
+		fmt.Println("ERROR: The UTXO set is out of date; run rescan first")
+		bc.db.Close()
+		os.Exit(1)
+	}
+	entries, err := utxos.ListUnspent(AddressToPubKeyHash(address))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	outputs := []unspentOutput{}
+	total := 0
+	for _, entry := range entries {
+		outputs = append(outputs, unspentOutput{hex.EncodeToString(entry.TxID), entry.Vout, entry.Output.Value, entry.Confirmations})
+		total += entry.Output.Value
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(outputs, "", "  ")
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("%d unspent output(s) of '%s', total %d\n", len(outputs), address, total)
+	for _, out := range outputs {
+		fmt.Printf("  %s:%d\t%d\t%d confirmation(s)\n", out.TxID, out.Vout, out.Value, out.Confirmations)
+	}
+}
+
+// mempoolOutput is the JSON form of a transaction output in the mempool listing
+type mempoolOutput struct {
+	Value      int    `json:"value"`
+	PubKeyHash string `json:"pubkeyhash"`
+}
+
+// mempoolEntry is the JSON form of a transaction in the mempool listing
+// The ancestor and descendant fields sum its packages, as in MempoolTxStats
+type mempoolEntry struct {
+	ID             string          `json:"id"`
+	Inputs         []string        `json:"inputs"`
+	Outputs        []mempoolOutput `json:"outputs"`
+	Fee            int             `json:"fee"`
+	Size           int             `json:"size"`
+	Ancestors      int             `json:"ancestorcount"`
+	AncestorFee    int             `json:"ancestorfee"`
+	AncestorSize   int             `json:"ancestorsize"`
+	Descendants    int             `json:"descendantcount"`
+	DescendantFee  int             `json:"descendantfee"`
+	DescendantSize int             `json:"descendantsize"`
+}
+
+// listMempool prints the transactions waiting in the mempool
+func (cli *CLI) listMempool(asJSON bool, nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	entries := []mempoolEntry{}
+	for _, stats := range bc.MempoolPackageStats() {
+		tx := stats.Tx
+		entry := mempoolEntry{
+			hex.EncodeToString(tx.ID), nil, nil, stats.Fee, stats.Size,
+			stats.Ancestors, stats.AncestorFee, stats.AncestorSize,
+			stats.Descendants, stats.DescendantFee, stats.DescendantSize,
+		}
+		for _, in := range tx.Vin {
+			entry.Inputs = append(entry.Inputs, fmt.Sprintf("%x:%d", in.Txid, in.Vout))
+		}
+		for _, out := range tx.Vout {
+			entry.Outputs = append(entry.Outputs, mempoolOutput{out.Value, hex.EncodeToString(out.PubKeyHash)})
+		}
+		entries = append(entries, entry)
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("%d transaction(s) in mempool\n", len(entries))
+	for _, entry := range entries {
+		total := 0
+		for _, out := range entry.Outputs {
+			total += out.Value
+		}
+
+		fmt.Printf("--- Transaction %s\n", entry.ID)
+		fmt.Printf("     Inputs:  %d\n", len(entry.Inputs))
+		for _, in := range entry.Inputs {
+			fmt.Printf("       %s\n", in)
+		}
+		fmt.Printf("     Outputs: %d (total %d)\n", len(entry.Outputs), total)
+		for _, out := range entry.Outputs {
+			fmt.Printf("       %d -> %s\n", out.Value, out.PubKeyHash)
+		}
+		fmt.Printf("     Fee:     %d\n", entry.Fee)
+		fmt.Printf("     Size:    %d bytes (%.3f fee per byte)\n", entry.Size, feeRate(entry.Fee, entry.Size))
+		if entry.Ancestors > 1 {
+			fmt.Printf("     With %d ancestor(s): fee %d for %d bytes (%.3f fee per byte)\n", entry.Ancestors-1, entry.AncestorFee, entry.AncestorSize, feeRate(entry.AncestorFee, entry.AncestorSize))
+		}
+		if entry.Descendants > 1 {
+			fmt.Printf("     With %d descendant(s): fee %d for %d bytes\n", entry.Descendants-1, entry.DescendantFee, entry.DescendantSize)
+		}
+	}
+}
+
+// listPeers prints the peers persisted by the node's server
+func (cli *CLI) listPeers(nodeID string) {
+	infos := LoadPeerInfos(dataFilePath(peersFile, nodeID))
+	fmt.Printf("%d known peer(s)\n", len(infos))
+
+	for _, info := range infos {
+		lastSeen := "never"
+		if info.LastSeen > 0 {
+			lastSeen = time.Unix(info.LastSeen, 0).Format(time.RFC3339)
+		}
+		version := "unknown"
+		if info.Version > 0 {
+			version = strconv.Itoa(info.Version)
+		}
+		fmt.Printf("%-21s  last seen: %-25s  height: %-6d  protocol: %s\n", info.Address, lastSeen, info.BestHeight, version)
+	}
+}
+
+// addNode asks the running node to connect to the peer at address and add it to its known peers
+func (cli *CLI) addNode(address, nodeID string) {
+	cli.sendNodeCommand("addnode", address, nodeID)
+	fmt.Printf("Asked node %s to connect to %s\n", nodeID, address)
+}
+
+// disconnectNode asks the running node to drop the peer at address from its known peers
+func (cli *CLI) disconnectNode(address, nodeID string) {
+	cli.sendNodeCommand("removenode", address, nodeID)
+	fmt.Printf("Asked node %s to disconnect from %s\n", nodeID, address)
+}
+
+// sendNodeCommand sends a peer table command to the running node, exiting if address or the node is unusable
+func (cli *CLI) sendNodeCommand(command, address, nodeID string) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		fmt.Printf("ERROR: Address %q is not HOST:PORT\n", address)
+		os.Exit(1)
+	}
+
+	listenAddr, err := ResolveListenAddress("", nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := sendNodeCommand(listenAddr, command, address); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// printChain prints all blocks in the blockchain
+func (cli *CLI) printChain(nodeID string) {
+	bc, err := openBlockchainReadOnly(nodeID)
+	if errors.Is(err, errNoBlocks) {
+		fmt.Println("No blocks yet.")
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer bc.db.Close()
+
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+		printBlock(block, bc.sealer)
+
+		// Stop when we reach genesis block
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+}
+
+// printBlock prints a block and its transactions, checking its seal with sealer
+func printBlock(block *Block, sealer Sealer) {
+	fmt.Printf("============ Block %x ============\n", block.Hash)
+	fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
+	fmt.Printf("Timestamp: %d\n", block.Timestamp)
+	fmt.Printf("Nonce: %d\n", block.Nonce)
+
+	// Validate PoW
+	fmt.Printf("PoW: %s\n", strconv.FormatBool(sealer.Verify(block)))
+
+	// Print transactions
+	if block.IsPruned() {
+		fmt.Printf("Transactions pruned (merkle root %x)\n", block.MerkleRoot())
+	}
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+	fmt.Println()
+}
+
+// prune discards the transactions of all but the keep most recent blocks
+func (cli *CLI) prune(keep int, nodeID string) {
+	bc := NewBlockchain("", nodeID)
+	defer bc.db.Close()
+
+	pruned, err := bc.Prune(keep)
+	if err != nil {
+		fmt.Printf("ERROR: Pruning failed: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Pruned %d block(s), keeping the transactions of the last %d\n", pruned, keep)
+}
+
+// removeTx removes a transaction and its mempool descendants from the mempool
+func (cli *CLI) removeTx(txID, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		fmt.Println("ERROR: Transaction ID is not valid hex")
+		os.Exit(1)
+	}
+
+	bc := NewBlockchain("", nodeID)
+	defer bc.db.Close()
+
+	descendants, err := bc.RemoveFromMempool(id)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Removed transaction %s from the mempool\n", txID)
+	for _, tx := range descendants {
+		fmt.Printf("Removed transaction %x, which spent its outputs\n", tx.ID)
+	}
+}
+
+// rescan rebuilds the UTXO set and the transaction index from the blocks
+func (cli *CLI) rescan(nodeID string) {
+	bc := NewBlockchain("", nodeID)
+	defer bc.db.Close()
+
+	utxos := UTXOSet{bc}
+	err := utxos.Reindex()
+	if err == nil {
+		err = TxIndex{bc}.Reindex()
+	}
+	if err != nil {
+		fmt.Printf("ERROR: Rescan failed: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	outputs, transactions, err := utxos.CountOutputs()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Rescan complete: %d unspent output(s) in %d transaction(s)\n", outputs, transactions)
+}
+
+// send sends coins from one address to another (adds to mempool)
+// changeTo picks where the change goes, as for NewUTXOTransaction
+func (cli *CLI) send(from, to string, amount, fee int, dataHex, memo, inputs string, replace, dryRun bool, changeTo, nodeID string) {
+	if err := CheckAddress(from); err != nil {
+		log.Panicf("ERROR: Sender address is not valid: %s", err)
+	}
+	if err := CheckAddress(to); err != nil {
+		log.Panicf("ERROR: Recipient address is not valid: %s", err)
+	}
+	if _, err := sendTotal(amount, fee); err != nil {
+		fmt.Printf("ERROR: Invalid amount: %s\n", err)
+		os.Exit(1)
+	}
+	data, err := hex.DecodeString(dataHex)
+	if err != nil || len(data) > maxDataOutputSize {
+		fmt.Printf("ERROR: -data must be at most %d hex-encoded bytes\n", maxDataOutputSize)
+		os.Exit(1)
+	}
+	if len(memo) > maxMemoSize {
+		fmt.Printf("ERROR: -memo must be at most %d bytes\n", maxMemoSize)
+		os.Exit(1)
+	}
+	var coins map[string][]int
+	if inputs != "" {
+		coins, err = ParseOutpoints(inputs)
+		if err != nil {
+			fmt.Printf("ERROR: Invalid -inputs: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	known := wallets.GetAddresses()
+
+	bc := NewBlockchain(from, nodeID)
+	defer bc.db.Close()
+
+	tx, err := NewUTXOTransaction(from, to, amount, fee, SendOptions{data, []byte(memo), coins, replace, changeTo}, bc, wallets)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+	newKeys := newAddresses(wallets, known)
+
+	if dryRun {
+		change := from
+		if changeTo != "" && changeTo != newChangeAddress {
+			change = changeTo
+		}
+		if len(newKeys) > 0 {
+			change = newKeys[0]
+		}
+		printTxBreakdown(bc, tx, change)
+		if _, err := bc.CheckMempoolAccept(tx); err != nil {
+			fmt.Printf("ERROR: Transaction would be rejected: %s\n", err)
+			bc.db.Close()
+			os.Exit(1)
+		}
+		if len(newKeys) > 0 {
+			fmt.Printf("Dry run: the change address %s was not added to the wallet.\n", change)
+		}
+		fmt.Println("Dry run: the transaction was not added to the Mempool.")
+		return
+	}
+
+	err = bc.AddToMempool(tx)
+	if err != nil {
+		fmt.Printf("ERROR: Transaction rejected: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+	if len(newKeys) > 0 {
+		wallets.SaveToFile(nodeID)
+	}
+
+	fmt.Println("Success! Transaction added to Mempool.")
+}
+
+// newAddresses returns the addresses of wallets that aren't in known, such as the key created for a payment's change
+func newAddresses(wallets *Wallets, known []string) []string {
+	seen := make(map[string]bool)
+	for _, address := range known {
+		seen[address] = true
+	}
+
+	var added []string
+	for _, address := range wallets.GetAddresses() {
+		if !seen[address] {
+			added = append(added, address)
+		}
+	}
+
+	return added
+}
+
+// printTxBreakdown prints the inputs, outputs, change and fee of a transaction whose change goes to change
+func printTxBreakdown(bc *Blockchain, tx *Transaction, change string) {
+	changeHash := AddressToPubKeyHash(change)
+
+	fmt.Printf("Transaction %x (%d bytes)\n", tx.ID, tx.SerializedSize())
+	inputValue := 0
+	fmt.Printf("  Inputs:  %d\n", len(tx.Vin))
+	for _, vin := range tx.Vin {
+		prevTx, err := bc.findPrevTransaction(vin.Txid)
+		if err != nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			fmt.Printf("    %x:%d\t(unknown)\n", vin.Txid, vin.Vout)
+			continue
+		}
+		inputValue += prevTx.Vout[vin.Vout].Value
+		fmt.Printf("    %x:%d\t%d\n", vin.Txid, vin.Vout, prevTx.Vout[vin.Vout].Value)
+	}
+
+	outputValue := 0
+	fmt.Printf("  Outputs: %d\n", len(tx.Vout))
+	for i, out := range tx.Vout {
+		outputValue += out.Value
+		switch {
+		case out.IsData():
+			fmt.Printf("    %d\tdata %x\n", i, out.Data)
+		case out.IsMultisig():
+			fmt.Printf("    %d\t%d -> %s\n", i, out.Value, out.Multisig.Address())
+		case out.ScriptHash:
+			fmt.Printf("    %d\t%d -> %s\n", i, out.Value, ScriptHashToAddress(out.PubKeyHash))
+		case i > 0 && bytes.Equal(out.PubKeyHash, changeHash):
+			fmt.Printf("    %d\t%d -> %s (change)\n", i, out.Value, change)
+		default:
+			fmt.Printf("    %d\t%d -> %s\n", i, out.Value, PubKeyHashToAddress(out.PubKeyHash))
+		}
+	}
+	fmt.Printf("  Fee:     %d\n", inputValue-outputValue)
+	if len(tx.Memo) > 0 {
+		fmt.Printf("  Memo:    %s\n", tx.Memo)
+	}
+	if tx.Replaceable {
+		fmt.Println("  Replaceable: yes")
+	}
+}
+
+// sendMulti sends amount to to, combining the funds of several wallet addresses
+func (cli *CLI) sendMulti(fromAddrs []string, to string, amount, fee int, changeTo, nodeID string) {
+	for i, from := range fromAddrs {
+		fromAddrs[i] = strings.TrimSpace(from)
+		if err := CheckAddress(fromAddrs[i]); err != nil {
+			log.Panicf("ERROR: Sender address is not valid: %s", err)
+		}
+	}
+	if err := CheckAddress(to); err != nil {
+		log.Panicf("ERROR: Recipient address is not valid: %s", err)
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	known := wallets.GetAddresses()
+
+	bc := NewBlockchain(fromAddrs[0], nodeID)
+	defer bc.db.Close()
+
+	tx, err := NewUTXOTransactionMultiSource(fromAddrs, to, amount, fee, changeTo, bc, wallets)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	err = bc.AddToMempool(tx)
+	if err != nil {
+		fmt.Printf("ERROR: Transaction rejected: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+	if len(newAddresses(wallets, known)) > 0 {
+		wallets.SaveToFile(nodeID)
+	}
+
+	fmt.Printf("Success! Transaction spending from %d address(es) added to Mempool.\n", len(fromAddrs))
+}
+
+// sendMany pays every recipient of a payments file from one address, in a single transaction
+func (cli *CLI) sendMany(from, paymentsFile string, fee int, nodeID string) {
+	if err := CheckAddress(from); err != nil {
+		log.Panicf("ERROR: Sender address is not valid: %s", err)
+	}
+
+	payments, err := LoadPayments(paymentsFile)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	known := wallets.GetAddresses()
+
+	bc := NewBlockchain(from, nodeID)
+	defer bc.db.Close()
+
+	tx, err := NewUTXOTransactionMany(from, payments, fee, bc, wallets)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	err = bc.AddToMempool(tx)
+	if err != nil {
+		fmt.Printf("ERROR: Transaction rejected: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+	if len(newAddresses(wallets, known)) > 0 {
+		wallets.SaveToFile(nodeID)
+	}
+
+	total := 0
+	for _, to := range paymentAddresses(payments) {
+		fmt.Printf("  %s: %d\n", to, payments[to])
+		total += payments[to]
+	}
+	fmt.Printf("Success! Transaction %x paying %d to %d recipient(s) added to Mempool (fee %d).\n", tx.ID, total, len(payments), fee)
+}
+
+// mine mines a block with transactions from the mempool
+func (cli *CLI) mine(address, coinbaseData, nodeID string) {
+	if err := CheckAddress(address); err != nil {
+		log.Panicf("ERROR: Miner address is not valid: %s", err)
+	}
+	if len(coinbaseData) > maxCoinbaseTextLen {
+		fmt.Printf("ERROR: Coinbase data has %d bytes, more than %d\n", len(coinbaseData), maxCoinbaseTextLen)
+		os.Exit(1)
+	}
+
+	bc := NewBlockchain(address, nodeID)
+	defer bc.db.Close()
+
+	// Verify transactions before mining, collecting their fees for the coinbase
+	txs, fees, skipped := bc.selectMempoolTransactions()
+	for _, err := range skipped {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+
+	if len(txs) == 0 {
+		fmt.Println("No valid transactions in mempool. Mining new block with Coinbase only.")
+	}
+
+	// Add coinbase transaction
+	cbTx, err := NewMinerCoinbaseTX(address, coinbaseData, fees, bc.GetBestHeight()+1)
+	if err != nil {
+		log.Panic(err)
+	}
+	txs = append([]*Transaction{cbTx}, txs...) // Coinbase first
+
+	// Mine block
+	newBlock := bc.MineBlock(txs)
+
+	// Only the mined transactions leave the mempool; those left out wait for a later block
+	if err := bc.RemoveMinedFromMempool(txs[1:]); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+
+	fmt.Printf("Success! Mined block: %x\n", newBlock.Hash)
+}
+
+// signMessage prints a signature proving that the wallet owns address
+func (cli *CLI) signMessage(address, message, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("ERROR: Address %s is not in the wallet file", address)
+	}
+
+	fmt.Println(SignMessage(*wallet, message))
+}
+
+// startNode starts a node listening on listenAddr
+// A positive mineInterval makes a mining node mine a block at that interval
+func (cli *CLI) startNode(nodeID, listenAddr, minerAddress string, mineInterval time.Duration, seeds []string) {
+	fmt.Printf("Starting node %s\n", nodeID)
+	if len(minerAddress) > 0 {
+		if ValidateAddress(minerAddress) {
+			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
+		} else {
+			log.Panic("Wrong miner address!")
+		}
+	}
+	if mineInterval < 0 {
+		fmt.Println("ERROR: Mine interval must not be negative")
+		os.Exit(1)
+	}
+	if mineInterval > 0 && minerAddress == "" {
+		fmt.Println("ERROR: -mine-interval requires -miner")
+		os.Exit(1)
+	}
+	StartServer(nodeID, listenAddr, minerAddress, mineInterval, seeds)
+}
+
+// vanity searches for a key whose address starts with prefix and adds it to the wallet
+func (cli *CLI) vanity(prefix string, workers int, nodeID string) {
+	if expected := VanityAttempts(prefix); expected > vanityWarnAttempts {
+		fmt.Printf("Warning: a %d-character prefix takes about %.0f attempts and may not be found\n", len(prefix), expected)
+	}
+
+	wallet, attempts, err := FindVanityWallet(prefix, workers, maxVanityAttempts)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	wallets, _ := NewWallets(nodeID)
+	address := wallets.AddWallet(wallet)
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Found after %d attempt(s). Your new address: %s\n", attempts, address)
+}
+
+// verifyMessage checks a signature printed by signmessage
+func (cli *CLI) verifyMessage(address, message, signature string) {
+	valid, err := VerifyMessage(address, message, signature)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if !valid {
+		fmt.Println("Signature is NOT valid")
+		os.Exit(1)
+	}
+
+	fmt.Println("Signature is valid")
+}
+
+// verifyTx checks a transaction, given serialized or by ID, against the chain and prints why it fails, if it does
+// A transaction already in a block spends its own inputs, so only its signatures and values are checked
+// Similar to Bitcoin's testmempoolaccept RPC
+func (cli *CLI) verifyTx(rawHex, txID, nodeID string) {
+	bc := OpenBlockchainReadOnly(nodeID)
+	defer bc.db.Close()
+
+	var raw, blockHash []byte
+	var err error
+	if rawHex != "" {
+		raw, err = hex.DecodeString(rawHex)
+		if err != nil {
+			fmt.Println("ERROR: Transaction is not valid hex")
+			bc.db.Close()
+			os.Exit(1)
+		}
+	} else {
+		id, err := hex.DecodeString(txID)
+		if err != nil {
+			fmt.Println("ERROR: Transaction ID is not valid hex")
+			bc.db.Close()
+			os.Exit(1)
+		}
+		raw, blockHash, err = bc.GetRawTransaction(id)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			bc.db.Close()
+			os.Exit(1)
+		}
+	}
+
+	tx, err := DecodeTransaction(raw)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Transaction %x\n", tx.ID)
+	if !tx.IsCoinbase() {
+		for inID, vin := range tx.Vin {
+			prevOut := "previous transaction not found"
+			if prevTx, err := bc.findPrevTransaction(vin.Txid); err == nil {
+				prevOut = "output not found"
+				if vin.Vout >= 0 && vin.Vout < len(prevTx.Vout) {
+					prevOut = fmt.Sprintf("value %d", prevTx.Vout[vin.Vout].Value)
+				}
+			}
+			fmt.Printf("  Input %d: %x:%d, %s\n", inID, vin.Txid, vin.Vout, prevOut)
+		}
+	}
+
+	if blockHash != nil {
+		fmt.Printf("Confirmed in block %x; checking signatures and values only\n", blockHash)
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			err = errors.New("transaction ID does not match its contents")
+		} else {
+			err = bc.verifyTransactionInputs(tx)
+		}
+	} else {
+		err = bc.VerifyTransaction(tx)
+	}
+	if err != nil {
+		fmt.Printf("INVALID (%s): %s\n", txFailureClass(err), err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Println("Valid")
+}
+
+// txFailureClass names the kind of failure of a transaction verification error
+func txFailureClass(err error) string {
+	switch {
+	case errors.Is(err, errMissingPrevTx):
+		return "missing previous transaction"
+	case errors.Is(err, errBadSignature):
+		return "bad signature"
+	case errors.Is(err, errDoubleSpend):
+		return "double spend"
+	default:
+		return "invalid transaction"
+	}
+}
+
+// Run parses command line arguments and executes commands
+func (cli *CLI) Run() {
+	cli.validateArgs()
+
+	nodeID := os.Getenv("NODE_ID")
+	if nodeID == "" {
+		fmt.Printf("NODE_ID env. var is not set!\n")
+		os.Exit(1)
+	}
+	loadLogLevelFromEnv()
+	loadPolicyFromEnv()
+	loadTargetBitsFromEnv()
+
+	activateAddressCmd := flag.NewFlagSet("activateaddress", flag.ExitOnError)
+	addNodeCmd := flag.NewFlagSet("addnode", flag.ExitOnError)
+	compactCmd := flag.NewFlagSet("compact", flag.ExitOnError)
+	confirmationsCmd := flag.NewFlagSet("confirmations", flag.ExitOnError)
+	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMultisigWalletCmd := flag.NewFlagSet("createmultisigwallet", flag.ExitOnError)
+	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	deactivateAddressCmd := flag.NewFlagSet("deactivateaddress", flag.ExitOnError)
+	decodeAddressCmd := flag.NewFlagSet("decodeaddress", flag.ExitOnError)
+	decodeBlockCmd := flag.NewFlagSet("decodeblock", flag.ExitOnError)
+	decodeTxCmd := flag.NewFlagSet("decodetx", flag.ExitOnError)
+	disconnectNodeCmd := flag.NewFlagSet("disconnectnode", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	estimateFeeCmd := flag.NewFlagSet("estimatefee", flag.ExitOnError)
+	estimateHashRateCmd := flag.NewFlagSet("estimatehashrate", flag.ExitOnError)
+	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	getBlockHeaderCmd := flag.NewFlagSet("getblockheader", flag.ExitOnError)
+	getBlockTxsCmd := flag.NewFlagSet("getblocktxs", flag.ExitOnError)
+	getChainIDCmd := flag.NewFlagSet("getchainid", flag.ExitOnError)
+	getDifficultyCmd := flag.NewFlagSet("getdifficulty", flag.ExitOnError)
+	getMiningInfoCmd := flag.NewFlagSet("getmininginfo", flag.ExitOnError)
+	getNewAddressCmd := flag.NewFlagSet("getnewaddress", flag.ExitOnError)
+	getRawTxCmd := flag.NewFlagSet("getrawtx", flag.ExitOnError)
+	getUTXOStatsCmd := flag.NewFlagSet("getutxostats", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	infoCmd := flag.NewFlagSet("info", flag.ExitOnError)
+	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	activateAddressAddress := activateAddressCmd.String("address", "", "The wallet address to activate again")
+	deactivateAddressAddress := deactivateAddressCmd.String("address", "", "The wallet address to deactivate")
+	estimateFeeSize := estimateFeeCmd.Int("size", typicalTxSize, "Size of the transaction in bytes")
+	estimateFeeParent := estimateFeeCmd.String("parent", "", "ID of a stuck mempool transaction the new transaction spends and pays for")
+	listAddressesActive := listAddressesCmd.Bool("active", false, "Leave out addresses marked inactive")
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	mempoolCmd := flag.NewFlagSet("mempool", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	peersCmd := flag.NewFlagSet("peers", flag.ExitOnError)
+	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+	removeTxCmd := flag.NewFlagSet("removetx", flag.ExitOnError)
+	rescanCmd := flag.NewFlagSet("rescan", flag.ExitOnError)
+	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	vanityCmd := flag.NewFlagSet("vanity", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
+	verifyTxCmd := flag.NewFlagSet("verifytx", flag.ExitOnError)
+
+	addNodeAddress := addNodeCmd.String("address", "", "HOST:PORT of the peer to connect to")
+	confirmationsID := confirmationsCmd.String("id", "", "ID of the transaction")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainChainID := createBlockchainCmd.Int64("chainid", defaultChainID, "Chain ID that transactions are signed for")
+	createBlockchainGenesis := createBlockchainCmd.String("genesis", "", "JSON file with the genesis message and allocations")
+	createBlockchainCompress := createBlockchainCmd.Bool("compress", false, "Store the blocks of the chain gzip-compressed")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", "", "Consensus algorithm of the chain: "+strings.Join(consensusNames(), ", ")+" (defaults to the network's)")
+	createMultisigM := createMultisigCmd.Int("m", 0, "Number of signatures required to spend")
+	createMultisigAddresses := createMultisigCmd.String("addresses", "", "Comma-separated wallet addresses whose keys can sign")
+	createMultisigWalletM := createMultisigWalletCmd.Int("m", 0, "Number of signatures required to spend")
+	createMultisigWalletPubKeys := createMultisigWalletCmd.String("pubkeys", "", "Comma-separated hex public keys or wallet addresses whose keys can sign")
+	decodeAddressAddress := decodeAddressCmd.String("address", "", "The address to decode")
+	decodeBlockHex := decodeBlockCmd.String("hex", "", "The serialized block, hex-encoded")
+	decodeTxHex := decodeTxCmd.String("hex", "", "The serialized transaction, hex-encoded")
+	disconnectNodeAddress := disconnectNodeCmd.String("address", "", "HOST:PORT of the peer to drop")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "The wallet address to export the key of")
+	estimateHashRateBlocks := estimateHashRateCmd.Int("blocks", defaultHashRateBlocks, "Number of recent blocks to average over")
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	getBalanceMinConf := getBalanceCmd.Int("minconf", 1, "Only count outputs with at least this many confirmations")
+	getBlockHeaderHash := getBlockHeaderCmd.String("hash", "", "Hash of the block")
+	getBlockTxsHash := getBlockTxsCmd.String("hash", "", "Hash of the block")
+	getBlockTxsJSON := getBlockTxsCmd.Bool("json", false, "Print the full transactions as JSON")
+	getNewAddressLabel := getNewAddressCmd.String("label", "", "Label to attach to the new address")
+	getRawTxID := getRawTxCmd.String("id", "", "ID of the transaction to print")
+	historyAddress := historyCmd.String("address", "", "The address to list transactions for")
+	importAddressAddress := importAddressCmd.String("address", "", "The address to watch")
+	importAddressPubKeyHash := importAddressCmd.String("pubkeyhash", "", "The hex-encoded pubkey hash to watch, instead of -address")
+	importPrivKeyKey := importPrivKeyCmd.String("key", "", "The base58check private key to import")
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list unspent outputs for")
+	listUnspentJSON := listUnspentCmd.Bool("json", false, "Print the outputs as JSON")
+	mempoolJSON := mempoolCmd.Bool("json", false, "Print the mempool as JSON")
+	mineAddress := mineCmd.String("address", "", "The address to send mining rewards to")
+	mineCoinbaseData := mineCmd.String("coinbasedata", "", "Text to put in the coinbase (defaults to naming the reward address)")
+	pruneKeep := pruneCmd.Int("keep", defaultPruneKeep, "Number of recent blocks whose transactions are kept")
+	removeTxID := removeTxCmd.String("id", "", "ID of the transaction to remove")
+	sendFrom := sendCmd.String("from", "", "Source wallet address")
+	sendFromMulti := sendCmd.String("from-multi", "", "Comma-separated source addresses whose funds are combined (instead of -from)")
+	sendTo := sendCmd.String("to", "", "Destination wallet address")
+	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", -1, "Fee paid to the miner (defaults to the minimum relay fee)")
+	sendData := sendCmd.String("data", "", "Hex-encoded data to embed in an unspendable output")
+	sendReplace := sendCmd.Bool("replace", false, "Signal replace-by-fee, and allow -inputs to replace a replaceable mempool transaction")
+	sendMemo := sendCmd.String("memo", "", "Note stored with the transaction")
+	sendDryRun := sendCmd.Bool("dryrun", false, "Build and sign the transaction and print it, without adding it to the mempool")
+	sendInputs := sendCmd.String("inputs", "", "Comma-separated TXID:VOUT outputs to spend (default: chosen automatically)")
+	sendChangeAddr := sendCmd.String("changeaddr", "", "Wallet address the change goes to (default: the sender)")
+	sendAutoChange := sendCmd.Bool("auto-change", false, "Send the change to a new wallet address")
+	sendManyFrom := sendManyCmd.String("from", "", "Source wallet address")
+	sendManyFile := sendManyCmd.String("file", "", "JSON file mapping recipient addresses to amounts")
+	sendManyFee := sendManyCmd.Int("fee", -1, "Fee paid to the miner (defaults to the minimum relay fee)")
+	sendRawTxHex := sendRawTxCmd.String("hex", "", "The serialized transaction, hex-encoded")
+	setLabelAddress := setLabelCmd.String("address", "", "The wallet address to label")
+	setLabelName := setLabelCmd.String("label", "", "The label to attach")
+	signMessageAddress := signMessageCmd.String("address", "", "The wallet address to sign with")
+	signMessageText := signMessageCmd.String("message", "", "The message to sign")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeSeeds := startNodeCmd.String("seeds", "", "Comma-separated seed nodes to connect to (HOST:PORT)")
+	startNodeMineInterval := startNodeCmd.Duration("mine-interval", 0, "Mine a block every DURATION (e.g. 10s); requires -miner")
+	startNodeCheckpoints := startNodeCmd.String("checkpoints", "", "File of HEIGHT HASH lines that received blocks must match")
+	startNodeListen := startNodeCmd.String("listen", "", "HOST:PORT to bind to (default localhost:NODE_ID, or LISTEN_ADDR env)")
+	startNodeMaxInbound := startNodeCmd.Int("maxinbound", defaultMaxInbound, "Reject connections beyond N handled at once; 0 disables the limit")
+	startNodeMaxConnRate := startNodeCmd.Int("maxconnrate", defaultMaxConnRate, "Reject connections beyond N per minute from one IP; 0 disables the limit")
+	startNodeMetricsAddr := startNodeCmd.String("metrics-addr", "", "HOST:PORT to serve Prometheus metrics on (disabled if empty)")
+	startNodeMaxReorg := startNodeCmd.Int("maxreorg", defaultMaxReorgDepth, "Reject branches forking more than DEPTH blocks below the tip; 0 disables the limit")
+	vanityPrefix := vanityCmd.String("prefix", "", "Base58 prefix the address must start with, e.g. 1Bob")
+	vanityWorkers := vanityCmd.Int("workers", runtime.NumCPU(), "Number of keys generated in parallel")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "The address that signed the message")
+	verifyMessageText := verifyMessageCmd.String("message", "", "The signed message")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "The signature printed by signmessage")
+	verifyTxHex := verifyTxCmd.String("hex", "", "The serialized transaction, e.g. as printed by getrawtx")
+	verifyTxID := verifyTxCmd.String("id", "", "ID of a transaction in the mempool or a block")
+
+	switch os.Args[1] {
+	case "activateaddress":
+		err := activateAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "addnode":
+		err := addNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "compact":
+		err := compactCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "confirmations":
+		err := confirmationsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createblockchain":
+		err := createBlockchainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisigwallet":
+		err := createMultisigWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createwallet":
+		err := createWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "deactivateaddress":
+		err := deactivateAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "decodeaddress":
+		err := decodeAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "decodeblock":
+		err := decodeBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "decodetx":
+		err := decodeTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "disconnectnode":
+		err := disconnectNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "estimatefee":
+		err := estimateFeeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "estimatehashrate":
+		err := estimateHashRateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getbalance":
+		err := getBalanceCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblockheader":
+		err := getBlockHeaderCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblocktxs":
+		err := getBlockTxsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getchainid":
+		err := getChainIDCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getdifficulty":
+		err := getDifficultyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getmininginfo":
+		err := getMiningInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getnewaddress":
+		err := getNewAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getrawtx":
+		err := getRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getutxostats":
+		err := getUTXOStatsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importaddress":
+		err := importAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "info":
+		err := infoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listaddresses":
+		err := listAddressesCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mempool":
+		err := mempoolCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mine":
+		err := mineCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "peers":
+		err := peersCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "printchain":
+		err := printChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "prune":
+		err := pruneCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "removetx":
+		err := removeTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "rescan":
+		err := rescanCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "send":
+		err := sendCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setlabel":
+		err := setLabelCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmessage":
+		err := signMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "vanity":
+		err := vanityCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifytx":
+		err := verifyTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	default:
+		cli.printUsage()
+		os.Exit(1)
+	}
+
+	if activateAddressCmd.Parsed() {
+		if *activateAddressAddress == "" {
+			activateAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setAddressInactive(*activateAddressAddress, false, nodeID)
+	}
+
+	if addNodeCmd.Parsed() {
+		if *addNodeAddress == "" {
+			addNodeCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.addNode(*addNodeAddress, nodeID)
+	}
+
+	if compactCmd.Parsed() {
+		cli.compact(nodeID)
+	}
+
+	if confirmationsCmd.Parsed() {
+		if *confirmationsID == "" {
+			confirmationsCmd.Usage()
+			os.Exit(1)
+		}
+		cli.confirmations(*confirmationsID, nodeID)
+	}
+
+	if createBlockchainCmd.Parsed() {
+		if (*createBlockchainAddress == "") == (*createBlockchainGenesis == "") {
+			createBlockchainCmd.Usage()
+			os.Exit(1)
+		}
+		if *createBlockchainConsensus != "" {
+			algo, err := parseConsensus(*createBlockchainConsensus)
+			if err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+				os.Exit(1)
+			}
+			defaultHasher = hashers[algo]
+		}
+		compressBlocks = *createBlockchainCompress
+		if *createBlockchainGenesis != "" {
+			cli.createBlockchainFromGenesis(*createBlockchainGenesis, nodeID, *createBlockchainChainID)
+		} else {
+			cli.createBlockchain(*createBlockchainAddress, nodeID, *createBlockchainChainID)
+		}
+	}
+
+	if createMultisigCmd.Parsed() {
+		if *createMultisigM <= 0 || *createMultisigAddresses == "" {
+			createMultisigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisig(*createMultisigM, strings.Split(*createMultisigAddresses, ","), nodeID)
+	}
+
+	if createMultisigWalletCmd.Parsed() {
+		if *createMultisigWalletM <= 0 || *createMultisigWalletPubKeys == "" {
+			createMultisigWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisigWallet(*createMultisigWalletM, strings.Split(*createMultisigWalletPubKeys, ","), nodeID)
+	}
+
+	if createWalletCmd.Parsed() {
+		cli.createWallet(nodeID)
+	}
+
+	if deactivateAddressCmd.Parsed() {
+		if *deactivateAddressAddress == "" {
+			deactivateAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setAddressInactive(*deactivateAddressAddress, true, nodeID)
+	}
+
+	if decodeAddressCmd.Parsed() {
+		if *decodeAddressAddress == "" {
+			decodeAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.decodeAddress(*decodeAddressAddress)
+	}
+
+	if decodeBlockCmd.Parsed() {
+		if *decodeBlockHex == "" {
+			decodeBlockCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.decodeBlock(*decodeBlockHex)
+	}
+
+	if decodeTxCmd.Parsed() {
+		if *decodeTxHex == "" {
+			decodeTxCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.decodeTx(*decodeTxHex)
+	}
+
+	if disconnectNodeCmd.Parsed() {
+		if *disconnectNodeAddress == "" {
+			disconnectNodeCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.disconnectNode(*disconnectNodeAddress, nodeID)
+	}
+
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(*dumpPrivKeyAddress, nodeID)
+	}
+
+	if estimateFeeCmd.Parsed() {
+		if *estimateFeeSize <= 0 {
+			estimateFeeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.estimateFee(*estimateFeeSize, *estimateFeeParent, nodeID)
+	}
+
+	if estimateHashRateCmd.Parsed() {
+		if *estimateHashRateBlocks < 1 {
+			estimateHashRateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.estimateHashRate(*estimateHashRateBlocks, nodeID)
+	}
+
+	if getBalanceCmd.Parsed() {
+		if *getBalanceAddress == "" || *getBalanceMinConf < 0 {
+			getBalanceCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBalance(*getBalanceAddress, *getBalanceMinConf, nodeID)
+	}
+
+	if getBlockHeaderCmd.Parsed() {
+		if *getBlockHeaderHash == "" {
+			getBlockHeaderCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.getBlockHeader(*getBlockHeaderHash, nodeID)
+	}
+
+	if getBlockTxsCmd.Parsed() {
+		if *getBlockTxsHash == "" {
+			getBlockTxsCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.getBlockTxs(*getBlockTxsHash, *getBlockTxsJSON, nodeID)
+	}
+
+	if getChainIDCmd.Parsed() {
+		cli.getChainID(nodeID)
+	}
+
+	if getDifficultyCmd.Parsed() {
+		cli.getDifficulty(nodeID)
+	}
+
+	if getMiningInfoCmd.Parsed() {
+		cli.getMiningInfo(nodeID)
+	}
+
+	if getNewAddressCmd.Parsed() {
+		cli.getNewAddress(*getNewAddressLabel, nodeID)
+	}
+
+	if getRawTxCmd.Parsed() {
+		if *getRawTxID == "" {
+			getRawTxCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.getRawTx(*getRawTxID, nodeID)
+	}
+
+	if getUTXOStatsCmd.Parsed() {
+		cli.getUTXOStats(nodeID)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyAddress, nodeID)
+	}
+
+	if importAddressCmd.Parsed() {
+		if (*importAddressAddress == "") == (*importAddressPubKeyHash == "") {
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importAddress(*importAddressAddress, *importAddressPubKeyHash, nodeID)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKeyKey == "" {
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(*importPrivKeyKey, nodeID)
+	}
+
+	if infoCmd.Parsed() {
+		cli.info(nodeID)
+	}
+
+	if listAddressesCmd.Parsed() {
+		cli.listAddresses(*listAddressesActive, nodeID)
+	}
+
+	if listUnspentCmd.Parsed() {
+		if *listUnspentAddress == "" {
+			listUnspentCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.listUnspent(*listUnspentAddress, *listUnspentJSON, nodeID)
+	}
+
+	if mempoolCmd.Parsed() {
+		cli.listMempool(*mempoolJSON, nodeID)
+	}
+
+	if mineCmd.Parsed() {
+		if *mineAddress == "" {
+			mineCmd.Usage()
+			os.Exit(1)
+		}
+		cli.mine(*mineAddress, *mineCoinbaseData, nodeID)
+	}
+
+	if peersCmd.Parsed() {
+		cli.listPeers(nodeID)
+	}
+
+	if printChainCmd.Parsed() {
+		cli.printChain(nodeID)
+	}
+
+	if pruneCmd.Parsed() {
+		if *pruneKeep < 1 {
+			pruneCmd.Usage()
+			os.Exit(1)
+		}
+		cli.prune(*pruneKeep, nodeID)
+	}
+
+	if removeTxCmd.Parsed() {
+		if *removeTxID == "" {
+			removeTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.removeTx(*removeTxID, nodeID)
+	}
+
+	if rescanCmd.Parsed() {
+		cli.rescan(nodeID)
+	}
+
+	if sendCmd.Parsed() {
+		if (*sendFrom == "") == (*sendFromMulti == "") || *sendTo == "" || *sendAmount <= 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID)
+		if *sendFee < 0 {
+			*sendFee = minRelayFee
+		}
+		changeTo := *sendChangeAddr
+		if *sendAutoChange {
+			if changeTo != "" {
+				fmt.Println("ERROR: -changeaddr and -auto-change can't be combined")
+				os.Exit(1)
+			}
+			changeTo = newChangeAddress
+		}
+
+		if *sendFromMulti != "" {
+			if *sendData != "" || *sendMemo != "" || *sendInputs != "" || *sendReplace || *sendDryRun {
+				fmt.Println("ERROR: -from-multi can't be combined with -data, -memo, -inputs, -replace or -dryrun")
+				os.Exit(1)
+			}
+			cli.sendMulti(strings.Split(*sendFromMulti, ","), *sendTo, *sendAmount, *sendFee, changeTo, nodeID)
+			return
+		}
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendData, *sendMemo, *sendInputs, *sendReplace, *sendDryRun, changeTo, nodeID)
+	}
+
+	if sendManyCmd.Parsed() {
+		if *sendManyFrom == "" || *sendManyFile == "" {
+			sendManyCmd.Usage()
+			os.Exit(1)
+		}
+		if *sendManyFee < 0 {
+			*sendManyFee = minRelayFee
+		}
+		cli.sendMany(*sendManyFrom, *sendManyFile, *sendManyFee, nodeID)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxHex == "" {
+			sendRawTxCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.sendRawTx(*sendRawTxHex, nodeID)
+	}
+
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" {
+			setLabelCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setLabel(*setLabelAddress, *setLabelName, nodeID)
+	}
+
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMessage(*signMessageAddress, *signMessageText, nodeID)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -313,6 +2308,61 @@ func (cli *CLI) Run() {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		if *startNodeCheckpoints != "" {
+			loaded, err := LoadCheckpoints(*startNodeCheckpoints)
+			if err != nil {
+				fmt.Printf("ERROR: Loading checkpoints: %s\n", err)
+				os.Exit(1)
+			}
+			checkpoints = loaded
+		}
+		if *startNodeMaxReorg < 0 {
+			fmt.Println("ERROR: -maxreorg must not be negative")
+			os.Exit(1)
+		}
+		maxReorgDepth = *startNodeMaxReorg
+		if *startNodeMaxInbound < 0 || *startNodeMaxConnRate < 0 {
+			fmt.Println("ERROR: -maxinbound and -maxconnrate must not be negative")
+			os.Exit(1)
+		}
+		maxInbound = *startNodeMaxInbound
+		maxConnRate = *startNodeMaxConnRate
+		if *startNodeMetricsAddr != "" {
+			if _, _, err := net.SplitHostPort(*startNodeMetricsAddr); err != nil {
+				fmt.Printf("ERROR: Metrics address %q is not HOST:PORT\n", *startNodeMetricsAddr)
+				os.Exit(1)
+			}
+		}
+		metricsAddr = *startNodeMetricsAddr
+		listenAddr, err := ResolveListenAddress(*startNodeListen, nodeID)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		cli.startNode(nodeID, listenAddr, *startNodeMiner, *startNodeMineInterval, ResolveSeedNodes(*startNodeSeeds))
+	}
+
+	if vanityCmd.Parsed() {
+		if *vanityPrefix == "" {
+			vanityCmd.Usage()
+			os.Exit(1)
+		}
+		cli.vanity(*vanityPrefix, *vanityWorkers, nodeID)
+	}
+
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyMessage(*verifyMessageAddress, *verifyMessageText, *verifyMessageSignature)
+	}
+
+	if verifyTxCmd.Parsed() {
+		if (*verifyTxHex == "") == (*verifyTxID == "") {
+			verifyTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyTx(*verifyTxHex, *verifyTxID, nodeID)
 	}
 }
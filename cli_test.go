@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDiskTestChain returns a chain as newTestChain does, kept in nodeID's DB in a temporary data dir,
+// with the genesis wallet saved to nodeID's wallet file
+// Close the chain before running a command on it; commands open the DB themselves
+func newDiskTestChain(t *testing.T, nodeID string) (*Blockchain, *Wallet) {
+	t.Helper()
+
+	useDataDir(t)
+	store, err := openBoltStore(dataFilePath(dbFile, nodeID), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, w := newTestChainWithStore(t, store)
+
+	wallets, _ := NewWallets(nodeID)
+	wallets.AddWallet(w)
+	wallets.SaveToFile(nodeID)
+
+	return bc, w
+}
+
+// runCLI runs the command line args as node 3000 in a child process of the test binary, with the test's
+// data dir and target bits, and returns its standard output and whether it succeeded
+// Failing commands call os.Exit, which would end the test binary if run in-process
+func runCLI(t *testing.T, args ...string) (string, bool) {
+	t.Helper()
+
+	out, err := cliCommand(args...).Output()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		t.Fatal(err)
+	}
+
+	return string(out), err == nil
+}
+
+// cliCommand returns the child process runCLI runs args in, for commands that don't exit on their own
+func cliCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestCLIHelperProcess$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "CLI_HELPER_PROCESS=1", "NODE_ID=3000",
+		"DATA_DIR="+dataDir, "POW_TARGET_BITS="+strconv.Itoa(targetBits))
+
+	return cmd
+}
+
+// TestCLIHelperProcess runs the command of runCLI; it does nothing when run by go test
+func TestCLIHelperProcess(t *testing.T) {
+	if os.Getenv("CLI_HELPER_PROCESS") == "" {
+		return
+	}
+
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"blockchain"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	(&CLI{}).Run()
+	os.Exit(0)
+}
+
+// captureOutput returns what run prints to standard output
+func captureOutput(t *testing.T, run func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+
+	run()
+	w.Close()
+
+	return <-out
+}
+
+func TestListMempool(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	address := string(w.GetAddress())
+
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 2))); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, hash := range bc.GetBlockHashes() {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := spendOutput(t, bc, w, block.Transactions[0], 0, string(NewWallet().GetAddress()), 2, false)
+		if err := bc.AddToMempool(tx); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, hex.EncodeToString(tx.ID))
+	}
+	bc.db.Close()
+
+	var entries []mempoolEntry
+	out := captureOutput(t, func() { (&CLI{}).listMempool(true, "3000") })
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	var got []string
+	for _, entry := range entries {
+		if entry.Fee != 2 || len(entry.Inputs) != 1 || len(entry.Outputs) != 1 || entry.Outputs[0].Value != subsidy-2 {
+			t.Errorf("entry %+v doesn't describe its transaction", entry)
+		}
+		got = append(got, entry.ID)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("mempool lists %v, want %v", got, want)
+	}
+}
+
+// outputFields maps the "Name:   value" lines of a command's output to their values
+func outputFields(out string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			fields[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	return fields
+}
+
+func TestInfo(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	address := string(w.GetAddress())
+
+	spend := spendCoinbase(t, bc, w, address, 1)
+	coinbase := NewCoinbaseTX(address, "", 1, 2)
+	if err := bc.AddBlock(peerBlock(t, bc, coinbase, spend)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddToMempool(spendOutput(t, bc, w, coinbase, 0, address, 1, false)); err != nil {
+		t.Fatal(err)
+	}
+	bc.db.Close()
+
+	fields := outputFields(captureOutput(t, func() { (&CLI{}).info("3000") }))
+	want := map[string]string{
+		"Best height":      "2",
+		"Transactions":     "3",
+		"Total supply":     strconv.Itoa(2 * subsidy),
+		"Mempool size":     "1",
+		"Wallet addresses": "1",
+		"Chain ID":         strconv.Itoa(defaultChainID),
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("%s: %q, want %q", name, fields[name], value)
+		}
+	}
+}
+
+func TestDecodeCommands(t *testing.T) {
+	useDataDir(t)
+	tx, _ := signedSpend(t, NewWallet(), string(NewWallet().GetAddress()), 7, defaultChainID)
+	block := newBlockAt([]*Transaction{NewCoinbaseTX(string(NewWallet().GetAddress()), "", 0, 2), tx}, make([]byte, 32), 1, hashSHA256, 4, InstantSealer{})
+	rawTx, rawBlock := hex.EncodeToString(tx.Serialize()), hex.EncodeToString(EncodeBlock(block))
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantOK   bool
+		wantText string
+	}{
+		{"transaction", []string{"decodetx", "-hex", rawTx}, true, fmt.Sprintf("Transaction %x", tx.ID)},
+		{"truncated transaction", []string{"decodetx", "-hex", rawTx[:len(rawTx)/2]}, false, "ERROR: "},
+		{"transaction not hex", []string{"decodetx", "-hex", "xyz"}, false, "ERROR: Transaction is not valid hex"},
+		{"block", []string{"decodeblock", "-hex", rawBlock}, true, fmt.Sprintf("Block %x", block.Hash)},
+		{"truncated block", []string{"decodeblock", "-hex", rawBlock[:len(rawBlock)-10]}, false, "ERROR: "},
+		{"block not hex", []string{"decodeblock", "-hex", rawBlock + "0"}, false, "ERROR: Block is not valid hex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, ok := runCLI(t, tt.args...)
+			if ok != tt.wantOK || !strings.Contains(out, tt.wantText) {
+				t.Fatalf("succeeded %v with output:\n%s\nwant success %v and %q", ok, out, tt.wantOK, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestSendMany(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	from := string(w.GetAddress())
+	bob, carol := string(NewWallet().GetAddress()), string(NewWallet().GetAddress())
+	bc.db.Close()
+
+	tests := []struct {
+		name     string
+		payments string
+		wantOK   bool
+		wantText string
+	}{
+		{"bad address", fmt.Sprintf(`{%q: 3, "1NotAnAddress": 4}`, bob), false, `recipient address "1NotAnAddress" is not valid`},
+		{"zero amount", fmt.Sprintf(`{%q: 3, %q: 0}`, bob, carol), false, "amount for " + carol + " must be positive"},
+		{"not JSON", "[", false, "ERROR: "},
+		{"two recipients", fmt.Sprintf(`{%q: 3, %q: 4}`, bob, carol), true, "paying 7 to 2 recipient(s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dataDir, strings.ReplaceAll(tt.name, " ", "_")+".json")
+			if err := os.WriteFile(path, []byte(tt.payments), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			out, ok := runCLI(t, "sendmany", "-from", from, "-file", path, "-fee", "1")
+			if ok != tt.wantOK || !strings.Contains(out, tt.wantText) {
+				t.Fatalf("succeeded %v with output:\n%s\nwant success %v and %q", ok, out, tt.wantOK, tt.wantText)
+			}
+		})
+	}
+
+	bc = OpenBlockchainReadOnly("3000")
+	defer bc.db.Close()
+	pool := bc.GetMempool()
+	if len(pool) != 1 {
+		t.Fatalf("%d mempool transaction(s), want only the valid payment", len(pool))
+	}
+	want := map[string]int{bob: 3, carol: 4}
+	for _, out := range pool[0].Vout {
+		for address, amount := range want {
+			if bytes.Equal(out.PubKeyHash, AddressToPubKeyHash(address)) && out.Value == amount {
+				delete(want, address)
+			}
+		}
+	}
+	if len(want) != 0 || len(pool[0].Vout) != 3 {
+		t.Fatalf("outputs %+v, want the two payments and the change", pool[0].Vout)
+	}
+}
+
+func TestSendDryRun(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	from, bob := string(w.GetAddress()), string(NewWallet().GetAddress())
+	bc.db.Close()
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantOK   bool
+		wantText string
+	}{
+		{"valid", []string{"-fee", "1", "-auto-change"}, true, "Dry run: the transaction was not added to the Mempool."},
+		{"below the relay fee", []string{"-fee", "0"}, false, "ERROR: Transaction would be rejected: transaction fee 0 is below the minimum relay fee 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"send", "-from", from, "-to", bob, "-amount", "3", "-dryrun"}, tt.args...)
+			out, ok := runCLI(t, args...)
+			if ok != tt.wantOK || !strings.Contains(out, tt.wantText) {
+				t.Fatalf("succeeded %v with output:\n%s\nwant success %v and %q", ok, out, tt.wantOK, tt.wantText)
+			}
+		})
+	}
+
+	bc = OpenBlockchainReadOnly("3000")
+	defer bc.db.Close()
+	if pool := bc.GetMempool(); len(pool) != 0 {
+		t.Fatalf("dry runs left %d mempool transaction(s)", len(pool))
+	}
+	if wallets, _ := NewWallets("3000"); len(wallets.GetAddresses()) != 1 {
+		t.Fatalf("dry runs left %d wallet addresses, want only the sender", len(wallets.GetAddresses()))
+	}
+}
+
+func TestGetMiningInfo(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	bc.db.Close()
+	if out := captureOutput(t, func() { (&CLI{}).getMiningInfo("3000") }); !strings.Contains(out, "No block has been mined by this node yet") {
+		t.Fatalf("getmininginfo before mining printed:\n%s", out)
+	}
+
+	bc = NewBlockchain(string(w.GetAddress()), "3000")
+	mined := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "", 0, 2)})
+	bc.db.Close()
+
+	out := captureOutput(t, func() { (&CLI{}).getMiningInfo("3000") })
+	fields := outputFields(out)
+	if hashes, err := strconv.Atoi(fields["Hashes tried"]); err != nil || hashes < 1 || hashes < mined.Nonce {
+		t.Errorf("Hashes tried: %q, want at least 1 and the nonce %d", fields["Hashes tried"], mined.Nonce)
+	}
+	if fields["Blocks"] != "2" || fields["Nonce"] != strconv.Itoa(mined.Nonce) {
+		t.Errorf("Blocks %q and Nonce %q, want 2 and %d", fields["Blocks"], fields["Nonce"], mined.Nonce)
+	}
+	if !strings.Contains(out, fmt.Sprintf("Last mined block %x", mined.Hash)) {
+		t.Errorf("output doesn't name the mined block %x:\n%s", mined.Hash, out)
+	}
+}
+
+func TestDBWithoutMempoolBucket(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	if err := bc.db.Update(func(tx StoreTx) error { return tx.DeleteBucket([]byte(mempoolBucket)) }); err != nil {
+		t.Fatal(err)
+	}
+	bc.db.Close()
+
+	// Read-only commands see an empty mempool
+	if out := strings.TrimSpace(captureOutput(t, func() { (&CLI{}).listMempool(true, "3000") })); out != "[]" {
+		t.Fatalf("listmempool printed %q, want an empty list", out)
+	}
+	readOnly := OpenBlockchainReadOnly("3000")
+	if pool := readOnly.GetMempool(); len(pool) != 0 {
+		t.Fatalf("%d mempool transaction(s), want none", len(pool))
+	}
+	readOnly.db.Close()
+
+	// A writable open recreates the bucket
+	bc = NewBlockchain(string(w.GetAddress()), "3000")
+	defer bc.db.Close()
+	tx := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+	if err := bc.AddToMempool(tx); err != nil {
+		t.Fatalf("AddToMempool: %s", err)
+	}
+	if pool := bc.GetMempool(); len(pool) != 1 || !bytes.Equal(pool[0].ID, tx.ID) {
+		t.Fatalf("mempool holds %d transaction(s), want the added one", len(pool))
+	}
+}
+
+func TestVerifyTx(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	address := string(w.GetAddress())
+	spend := spendCoinbase(t, bc, w, address, 1)
+	doubleSpend := spendCoinbase(t, bc, w, address, 2)
+	coinbase := NewCoinbaseTX(address, "", 1, 2)
+	if err := bc.AddBlocks(chainBlocks(t, bc, []*Transaction{coinbase, spend}, []*Transaction{NewCoinbaseTX(address, "", 0, 3)})); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := spendOutput(t, bc, w, coinbase, 0, address, 1, false)
+	badSignature := spendOutput(t, bc, w, coinbase, 0, address, 1, false)
+	badSignature.Vin[0].Signature[len(badSignature.Vin[0].Signature)-1] ^= 1
+	badSignature.ID = badSignature.Hash()
+	badID := spendOutput(t, bc, w, coinbase, 0, address, 1, false)
+	badID.ID[0] ^= 1
+
+	// The spend's confirmed block is kept, but the genesis block holding its input is pruned
+	if _, err := bc.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+	bc.db.Close()
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantText string
+	}{
+		{"valid", []string{"-hex", hex.EncodeToString(valid.Serialize())}, "Valid"},
+		{"bad signature", []string{"-hex", hex.EncodeToString(badSignature.Serialize())}, "INVALID (bad signature): input 0"},
+		{"double spend", []string{"-hex", hex.EncodeToString(doubleSpend.Serialize())}, "INVALID (double spend): input 0"},
+		{"ID mismatch", []string{"-hex", hex.EncodeToString(badID.Serialize())}, "INVALID (invalid transaction)"},
+		{"pruned input", []string{"-id", hex.EncodeToString(spend.ID)}, "INVALID (missing previous transaction): input 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, ok := runCLI(t, append([]string{"verifytx"}, tt.args...)...)
+			if ok != (tt.wantText == "Valid") || !strings.Contains(out, tt.wantText) {
+				t.Fatalf("succeeded %v with output:\n%s\nwant %q", ok, out, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestPrintChainWithoutBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T)
+		output string
+		ok     bool
+	}{
+		{"no DB", func(t *testing.T) { useDataDir(t) }, errNoBlockchain.Error(), false},
+		{"empty DB file", func(t *testing.T) {
+			useDataDir(t)
+			if err := os.WriteFile(dataFilePath(dbFile, "3000"), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, "No blocks yet.", true},
+		{"missing blocks bucket", func(t *testing.T) {
+			bc, _ := newDiskTestChain(t, "3000")
+			if err := bc.db.Update(func(tx StoreTx) error { return tx.DeleteBucket([]byte(blocksBucket)) }); err != nil {
+				t.Fatal(err)
+			}
+			bc.db.Close()
+		}, "No blocks yet.", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+			out, ok := runCLI(t, "printchain")
+			if ok != tt.ok || strings.TrimSpace(out) != tt.output {
+				t.Errorf("printchain printed %q (ok %v), want %q (ok %v)", out, ok, tt.output, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGetBlockTxs(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	address := string(w.GetAddress())
+	spend := spendCoinbase(t, bc, w, address, 1)
+	if err := bc.AddToMempool(spend); err != nil {
+		t.Fatal(err)
+	}
+	child := spendOutput(t, bc, w, spend, 0, address, 1, false)
+	blocks := chainBlocks(t, bc, []*Transaction{NewCoinbaseTX(address, "", 2, 2), spend, child})
+	if err := bc.AddBlocks(blocks); err != nil {
+		t.Fatal(err)
+	}
+	block := blocks[0]
+	bc.db.Close()
+	hash := hex.EncodeToString(block.Hash)
+
+	var ids []string
+	for _, tx := range block.Transactions {
+		ids = append(ids, hex.EncodeToString(tx.ID))
+	}
+	out := captureOutput(t, func() { (&CLI{}).getBlockTxs(hash, false, "3000") })
+	if got := strings.Fields(out); strings.Join(got, " ") != strings.Join(ids, " ") {
+		t.Fatalf("getblocktxs listed %v, want %v", got, ids)
+	}
+
+	var entries []blockTxEntry
+	out = captureOutput(t, func() { (&CLI{}).getBlockTxs(hash, true, "3000") })
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("getblocktxs -json printed %q: %s", out, err)
+	}
+	if len(entries) != len(block.Transactions) {
+		t.Fatalf("getblocktxs -json listed %d transactions, want %d", len(entries), len(block.Transactions))
+	}
+	for i, tx := range block.Transactions {
+		entry := entries[i]
+		if entry.ID != ids[i] || entry.Coinbase != tx.IsCoinbase() || entry.Size != tx.SerializedSize() {
+			t.Errorf("entry %d is %+v, want transaction %s", i, entry, ids[i])
+		}
+		if tx.IsCoinbase() {
+			if len(entry.Inputs) != 0 {
+				t.Errorf("coinbase entry lists inputs %v", entry.Inputs)
+			}
+			continue
+		}
+		if want := fmt.Sprintf("%x:%d", tx.Vin[0].Txid, tx.Vin[0].Vout); len(entry.Inputs) != len(tx.Vin) || entry.Inputs[0] != want {
+			t.Errorf("entry %d lists inputs %v, want %s first", i, entry.Inputs, want)
+		}
+		if len(entry.Outputs) != len(tx.Vout) || entry.Outputs[0].Value != tx.Vout[0].Value {
+			t.Errorf("entry %d lists outputs %+v, want %d of them", i, entry.Outputs, len(tx.Vout))
+		}
+	}
+}
+
+func TestStartNodeListensOnConfiguredAddress(t *testing.T) {
+	bc, _ := newDiskTestChain(t, "3000")
+	bc.db.Close()
+
+	// Pick a free port other than NODE_ID's
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenAddr := ln.Addr().String()
+	ln.Close()
+
+	node := cliCommand("startnode", "-listen", listenAddr, "-seeds", listenAddr)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		node.Process.Kill()
+		node.Wait()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout(protocol, listenAddr, dialTimeout)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node not listening on %s: %s", listenAddr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCreateBlockchainOnExistingDB(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	address := string(w.GetAddress())
+	bc.db.Close()
+	genesisFile := filepath.Join(t.TempDir(), "genesis.json")
+	data, err := json.Marshal(DefaultGenesis(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(genesisFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	otherID := strconv.Itoa(defaultChainID + 1)
+	mismatch := fmt.Sprintf("the DB was created for chain ID %d, not %s", defaultChainID, otherID)
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantOK   bool
+		wantText string
+	}{
+		{"same chain ID", []string{"-address", address}, true, "Done!"},
+		{"another chain ID", []string{"-address", address, "-chainid", otherID}, false, mismatch},
+		{"same genesis file", []string{"-genesis", genesisFile}, true, "Done!"},
+		{"genesis file with another chain ID", []string{"-genesis", genesisFile, "-chainid", otherID}, false, mismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, ok := runCLI(t, append([]string{"createblockchain"}, tt.args...)...)
+			if ok != tt.wantOK || !strings.Contains(out, tt.wantText) {
+				t.Fatalf("succeeded %v with output:\n%s\nwant success %v and %q", ok, out, tt.wantOK, tt.wantText)
+			}
+		})
+	}
+
+	if out, _ := runCLI(t, "getchainid"); !strings.Contains(out, strconv.Itoa(defaultChainID)) {
+		t.Fatalf("getchainid printed %q after the failed creates, want %d", out, defaultChainID)
+	}
+}
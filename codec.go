@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// codecVersion is the leading byte of every blob written by the codec
+// Legacy blobs are gob streams, whose first byte (a message length) is never this small
+const codecVersion = byte(0x01)
+
+// prunedCodecVersion is the leading byte of a pruned block, stored as its header only
+const prunedCodecVersion = byte(0x02)
+
+// headerCodecVersion is the leading byte of a block header sent or stored apart from its body
+const headerCodecVersion = byte(0x03)
+
+// hashAlgoMarker precedes the hash algorithm of a block or header not hashed with single SHA-256
+// Blocks hashed with SHA-256 encode exactly as before blocks recorded their algorithm
+const hashAlgoMarker = math.MaxUint32 - 2
+
+// bitsMarker precedes the target bits of a block that records them, after its hash algorithm
+const bitsMarker = math.MaxUint32 - 3
+
+// The codec writes fields in a fixed order: integers as 8-byte big-endian values,
+// byte strings and lists prefixed by a 4-byte big-endian length.
+// Unlike gob it carries no type metadata, so the same value always encodes to the same bytes.
+// Similar to Geth's RLP (rlp.EncodeToBytes / rlp.DecodeBytes)
+
+// EncodeBlock encodes a block with the versioned codec
+func EncodeBlock(b *Block) []byte {
+	enc := &codecWriter{}
+	enc.buf.WriteByte(codecVersion)
+
+	enc.writeHashAlgo(b.HashAlgo)
+	enc.writeBits(b.Bits)
+	enc.writeInt(b.Timestamp)
+	enc.writeBytes(b.PrevBlockHash)
+	enc.writeBytes(b.Hash)
+	enc.writeInt(int64(b.Nonce))
+	enc.writeLen(len(b.Transactions))
+	for _, tx := range b.Transactions {
+		enc.writeTransaction(tx)
+	}
+
+	return enc.buf.Bytes()
+}
+
+// DecodeBlock decodes a block written by EncodeBlock, compressed or not, or a legacy gob-encoded block
+func DecodeBlock(data []byte) (*Block, error) {
+	if len(data) == 0 {
+		return nil, errors.New("block data is empty")
+	}
+	if data[0] == compressedCodecVersion {
+		inner, err := decompressBlock(data)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeBlock(inner)
+	}
+	if data[0] == prunedCodecVersion {
+		return decodePrunedBlock(data)
+	}
+	if data[0] != codecVersion {
+		return decodeLegacyBlock(data)
+	}
+
+	dec := &codecReader{data: data[1:]}
+	block := &Block{}
+
+	block.HashAlgo = dec.readHashAlgo()
+	block.Bits = dec.readBits()
+	block.Timestamp = dec.readInt()
+	block.PrevBlockHash = dec.readBytes()
+	block.Hash = dec.readBytes()
+	block.Nonce = int(dec.readInt())
+	txCount := dec.readLen()
+	for i := 0; i < txCount && dec.err == nil; i++ {
+		block.Transactions = append(block.Transactions, dec.readTransaction())
+	}
+
+	if err := dec.finish(); err != nil {
+		return nil, fmt.Errorf("invalid block data: %s", err)
+	}
+
+	return block, nil
+}
+
+// EncodePrunedBlock encodes only the header of a block, keeping the merkle root of its transactions
+func EncodePrunedBlock(b *Block) []byte {
+	enc := &codecWriter{}
+	enc.buf.WriteByte(prunedCodecVersion)
+
+	enc.writeHashAlgo(b.HashAlgo)
+	enc.writeBits(b.Bits)
+	enc.writeInt(b.Timestamp)
+	enc.writeBytes(b.PrevBlockHash)
+	enc.writeBytes(b.Hash)
+	enc.writeInt(int64(b.Nonce))
+	enc.writeBytes(b.MerkleRoot())
+
+	return enc.buf.Bytes()
+}
+
+// decodePrunedBlock decodes a block written by EncodePrunedBlock; it has no transactions
+func decodePrunedBlock(data []byte) (*Block, error) {
+	dec := &codecReader{data: data[1:]}
+	block := &Block{}
+
+	block.HashAlgo = dec.readHashAlgo()
+	block.Bits = dec.readBits()
+	block.Timestamp = dec.readInt()
+	block.PrevBlockHash = dec.readBytes()
+	block.Hash = dec.readBytes()
+	block.Nonce = int(dec.readInt())
+	block.prunedRoot = dec.readBytes()
+
+	if err := dec.finish(); err != nil {
+		return nil, fmt.Errorf("invalid pruned block data: %s", err)
+	}
+	if len(block.prunedRoot) == 0 {
+		return nil, errors.New("invalid pruned block data: missing merkle root")
+	}
+
+	return block, nil
+}
+
+// EncodeBlockHeader encodes a block header with the versioned codec
+func EncodeBlockHeader(h BlockHeader) []byte {
+	enc := &codecWriter{}
+	enc.buf.WriteByte(headerCodecVersion)
+
+	enc.writeHashAlgo(h.HashAlgo)
+	enc.writeBytes(h.PrevBlockHash)
+	enc.writeBytes(h.MerkleRoot)
+	enc.writeInt(h.Timestamp)
+	enc.writeInt(int64(h.Difficulty))
+	enc.writeInt(int64(h.Nonce))
+	enc.writeBytes(h.Hash)
+
+	return enc.buf.Bytes()
+}
+
+// DecodeBlockHeader decodes a header written by EncodeBlockHeader
+func DecodeBlockHeader(data []byte) (BlockHeader, error) {
+	var h BlockHeader
+	if len(data) == 0 || data[0] != headerCodecVersion {
+		return h, errors.New("invalid block header data")
+	}
+
+	dec := &codecReader{data: data[1:]}
+	h.HashAlgo = dec.readHashAlgo()
+	h.PrevBlockHash = dec.readBytes()
+	h.MerkleRoot = dec.readBytes()
+	h.Timestamp = dec.readInt()
+	h.Difficulty = int(dec.readInt())
+	h.Nonce = int(dec.readInt())
+	h.Hash = dec.readBytes()
+
+	if err := dec.finish(); err != nil {
+		return BlockHeader{}, fmt.Errorf("invalid block header data: %s", err)
+	}
+
+	return h, nil
+}
+
+// EncodeTransaction encodes a transaction with the versioned codec
+func EncodeTransaction(tx *Transaction) []byte {
+	enc := &codecWriter{}
+	enc.buf.WriteByte(codecVersion)
+	enc.writeTransaction(tx)
+
+	return enc.buf.Bytes()
+}
+
+// DecodeTransaction decodes a transaction written by EncodeTransaction, or a legacy gob-encoded one
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	if len(data) == 0 {
+		return nil, errors.New("transaction data is empty")
+	}
+	if data[0] != codecVersion {
+		return decodeLegacyTransaction(data)
+	}
+
+	dec := &codecReader{data: data[1:]}
+	tx := dec.readTransaction()
+
+	if err := dec.finish(); err != nil {
+		return nil, fmt.Errorf("invalid transaction data: %s", err)
+	}
+
+	return tx, nil
+}
+
+// decodeLegacyBlock decodes a block stored with gob before the codec existed
+func decodeLegacyBlock(data []byte) (*Block, error) {
+	var block Block
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid legacy block data: %s", err)
+	}
+
+	return &block, nil
+}
+
+// decodeLegacyTransaction decodes a transaction stored with gob before the codec existed
+func decodeLegacyTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&tx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid legacy transaction data: %s", err)
+	}
+
+	return &tx, nil
+}
+
+// codecWriter accumulates codec-encoded fields
+type codecWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *codecWriter) writeInt(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	w.buf.Write(b[:])
+}
+
+func (w *codecWriter) writeLen(n int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	w.buf.Write(b[:])
+}
+
+func (w *codecWriter) writeBytes(data []byte) {
+	w.writeLen(len(data))
+	w.buf.Write(data)
+}
+
+// replaceableMarker precedes the ID of a transaction signaling replace-by-fee
+// It's larger than any valid length, so transactions without the flag encode exactly as before
+const replaceableMarker = math.MaxUint32
+
+// memoMarker precedes the memo of a transaction that has one, ahead of its ID
+const memoMarker = math.MaxUint32 - 1
+
+func (w *codecWriter) writeHashAlgo(algo HashAlgo) {
+	if algo != hashSHA256 {
+		w.writeLen(hashAlgoMarker)
+		w.writeInt(int64(algo))
+	}
+}
+
+func (w *codecWriter) writeBits(bits int) {
+	if bits != 0 {
+		w.writeLen(bitsMarker)
+		w.writeInt(int64(bits))
+	}
+}
+
+func (w *codecWriter) writeTransaction(tx *Transaction) {
+	if tx.Replaceable {
+		w.writeLen(replaceableMarker)
+	}
+	if len(tx.Memo) > 0 {
+		w.writeLen(memoMarker)
+		w.writeBytes(tx.Memo)
+	}
+	w.writeBytes(tx.ID)
+
+	w.writeLen(len(tx.Vin))
+	for _, in := range tx.Vin {
+		w.writeBytes(in.Txid)
+		w.writeInt(int64(in.Vout))
+		w.writeBytes(in.Signature)
+		w.writeBytes(in.PubKey)
+		w.writeLen(len(in.Signatures))
+		for _, sig := range in.Signatures {
+			w.writeBytes(sig)
+		}
+	}
+
+	w.writeLen(len(tx.Vout))
+	for _, out := range tx.Vout {
+		w.writeOutput(out)
+	}
+}
+
+// dataOutputMarker takes the place of the multisig M in data outputs, followed by the data
+// Regular outputs encode exactly as before data outputs existed, so their hashes are unchanged
+const dataOutputMarker = -1
+
+// scriptHashOutputMarker takes the place of the multisig M in outputs locked to a script hash
+const scriptHashOutputMarker = -2
+
+func (w *codecWriter) writeOutput(out TXOutput) {
+	w.writeInt(int64(out.Value))
+	w.writeBytes(out.PubKeyHash)
+	if out.IsData() {
+		w.writeInt(dataOutputMarker)
+		w.writeBytes(out.Data)
+		return
+	}
+	if out.ScriptHash {
+		w.writeInt(scriptHashOutputMarker)
+		return
+	}
+	w.writeInt(int64(out.Multisig.M))
+	w.writeLen(len(out.Multisig.PubKeys))
+	for _, pubKey := range out.Multisig.PubKeys {
+		w.writeBytes(pubKey)
+	}
+}
+
+// codecReader reads codec-encoded fields, remembering the first error
+// Lengths are checked against the remaining data so malformed input can't force large allocations
+type codecReader struct {
+	data []byte
+	err  error
+}
+
+func (r *codecReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+
+	chunk := r.data[:n]
+	r.data = r.data[n:]
+	return chunk
+}
+
+func (r *codecReader) readInt() int64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (r *codecReader) readLen() int {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+
+	n := int(binary.BigEndian.Uint32(b))
+	if n > len(r.data) {
+		// Every element takes at least one byte, so a longer count can't be valid
+		r.err = errors.New("length exceeds remaining data")
+		return 0
+	}
+
+	return n
+}
+
+func (r *codecReader) readBytes() []byte {
+	n := r.readLen()
+	if n == 0 {
+		return nil
+	}
+
+	return append([]byte(nil), r.take(n)...)
+}
+
+// peekMarker consumes the next length field if it's marker, reporting whether it was
+func (r *codecReader) peekMarker(marker uint32) bool {
+	if r.err != nil || len(r.data) < 4 || binary.BigEndian.Uint32(r.data) != marker {
+		return false
+	}
+	r.take(4)
+
+	return true
+}
+
+func (r *codecReader) readHashAlgo() HashAlgo {
+	if !r.peekMarker(hashAlgoMarker) {
+		return hashSHA256
+	}
+
+	return HashAlgo(r.readInt())
+}
+
+func (r *codecReader) readBits() int {
+	if !r.peekMarker(bitsMarker) {
+		return 0
+	}
+
+	bits := int(r.readInt())
+	if (bits < 1 || bits > 255) && r.err == nil {
+		r.err = errors.New("target bits out of range")
+	}
+
+	return bits
+}
+
+func (r *codecReader) readTransaction() *Transaction {
+	tx := &Transaction{}
+	if r.peekMarker(replaceableMarker) {
+		tx.Replaceable = true
+	}
+	if r.peekMarker(memoMarker) {
+		tx.Memo = r.readBytes()
+		if (len(tx.Memo) == 0 || len(tx.Memo) > maxMemoSize) && r.err == nil {
+			r.err = errors.New("invalid memo size")
+		}
+	}
+	tx.ID = r.readBytes()
+
+	vinCount := r.readLen()
+	for i := 0; i < vinCount && r.err == nil; i++ {
+		var in TXInput
+		in.Txid = r.readBytes()
+		in.Vout = int(r.readInt())
+		in.Signature = r.readBytes()
+		in.PubKey = r.readBytes()
+		sigCount := r.readLen()
+		for j := 0; j < sigCount && r.err == nil; j++ {
+			in.Signatures = append(in.Signatures, r.readBytes())
+		}
+		tx.Vin = append(tx.Vin, in)
+	}
+
+	voutCount := r.readLen()
+	for i := 0; i < voutCount && r.err == nil; i++ {
+		tx.Vout = append(tx.Vout, r.readOutput())
+	}
+
+	return tx
+}
+
+func (r *codecReader) readOutput() TXOutput {
+	var out TXOutput
+	out.Value = int(r.readInt())
+	out.PubKeyHash = r.readBytes()
+	m := int(r.readInt())
+	if m == dataOutputMarker {
+		out.Data = r.readBytes()
+		if len(out.Data) == 0 && r.err == nil {
+			r.err = errors.New("empty data output")
+		}
+		return out
+	}
+	if m == scriptHashOutputMarker {
+		out.ScriptHash = true
+		return out
+	}
+	out.Multisig.M = m
+	keyCount := r.readLen()
+	for j := 0; j < keyCount && r.err == nil; j++ {
+		out.Multisig.PubKeys = append(out.Multisig.PubKeys, r.readBytes())
+	}
+
+	return out
+}
+
+// finish reports a decoding error, including leftover bytes after the last field
+func (r *codecReader) finish() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) != 0 {
+		return errors.New("trailing data")
+	}
+
+	return nil
+}
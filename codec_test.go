@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+)
+
+// codecTransactions returns transactions using every optional field of the codec, by name
+func codecTransactions(t *testing.T) map[string]*Transaction {
+	t.Helper()
+
+	w := NewWallet()
+	to := string(NewWallet().GetAddress())
+	script, err := NewMultisigScript(2, [][]byte{w.PublicKey, NewWallet().PublicKey, NewWallet().PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataOut, err := NewDataOutput([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, _ := signedSpend(t, w, to, 10, defaultChainID)
+	replaceable := &Transaction{nil, payment.Vin, payment.Vout, true, []byte("rent for March")}
+	withData := &Transaction{nil, payment.Vin, []TXOutput{payment.Vout[0], *dataOut}, false, nil}
+	toMultisig := &Transaction{nil, payment.Vin, []TXOutput{*NewTXOutput(7, script.Address())}, false, nil}
+	toScriptHash := &Transaction{nil, payment.Vin, []TXOutput{*NewTXOutput(7, script.ScriptHashAddress())}, false, nil}
+	fromMultisig := &Transaction{nil, []TXInput{{payment.ID, 0, nil, script.Serialize(), [][]byte{{1, 2}, nil, {3}}}}, payment.Vout, false, nil}
+
+	txs := map[string]*Transaction{
+		"coinbase":             NewCoinbaseTX(to, "", 0, 1),
+		"payment":              payment,
+		"replaceable and memo": replaceable,
+		"data output":          withData,
+		"multisig output":      toMultisig,
+		"script hash output":   toScriptHash,
+		"multisig input":       fromMultisig,
+	}
+	for _, tx := range txs {
+		tx.ID = tx.Hash()
+	}
+
+	return txs
+}
+
+func TestTransactionCodecRoundTrip(t *testing.T) {
+	for name, tx := range codecTransactions(t) {
+		t.Run(name, func(t *testing.T) {
+			data := EncodeTransaction(tx)
+			if !bytes.Equal(EncodeTransaction(tx), data) {
+				t.Fatal("encoding the same transaction twice gave different bytes")
+			}
+
+			decoded, err := DecodeTransaction(data)
+			if err != nil {
+				t.Fatalf("DecodeTransaction: %s", err)
+			}
+			if !bytes.Equal(EncodeTransaction(decoded), data) {
+				t.Fatal("decoded transaction encodes differently")
+			}
+			if !bytes.Equal(decoded.Hash(), tx.ID) {
+				t.Fatalf("decoded transaction hashes to %x, want %x", decoded.Hash(), tx.ID)
+			}
+		})
+	}
+}
+
+func TestBlockCodecRoundTrip(t *testing.T) {
+	byName := codecTransactions(t)
+	txs := []*Transaction{byName["coinbase"]}
+	for name, tx := range byName {
+		if name != "coinbase" {
+			txs = append(txs, tx)
+		}
+	}
+
+	tests := []struct {
+		name string
+		algo HashAlgo
+		bits int
+		txs  []*Transaction
+	}{
+		{"SHA-256 without bits", hashSHA256, 0, txs},
+		{"double SHA-256 with bits", hashDoubleSHA256, 12, txs},
+		{"coinbase only", hashSHA256, 4, txs[:1]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := &Block{Timestamp: 1700000000, Transactions: tt.txs, PrevBlockHash: bytes.Repeat([]byte{9}, 32), Nonce: 42, HashAlgo: tt.algo, Bits: tt.bits}
+			block.Hash = block.CalculateHash()
+
+			data := EncodeBlock(block)
+			decoded, err := DecodeBlock(data)
+			if err != nil {
+				t.Fatalf("DecodeBlock: %s", err)
+			}
+			if !bytes.Equal(EncodeBlock(decoded), data) {
+				t.Fatal("decoded block encodes differently")
+			}
+			if decoded.HashAlgo != tt.algo || decoded.Bits != tt.bits || !bytes.Equal(decoded.CalculateHash(), block.Hash) {
+				t.Fatalf("decoded algo %d, bits %d, hash %x; want %d, %d, %x", decoded.HashAlgo, decoded.Bits, decoded.CalculateHash(), tt.algo, tt.bits, block.Hash)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsMalformedData(t *testing.T) {
+	tx := codecTransactions(t)["replaceable and memo"]
+	txData := EncodeTransaction(tx)
+	block := &Block{Timestamp: 1, Transactions: []*Transaction{tx}, PrevBlockHash: []byte{1}, Nonce: 1, Bits: 8}
+	blockData := EncodeBlock(block)
+
+	// Sets the 4-byte length or marker at offset to n
+	withLen := func(data []byte, offset int, n uint32) []byte {
+		changed := append([]byte(nil), data...)
+		changed[offset], changed[offset+1], changed[offset+2], changed[offset+3] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+		return changed
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		block   bool
+		wantErr string
+	}{
+		{"empty transaction", nil, false, "empty"},
+		{"trailing byte", append(append([]byte(nil), txData...), 0), false, "invalid transaction data"},
+		{"truncated transaction", txData[:len(txData)-3], false, "invalid transaction data"},
+		{"length beyond the data", withLen(txData, 9, 1<<30), false, "invalid transaction data"},
+		{"empty memo", withLen(txData, 9, 0), false, "invalid transaction data"},
+		{"not gob either", []byte{0xff, 0xff}, false, "legacy"},
+		{"empty block", nil, true, "empty"},
+		{"truncated block", blockData[:len(blockData)/2], true, "invalid block data"},
+		{"bits out of range", append(withLen(blockData, 1, bitsMarker)[:5], append(make([]byte, 8), blockData[13:]...)...), true, "invalid block data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.block {
+				_, err = DecodeBlock(tt.data)
+			} else {
+				_, err = DecodeTransaction(tt.data)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("decoding = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeNeverPanicsOnTruncatedData(t *testing.T) {
+	txs := codecTransactions(t)
+	block := &Block{Timestamp: 1, Transactions: []*Transaction{txs["payment"], txs["multisig input"]}, PrevBlockHash: []byte{1}, Bits: 8}
+	data := EncodeBlock(block)
+
+	for n := 0; n < len(data); n++ {
+		if _, err := DecodeBlock(data[:n]); err == nil {
+			t.Fatalf("block truncated to %d of %d bytes decoded", n, len(data))
+		}
+	}
+}
+
+func TestDecodeLegacyGobTransaction(t *testing.T) {
+	tx := codecTransactions(t)["payment"]
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeTransaction(buf.Bytes())
+	if err != nil {
+		t.Fatalf("DecodeTransaction: %s", err)
+	}
+	if !bytes.Equal(EncodeTransaction(decoded), EncodeTransaction(tx)) {
+		t.Fatal("legacy transaction decoded differently")
+	}
+}
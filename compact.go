@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// compactTxMaxSize is how many bytes are copied per write transaction while compacting
+const compactTxMaxSize = 64 * 1024
+
+// CompactDB rewrites the bbolt file at path with only its live data, reclaiming the space
+// freed by pruning and mempool churn, and returns the file size before and after
+// The DB is opened for writing so no node can change it meanwhile; the compacted copy is synced
+// and renamed over the original, so a crash leaves either the old or the new file
+// Similar to bbolt's "compact" command
+func CompactDB(path string) (int64, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before := info.Size()
+
+	src, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: readOnlyOpenTimeout})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bbolt.Open(tmpPath, info.Mode().Perm(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = bbolt.Compact(dst, src, compactTxMaxSize)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, 0, err
+	}
+
+	// Sync the directory too, so the rename itself survives a crash
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return before, info.Size(), nil
+}
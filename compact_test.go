@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCompactDB(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	address := string(w.GetAddress())
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 2))); err != nil {
+		t.Fatal(err)
+	}
+	tip := bc.Tip()
+
+	// Pages freed by deleting a bucket stay in the file until it's compacted
+	err := bc.db.Update(func(tx StoreTx) error {
+		b, err := tx.CreateBucket([]byte("scratch"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 1024)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.db.Update(func(tx StoreTx) error { return tx.DeleteBucket([]byte("scratch")) }); err != nil {
+		t.Fatal(err)
+	}
+	bc.db.Close()
+
+	path := dataFilePath(dbFile, "3000")
+	before, after, err := CompactDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before || before < 1000*1024 {
+		t.Fatalf("compacted from %d to %d bytes, want the freed megabyte reclaimed", before, after)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Fatalf("temporary copy left behind: %v", err)
+	}
+
+	compacted := OpenBlockchainReadOnly("3000")
+	defer compacted.db.Close()
+	if !bytes.Equal(compacted.Tip(), tip) || compacted.GetBestHeight() != 2 {
+		t.Fatalf("tip %x at height %d after compacting, want %x at 2", compacted.Tip(), compacted.GetBestHeight(), tip)
+	}
+	if balance, err := compacted.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != 2*subsidy {
+		t.Fatalf("balance %d, %v after compacting, want %d", balance, err, 2*subsidy)
+	}
+}
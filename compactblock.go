@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// compactBlocksVersion is the protocol version from which peers are sent compact blocks instead of inv announcements
+const compactBlocksVersion = 2
+
+// shortIDLen is the length of the short transaction IDs of compact blocks
+const shortIDLen = 6
+
+// maxPartialBlocks bounds how many compact blocks may wait for their missing transactions at once
+const maxPartialBlocks = 16
+
+// cmpctblock announces a new block as its header, its coinbase and the short IDs of its other transactions,
+// which the receiver looks up in its mempool
+// Similar to Bitcoin's BIP 152 cmpctblock message
+type cmpctblock struct {
+	AddrFrom string
+	Header   []byte   // Codec-encoded header
+	Coinbase []byte   // Serialized coinbase, which no mempool can hold
+	ShortIDs [][]byte // Short IDs of the transactions after the coinbase, in block order
+}
+
+// blocktxn answers a getdata for the transactions of a block at the given indexes
+// Similar to Bitcoin's BIP 152 blocktxn message
+type blocktxn struct {
+	AddrFrom     string
+	BlockHash    []byte
+	Indexes      []int
+	Transactions [][]byte // Serialized transactions, one per index
+}
+
+// partialBlock is a compact block waiting for the transactions its receiver's mempool lacked
+type partialBlock struct {
+	from   string
+	header BlockHeader
+	txs    []*Transaction // Nil where the transaction is missing
+}
+
+var (
+	partialBlocksMu sync.Mutex
+	partialBlocks   = make(map[string]*partialBlock)
+)
+
+// shortTxID returns the short ID of a transaction within a block
+// Keying it with the block hash keeps collisions from being crafted ahead of the block
+func shortTxID(blockHash, txID []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, blockHash...), txID...))
+
+	return hash[:shortIDLen]
+}
+
+// newCompactBlock builds the compact form of a block
+func newCompactBlock(b *Block) cmpctblock {
+	compact := cmpctblock{AddrFrom: nodeAddress, Header: EncodeBlockHeader(b.Header())}
+	for i, tx := range b.Transactions {
+		if i == 0 {
+			compact.Coinbase = tx.Serialize()
+			continue
+		}
+		compact.ShortIDs = append(compact.ShortIDs, shortTxID(b.Hash, tx.ID))
+	}
+
+	return compact
+}
+
+// fillFromMempool returns the transactions of a compact block, taken from pool where possible,
+// and the indexes of those still missing. A short ID matching several pool transactions counts as missing
+func fillFromMempool(compact cmpctblock, blockHash []byte, pool []*Transaction) ([]*Transaction, []int, error) {
+	coinbase, err := DecodeTransaction(compact.Coinbase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid coinbase: %s", err)
+	}
+
+	byShortID := make(map[string]*Transaction)
+	collided := make(map[string]bool)
+	for _, tx := range pool {
+		id := string(shortTxID(blockHash, tx.ID))
+		if byShortID[id] != nil {
+			collided[id] = true
+		}
+		byShortID[id] = tx
+	}
+
+	txs := []*Transaction{coinbase}
+	var missing []int
+	for i, shortID := range compact.ShortIDs {
+		tx := byShortID[string(shortID)]
+		if tx == nil || collided[string(shortID)] {
+			tx = nil
+			missing = append(missing, i+1)
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, missing, nil
+}
+
+// assembleBlock rebuilds a block from its header and transactions
+// Fails if the transactions don't match the header's merkle root, e.g. after a short ID collision
+func assembleBlock(header BlockHeader, txs []*Transaction) (*Block, error) {
+	block := &Block{header.Timestamp, txs, header.PrevBlockHash, header.Hash, header.Nonce, header.HashAlgo, header.Difficulty, nil}
+	if !bytes.Equal(block.MerkleRoot(), header.MerkleRoot) {
+		return nil, errors.New("transactions don't match the merkle root")
+	}
+
+	return block, nil
+}
+
+// sendCompactBlock announces a block to a peer, compactly if its negotiated protocol version allows
+func sendCompactBlock(address string, b *Block) {
+	if peerVersion(address) < compactBlocksVersion {
+		sendInv(address, "block", [][]byte{b.Hash})
+		return
+	}
+
+	request := append(commandToBytes("cmpctblock"), gobEncode(newCompactBlock(b))...)
+	sendData(address, request)
+}
+
+func sendBlockTxn(address string, blockHash []byte, indexes []int, txs [][]byte) {
+	payload := gobEncode(blocktxn{nodeAddress, blockHash, indexes, txs})
+	request := append(commandToBytes("blocktxn"), payload...)
+
+	sendData(address, request)
+}
+
+func handleCmpctBlock(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload cmpctblock
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+	touchPeer(payload.AddrFrom)
+
+	header, err := DecodeBlockHeader(payload.Header)
+	if err != nil {
+		logger.Errorf("Dropping compact block from %s: %s", payload.AddrFrom, err)
+		return
+	}
+	if bc.HasBlock(header.Hash) {
+		return
+	}
+	// Only a block on top of our tip can be rebuilt from the mempool; otherwise sync the way an inv would
+	if !syncer.IsSynced() || !bytes.Equal(header.PrevBlockHash, bc.Tip()) {
+		syncer.HandleInv(payload.AddrFrom, [][]byte{header.Hash}, bc)
+		return
+	}
+
+	txs, missing, err := fillFromMempool(payload, header.Hash, bc.GetMempool())
+	if err != nil {
+		logger.Warnf("Compact block %x from %s: %s; requesting the full block", header.Hash, payload.AddrFrom, err)
+		sendGetData(payload.AddrFrom, "block", header.Hash)
+		return
+	}
+	if len(missing) == 0 {
+		completeCompactBlock(payload.AddrFrom, header, txs, bc)
+		return
+	}
+
+	partialBlocksMu.Lock()
+	if len(partialBlocks) >= maxPartialBlocks {
+		partialBlocks = make(map[string]*partialBlock)
+	}
+	partialBlocks[hex.EncodeToString(header.Hash)] = &partialBlock{payload.AddrFrom, header, txs}
+	partialBlocksMu.Unlock()
+
+	logger.Debugf("Compact block %x is missing %d of %d transaction(s)", header.Hash, len(missing), len(txs))
+	sendGetBlockTxn(payload.AddrFrom, header.Hash, missing)
+}
+
+func handleBlockTxn(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload blocktxn
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+	touchPeer(payload.AddrFrom)
+
+	key := hex.EncodeToString(payload.BlockHash)
+	partialBlocksMu.Lock()
+	partial := partialBlocks[key]
+	delete(partialBlocks, key)
+	partialBlocksMu.Unlock()
+	if partial == nil || partial.from != payload.AddrFrom {
+		return
+	}
+
+	if len(payload.Indexes) != len(payload.Transactions) {
+		sendGetData(payload.AddrFrom, "block", payload.BlockHash)
+		return
+	}
+	for i, index := range payload.Indexes {
+		tx, err := DecodeTransaction(payload.Transactions[i])
+		if err != nil || index <= 0 || index >= len(partial.txs) {
+			logger.Warnf("Bad transactions for compact block %x from %s; requesting the full block", payload.BlockHash, payload.AddrFrom)
+			sendGetData(payload.AddrFrom, "block", payload.BlockHash)
+			return
+		}
+		partial.txs[index] = tx
+	}
+
+	completeCompactBlock(payload.AddrFrom, partial.header, partial.txs, bc)
+}
+
+// completeCompactBlock assembles and adds a compact block, falling back to downloading it in full
+// if its transactions don't add up to the announced block
+func completeCompactBlock(from string, header BlockHeader, txs []*Transaction, bc *Blockchain) {
+	for _, tx := range txs {
+		if tx == nil {
+			sendGetData(from, "block", header.Hash)
+			return
+		}
+	}
+
+	block, err := assembleBlock(header, txs)
+	if err != nil {
+		logger.Warnf("Reconstructing compact block %x: %s; requesting the full block", header.Hash, err)
+		sendGetData(from, "block", header.Hash)
+		return
+	}
+
+	processBlock(from, block, bc)
+}
+
+// serveBlockTxn answers a getdata for some transactions of a block with a blocktxn message
+func serveBlockTxn(address string, blockHash []byte, indexes []int, bc *Blockchain) {
+	block, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return
+	}
+
+	var txs [][]byte
+	for _, index := range indexes {
+		if index < 0 || index >= len(block.Transactions) {
+			return
+		}
+		txs = append(txs, block.Transactions[index].Serialize())
+	}
+
+	sendBlockTxn(address, blockHash, indexes, txs)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// compactTestBlock returns a block of a coinbase and n payments, and the payments
+func compactTestBlock(t *testing.T, n int) (*Block, []*Transaction) {
+	t.Helper()
+
+	bc, w := newTestChain(t)
+	var payments []*Transaction
+	for i := 0; i < n; i++ {
+		tx, _ := signedSpend(t, w, string(NewWallet().GetAddress()), i+1, defaultChainID)
+		payments = append(payments, tx)
+	}
+
+	return peerBlock(t, bc, append([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "", 0, 2)}, payments...)...), payments
+}
+
+func TestCompactBlockReconstruction(t *testing.T) {
+	other, _ := signedSpend(t, NewWallet(), string(NewWallet().GetAddress()), 5, defaultChainID)
+
+	tests := []struct {
+		name        string
+		pool        func(payments []*Transaction) []*Transaction
+		wantMissing []int
+	}{
+		{"whole block in the mempool", func(p []*Transaction) []*Transaction { return p }, nil},
+		{"unrelated mempool transactions", func(p []*Transaction) []*Transaction { return append([]*Transaction{other}, p...) }, nil},
+		{"one transaction missing", func(p []*Transaction) []*Transaction { return []*Transaction{p[0], p[2]} }, []int{2}},
+		{"empty mempool", func(p []*Transaction) []*Transaction { return nil }, []int{1, 2, 3}},
+		{"short ID matching two mempool transactions", func(p []*Transaction) []*Transaction {
+			return append(p, p[1])
+		}, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, payments := compactTestBlock(t, 3)
+			compact := newCompactBlock(block)
+			header := block.Header()
+
+			txs, missing, err := fillFromMempool(compact, block.Hash, tt.pool(payments))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Fatalf("missing %v, want %v", missing, tt.wantMissing)
+			}
+
+			// The peer answers the getdata for the missing indexes with a blocktxn
+			reply := blocktxn{"peer", block.Hash, missing, nil}
+			for _, index := range missing {
+				reply.Transactions = append(reply.Transactions, block.Transactions[index].Serialize())
+			}
+			for i, index := range reply.Indexes {
+				tx, err := DecodeTransaction(reply.Transactions[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				txs[index] = tx
+			}
+
+			rebuilt, err := assembleBlock(header, txs)
+			if err != nil {
+				t.Fatalf("assembleBlock: %s", err)
+			}
+			if !bytes.Equal(EncodeBlock(rebuilt), EncodeBlock(block)) || !NewProofOfWork(rebuilt).Validate() {
+				t.Fatal("rebuilt block differs from the announced one")
+			}
+		})
+	}
+}
+
+func TestCompactBlockFallsBack(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(compact *cmpctblock, block *Block, pool []*Transaction) []*Transaction
+		fill   bool // fillFromMempool succeeds, and assembleBlock must catch the mismatch
+	}{
+		{"short ID resolving to the wrong transaction", func(compact *cmpctblock, block *Block, pool []*Transaction) []*Transaction {
+			// As if another mempool transaction collided with the first payment's short ID
+			compact.ShortIDs[0] = shortTxID(block.Hash, pool[1].ID)
+			return pool
+		}, true},
+		{"transactions out of order", func(compact *cmpctblock, block *Block, pool []*Transaction) []*Transaction {
+			compact.ShortIDs[0], compact.ShortIDs[1] = compact.ShortIDs[1], compact.ShortIDs[0]
+			return pool
+		}, true},
+		{"malformed coinbase", func(compact *cmpctblock, block *Block, pool []*Transaction) []*Transaction {
+			compact.Coinbase = compact.Coinbase[:3]
+			return pool
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, payments := compactTestBlock(t, 2)
+			compact := newCompactBlock(block)
+			pool := tt.tamper(&compact, block, payments)
+
+			txs, missing, err := fillFromMempool(compact, block.Hash, pool)
+			if !tt.fill {
+				if err == nil {
+					t.Fatal("fillFromMempool succeeded")
+				}
+				return
+			}
+			if err != nil || len(missing) != 0 {
+				t.Fatalf("fillFromMempool = missing %v, %v, want every transaction found", missing, err)
+			}
+			if _, err := assembleBlock(block.Header(), txs); err == nil {
+				t.Fatal("assembleBlock accepted transactions not matching the merkle root")
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// compressedCodecVersion is the leading byte of a gzip-compressed block, holding the blob EncodeBlock wrote
+const compressedCodecVersion = byte(0x04)
+
+// blockCompressionKey is the meta key recording that a chain stores its blocks compressed
+const blockCompressionKey = "blockcompression"
+
+// blockCompressionGzip is the only compression recorded under blockCompressionKey
+const blockCompressionGzip = "gzip"
+
+// compressBlocks makes Block.Serialize compress blocks
+// It's set from the chain's meta when it's opened, and by createblockchain -compress for a new chain
+var compressBlocks = false
+
+// compressBlock gzips a block blob, keeping the blob as is if that doesn't make it smaller,
+// as for blocks holding little more than a coinbase
+func compressBlock(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(compressedCodecVersion)
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return data
+	}
+	if err := zw.Close(); err != nil {
+		return data
+	}
+	if buf.Len() >= len(data) {
+		return data
+	}
+
+	return buf.Bytes()
+}
+
+// decompressBlock returns the blob held by a compressed block
+// The output is bounded by maxMessageSize, so a crafted blob can't inflate without limit
+func decompressBlock(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed block: %s", err)
+	}
+	defer zr.Close()
+
+	inner, err := io.ReadAll(io.LimitReader(zr, maxMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed block: %s", err)
+	}
+	if len(inner) > maxMessageSize {
+		return nil, errors.New("compressed block is too large")
+	}
+	if len(inner) == 0 || inner[0] == compressedCodecVersion {
+		return nil, errors.New("invalid compressed block")
+	}
+
+	return inner, nil
+}
+
+// blockCompressionInTx sets compressBlocks from the chain's meta
+func blockCompressionInTx(tx StoreTx) {
+	compressBlocks = false
+	if meta := tx.Bucket([]byte(metaBucket)); meta != nil {
+		compressBlocks = string(meta.Get([]byte(blockCompressionKey))) == blockCompressionGzip
+	}
+}
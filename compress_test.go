@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"strings"
+	"testing"
+)
+
+// paymentsBlock returns a mined block holding a coinbase and n payments from one wallet, as blocks of a busy chain do
+func paymentsBlock(t testing.TB, n int) *Block {
+	t.Helper()
+
+	w := NewWallet()
+	txs := []*Transaction{NewCoinbaseTX(string(w.GetAddress()), "", 0, 2)}
+	for i := 0; i < n; i++ {
+		tx, _ := signedSpend(t, w, string(NewWallet().GetAddress()), 10+i, defaultChainID)
+		txs = append(txs, tx)
+	}
+
+	return newBlockAt(txs, bytes.Repeat([]byte{1}, 32), 1, hashSHA256, 4, InstantSealer{})
+}
+
+// withBlockCompression sets whether blocks are serialized compressed for the rest of the test
+func withBlockCompression(t testing.TB, compress bool) {
+	t.Helper()
+
+	saved := compressBlocks
+	compressBlocks = compress
+	t.Cleanup(func() { compressBlocks = saved })
+}
+
+func TestBlockCompressionRoundTrip(t *testing.T) {
+	legacy := func(b *Block) []byte {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name           string
+		block          *Block
+		compress       bool
+		encode         func(b *Block) []byte // Serialize if nil
+		wantCompressed bool
+	}{
+		{"compressed", paymentsBlock(t, 20), true, nil, true},
+		{"uncompressed", paymentsBlock(t, 20), false, nil, false},
+		{"legacy gob, read by a compressing chain", paymentsBlock(t, 5), true, legacy, false},
+		{"legacy gob, read by an uncompressed chain", paymentsBlock(t, 5), false, legacy, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withBlockCompression(t, tt.compress)
+			tt.block.Bits = 0 // Legacy gob blocks predate the recorded bits
+			tt.block.Hash = tt.block.CalculateHash()
+
+			data := tt.block.Serialize()
+			if tt.encode != nil {
+				data = tt.encode(tt.block)
+			}
+			if compressed := data[0] == compressedCodecVersion; compressed != tt.wantCompressed {
+				t.Fatalf("compressed: %t, want %t", compressed, tt.wantCompressed)
+			}
+
+			decoded, err := DecodeBlock(data)
+			if err != nil {
+				t.Fatalf("DecodeBlock: %s", err)
+			}
+			if !bytes.Equal(decoded.CalculateHash(), tt.block.Hash) {
+				t.Fatalf("decoded block hashes to %x, want %x", decoded.CalculateHash(), tt.block.Hash)
+			}
+			if !decoded.IsPruned() && !bytes.Equal(EncodeBlock(decoded), EncodeBlock(tt.block)) {
+				t.Fatal("decoded block differs from the original")
+			}
+		})
+	}
+}
+
+func TestCompressBlockKeepsIncompressibleBlob(t *testing.T) {
+	data := make([]byte, 300)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = codecVersion
+
+	if compressed := compressBlock(data); !bytes.Equal(compressed, data) {
+		t.Fatalf("random blob of %d bytes compressed to %d bytes", len(data), len(compressed))
+	}
+}
+
+func TestDecodeBlockRejectsBadCompression(t *testing.T) {
+	withBlockCompression(t, true)
+	data := paymentsBlock(t, 20).Serialize()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"truncated", data[:len(data)/2], "invalid compressed block"},
+		{"not gzip", append([]byte{compressedCodecVersion}, bytes.Repeat([]byte{7}, 50)...), "invalid compressed block"},
+		{"compressed twice", compressBlock(append(data, bytes.Repeat([]byte{0}, 1000)...)), "invalid compressed block"},
+		{"empty", compressBlock(nil), "block data is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeBlock(tt.data); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("DecodeBlock = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChainReadsBlocksStoredBeforeCompression(t *testing.T) {
+	withBlockCompression(t, false)
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+
+	before := chainBlocks(t, bc, coinbaseBlocks(bc, address, 2)...)
+	if err := bc.AddBlocks(before); err != nil {
+		t.Fatal(err)
+	}
+	compressBlocks = true
+	after := chainBlocks(t, bc, coinbaseBlocks(bc, address, 2)...)
+	if err := bc.AddBlocks(after); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.blocks.Purge()
+	for _, block := range append(before, after...) {
+		stored, err := bc.GetBlock(block.Hash)
+		if err != nil || !bytes.Equal(stored.CalculateHash(), block.Hash) {
+			t.Fatalf("GetBlock(%x) = %x, %v", block.Hash, stored.CalculateHash(), err)
+		}
+	}
+}
+
+// BenchmarkBlockCompression serializes a block of 50 payments with and without compression, reporting the stored size
+func BenchmarkBlockCompression(b *testing.B) {
+	block := paymentsBlock(b, 50)
+
+	for _, compress := range []bool{false, true} {
+		name := "none"
+		if compress {
+			name = "gzip"
+		}
+		b.Run(name, func(b *testing.B) {
+			withBlockCompression(b, compress)
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data = block.Serialize()
+			}
+			b.ReportMetric(float64(len(data)), "bytes/block")
+		})
+	}
+}
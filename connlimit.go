@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultMaxInbound is how many inbound connections a node handles at once by default
+// Similar to Bitcoin's -maxconnections
+const defaultMaxInbound = 125
+
+// defaultMaxConnRate is how many connections one IP may open per connRateWindow by default
+// Every message arrives on its own connection, so this must leave room for a peer sending blocks during sync
+const defaultMaxConnRate = 600
+
+// connRateWindow is the period per-IP connection counts are kept for
+const connRateWindow = time.Minute
+
+// maxInbound bounds the inbound connections being handled at once; 0 disables the limit
+// Set with startnode -maxinbound
+var maxInbound = defaultMaxInbound
+
+// maxConnRate bounds the connections one IP may open per connRateWindow; 0 disables the limit
+// Loopback connections are exempt, so nodes sharing a machine aren't throttled together
+// Set with startnode -maxconnrate
+var maxConnRate = defaultMaxConnRate
+
+var (
+	errTooManyInbound = errors.New("too many inbound connections")
+	errConnRateLimit  = errors.New("too many connections from this IP")
+)
+
+// connWindow counts the connections of one IP since start
+type connWindow struct {
+	start time.Time
+	count int
+}
+
+// connLimiter admits inbound connections while they stay within maxInbound and maxConnRate
+type connLimiter struct {
+	mu        sync.Mutex
+	inbound   int
+	windows   map[string]*connWindow
+	lastPrune time.Time
+}
+
+var inboundLimiter = &connLimiter{windows: make(map[string]*connWindow)}
+
+// acquire admits a connection from remote, which must be released once handled, or returns why it's rejected
+func (l *connLimiter) acquire(remote net.Addr, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if maxInbound > 0 && l.inbound >= maxInbound {
+		return errTooManyInbound
+	}
+
+	if maxConnRate > 0 && !isLoopback(remote) {
+		host, _, err := net.SplitHostPort(remote.String())
+		if err != nil {
+			host = remote.String()
+		}
+		window := l.windows[host]
+		if window == nil || now.Sub(window.start) >= connRateWindow {
+			l.pruneWindows(now)
+			window = &connWindow{start: now}
+			l.windows[host] = window
+		}
+		if window.count >= maxConnRate {
+			return errConnRateLimit
+		}
+		window.count++
+	}
+
+	l.inbound++
+
+	return nil
+}
+
+// release frees the slot of a connection admitted by acquire
+func (l *connLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inbound--
+}
+
+// pruneWindows drops the counts of IPs whose window has ended, so the map doesn't grow with every IP ever seen
+// It runs at most once per window
+func (l *connLimiter) pruneWindows(now time.Time) {
+	if now.Sub(l.lastPrune) < connRateWindow {
+		return
+	}
+	l.lastPrune = now
+
+	for host, window := range l.windows {
+		if now.Sub(window.start) >= connRateWindow {
+			delete(l.windows, host)
+		}
+	}
+}
+
+// InboundConnections returns how many inbound connections the node is handling
+func InboundConnections() int {
+	inboundLimiter.mu.Lock()
+	defer inboundLimiter.mu.Unlock()
+
+	return inboundLimiter.inbound
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLimiterAcquire(t *testing.T) {
+	type step struct {
+		ip      string
+		at      time.Duration // Since the first connection
+		release bool          // Release a connection admitted earlier first
+		wantErr error
+	}
+
+	tests := []struct {
+		name        string
+		maxInbound  int
+		maxConnRate int
+		steps       []step
+	}{
+		{"inbound limit", 2, 0, []step{
+			{"10.0.0.1", 0, false, nil},
+			{"10.0.0.2", 0, false, nil},
+			{"10.0.0.3", 0, false, errTooManyInbound},
+			{"10.0.0.3", 0, true, nil},
+		}},
+		{"per-IP rate", 0, 2, []step{
+			{"10.0.0.1", 0, false, nil},
+			{"10.0.0.1", time.Second, false, nil},
+			{"10.0.0.1", 2 * time.Second, false, errConnRateLimit},
+			{"10.0.0.2", 2 * time.Second, false, nil},
+		}},
+		{"window resets", 0, 2, []step{
+			{"10.0.0.1", 0, false, nil},
+			{"10.0.0.1", 0, false, nil},
+			{"10.0.0.1", connRateWindow - time.Second, false, errConnRateLimit},
+			{"10.0.0.1", connRateWindow, false, nil},
+			{"10.0.0.1", connRateWindow, false, nil},
+			{"10.0.0.1", connRateWindow, false, errConnRateLimit},
+		}},
+		{"loopback exempt from the rate", 0, 1, []step{
+			{"127.0.0.1", 0, false, nil},
+			{"127.0.0.1", 0, false, nil},
+			{"127.0.0.1", 0, false, nil},
+		}},
+		{"rate-limited connection takes no slot", 1, 1, []step{
+			{"10.0.0.1", 0, false, nil},
+			{"10.0.0.1", 0, true, errConnRateLimit},
+			{"10.0.0.2", 0, false, nil},
+		}},
+		{"limits disabled", 0, 0, []step{
+			{"10.0.0.1", 0, false, nil},
+			{"10.0.0.1", 0, false, nil},
+			{"10.0.0.1", 0, false, nil},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedInbound, savedRate := maxInbound, maxConnRate
+			maxInbound, maxConnRate = tt.maxInbound, tt.maxConnRate
+			defer func() { maxInbound, maxConnRate = savedInbound, savedRate }()
+
+			limiter := &connLimiter{windows: make(map[string]*connWindow)}
+			start := time.Unix(1700000000, 0)
+			for i, s := range tt.steps {
+				if s.release {
+					limiter.release()
+				}
+				err := limiter.acquire(&net.TCPAddr{IP: net.ParseIP(s.ip), Port: 3000 + i}, start.Add(s.at))
+				if !errors.Is(err, s.wantErr) {
+					t.Fatalf("step %d: acquire from %s = %v, want %v", i, s.ip, err, s.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestConnLimiterPrunesEndedWindows(t *testing.T) {
+	limiter := &connLimiter{windows: make(map[string]*connWindow)}
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		if err := limiter.acquire(&net.TCPAddr{IP: net.IPv4(10, 0, 0, byte(i+1))}, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A new window after the old ones ended drops them
+	if err := limiter.acquire(&net.TCPAddr{IP: net.IPv4(10, 0, 1, 1)}, start.Add(2*connRateWindow)); err != nil {
+		t.Fatal(err)
+	}
+	if len(limiter.windows) != 1 {
+		t.Fatalf("%d windows kept, want only the new one", len(limiter.windows))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// consensusKey is the meta key of the consensus algorithm a chain was created with
+const consensusKey = "consensus"
+
+// consensusName returns the identifier of proof of work hashed with algo, e.g. "sha256-pow"
+// Chains record it so other consensus algorithms can be added without old chains changing rules
+func consensusName(algo HashAlgo) string {
+	return algo.String() + "-pow"
+}
+
+// consensusNames returns the identifiers of the known consensus algorithms, sorted
+func consensusNames() []string {
+	var names []string
+	for algo := range hashers {
+		names = append(names, consensusName(algo))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// parseConsensus returns the hash algorithm of a consensus identifier
+func parseConsensus(name string) (HashAlgo, error) {
+	for algo := range hashers {
+		if consensusName(algo) == name {
+			return algo, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown consensus algorithm %q (known: %s)", name, strings.Join(consensusNames(), ", "))
+}
+
+// chainConsensusInTx returns the hash algorithm of the consensus recorded for the chain ending at tip
+// DBs created before it was recorded use their genesis block's algorithm, and record it on their first writable open
+func chainConsensusInTx(tx StoreTx, tip []byte) (HashAlgo, error) {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta != nil {
+		if name := meta.Get([]byte(consensusKey)); name != nil {
+			return parseConsensus(string(name))
+		}
+	}
+
+	genesis, err := genesisBlockInTx(tx, tip)
+	if err != nil {
+		return 0, err
+	}
+	if meta != nil && tx.Writable() {
+		err = meta.Put([]byte(consensusKey), []byte(consensusName(genesis.HashAlgo)))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return genesis.HashAlgo, nil
+}
+
+// checkConsensus rejects a block hashed with another algorithm than the chain's
+func (bc *Blockchain) checkConsensus(algo HashAlgo) error {
+	if algo != bc.consensus {
+		return fmt.Errorf("block follows %s, but the chain uses %s", consensusName(algo), consensusName(bc.consensus))
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlocksFollowChainConsensus(t *testing.T) {
+	tests := []struct {
+		name    string
+		algo    HashAlgo
+		wantErr string
+	}{
+		{"matching", hashSHA256, ""},
+		{"mismatching", hashDoubleSHA256, "block follows sha256d-pow, but the chain uses sha256-pow"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			medianTime, err := bc.MedianTimePast(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := newBlockAt([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "", 0, 2)}, bc.Tip(), medianTime+1, tt.algo, targetBits, bc.sealer)
+
+			err = bc.AddBlock(block)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || bc.GetBestHeight() != 1 {
+				t.Fatalf("AddBlock = %v at height %d, want %q", err, bc.GetBestHeight(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordedConsensus(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded string // "" removes the record, as in DBs created before it
+		wantAlgo HashAlgo
+		wantErr  bool
+	}{
+		{"recorded", "sha256d-pow", hashDoubleSHA256, false},
+		{"not recorded", "", hashSHA256, false},
+		{"unknown", "scrypt-pow", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			_, w := newTestChainWithStore(t, store)
+			err := store.Update(func(tx StoreTx) error {
+				meta := tx.Bucket([]byte(metaBucket))
+				if tt.recorded == "" {
+					return meta.Delete([]byte(consensusKey))
+				}
+				return meta.Put([]byte(consensusKey), []byte(tt.recorded))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bc, err := NewBlockchainWithStore(store, DefaultGenesis(string(w.GetAddress())), defaultChainID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("opened a chain recording consensus %q", tt.recorded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bc.consensus != tt.wantAlgo {
+				t.Fatalf("chain uses %s, want %s", bc.consensus, tt.wantAlgo)
+			}
+			store.View(func(tx StoreTx) error {
+				if name := string(tx.Bucket([]byte(metaBucket)).Get([]byte(consensusKey))); name != consensusName(tt.wantAlgo) {
+					t.Errorf("recorded consensus %q, want %q", name, consensusName(tt.wantAlgo))
+				}
+				return nil
+			})
+		})
+	}
+}
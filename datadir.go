@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// dataDir is the directory holding the node's files (blockchain DB, wallet file, known peers)
+// Empty means the current directory; set with -datadir or the DATA_DIR env var
+// Similar to Geth's --datadir
+var dataDir string
+
+// dataFilePath returns the path of a per-node file, given its name format and the node ID
+// Names get the active network's prefix, so each network keeps its own files
+// The data directory is created on first use
+func dataFilePath(nameFormat, nodeID string) string {
+	name := activeNetwork.FilePrefix + fmt.Sprintf(nameFormat, nodeID)
+	if dataDir == "" {
+		return name
+	}
+
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return filepath.Join(dataDir, name)
+}
+
+// writeFileAtomic replaces the file at path with data, so a crash leaves either the old or the new content
+// The data is written to a temporary file in the same directory, synced to disk and renamed into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Sync the directory too, so the rename itself survives a crash
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFilesLandInDataDir(t *testing.T) {
+	useKnownNodes(t, "3000", "localhost:3000", nil)
+	bits := targetBits
+	if err := SetTargetBits(4); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTargetBits(bits) })
+
+	// A data dir that doesn't exist yet is created; nothing is written to the current directory
+	dataDir = filepath.Join(t.TempDir(), "node", "data")
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+
+	wallets, _ := NewWallets("3000")
+	address := wallets.CreateWallet()
+	wallets.SaveToFile("3000")
+	initKnownNodes("3000", []string{"a:1"})
+	bc := CreateBlockchain(address, "3000", defaultChainID)
+	bc.db.Close()
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{dataFileName(dbFile), dataFileName(peersFile), dataFileName(walletFile)}
+	sort.Strings(names)
+	sort.Strings(want)
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("data dir holds %q, want %q", names, want)
+	}
+	if entries, _ := os.ReadDir(cwd); len(entries) != 0 {
+		t.Fatalf("%d file(s) written to the current directory", len(entries))
+	}
+
+	// Reopened from the data dir, the chain and wallet are found again
+	reopened, err := openBlockchainReadOnly("3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.db.Close()
+	if reloaded, err := NewWallets("3000"); err != nil || len(reloaded.GetAddresses()) != 1 {
+		t.Fatalf("wallet file not found in the data dir: %v", err)
+	}
+}
+
+// dataFileName is the name of node 3000's file of the given name format on the active network
+func dataFileName(nameFormat string) string {
+	return filepath.Base(dataFilePath(nameFormat, "3000"))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+)
+
+// EventKind tells what happened in an Event
+type EventKind int
+
+const (
+	EventNewBlock EventKind = iota // A block was mined or accepted from a peer
+	EventNewTx                     // A transaction was added to the mempool
+)
+
+// defaultEventBuffer is how many undelivered events a subscription holds before dropping new ones
+const defaultEventBuffer = 16
+
+// Event is published on the event bus; Block or Tx is set depending on Kind
+type Event struct {
+	Kind  EventKind
+	Block *Block
+	Tx    *Transaction
+}
+
+// Subscription receives events on C until Unsubscribe is called
+type Subscription struct {
+	C <-chan Event
+
+	bus *EventBus
+	ch  chan Event
+}
+
+// Unsubscribe stops delivery and closes C
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
+
+// EventBus fans out chain events to in-process subscribers
+// Publishing never blocks: a subscriber whose buffer is full misses the event
+// Similar to Geth's event.Feed (BlockChain.SubscribeChainEvent, TxPool.SubscribeNewTxsEvent)
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// eventBus carries the node's block and mempool events
+var eventBus = NewEventBus()
+
+// NewEventBus creates an EventBus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber whose channel buffers up to buffer events
+func (eb *EventBus) Subscribe(buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, bus: eb, ch: ch}
+
+	eb.mu.Lock()
+	eb.subs[sub] = struct{}{}
+	eb.mu.Unlock()
+
+	return sub
+}
+
+// Publish delivers ev to every subscriber with room in its buffer
+// Returns how many subscribers dropped the event
+func (eb *EventBus) Publish(ev Event) int {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	dropped := 0
+	for sub := range eb.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			dropped++
+		}
+	}
+
+	if dropped > 0 {
+		logger.Debugf("%d slow subscriber(s) missed an event", dropped)
+	}
+
+	return dropped
+}
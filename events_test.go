@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChainEvents(t *testing.T) {
+	bc, w := newTestChain(t)
+	sub := eventBus.Subscribe(defaultEventBuffer)
+	defer sub.Unsubscribe()
+
+	// nextEvent returns the next event published, failing if there is none
+	nextEvent := func() Event {
+		t.Helper()
+		select {
+		case ev := <-sub.C:
+			return ev
+		default:
+			t.Fatal("no event published")
+			return Event{}
+		}
+	}
+
+	tx := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+	if err := bc.AddToMempool(tx); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(); ev.Kind != EventNewTx || ev.Tx == nil || !bytes.Equal(ev.Tx.ID, tx.ID) {
+		t.Fatalf("mempool add published %+v", ev)
+	}
+
+	mined := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "", 1, 2), tx})
+	if ev := nextEvent(); ev.Kind != EventNewBlock || ev.Block == nil || !bytes.Equal(ev.Block.Hash, mined.Hash) {
+		t.Fatalf("mining published %+v", ev)
+	}
+
+	received := peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 0, 3))
+	if err := bc.AddBlock(received); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(); ev.Kind != EventNewBlock || !bytes.Equal(ev.Block.Hash, received.Hash) {
+		t.Fatalf("accepting a block published %+v", ev)
+	}
+
+	sub.Unsubscribe()
+	if _, open := <-sub.C; open {
+		t.Fatal("subscription open after Unsubscribe")
+	}
+}
+
+func TestEventBusDropsForFullSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	slow, fast := bus.Subscribe(1), bus.Subscribe(2)
+
+	for i, wantDropped := range []int{0, 1, 2} {
+		if dropped := bus.Publish(Event{Kind: EventNewTx}); dropped != wantDropped {
+			t.Fatalf("event %d dropped by %d subscriber(s), want %d", i, dropped, wantDropped)
+		}
+	}
+	if len(slow.C) != 1 || len(fast.C) != 2 {
+		t.Fatalf("subscribers hold %d and %d event(s), want 1 and 2", len(slow.C), len(fast.C))
+	}
+}
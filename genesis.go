@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// defaultGenesisMessage is the coinbase data of genesis blocks that don't set their own
+const defaultGenesisMessage = "Genesis Block"
+
+// genesisKey is the meta key of the genesis block's hash, recorded when the chain is created
+const genesisKey = "genesis"
+
+// networkKey is the meta key of the network the chain was created on
+const networkKey = "network"
+
+// errWrongChain is wrapped by the errors of a DB that holds another chain than expected
+var errWrongChain = errors.New("unexpected chain")
+
+// Genesis specifies the first block of a chain: a message and the initial allocation of coins
+// Test networks use it to pre-fund several addresses
+// Similar to Geth's core.Genesis, loaded from genesis.json
+type Genesis struct {
+	Message string         `json:"message"` // Coinbase data of the genesis block
+	Alloc   map[string]int `json:"alloc"`   // Coins paid to each address
+}
+
+// DefaultGenesis returns the genesis paying the block reward to a single address
+func DefaultGenesis(address string) *Genesis {
+	return &Genesis{defaultGenesisMessage, map[string]int{address: subsidy}}
+}
+
+// LoadGenesis reads and validates a genesis file
+// The file is JSON, e.g. {"message": "testnet", "alloc": {"ADDRESS": 100, "ADDRESS2": 50}}
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var genesis Genesis
+	err = json.Unmarshal(data, &genesis)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if genesis.Message == "" {
+		genesis.Message = defaultGenesisMessage
+	}
+
+	err = genesis.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return &genesis, nil
+}
+
+// Validate checks that the genesis allocates positive amounts to well-formed addresses
+func (g *Genesis) Validate() error {
+	if len(g.Alloc) == 0 {
+		return errors.New("genesis allocates no coins")
+	}
+	if len(g.Message) > maxCoinbaseDataLen {
+		return fmt.Errorf("genesis message is longer than %d bytes", maxCoinbaseDataLen)
+	}
+
+	total := 0
+	for address, amount := range g.Alloc {
+		if err := CheckAddress(address); err != nil {
+			return fmt.Errorf("allocation address %q is not valid: %s", address, err)
+		}
+		if amount <= 0 {
+			return fmt.Errorf("allocation to %s must be positive, got %d", address, amount)
+		}
+
+		var err error
+		total, err = addValue(total, amount)
+		if err != nil {
+			return fmt.Errorf("total allocation: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// ToBlock creates the genesis block, sealed with sealer
+// Similar to Geth's Genesis.ToBlock()
+func (g *Genesis) ToBlock(sealer Sealer) *Block {
+	return NewBlock([]*Transaction{g.coinbase()}, []byte{}, sealer)
+}
+
+// coinbase returns the genesis block's only transaction
+// The allocations become its outputs, ordered by address; unlike the block's hash, its ID doesn't depend on when it is mined
+func (g *Genesis) coinbase() *Transaction {
+	addresses := make([]string, 0, len(g.Alloc))
+	for address := range g.Alloc {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var outputs []TXOutput
+	for _, address := range addresses {
+		outputs = append(outputs, *NewTXOutput(g.Alloc[address], address))
+	}
+
+	txin := TXInput{[]byte{}, -1, IntToHex(1), []byte(g.Message), nil}
+	cbtx := Transaction{nil, []TXInput{txin}, outputs, false, nil}
+	cbtx.ID = cbtx.Hash()
+
+	return &cbtx
+}
+
+// CheckGenesis fails unless the chain was created from genesis
+// Only the genesis transaction is compared, since the block's hash also covers when it was mined
+func (bc *Blockchain) CheckGenesis(genesis *Genesis) error {
+	var stored *Block
+
+	err := bc.db.View(func(tx StoreTx) error {
+		var err error
+		stored, err = genesisBlockInTx(tx, bc.Tip())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(stored.Transactions) > 0 && !bytes.Equal(stored.Transactions[0].ID, genesis.coinbase().ID) {
+		return fmt.Errorf("%w: the DB's genesis block %x doesn't match the genesis file", errWrongChain, stored.Hash)
+	}
+
+	return nil
+}
+
+// CheckChainID fails with errWrongChain unless the chain was created for chainID
+// An existing DB keeps the chain ID it was created with, so asking for another one must not pass silently
+func (bc *Blockchain) CheckChainID(chainID int64) error {
+	if bc.ChainID() != chainID {
+		return fmt.Errorf("%w: the DB was created for chain ID %d, not %d", errWrongChain, bc.ChainID(), chainID)
+	}
+
+	return nil
+}
+
+// checkChainIdentity fails unless the DB was created on the active network and still holds its recorded genesis block
+// DBs created before these were recorded get them on their first writable open
+func checkChainIdentity(tx StoreTx, tip []byte) error {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return nil
+	}
+
+	if network := meta.Get([]byte(networkKey)); network != nil {
+		if string(network) != activeNetwork.Name {
+			return fmt.Errorf("%w: the DB was created for %s, not %s", errWrongChain, network, activeNetwork.Name)
+		}
+	} else if tx.Writable() {
+		if err := meta.Put([]byte(networkKey), []byte(activeNetwork.Name)); err != nil {
+			return err
+		}
+	}
+
+	genesisHash := meta.Get([]byte(genesisKey))
+	if genesisHash == nil {
+		if !tx.Writable() {
+			return nil
+		}
+		genesis, err := genesisBlockInTx(tx, tip)
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte(genesisKey), genesis.Hash)
+	}
+
+	data := tx.Bucket([]byte(blocksBucket)).Get(genesisHash)
+	if data == nil {
+		return fmt.Errorf("%w: the DB doesn't hold its genesis block %x", errWrongChain, genesisHash)
+	}
+	genesis, err := DecodeBlock(data)
+	if err != nil {
+		return err
+	}
+	if len(genesis.PrevBlockHash) != 0 {
+		return fmt.Errorf("%w: recorded genesis block %x has a parent", errWrongChain, genesisHash)
+	}
+
+	return nil
+}
+
+// genesisBlockInTx returns the first block of the chain ending at tip
+func genesisBlockInTx(tx StoreTx, tip []byte) (*Block, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	for current := tip; ; {
+		data := b.Get(current)
+		if data == nil {
+			return nil, fmt.Errorf("block %x is not found", current)
+		}
+		block, err := DecodeBlock(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(block.PrevBlockHash) == 0 {
+			return block, nil
+		}
+		current = block.PrevBlockHash
+	}
+}
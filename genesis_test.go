@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenesisAllocations(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	genesis := &Genesis{"allocations", map[string]int{}}
+	for i, w := range wallets {
+		genesis.Alloc[string(w.GetAddress())] = 50 * (i + 1)
+	}
+
+	bc, err := NewBlockchainWithStore(NewMemoryStore(), genesis, defaultChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.db.Close()
+
+	for i, w := range wallets {
+		if balance, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != 50*(i+1) {
+			t.Errorf("allocation %d: balance %d, %v, want %d", i, balance, err, 50*(i+1))
+		}
+	}
+	if supply, err := (UTXOSet{bc}).TotalSupply(); err != nil || supply != 300 {
+		t.Fatalf("TotalSupply = %d, %v, want 300", supply, err)
+	}
+}
+
+func TestLoadGenesis(t *testing.T) {
+	a, b := string(NewWallet().GetAddress()), string(NewWallet().GetAddress())
+
+	tests := []struct {
+		name    string
+		file    string
+		want    map[string]int
+		wantErr string
+	}{
+		{"two allocations", fmt.Sprintf(`{"message": "testnet", "alloc": {"%s": 100, "%s": 50}}`, a, b), map[string]int{a: 100, b: 50}, ""},
+		{"no allocations", `{"message": "testnet", "alloc": {}}`, nil, "allocates no coins"},
+		{"zero allocation", fmt.Sprintf(`{"alloc": {"%s": 0}}`, a), nil, "must be positive"},
+		{"bad address", `{"alloc": {"nope": 5}}`, nil, "is not valid"},
+		{"not JSON", `alloc`, nil, "invalid character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "genesis.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			genesis, err := LoadGenesis(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadGenesis: %v, want error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(genesis.Alloc) != len(tt.want) || genesis.Alloc[a] != tt.want[a] || genesis.Alloc[b] != tt.want[b] {
+				t.Fatalf("allocations %v, want %v", genesis.Alloc, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGenesis(t *testing.T) {
+	store := NewMemoryStore()
+	bc, w := newTestChainWithStore(t, store)
+	address := string(w.GetAddress())
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		genesis *Genesis
+		wantErr bool
+	}{
+		{"same genesis", DefaultGenesis(address), false},
+		{"another address", DefaultGenesis(string(NewWallet().GetAddress())), true},
+		{"another message", &Genesis{"another chain", DefaultGenesis(address).Alloc}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bc.CheckGenesis(tt.genesis)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, errWrongChain)) {
+				t.Fatalf("CheckGenesis = %v, want an errWrongChain error %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The DB records its network too
+	useSelectedNetwork(t, "regtest")
+	if _, err := NewBlockchainWithStore(store, DefaultGenesis(address), defaultChainID); !errors.Is(err, errWrongChain) {
+		t.Fatalf("opening a mainnet DB on regtest: %v, want an errWrongChain error", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// HashAlgo identifies the algorithm a block was hashed with; blocks record it so they validate the same way
+type HashAlgo byte
+
+// hashSHA256 is single SHA-256, the algorithm of every block written before blocks recorded one
+const hashSHA256 = HashAlgo(0)
+
+// hashDoubleSHA256 is SHA-256 applied twice, which unlike a single round resists length extension
+// Similar to Bitcoin's SHA256d block hash
+const hashDoubleSHA256 = HashAlgo(1)
+
+// hashAlgoNames names the algorithms for display
+var hashAlgoNames = map[HashAlgo]string{
+	hashSHA256:       "sha256",
+	hashDoubleSHA256: "sha256d",
+}
+
+func (a HashAlgo) String() string {
+	if name, ok := hashAlgoNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown (%d)", byte(a))
+}
+
+// Hasher computes block hashes, for both the proof of work and the merkle root
+// Similar to Geth's consensus engines each bringing their own seal hash
+type Hasher interface {
+	// Algo returns the identifier recorded in blocks hashed with this Hasher
+	Algo() HashAlgo
+	// Sum returns the hash of data
+	Sum(data []byte) []byte
+}
+
+// sha256Hasher hashes with a single round of SHA-256
+type sha256Hasher struct{}
+
+func (sha256Hasher) Algo() HashAlgo { return hashSHA256 }
+
+func (sha256Hasher) Sum(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// doubleSHA256Hasher hashes with two rounds of SHA-256
+type doubleSHA256Hasher struct{}
+
+func (doubleSHA256Hasher) Algo() HashAlgo { return hashDoubleSHA256 }
+
+func (doubleSHA256Hasher) Sum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	hash := sha256.Sum256(first[:])
+	return hash[:]
+}
+
+// hashers lists the known hash algorithms by identifier
+var hashers = map[HashAlgo]Hasher{
+	hashSHA256:       sha256Hasher{},
+	hashDoubleSHA256: doubleSHA256Hasher{},
+}
+
+// defaultHasher hashes the blocks mined by this node; the active network selects it
+var defaultHasher Hasher = sha256Hasher{}
+
+// hasherFor returns the Hasher of a block's recorded algorithm
+func hasherFor(algo HashAlgo) (Hasher, error) {
+	hasher, ok := hashers[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %d", algo)
+	}
+
+	return hasher, nil
+}
+
+// hashWith hashes data with the algorithm algo, or returns nil if the algorithm is unknown
+// A nil hash matches no block hash and meets no target, so such blocks never validate
+func hashWith(algo HashAlgo, data []byte) []byte {
+	hasher, err := hasherFor(algo)
+	if err != nil {
+		return nil
+	}
+
+	return hasher.Sum(data)
+}
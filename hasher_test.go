@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+)
+
+// sha512Hasher hashes with SHA-512/256, standing in for an algorithm the node doesn't ship with
+type sha512Hasher struct{}
+
+func (sha512Hasher) Algo() HashAlgo { return HashAlgo(0x7f) }
+
+func (sha512Hasher) Sum(data []byte) []byte {
+	hash := sha512.Sum512_256(data)
+	return hash[:]
+}
+
+// useHasher registers hasher under name and makes it the default for the test
+func useHasher(t *testing.T, hasher Hasher, name string) {
+	t.Helper()
+
+	saved := defaultHasher
+	hashers[hasher.Algo()], hashAlgoNames[hasher.Algo()] = hasher, name
+	defaultHasher = hasher
+	t.Cleanup(func() {
+		delete(hashers, hasher.Algo())
+		delete(hashAlgoNames, hasher.Algo())
+		defaultHasher = saved
+	})
+}
+
+func TestMineWithSecondHasher(t *testing.T) {
+	hasher := sha512Hasher{}
+	useHasher(t, hasher, "sha512/256")
+	store := NewMemoryStore()
+	bc, w := newTestChainWithStore(t, store)
+	address := string(w.GetAddress())
+
+	mined := bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", 0, 2)})
+	received := peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 3))
+	if err := bc.AddBlock(received); err != nil {
+		t.Fatalf("AddBlock: %s", err)
+	}
+
+	for i, hash := range bc.GetBlockHashes() {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.HashAlgo != hasher.Algo() || !bytes.Equal(block.Hash, hasher.Sum(block.PrepareData())) {
+			t.Fatalf("block %d below the tip hashed with %s, want %s", i, block.HashAlgo, hasher.Algo())
+		}
+		if !NewProofOfWork(&block).Validate() {
+			t.Fatalf("block %d below the tip fails its proof of work", i)
+		}
+	}
+	if !bytes.Equal(bc.Tip(), received.Hash) || bc.GetBestHeight() != 3 || !bytes.Equal(received.PrevBlockHash, mined.Hash) {
+		t.Fatalf("tip %x at height %d, want %x at 3", bc.Tip(), bc.GetBestHeight(), received.Hash)
+	}
+
+	// Reopened, the chain keeps its recorded algorithm
+	reopened, err := NewBlockchainWithStore(store, DefaultGenesis(address), defaultChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.consensus != hasher.Algo() {
+		t.Fatalf("reopened chain uses %s, want %s", reopened.consensus, hasher.Algo())
+	}
+}
+
+func TestDoubleSHA256Blocks(t *testing.T) {
+	single, w := newTestChain(t)
+	address := string(w.GetAddress())
+	useSelectedNetwork(t, "testnet")
+	double, _ := newTestChain(t)
+
+	for _, tt := range []struct {
+		name string
+		bc   *Blockchain
+		algo HashAlgo
+	}{
+		{"testnet chain", double, hashDoubleSHA256},
+		{"chain started with single SHA-256", single, hashSHA256},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", 0, 2)})
+			if err := tt.bc.AddBlock(peerBlock(t, tt.bc, NewCoinbaseTX(address, "", 0, 3))); err != nil {
+				t.Fatalf("AddBlock: %s", err)
+			}
+
+			for i, hash := range tt.bc.GetBlockHashes() {
+				block, err := tt.bc.GetBlock(hash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				once := sha256.Sum256(block.PrepareData())
+				want := once[:]
+				if tt.algo == hashDoubleSHA256 {
+					twice := sha256.Sum256(once[:])
+					want = twice[:]
+				}
+				if block.HashAlgo != tt.algo || !bytes.Equal(block.Hash, want) {
+					t.Fatalf("block %d below the tip hashed with %s, want %s", i, block.HashAlgo, tt.algo)
+				}
+				if !NewProofOfWork(&block).Validate() {
+					t.Fatalf("block %d below the tip fails its proof of work", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// defaultHashRateBlocks is how many recent blocks estimatehashrate looks at by default
+const defaultHashRateBlocks = 120
+
+// Difficulty returns the expected number of hashes needed to mine a block at the given target bits
+// Each hash has a 1 in 2^bits chance of being below the target
+func Difficulty(bits int) float64 {
+	return math.Pow(2, float64(bits))
+}
+
+// EstimateHashRate estimates the hashes per second that mined the given headers, ordered from newest to oldest
+// The work of every header but the oldest is divided by the time elapsed since the oldest
+// Similar to Bitcoin's getnetworkhashps
+func EstimateHashRate(headers []BlockHeader) (float64, error) {
+	if len(headers) < 2 {
+		return 0, errors.New("at least two blocks are needed to estimate the hashrate")
+	}
+
+	work := 0.0
+	for _, header := range headers[:len(headers)-1] {
+		work += Difficulty(header.TargetBits())
+	}
+
+	elapsed := headers[0].Timestamp - headers[len(headers)-1].Timestamp
+	if elapsed <= 0 {
+		return 0, fmt.Errorf("the last %d blocks span no time", len(headers)-1)
+	}
+
+	return work / float64(elapsed), nil
+}
+
+// maxRetargetBits bounds how far one retarget moves the difficulty, in target bits (a factor of 4)
+// Similar to Bitcoin limiting each adjustment to a factor of 4
+const maxRetargetBits = 2
+
+// RetargetBits returns the target bits that would make blocks take interval seconds on average at hashRate,
+// moving at most maxRetargetBits away from bits
+// Each target bit doubles the expected hashes per block (see Difficulty)
+func RetargetBits(bits int, hashRate float64, interval int) int {
+	if hashRate <= 0 || interval <= 0 {
+		return bits
+	}
+
+	retargeted := int(math.Round(math.Log2(hashRate * float64(interval))))
+	retargeted = max(retargeted, bits-maxRetargetBits, 1)
+	retargeted = min(retargeted, bits+maxRetargetBits, 255)
+
+	return retargeted
+}
+
+// nextTargetBitsInTx returns the target bits required of the block at height on top of parent, a stored block or header
+// A block keeps its parent's bits, except every RetargetInterval blocks, when they're retargeted from the hashrate of
+// the last RetargetInterval blocks so blocks take TargetBlockInterval seconds on average.
+// On networks that don't retarget, and for the genesis block, they're targetBits
+// Similar to Bitcoin's GetNextWorkRequired
+func nextTargetBitsInTx(tx StoreTx, parent []byte, height int) (int, error) {
+	interval := activeNetwork.RetargetInterval
+	if interval <= 0 || len(parent) == 0 {
+		return targetBits, nil
+	}
+
+	header, err := lookupHeaderInTx(tx, parent)
+	if err != nil {
+		return 0, fmt.Errorf("parent %x: %w", parent, err)
+	}
+	bits := header.TargetBits()
+	if (height-1)%interval != 0 {
+		return bits, nil
+	}
+
+	headers := []BlockHeader{header}
+	for len(headers) <= interval && len(header.PrevBlockHash) > 0 {
+		header, err = lookupHeaderInTx(tx, header.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+		headers = append(headers, header)
+	}
+	hashRate, err := EstimateHashRate(headers)
+	if err != nil {
+		// Only a window of a few blocks can span no time, as each block's timestamp passes the median time past
+		return bits, nil
+	}
+
+	return RetargetBits(bits, hashRate, activeNetwork.TargetBlockInterval), nil
+}
+
+// NextTargetBits returns the target bits required of a block on top of the stored block prevHash
+func (bc *Blockchain) NextTargetBits(prevHash []byte) (int, error) {
+	height, err := bc.blockHeight(prevHash)
+	if err != nil {
+		return 0, err
+	}
+
+	var bits int
+	err = bc.db.View(func(tx StoreTx) error {
+		bits, err = nextTargetBitsInTx(tx, prevHash, height+1)
+		return err
+	})
+
+	return bits, err
+}
+
+// RecentHeaders returns the headers of up to n blocks ending at the tip, ordered from newest to oldest
+func (bc *Blockchain) RecentHeaders(n int) []BlockHeader {
+	var headers []BlockHeader
+	bci := bc.Iterator()
+
+	for len(headers) < n {
+		block := bci.Next()
+		headers = append(headers, block.Header())
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return headers
+}
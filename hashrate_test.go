@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEstimateHashRate(t *testing.T) {
+	bits := targetBits
+	if err := SetTargetBits(10); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTargetBits(bits)
+
+	tests := []struct {
+		name    string
+		headers []BlockHeader // Newest first
+		want    float64
+		wantErr bool
+	}{
+		{"one block a minute at 16 bits", []BlockHeader{{Timestamp: 180, Difficulty: 16}, {Timestamp: 120, Difficulty: 16}, {Timestamp: 60, Difficulty: 16}, {Timestamp: 0, Difficulty: 16}}, 3 * 65536 / 180.0, false},
+		{"oldest block's work isn't counted", []BlockHeader{{Timestamp: 10, Difficulty: 8}, {Timestamp: 0, Difficulty: 20}}, 256 / 10.0, false},
+		{"difficulty changing between blocks", []BlockHeader{{Timestamp: 30, Difficulty: 12}, {Timestamp: 20, Difficulty: 11}, {Timestamp: 0, Difficulty: 11}}, (4096 + 2048) / 30.0, false},
+		{"blocks without recorded bits use targetBits", []BlockHeader{{Timestamp: 4}, {Timestamp: 0}}, 1024 / 4.0, false},
+		{"a single block", []BlockHeader{{Timestamp: 0, Difficulty: 16}}, 0, true},
+		{"no time elapsed", []BlockHeader{{Timestamp: 50, Difficulty: 16}, {Timestamp: 50, Difficulty: 16}}, 0, true},
+		{"timestamps going backwards", []BlockHeader{{Timestamp: 40, Difficulty: 16}, {Timestamp: 50, Difficulty: 16}}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EstimateHashRate(tt.headers)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("EstimateHashRate = %f, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EstimateHashRate: %s", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("EstimateHashRate = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+// useNetwork makes a copy of the active network with the given retarget parameters active for the test
+func useNetwork(t *testing.T, retargetInterval, blockInterval int) {
+	t.Helper()
+
+	network := *activeNetwork
+	network.RetargetInterval = retargetInterval
+	network.TargetBlockInterval = blockInterval
+	saved := activeNetwork
+	activeNetwork = &network
+	t.Cleanup(func() { activeNetwork = saved })
+}
+
+// extendChain adds n blocks on bc's tip, spacing seconds apart, each at the bits the chain requires
+func extendChain(t *testing.T, bc *Blockchain, address string, n int, spacing int64) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		tip, err := bc.GetBlock(bc.Tip())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bits, err := bc.NextTargetBits(tip.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		coinbase := NewCoinbaseTX(address, "", 0, bc.GetBestHeight()+1)
+		if err := bc.AddBlock(newBlockAt([]*Transaction{coinbase}, tip.Hash, tip.Timestamp+spacing, bc.consensus, bits, bc.sealer)); err != nil {
+			t.Fatalf("AddBlock: %s", err)
+		}
+	}
+}
+
+func TestNextTargetBitsRetargets(t *testing.T) {
+	// The chain starts at 4 bits (16 hashes a block), so blocks spacing seconds apart mean 16/spacing hashes/s
+	tests := []struct {
+		name          string
+		spacing       int64
+		blockInterval int
+		want          int
+	}{
+		{"blocks on schedule", 1, 1, 4},
+		{"blocks on a slower schedule", 2, 2, 4},
+		{"blocks too fast", 1, 4, 6},
+		{"blocks too slow", 4, 1, 2},
+		{"at most a factor of 4 up", 1, 60, 6},
+		{"at most a factor of 4 down", 60, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			useNetwork(t, 4, tt.blockInterval)
+
+			// Heights 2 to 4 keep the genesis block's bits; height 5 is the first retarget
+			extendChain(t, bc, string(w.GetAddress()), 3, tt.spacing)
+			bits, err := bc.NextTargetBits(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bits != tt.want {
+				t.Fatalf("target bits %d at the retarget, want %d", bits, tt.want)
+			}
+
+			mined := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "", 0, 5)})
+			if mined.Bits != tt.want {
+				t.Fatalf("block mined at %d target bits, want %d", mined.Bits, tt.want)
+			}
+			// Until the next retarget, blocks keep the retargeted bits
+			if bits, _ := bc.NextTargetBits(mined.Hash); bits != tt.want {
+				t.Fatalf("target bits %d after the retarget, want %d", bits, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextTargetBitsWithoutRetargeting(t *testing.T) {
+	bc, w := newTestChain(t)
+	useNetwork(t, 0, 60)
+
+	extendChain(t, bc, string(w.GetAddress()), 8, 1)
+	if bits, err := bc.NextTargetBits(bc.Tip()); err != nil || bits != targetBits {
+		t.Fatalf("NextTargetBits = %d, %v, want targetBits %d", bits, err, targetBits)
+	}
+}
+
+func TestAddBlockChecksRetargetedBits(t *testing.T) {
+	bc, w := newTestChain(t)
+	useNetwork(t, 4, 4)
+	address := string(w.GetAddress())
+
+	extendChain(t, bc, address, 3, 1)
+	tip, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks came four times too fast, so the block at the retarget needs 6 bits, not the parent's 4
+	stale := newBlockAt([]*Transaction{NewCoinbaseTX(address, "stale", 0, 5)}, tip.Hash, tip.Timestamp+1, bc.consensus, 4, bc.sealer)
+	if err := bc.AddBlock(stale); err == nil || !strings.Contains(err.Error(), "target bits 4, want 6") {
+		t.Fatalf("AddBlock at the parent's bits = %v, want a target bits error", err)
+	}
+	retargeted := newBlockAt([]*Transaction{NewCoinbaseTX(address, "", 0, 5)}, tip.Hash, tip.Timestamp+1, bc.consensus, 6, bc.sealer)
+	if err := bc.AddBlock(retargeted); err != nil {
+		t.Fatalf("AddBlock at the retargeted bits: %s", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// BlockHeader holds the fields of a block that its hash commits to, without the transactions
+// Light clients and header sync need only these
+// Similar to Geth's types.Header
+type BlockHeader struct {
+	PrevBlockHash []byte   // Hash of the previous block
+	MerkleRoot    []byte   // Hash committing to the block's transactions
+	Timestamp     int64    // When the block was created (Unix timestamp)
+	Difficulty    int      // Target bits of the block's proof of work, as recorded in the block (see TargetBits)
+	Nonce         int      // Number used in Proof of Work mining
+	Hash          []byte   // Hash of the block
+	HashAlgo      HashAlgo // Algorithm the block is hashed with
+}
+
+// Header returns the header of the block
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.PrevBlockHash, b.MerkleRoot(), b.Timestamp, b.Bits, b.Nonce, b.Hash, b.HashAlgo}
+}
+
+// TargetBits returns the target bits the block's proof of work is checked against
+// Blocks from before blocks recorded them are checked against the node's targetBits
+func (b *Block) TargetBits() int {
+	return BlockHeader{Difficulty: b.Bits}.TargetBits()
+}
+
+// TargetBits returns the target bits the header's proof of work is checked against, as for Block.TargetBits
+func (h BlockHeader) TargetBits() int {
+	if h.Difficulty == 0 {
+		return targetBits
+	}
+
+	return h.Difficulty
+}
+
+// PrepareData returns the bytes hashed to get the block hash
+// It matches Block.PrepareData, so a header hashes to the same value as its full block
+// Algorithms other than SHA-256 are committed to as well; SHA-256 blocks hash as before they recorded one
+// Likewise the target bits, which blocks from before they were recorded hash without
+func (h BlockHeader) PrepareData() []byte {
+	fields := [][]byte{
+		h.PrevBlockHash,
+		h.MerkleRoot,
+		IntToHex(h.Timestamp),
+		IntToHex(int64(h.Nonce)),
+	}
+	if h.HashAlgo != hashSHA256 {
+		fields = append(fields, []byte{byte(h.HashAlgo)})
+	}
+	if h.Difficulty != 0 {
+		fields = append(fields, IntToHex(int64(h.Difficulty)))
+	}
+
+	return bytes.Join(fields, []byte{})
+}
+
+// CalculateHash calculates the hash of the block the header belongs to
+func (h BlockHeader) CalculateHash() []byte {
+	return hashWith(h.HashAlgo, h.PrepareData())
+}
+
+// String returns a human-readable representation of the header
+func (h BlockHeader) String() string {
+	return fmt.Sprintf("Header:\n"+
+		"  Hash:          %x\n"+
+		"  PrevBlockHash: %x\n"+
+		"  MerkleRoot:    %x\n"+
+		"  Timestamp:     %d\n"+
+		"  Difficulty:    %d\n"+
+		"  Nonce:         %d\n"+
+		"  Hash algo:     %s\n",
+		h.Hash,
+		h.PrevBlockHash,
+		h.MerkleRoot,
+		h.Timestamp,
+		h.TargetBits(),
+		h.Nonce,
+		h.HashAlgo,
+	)
+}
+
+// GetBlockHeader finds a block by its hash and returns its header
+// Headers of pruned blocks are still available
+func (bc *Blockchain) GetBlockHeader(blockHash []byte) (BlockHeader, error) {
+	var header BlockHeader
+
+	err := bc.db.View(func(tx StoreTx) error {
+		data := tx.Bucket([]byte(blocksBucket)).Get(blockHash)
+		if data == nil {
+			return fmt.Errorf("block %x is not found", blockHash)
+		}
+
+		block, err := DecodeBlock(data)
+		if err != nil {
+			return err
+		}
+		header = block.Header()
+
+		return nil
+	})
+
+	return header, err
+}
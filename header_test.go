@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBlockBitsAreEncodedAndHashed(t *testing.T) {
+	coinbase := NewCoinbaseTX(string(NewWallet().GetAddress()), "bits", 0, 1)
+	block := &Block{Timestamp: 1, Transactions: []*Transaction{coinbase}, PrevBlockHash: []byte{1}, Nonce: 7, Bits: 12}
+	block.Hash = block.CalculateHash()
+
+	for name, data := range map[string][]byte{"block": EncodeBlock(block), "pruned block": EncodePrunedBlock(block)} {
+		decoded, err := DecodeBlock(data)
+		if err != nil {
+			t.Fatalf("decoding %s: %s", name, err)
+		}
+		if decoded.Bits != 12 || !bytes.Equal(decoded.CalculateHash(), block.Hash) {
+			t.Fatalf("%s decoded with bits %d and hash %x, want 12 and %x", name, decoded.Bits, decoded.CalculateHash(), block.Hash)
+		}
+	}
+
+	header, err := DecodeBlockHeader(EncodeBlockHeader(block.Header()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Difficulty != 12 || !bytes.Equal(header.CalculateHash(), block.Hash) {
+		t.Fatalf("header decoded with difficulty %d and hash %x, want 12 and %x", header.Difficulty, header.CalculateHash(), block.Hash)
+	}
+
+	// The hash commits to the bits, so a block can't claim an easier target after it's mined
+	easier := *block
+	easier.Bits = 11
+	if bytes.Equal(easier.CalculateHash(), block.Hash) {
+		t.Fatal("changing the target bits didn't change the hash")
+	}
+
+	// Blocks from before blocks recorded their bits keep their encoding and hash
+	legacy := *block
+	legacy.Bits = 0
+	fields := bytes.Join([][]byte{legacy.PrevBlockHash, legacy.MerkleRoot(), IntToHex(legacy.Timestamp), IntToHex(int64(legacy.Nonce))}, []byte{})
+	if !bytes.Equal(legacy.PrepareData(), fields) {
+		t.Fatal("a block without bits doesn't hash as before")
+	}
+	if legacy.TargetBits() != targetBits {
+		t.Fatalf("block without bits checked against %d bits, want targetBits %d", legacy.TargetBits(), targetBits)
+	}
+}
+
+func TestAddBlockChecksTargetBits(t *testing.T) {
+	bc, w := newTestChain(t)
+	medianTime, err := bc.MedianTimePast(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	easier := newBlockAt([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "", 0, 2)}, bc.Tip(), medianTime+1, bc.consensus, targetBits-1, bc.sealer)
+	err = bc.AddBlock(easier)
+	if err == nil || !strings.Contains(err.Error(), "target bits") {
+		t.Fatalf("AddBlock of a block at %d bits = %v, want a target bits error", targetBits-1, err)
+	}
+}
+
+func TestHeaderHashMatchesBlock(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+
+	spend := spendCoinbase(t, bc, w, address, 1)
+	payments := peerBlock(t, bc, NewCoinbaseTX(address, "", 1, 2), spend)
+	if err := bc.AddBlock(payments); err != nil {
+		t.Fatal(err)
+	}
+	genesis := bc.GetBlockHashes()[1]
+
+	tests := []struct {
+		name  string
+		block *Block
+	}{
+		{"genesis", nil},
+		{"several transactions", payments},
+		{"double SHA-256", newBlockAt([]*Transaction{NewCoinbaseTX(address, "", 0, 3)}, payments.Hash, payments.Timestamp+1, hashDoubleSHA256, 4, bc.sealer)},
+		{"legacy without bits", newBlockAt([]*Transaction{NewCoinbaseTX(address, "", 0, 3)}, payments.Hash, payments.Timestamp+1, hashSHA256, 0, InstantSealer{})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := tt.block
+			if block == nil {
+				stored, err := bc.GetBlock(genesis)
+				if err != nil {
+					t.Fatal(err)
+				}
+				block = &stored
+			}
+
+			header := block.Header()
+			if !bytes.Equal(header.CalculateHash(), block.Hash) || !bytes.Equal(header.PrepareData(), block.PrepareData()) {
+				t.Fatalf("header hashes to %x, block to %x", header.CalculateHash(), block.Hash)
+			}
+			decoded, err := DecodeBlockHeader(EncodeBlockHeader(header))
+			if err != nil || !bytes.Equal(decoded.CalculateHash(), block.Hash) {
+				t.Fatalf("decoded header hashes to %x, %v, want %x", decoded.CalculateHash(), err, block.Hash)
+			}
+		})
+	}
+
+	// The stored header survives pruning the block's transactions
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 3))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.GetBlock(payments.Hash); !errors.Is(err, errBlockPruned) {
+		t.Fatalf("GetBlock of the pruned block: %v", err)
+	}
+	header, err := bc.GetBlockHeader(payments.Hash)
+	if err != nil || !bytes.Equal(header.CalculateHash(), payments.Hash) || !bytes.Equal(header.MerkleRoot, payments.MerkleRoot()) {
+		t.Fatalf("header of the pruned block hashes to %x, %v, want %x", header.CalculateHash(), err, payments.Hash)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// headersBucket holds headers downloaded ahead of their blocks' bodies, keyed by block hash
+const headersBucket = "headers"
+
+// headerTipKey is the meta key of the last header of the longest known header chain
+const headerTipKey = "headertip"
+
+// errUnknownHeader is returned when a block hash is neither a stored block nor a stored header
+var errUnknownHeader = errors.New("unknown block header")
+
+// toBlock returns a body-less block for the header, so its seal can be checked without the transactions
+func (h BlockHeader) toBlock() *Block {
+	return &Block{
+		Timestamp:     h.Timestamp,
+		PrevBlockHash: h.PrevBlockHash,
+		Hash:          h.Hash,
+		Nonce:         h.Nonce,
+		HashAlgo:      h.HashAlgo,
+		Bits:          h.Difficulty,
+		prunedRoot:    h.MerkleRoot,
+	}
+}
+
+// validateHeader checks a header received from a peer, at the height it would take in the chain with the target bits
+// required there. It applies the checks of validateBlock that don't need the transactions
+func (bc *Blockchain) validateHeader(h BlockHeader, height, bits int) error {
+	if err := checkpoints.Check(height, h.Hash); err != nil {
+		return err
+	}
+	if height <= checkpoints.LastHeight() {
+		return nil
+	}
+
+	if err := bc.checkConsensus(h.HashAlgo); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.CalculateHash(), h.Hash) {
+		return errors.New("header hash doesn't match its contents")
+	}
+	if h.TargetBits() != bits {
+		return fmt.Errorf("header has target bits %d, want %d", h.TargetBits(), bits)
+	}
+	if !bc.sealer.Verify(h.toBlock()) {
+		return errors.New("invalid proof of work")
+	}
+
+	return nil
+}
+
+// AddHeaders validates and stores a batch of headers, each one the parent of the next
+// The first header must extend a known block or header. Returns how many headers were new
+// Similar to Geth's HeaderChain.InsertHeaderChain()
+func (bc *Blockchain) AddHeaders(headers []BlockHeader) (int, error) {
+	if len(headers) == 0 {
+		return 0, nil
+	}
+	added := 0
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		hb, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+
+		prev := headers[0].PrevBlockHash
+		parentHeight := 0
+		if len(prev) > 0 {
+			parentHeight, err = headerHeightInTx(tx, prev)
+			if err != nil {
+				return fmt.Errorf("parent %x: %w", prev, err)
+			}
+		}
+
+		for i, h := range headers {
+			if !bytes.Equal(h.PrevBlockHash, prev) {
+				return fmt.Errorf("header %x doesn't extend %x", h.Hash, prev)
+			}
+			bits, err := nextTargetBitsInTx(tx, prev, parentHeight+i+1)
+			if err != nil {
+				return err
+			}
+			if err := bc.validateHeader(h, parentHeight+i+1, bits); err != nil {
+				return fmt.Errorf("header %x: %s", h.Hash, err)
+			}
+
+			if _, err := lookupHeaderInTx(tx, h.Hash); errors.Is(err, errUnknownHeader) {
+				if err := hb.Put(h.Hash, EncodeBlockHeader(h)); err != nil {
+					return err
+				}
+				added++
+			}
+			prev = h.Hash
+		}
+
+		// Longest header chain wins, as for blocks
+		tipHeight, err := headerHeightInTx(tx, headerTipInTx(tx, bc.Tip()))
+		if err != nil {
+			return err
+		}
+		if parentHeight+len(headers) > tipHeight {
+			return meta.Put([]byte(headerTipKey), prev)
+		}
+
+		return nil
+	})
+
+	return added, err
+}
+
+// HeaderTip returns the hash of the last header of the longest known header chain
+// It's the block tip unless headers have been downloaded beyond it
+func (bc *Blockchain) HeaderTip() []byte {
+	tip := bc.Tip()
+
+	err := bc.db.View(func(tx StoreTx) error {
+		tip = headerTipInTx(tx, tip)
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("Reading header tip: %s", err)
+	}
+
+	return tip
+}
+
+// MissingBodies returns the hashes of the longest header chain whose blocks aren't stored yet, oldest first
+func (bc *Blockchain) MissingBodies() ([][]byte, error) {
+	var missing [][]byte
+
+	err := bc.db.View(func(tx StoreTx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+
+		for current := headerTipInTx(tx, bc.Tip()); len(current) > 0 && blocks.Get(current) == nil; {
+			h, err := lookupHeaderInTx(tx, current)
+			if err != nil {
+				return err
+			}
+			missing = append(missing, current)
+			current = h.PrevBlockHash
+		}
+
+		return nil
+	})
+
+	// Walked from the tip back; reverse to go from oldest to newest
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+
+	return missing, err
+}
+
+// SyncProgress returns the height of the longest header chain and of the block chain
+// Headers run ahead of blocks while their bodies are being downloaded
+func (bc *Blockchain) SyncProgress() (int, int) {
+	headerHeight := 0
+
+	err := bc.db.View(func(tx StoreTx) error {
+		var err error
+		headerHeight, err = headerHeightInTx(tx, headerTipInTx(tx, bc.Tip()))
+		return err
+	})
+	if err != nil {
+		logger.Errorf("Reading header chain: %s", err)
+	}
+
+	return headerHeight, bc.GetBestHeight()
+}
+
+// headerTipInTx returns the stored header tip, or blockTip if it is at least as high
+func headerTipInTx(tx StoreTx, blockTip []byte) []byte {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return blockTip
+	}
+	headerTip := meta.Get([]byte(headerTipKey))
+	if headerTip == nil {
+		return blockTip
+	}
+
+	headerHeight, err := headerHeightInTx(tx, headerTip)
+	if err != nil {
+		return blockTip
+	}
+	blockHeight, err := headerHeightInTx(tx, blockTip)
+	if err != nil || headerHeight > blockHeight {
+		return headerTip
+	}
+
+	return blockTip
+}
+
+// headerHeightInTx returns the height of a stored block or header by walking back to the genesis block
+func headerHeightInTx(tx StoreTx, hash []byte) (int, error) {
+	height := 0
+
+	for current := hash; len(current) > 0; height++ {
+		h, err := lookupHeaderInTx(tx, current)
+		if err != nil {
+			return 0, err
+		}
+		current = h.PrevBlockHash
+	}
+
+	return height, nil
+}
+
+// lookupHeaderInTx returns the header of a stored block, or a header stored ahead of its body
+func lookupHeaderInTx(tx StoreTx, hash []byte) (BlockHeader, error) {
+	if data := tx.Bucket([]byte(blocksBucket)).Get(hash); data != nil {
+		block, err := DecodeBlock(data)
+		if err != nil {
+			return BlockHeader{}, err
+		}
+		return block.Header(), nil
+	}
+
+	if hb := tx.Bucket([]byte(headersBucket)); hb != nil {
+		if data := hb.Get(hash); data != nil {
+			return DecodeBlockHeader(data)
+		}
+	}
+
+	return BlockHeader{}, errUnknownHeader
+}
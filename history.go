@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+)
+
+// TxRecord describes how a single transaction affected an address
+type TxRecord struct {
+	TxID      []byte // The transaction
+	BlockHash []byte // Block containing the transaction
+	Height    int    // Height of that block (the genesis block is 1, matching GetBestHeight)
+	Timestamp int64  // Block timestamp
+	Received  int    // Value of the outputs paid to the address
+	Sent      int    // Value of the address's outputs spent by the transaction
+	Coinbase  bool   // Whether the transaction is a mining reward
+	Memo      []byte // The transaction's memo, if any
+}
+
+// FindTransactionsForAddress returns every transaction paying to or spending from pubKeyHash
+// Records are ordered chronologically, oldest first
+func (bc *Blockchain) FindTransactionsForAddress(pubKeyHash []byte) []TxRecord {
+	var records []TxRecord
+
+	// Outputs locked to the address, so spends can be valued as they're found
+	ownedOutputs := make(map[string]int)
+
+	blocks := bc.blocksFromGenesis()
+	for i, block := range blocks {
+		height := i + 1
+
+		for _, tx := range block.Transactions {
+			record := TxRecord{
+				TxID:      tx.ID,
+				BlockHash: block.Hash,
+				Height:    height,
+				Timestamp: block.Timestamp,
+				Coinbase:  tx.IsCoinbase(),
+				Memo:      tx.Memo,
+			}
+			involved := false
+
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Vin {
+					outpoint := fmt.Sprintf("%x:%d", in.Txid, in.Vout)
+					if value, ok := ownedOutputs[outpoint]; ok {
+						record.Sent += value
+						involved = true
+						delete(ownedOutputs, outpoint)
+					}
+				}
+			}
+
+			for outIdx, out := range tx.Vout {
+				if out.IsLockedWithKey(pubKeyHash) {
+					record.Received += out.Value
+					involved = true
+					ownedOutputs[fmt.Sprintf("%x:%d", tx.ID, outIdx)] = out.Value
+				}
+			}
+
+			if involved {
+				records = append(records, record)
+			}
+		}
+	}
+
+	return records
+}
+
+// blocksFromGenesis returns all blocks of the chain ordered from genesis to tip
+func (bc *Blockchain) blocksFromGenesis() []*Block {
+	var blocks []*Block
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	return blocks
+}
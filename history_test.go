@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFindTransactionsForAddress(t *testing.T) {
+	bc, alice := newTestChain(t)
+	bob, carol := NewWallet(), NewWallet()
+
+	genesis, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBob := spendOutput(t, bc, alice, genesis.Transactions[0], 0, string(bob.GetAddress()), 1, false)
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(alice.GetAddress()), "", 1, 2), toBob)); err != nil {
+		t.Fatal(err)
+	}
+	toAlice := spendOutput(t, bc, bob, toBob, 0, string(alice.GetAddress()), 1, false)
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(carol.GetAddress()), "", 1, 3), toAlice)); err != nil {
+		t.Fatal(err)
+	}
+
+	type record struct {
+		height, received, sent int
+		coinbase               bool
+	}
+	tests := []struct {
+		name   string
+		wallet *Wallet
+		want   []record
+	}{
+		{"miner and payer", alice, []record{
+			{1, subsidy, 0, true},
+			{2, subsidy + 1, 0, true},
+			{2, 0, subsidy, false},
+			{3, subsidy - 2, 0, false},
+		}},
+		{"payee spending on", bob, []record{{2, subsidy - 1, 0, false}, {3, 0, subsidy - 1, false}}},
+		{"miner only", carol, []record{{3, subsidy + 1, 0, true}}},
+		{"uninvolved", NewWallet(), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := bc.FindTransactionsForAddress(HashPubKey(tt.wallet.PublicKey))
+			if len(records) != len(tt.want) {
+				t.Fatalf("got %d record(s), want %d", len(records), len(tt.want))
+			}
+
+			for i, r := range records {
+				got := record{r.Height, r.Received, r.Sent, r.Coinbase}
+				if got != tt.want[i] {
+					t.Errorf("record %d is %+v, want %+v", i, got, tt.want[i])
+				}
+				block, err := bc.GetBlock(r.BlockHash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.Timestamp != block.Timestamp || !blockHasTransaction(&block, r.TxID) {
+					t.Errorf("record %d doesn't point at its block", i)
+				}
+			}
+		})
+	}
+}
+
+// blockHasTransaction reports whether block holds the transaction txID
+func blockHasTransaction(block *Block, txID []byte) bool {
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestMemo(t *testing.T) {
+	bc, alice := newTestChain(t)
+	bob := NewWallet()
+	from := string(alice.GetAddress())
+	wallets := Wallets{Wallets: map[string]*Wallet{from: alice}}
+	memo := []byte("rent for March")
+
+	if _, err := NewUTXOTransaction(from, string(bob.GetAddress()), 3, 1, SendOptions{Memo: make([]byte, maxMemoSize+1)}, bc, &wallets); err == nil {
+		t.Fatal("accepted a memo over the size limit")
+	}
+	tx, err := NewUTXOTransaction(from, string(bob.GetAddress()), 3, 1, SendOptions{Memo: memo}, bc, &wallets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Memo, memo) || !bytes.Equal(decoded.Hash(), tx.ID) {
+		t.Fatalf("decoded memo %q with ID %x, want %q with ID %x", decoded.Memo, decoded.Hash(), memo, tx.ID)
+	}
+	stripped := *decoded
+	stripped.Memo = nil
+	if bytes.Equal(stripped.Hash(), tx.ID) {
+		t.Fatal("the memo isn't covered by the transaction ID")
+	}
+
+	if err := bc.AddToMempool(decoded); err != nil {
+		t.Fatalf("AddToMempool: %s", err)
+	}
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(NewWallet().GetAddress()), "", 1, 2), decoded)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []*Wallet{alice, bob} {
+		records := bc.FindTransactionsForAddress(HashPubKey(w.PublicKey))
+		last := records[len(records)-1]
+		if !bytes.Equal(last.TxID, tx.ID) || !bytes.Equal(last.Memo, memo) {
+			t.Errorf("latest record is %x with memo %q, want %x with %q", last.TxID, last.Memo, tx.ID, memo)
+		}
+	}
+	if genesis := bc.FindTransactionsForAddress(HashPubKey(alice.PublicKey))[0]; genesis.Memo != nil {
+		t.Errorf("coinbase record has memo %q", genesis.Memo)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// locatorDenseEntries is how many of the latest blocks a locator lists one by one before it starts skipping
+const locatorDenseEntries = 10
+
+// maxLocatorHashes caps the hashes of a received locator; one of a chain of billions of blocks fits in far fewer
+// Similar to Bitcoin's MAX_LOCATOR_SZ
+const maxLocatorHashes = 101
+
+// BlockLocator describes the header chain of this node to a peer: hashes from the header tip back to
+// the genesis block, the latest locatorDenseEntries one by one and then twice as far apart each time
+// A peer on another branch finds the latest block both chains share from a few dozen hashes
+// Similar to Bitcoin's CBlockLocator
+func (bc *Blockchain) BlockLocator() [][]byte {
+	var locator [][]byte
+
+	err := bc.db.View(func(tx StoreTx) error {
+		var err error
+		locator, err = buildLocator(headerTipInTx(tx, bc.Tip()), func(hash []byte) ([]byte, error) {
+			h, err := lookupHeaderInTx(tx, hash)
+			return h.PrevBlockHash, err
+		})
+		return err
+	})
+	if err != nil {
+		logger.Errorf("Building block locator: %s", err)
+		return [][]byte{bc.HeaderTip()}
+	}
+
+	return locator
+}
+
+// buildLocator walks from tip to the genesis block with prev, which returns the parent of a hash
+// (empty for the genesis block), and lists the hashes of a block locator
+func buildLocator(tip []byte, prev func(hash []byte) ([]byte, error)) ([][]byte, error) {
+	var locator [][]byte
+	step := 1
+
+	for current := tip; len(current) > 0; {
+		locator = append(locator, current)
+		if len(locator) >= locatorDenseEntries {
+			step *= 2
+		}
+
+		// Step back, stopping at the genesis block, which always ends the locator
+		for i := 0; i < step; i++ {
+			parent, err := prev(current)
+			if err != nil {
+				return nil, err
+			}
+			if len(parent) == 0 {
+				if !bytes.Equal(current, locator[len(locator)-1]) {
+					locator = append(locator, current)
+				}
+				return locator, nil
+			}
+			current = parent
+		}
+	}
+
+	return locator, nil
+}
+
+// forkPoint returns the index in chain, a list of main chain hashes from tip to genesis, of the first
+// locator hash on it: the latest block the requester shares with us. It's -1 if they share none
+func forkPoint(chain [][]byte, locator [][]byte) int {
+	positions := make(map[string]int, len(chain))
+	for i, hash := range chain {
+		positions[hex.EncodeToString(hash)] = i
+	}
+
+	for _, hash := range locator {
+		if i, ok := positions[hex.EncodeToString(hash)]; ok {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// requestLocator returns the locator of a getblocks or getheaders request
+// Peers older than locators only send their tip, which serves as a locator of one hash
+func requestLocator(from []byte, locator [][]byte) [][]byte {
+	if len(locator) == 0 {
+		return [][]byte{from}
+	}
+
+	return locator
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota // Chatty progress, such as mining ticks and received commands
+	LevelInfo                  // Notable events, such as accepted blocks
+	LevelWarn                  // Recoverable problems, such as unreachable peers
+	LevelError                 // Failures that abort an operation
+)
+
+// defaultLogLevel keeps the node quiet unless LOG_LEVEL asks for more
+const defaultLogLevel = LevelInfo
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLogLevel parses a level name such as "debug" or "WARN"
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level := LevelDebug; level <= LevelError; level++ {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+
+	return defaultLogLevel, fmt.Errorf("unknown log level %q", name)
+}
+
+// Logger writes timestamped lines, dropping those below its level
+// Similar to Geth's log package (log.Debug, log.Info, ...)
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level LogLevel
+}
+
+// logger is the node's logger; it writes to stderr so command output on stdout stays clean
+var logger = NewLogger(os.Stderr, defaultLogLevel)
+
+// NewLogger creates a Logger writing messages of at least level to out
+func NewLogger(out io.Writer, level LogLevel) *Logger {
+	return &Logger{out: out, level: level}
+}
+
+// SetLevel changes the lowest level that is written
+func (l *Logger) SetLevel(level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.level = level
+}
+
+// Enabled reports whether messages of level are written
+func (l *Logger) Enabled(level LogLevel) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return level >= l.level
+}
+
+// Debugf logs a DEBUG message
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LevelDebug, format, args...)
+}
+
+// Infof logs an INFO message
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(LevelInfo, format, args...)
+}
+
+// Warnf logs a WARN message
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logf(LevelWarn, format, args...)
+}
+
+// Errorf logs an ERROR message
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LevelError, format, args...)
+}
+
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level < l.level {
+		return
+	}
+
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	fmt.Fprintf(l.out, "%s %-5s %s\n", timestamp, level, fmt.Sprintf(format, args...))
+}
+
+// loadLogLevelFromEnv applies the LOG_LEVEL env var (debug, info, warn or error)
+func loadLogLevelFromEnv() {
+	value := os.Getenv("LOG_LEVEL")
+	if value == "" {
+		return
+	}
+
+	level, err := ParseLogLevel(value)
+	if err != nil {
+		logger.Warnf("Ignoring invalid LOG_LEVEL: %s", err)
+		return
+	}
+	logger.SetLevel(level)
+}
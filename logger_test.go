@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		level LogLevel
+		want  []string // Levels of the lines written
+	}{
+		{LevelDebug, []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{LevelInfo, []string{"INFO", "WARN", "ERROR"}},
+		{LevelWarn, []string{"WARN", "ERROR"}},
+		{LevelError, []string{"ERROR"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			var out bytes.Buffer
+			l := NewLogger(&out, tt.level)
+			l.Debugf("debug %d", 1)
+			l.Infof("info %d", 2)
+			l.Warnf("warn %d", 3)
+			l.Errorf("error %d", 4)
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("wrote:\n%s\nwant %d line(s)", out.String(), len(tt.want))
+			}
+			for i, line := range lines {
+				fields := strings.Fields(line)
+				if len(fields) != 5 || fields[2] != tt.want[i] || !strings.EqualFold(fields[3], tt.want[i]) {
+					t.Errorf("line %q, want a timestamped %s message", line, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    LogLevel
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{"Warn", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", defaultLogLevel, true},
+		{"", defaultLogLevel, true},
+	}
+
+	for _, tt := range tests {
+		level, err := ParseLogLevel(tt.name)
+		if level != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseLogLevel(%q) = %s, %v", tt.name, level, err)
+		}
+	}
+}
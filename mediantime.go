@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// medianTimeSpan is the number of blocks whose timestamps make up the median time past
+const medianTimeSpan = 11
+
+// MedianTimePast returns the median timestamp of a stored block and up to 10 of its ancestors
+// Unlike a single block's timestamp, a lone miner can't move it far, so time-based rules use it as their clock.
+// Near the genesis block fewer timestamps are available and the median is taken over those
+// Similar to Bitcoin's CBlockIndex::GetMedianTimePast()
+func (bc *Blockchain) MedianTimePast(blockHash []byte) (int64, error) {
+	var timestamps []int64
+
+	err := bc.db.View(func(tx StoreTx) error {
+		var err error
+		timestamps, err = recentTimestamps(tx, blockHash)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return medianTimestamp(timestamps), nil
+}
+
+// recentTimestamps returns the timestamps of a stored block and up to medianTimeSpan-1 of its ancestors, newest first
+func recentTimestamps(tx StoreTx, blockHash []byte) ([]int64, error) {
+	var timestamps []int64
+	b := tx.Bucket([]byte(blocksBucket))
+
+	for current := blockHash; len(timestamps) < medianTimeSpan; {
+		data := b.Get(current)
+		if data == nil {
+			return nil, fmt.Errorf("block %x is not found", current)
+		}
+		block, err := DecodeBlock(data)
+		if err != nil {
+			return nil, err
+		}
+		timestamps = append(timestamps, block.Timestamp)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+		current = block.PrevBlockHash
+	}
+
+	return timestamps, nil
+}
+
+// checkBlockTime rejects a block whose timestamp isn't after medianTime, the median time past of its parent
+// The genesis block has no parent to compare with
+func checkBlockTime(block *Block, medianTime int64) error {
+	if len(block.PrevBlockHash) == 0 {
+		return nil
+	}
+	if block.Timestamp <= medianTime {
+		return fmt.Errorf("block timestamp %d is not after the median time past %d", block.Timestamp, medianTime)
+	}
+
+	return nil
+}
+
+// medianTimestamp returns the median of up to medianTimeSpan block timestamps
+func medianTimestamp(timestamps []int64) int64 {
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMedianTimestamp(t *testing.T) {
+	tests := []struct {
+		timestamps []int64
+		want       int64
+	}{
+		{nil, 0},
+		{[]int64{5}, 5},
+		{[]int64{3, 1, 2}, 2},
+		{[]int64{10, 1, 9, 2}, 9},
+		{[]int64{11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 6},
+		{[]int64{1, 100, 2, 99, 3, 98, 4, 97, 5, 96, 6}, 6},
+	}
+
+	for _, tt := range tests {
+		if got := medianTimestamp(tt.timestamps); got != tt.want {
+			t.Errorf("medianTimestamp(%v) = %d, want %d", tt.timestamps, got, tt.want)
+		}
+	}
+}
+
+func TestMedianTimePastWithNonMonotonicTimestamps(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+	genesis, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := genesis.Timestamp
+
+	// Seconds after the genesis block; each block is after the median time past of its parent, not its parent
+	for i, offset := range []int64{10, 30, 12, 40, 20} {
+		block := newBlockAt([]*Transaction{NewCoinbaseTX(address, "", 0, i+2)}, bc.Tip(), start+offset, bc.consensus, targetBits, bc.sealer)
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatalf("block %d at +%ds: %s", i+2, offset, err)
+		}
+	}
+
+	// Timestamps 0, 10, 30, 12, 40, 20 have median 20
+	medianTime, err := bc.MedianTimePast(bc.Tip())
+	if err != nil || medianTime != start+20 {
+		t.Fatalf("MedianTimePast = +%d, %v, want +20", medianTime-start, err)
+	}
+
+	tests := []struct {
+		offset int64
+		valid  bool
+	}{
+		{15, false},
+		{20, false},
+		{21, true},
+	}
+	for _, tt := range tests {
+		block := newBlockAt([]*Transaction{NewCoinbaseTX(address, "", 0, 7)}, bc.Tip(), start+tt.offset, bc.consensus, targetBits, bc.sealer)
+		err := bc.AddBlock(block)
+		if tt.valid && err != nil {
+			t.Fatalf("block at +%ds, before its parent but after the median: %s", tt.offset, err)
+		}
+		if !tt.valid && (err == nil || !strings.Contains(err.Error(), "median time past")) {
+			t.Fatalf("block at +%ds: %v, want it rejected as not after the median time past", tt.offset, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	errStoreClosed        = errors.New("store is closed")
+	errTxNotWritable      = errors.New("transaction is not writable")
+	errBucketExists       = errors.New("bucket already exists")
+	errBucketNotFound     = errors.New("bucket not found")
+	errBucketNameRequired = errors.New("bucket name required")
+	errKeyRequired        = errors.New("key required")
+)
+
+// memoryStore is a Store kept in memory and lost when closed, e.g. for tests and throwaway chains
+// Each update works on a copy of the data that replaces it on commit, so a failed update leaves no trace
+// Similar to Geth's ethdb/memorydb
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// NewMemoryStore returns an empty in-memory Store
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return errStoreClosed
+	}
+
+	return fn(&memoryTx{s.buckets, false})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errStoreClosed
+	}
+
+	// Values are never modified in place, so copying the maps is enough
+	buckets := make(map[string]map[string][]byte, len(s.buckets))
+	for name, bucket := range s.buckets {
+		copied := make(map[string][]byte, len(bucket))
+		for k, v := range bucket {
+			copied[k] = v
+		}
+		buckets[name] = copied
+	}
+
+	err := fn(&memoryTx{buckets, true})
+	if err != nil {
+		return err
+	}
+	s.buckets = buckets
+
+	return nil
+}
+
+func (s *memoryStore) Path() string {
+	return ""
+}
+
+func (s *memoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.buckets = nil
+
+	return nil
+}
+
+// memoryTx is a transaction of a memoryStore
+type memoryTx struct {
+	buckets  map[string]map[string][]byte
+	writable bool
+}
+
+func (t *memoryTx) Bucket(name []byte) StoreBucket {
+	bucket, ok := t.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+
+	return &memoryBucket{bucket, t.writable}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) (StoreBucket, error) {
+	if !t.writable {
+		return nil, errTxNotWritable
+	}
+	if len(name) == 0 {
+		return nil, errBucketNameRequired
+	}
+	if _, ok := t.buckets[string(name)]; ok {
+		return nil, errBucketExists
+	}
+
+	bucket := make(map[string][]byte)
+	t.buckets[string(name)] = bucket
+
+	return &memoryBucket{bucket, true}, nil
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memoryTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return errTxNotWritable
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		return errBucketNotFound
+	}
+	delete(t.buckets, string(name))
+
+	return nil
+}
+
+func (t *memoryTx) Writable() bool {
+	return t.writable
+}
+
+// memoryBucket is a bucket of a memoryTx
+type memoryBucket struct {
+	data     map[string][]byte
+	writable bool
+}
+
+func (b *memoryBucket) Get(key []byte) []byte {
+	return b.data[string(key)]
+}
+
+func (b *memoryBucket) Put(key, value []byte) error {
+	if !b.writable {
+		return errTxNotWritable
+	}
+	if len(key) == 0 {
+		return errKeyRequired
+	}
+	b.data[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (b *memoryBucket) Delete(key []byte) error {
+	if !b.writable {
+		return errTxNotWritable
+	}
+	delete(b.data, string(key))
+
+	return nil
+}
+
+func (b *memoryBucket) ForEach(fn func(k, v []byte) error) error {
+	for _, k := range b.sortedKeys() {
+		if err := fn([]byte(k), b.data[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryBucket) Cursor() StoreCursor {
+	return &memoryCursor{bucket: b}
+}
+
+// sortedKeys returns the bucket's keys in byte order, as bbolt iterates them
+func (b *memoryBucket) sortedKeys() []string {
+	keys := make([]string, 0, len(b.data))
+	for k := range b.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// memoryCursor iterates over the keys a memoryBucket had when First was called
+type memoryCursor struct {
+	bucket *memoryBucket
+	keys   []string
+	pos    int
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	c.keys = c.bucket.sortedKeys()
+	c.pos = 0
+
+	return c.current()
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	c.pos++
+
+	return c.current()
+}
+
+// current returns the key and value at the cursor, skipping keys deleted since First
+func (c *memoryCursor) current() ([]byte, []byte) {
+	for ; c.pos < len(c.keys); c.pos++ {
+		if v, ok := c.bucket.data[c.keys[c.pos]]; ok {
+			return []byte(c.keys[c.pos]), v
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// messageMagic prefixes signed messages so a message signature can never double as a transaction signature
+// Similar to Geth's accounts.TextHash ("\x19Ethereum Signed Message:\n")
+const messageMagic = "Simple Blockchain Signed Message:\n"
+
+// messageHash returns the digest that is signed for message
+func messageHash(message string) []byte {
+	hash := sha256.Sum256([]byte(messageMagic + message))
+
+	return hash[:]
+}
+
+// SignMessage signs message with the wallet's key, proving ownership of its address
+// P-256 signatures don't allow public key recovery, so the key is appended after r||s
+// Similar to Geth's personal_sign
+func SignMessage(w Wallet, message string) string {
+	signature := signData(w.PrivateKey, messageHash(message))
+
+	return base64.StdEncoding.EncodeToString(append(signature, w.PublicKey...))
+}
+
+// VerifyMessage checks that signature was made by the key behind address for message
+// Similar to Geth's personal_ecRecover
+func VerifyMessage(address, message, signature string) (bool, error) {
+	if IsMultisigAddress(address) || IsScriptHashAddress(address) {
+		return false, errors.New("messages can't be signed by multisig addresses")
+	}
+	if err := CheckAddress(address); err != nil {
+		return false, fmt.Errorf("invalid address %s: %s", address, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, fmt.Errorf("invalid signature encoding: %s", err)
+	}
+	if len(data) <= 2*sigScalarLen {
+		return false, errors.New("signature is too short")
+	}
+
+	sig, pubKey := data[:2*sigScalarLen], data[2*sigScalarLen:]
+	if !bytes.Equal(HashPubKey(pubKey), AddressToPubKeyHash(address)) {
+		return false, nil
+	}
+
+	return verifySignature(pubKey, sig, messageHash(message)), nil
+}
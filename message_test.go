@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyMessage(t *testing.T) {
+	w, other := NewWallet(), NewWallet()
+	address := string(w.GetAddress())
+	signature := SignMessage(*w, "I own this address")
+
+	tests := []struct {
+		name      string
+		address   string
+		message   string
+		signature string
+		valid     bool
+		wantErr   bool
+	}{
+		{"valid", address, "I own this address", signature, true, false},
+		{"tampered message", address, "I own this address!", signature, false, false},
+		{"other address", string(other.GetAddress()), "I own this address", signature, false, false},
+		{"other key's signature", address, "I own this address", SignMessage(*other, "I own this address"), false, false},
+		{"invalid address", address[:len(address)-1], "I own this address", signature, false, true},
+		{"not base64", address, "I own this address", "%%%", false, true},
+		{"too short", address, "I own this address", base64.StdEncoding.EncodeToString(make([]byte, 2*sigScalarLen)), false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := VerifyMessage(tt.address, tt.message, tt.signature)
+			if (err != nil) != tt.wantErr || valid != tt.valid {
+				t.Fatalf("VerifyMessage = %v, %v, want %v and error %v", valid, err, tt.valid, tt.wantErr)
+			}
+		})
+	}
+}
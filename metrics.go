@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// metricsPrefix namespaces the names of the node's metrics
+const metricsPrefix = "simplechain_"
+
+// metricsAddr is the HOST:PORT the metrics endpoint is served on; empty disables it
+// Set with startnode -metrics-addr
+var metricsAddr string
+
+// Counters of the node's work since it started, exported by the metrics endpoint
+var (
+	blocksMinedTotal     atomic.Int64 // Blocks mined by MineBlock
+	blocksConnectedTotal atomic.Int64 // Blocks from peers connected by AddBlock and AddBlocks
+	mempoolAcceptedTotal atomic.Int64 // Transactions added to the mempool
+	powHashesTotal       atomic.Int64 // Hashes tried by proof-of-work searches
+)
+
+// metric is one sample of the metrics endpoint
+type metric struct {
+	name  string
+	kind  string // Prometheus type: counter or gauge
+	help  string
+	value float64
+}
+
+// collectMetrics reads the counters and samples the state of the node
+func collectMetrics(bc *Blockchain) []metric {
+	hashRate := 0.0
+	if stats, ok := LastMiningStats(); ok {
+		hashRate = stats.HashRate()
+	}
+
+	dbSize := int64(0)
+	if path := bc.db.Path(); path != "" {
+		if info, err := os.Stat(path); err == nil {
+			dbSize = info.Size()
+		}
+	}
+
+	return []metric{
+		{"blocks_mined_total", "counter", "Blocks mined by this node", float64(blocksMinedTotal.Load())},
+		{"blocks_connected_total", "counter", "Blocks received from peers and connected", float64(blocksConnectedTotal.Load())},
+		{"mempool_accepted_total", "counter", "Transactions accepted into the mempool", float64(mempoolAcceptedTotal.Load())},
+		{"pow_hashes_total", "counter", "Hashes tried while mining", float64(powHashesTotal.Load())},
+		{"chain_height", "gauge", "Height of the best chain", float64(bc.GetBestHeight())},
+		{"mempool_size", "gauge", "Transactions in the mempool", float64(len(bc.GetMempool()))},
+		{"peers", "gauge", "Known peers", float64(len(getKnownNodes()))},
+		{"inbound_connections", "gauge", "Inbound connections being handled", float64(InboundConnections())},
+		{"hashrate", "gauge", "Hashes per second of the latest proof-of-work search", hashRate},
+		{"db_size_bytes", "gauge", "Size of the blockchain DB file", float64(dbSize)},
+	}
+}
+
+// writeMetrics writes the node's metrics in the Prometheus text exposition format
+func writeMetrics(w io.Writer, bc *Blockchain) error {
+	for _, m := range collectMetrics(bc) {
+		name := metricsPrefix + m.name
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, m.help, name, m.kind, name, m.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// metricsHandler serves the node's metrics on /metrics
+// Similar to Geth's --metrics.addr endpoint
+func metricsHandler(bc *Blockchain) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := writeMetrics(w, bc); err != nil {
+			logger.Debugf("Writing metrics: %s", err)
+		}
+	})
+
+	return mux
+}
+
+// serveMetrics serves the metrics endpoint on address until the process exits
+func serveMetrics(address string, bc *Blockchain) {
+	logger.Infof("Serving metrics on http://%s/metrics", address)
+	if err := http.ListenAndServe(address, metricsHandler(bc)); err != nil {
+		logger.Errorf("Serving metrics: %s", err)
+	}
+}
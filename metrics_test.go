@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics fetches url and returns the samples it lists by name, and the names with a TYPE line
+func scrapeMetrics(t *testing.T, url string) (map[string]float64, map[string]bool) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("scrape answered %s with %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	samples, typed := make(map[string]float64), make(map[string]bool)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 4 && fields[1] == "TYPE" {
+			typed[fields[2]] = true
+		}
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatalf("sample %q: %s", scanner.Text(), err)
+		}
+		samples[fields[0]] = value
+	}
+
+	return samples, typed
+}
+
+func TestScrapeMetrics(t *testing.T) {
+	useKnownNodes(t, "3000", "localhost:3000", []string{"a:1", "b:2"})
+	bc, w := newTestChain(t)
+	accepted := mempoolAcceptedTotal.Load()
+	if err := bc.AddToMempool(spendCoinbase(t, bc, w, string(w.GetAddress()), 1)); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(metricsHandler(bc))
+	defer server.Close()
+
+	samples, typed := scrapeMetrics(t, server.URL+"/metrics")
+	want := map[string]float64{
+		"chain_height":           1,
+		"mempool_size":           1,
+		"peers":                  2,
+		"mempool_accepted_total": float64(accepted + 1),
+	}
+	for name, value := range want {
+		if got, ok := samples[metricsPrefix+name]; !ok || got != value {
+			t.Errorf("%s%s = %g (listed %v), want %g", metricsPrefix, name, got, ok, value)
+		}
+	}
+	for _, name := range []string{"blocks_mined_total", "blocks_connected_total", "pow_hashes_total", "inbound_connections", "hashrate", "db_size_bytes"} {
+		if _, ok := samples[metricsPrefix+name]; !ok || !typed[metricsPrefix+name] {
+			t.Errorf("%s%s not listed with its type", metricsPrefix, name)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("scraping / answered %s, want not found", resp.Status)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBlockTxSize bounds the serialized size of the mempool transactions a mined block takes
+const maxBlockTxSize = 1000000
+
+// extraNonceLen is the length of the extranonce suffix of mined coinbase data: "/" and 16 hex digits
+const extraNonceLen = 17
+
+// maxCoinbaseTextLen is the longest text a miner can put in its coinbases, leaving room for the extranonce
+const maxCoinbaseTextLen = maxCoinbaseDataLen - extraNonceLen
+
+var (
+	extraNonceOnce sync.Once
+	extraNonce     atomic.Uint64
+)
+
+// nextExtraNonce returns a new extranonce for a mined coinbase
+// The counter starts at a random value so that separate runs and nodes don't repeat each other's
+// Similar to Bitcoin's IncrementExtraNonce()
+func nextExtraNonce() uint64 {
+	extraNonceOnce.Do(func() {
+		var seed [8]byte
+		if _, err := rand.Read(seed[:]); err == nil {
+			extraNonce.Store(binary.BigEndian.Uint64(seed[:]))
+		}
+	})
+
+	return extraNonce.Add(1)
+}
+
+// NewMinerCoinbaseTX creates the coinbase of a block mined by this node, paying to
+// Its data is text, or a default naming the recipient, followed by a new extranonce, so every coinbase is unique
+// and a block can be rebuilt with a different merkle root, giving the proof of work fresh hashes to try
+func NewMinerCoinbaseTX(to, text string, fees, height int) (*Transaction, error) {
+	if len(text) > maxCoinbaseTextLen {
+		return nil, fmt.Errorf("coinbase data has %d bytes, more than %d", len(text), maxCoinbaseTextLen)
+	}
+	if text == "" {
+		text = fmt.Sprintf("Reward to '%s'", to)
+		if len(text) > maxCoinbaseTextLen {
+			text = text[:maxCoinbaseTextLen]
+		}
+	}
+
+	return NewCoinbaseTX(to, fmt.Sprintf("%s/%016x", text, nextExtraNonce()), fees, height), nil
+}
+
+// selectMempoolTransactions picks the mempool transactions a new block can include, and their total fee
+// The candidates mempoolCandidates keeps are taken by selectPackages, highest package fee rate first,
+// until they would exceed maxBlockTxSize bytes
+// Returns why each left-out transaction was skipped
+func (bc *Blockchain) selectMempoolTransactions() ([]*Transaction, int, []error) {
+	candidates, fees, skipped := bc.mempoolCandidates()
+	txs, leftOut := selectPackages(candidates, fees, maxBlockTxSize)
+	for _, tx := range leftOut {
+		skipped = append(skipped, fmt.Errorf("Transaction %x in mempool doesn't fit in the block (%d bytes with its unmined ancestors)", tx.ID, tx.SerializedSize()))
+	}
+
+	total := 0
+	for _, tx := range txs {
+		total += fees[hex.EncodeToString(tx.ID)]
+	}
+
+	return txs, total, skipped
+}
+
+// mempoolCandidates returns the mempool transactions a new block could include, in canonical order, and their fees by hex ID
+// Parents are visited before the transactions spending their outputs,
+// and a transaction whose mempool parent is left out is left out too
+// Of several transactions spending the same output only the first is kept
+// Returns why each left-out transaction was skipped
+func (bc *Blockchain) mempoolCandidates() ([]*Transaction, map[string]int, []error) {
+	var txs []*Transaction
+	var skipped []error
+
+	mempool := bc.GetMempool()
+	fees := make(map[string]int)
+	spent := make(map[string]bool)
+	pooled := make(map[string]bool)
+	kept := make(map[string]bool)
+	for _, tx := range mempool {
+		pooled[hex.EncodeToString(tx.ID)] = true
+	}
+Mempool:
+	for _, tx := range orderTransactions(mempool) {
+		for _, vin := range tx.Vin {
+			parent := hex.EncodeToString(vin.Txid)
+			if pooled[parent] && !kept[parent] {
+				skipped = append(skipped, fmt.Errorf("Transaction %x in mempool depends on %s, which isn't mined", tx.ID, parent))
+				continue Mempool
+			}
+		}
+		if err := bc.VerifyTransaction(tx); err != nil {
+			skipped = append(skipped, fmt.Errorf("Invalid transaction %x found in mempool: %s", tx.ID, err))
+			continue
+		}
+		for _, vin := range tx.Vin {
+			if spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] {
+				skipped = append(skipped, fmt.Errorf("Transaction %x in mempool double-spends an input", tx.ID))
+				continue Mempool
+			}
+		}
+		fee, err := bc.TransactionFee(tx)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("Transaction %x in mempool: %s", tx.ID, err))
+			continue
+		}
+		for _, vin := range tx.Vin {
+			spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+		txs = append(txs, tx)
+		kept[hex.EncodeToString(tx.ID)] = true
+		fees[hex.EncodeToString(tx.ID)] = fee
+	}
+
+	return txs, fees, skipped
+}
+
+// runMiner mines a block paying address every interval until quit is closed
+// Each block takes the transactions selectMempoolTransactions picks, which then leave the mempool,
+// and is announced to the known peers, as a compact block to those that support it. No block is mined while the node is still syncing
+// Similar to Geth's --dev.period
+func runMiner(bc *Blockchain, address string, interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+
+		if !syncer.IsSynced() {
+			logger.Debugf("Skipping mining while syncing")
+			continue
+		}
+
+		txs, fees, skipped := bc.selectMempoolTransactions()
+		for _, err := range skipped {
+			logger.Warnf("%s", err)
+		}
+		cbTx, err := NewMinerCoinbaseTX(address, "", fees, bc.GetBestHeight()+1)
+		if err != nil {
+			logger.Errorf("Creating coinbase: %s", err)
+			continue
+		}
+		newBlock := bc.MineBlock(append([]*Transaction{cbTx}, txs...))
+
+		if err := bc.RemoveMinedFromMempool(txs); err != nil {
+			logger.Warnf("%s", err)
+		}
+		logger.Infof("Mined block %x with %d transaction(s)", newBlock.Hash, len(txs))
+
+		for _, node := range getKnownNodes() {
+			sendCompactBlock(node, newBlock)
+		}
+	}
+}
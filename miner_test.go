@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRunMinerKeepsInterval(t *testing.T) {
+	useSyncer(t)
+	useKnownNodes(t, "3000", "localhost:3000", nil)
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+	tx := spendCoinbase(t, bc, w, address, 1)
+	if err := bc.AddToMempool(tx); err != nil {
+		t.Fatal(err)
+	}
+	sub := eventBus.Subscribe(defaultEventBuffer)
+	defer sub.Unsubscribe()
+
+	// startMiner runs the miner until the returned function stops it
+	const interval = 100 * time.Millisecond
+	startMiner := func() func() {
+		quit, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			runMiner(bc, address, interval, quit)
+			close(done)
+		}()
+		return func() {
+			close(quit)
+			<-done
+		}
+	}
+
+	// Nothing is mined while syncing
+	syncer.state = stateDownloading
+	stop := startMiner()
+	time.Sleep(3 * interval)
+	stop()
+	if height := bc.GetBestHeight(); height != 1 {
+		t.Fatalf("height %d after mining while syncing, want 1", height)
+	}
+
+	syncer.state = stateSynced
+	stop = startMiner()
+	var mined []time.Time
+	for len(mined) < 4 {
+		select {
+		case ev := <-sub.C:
+			if ev.Kind == EventNewBlock {
+				mined = append(mined, time.Now())
+			}
+		case <-time.After(10 * interval):
+			t.Fatalf("%d block(s) mined in %s", len(mined), 10*interval)
+		}
+	}
+	stop()
+
+	for i := 1; i < len(mined); i++ {
+		if gap := mined[i].Sub(mined[i-1]); gap < interval/2 {
+			t.Fatalf("blocks %d and %d mined %s apart, want about %s", i-1, i, gap, interval)
+		}
+	}
+	if confirmations, err := bc.GetTransactionConfirmations(tx.ID); err != nil || confirmations < 1 || len(bc.GetMempool()) != 0 {
+		t.Fatalf("pooled transaction has %d confirmation(s), %v, with %d left in the mempool", confirmations, err, len(bc.GetMempool()))
+	}
+}
+
+func TestMinerCoinbasesAreDistinct(t *testing.T) {
+	w := NewWallet()
+	address := string(w.GetAddress())
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"default data", ""},
+		{"custom data", "pool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := NewMinerCoinbaseTX(address, tt.text, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := NewMinerCoinbaseTX(address, tt.text, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Equal(first.Vin[0].PubKey, second.Vin[0].PubKey) {
+				t.Errorf("coinbase data %q repeated", first.Vin[0].PubKey)
+			}
+			if bytes.Equal(first.ID, second.ID) {
+				t.Errorf("coinbases with different extranonces share ID %x", first.ID)
+			}
+			if tt.text != "" && !bytes.HasPrefix(first.Vin[0].PubKey, []byte(tt.text+"/")) {
+				t.Errorf("coinbase data %q doesn't start with %q", first.Vin[0].PubKey, tt.text)
+			}
+		})
+	}
+}
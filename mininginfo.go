@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// miningStatsKey is the meta key of the stats of the last block mined by this node
+const miningStatsKey = "mininginfo"
+
+// MiningStats counts the work of a proof-of-work search
+type MiningStats struct {
+	Hashes  int64         // Hashes tried so far
+	Elapsed time.Duration // Time spent searching
+	Nonce   int           // Nonce being tried, or the solution once found
+	Hash    []byte        // Hash of the block, once found
+}
+
+// HashRate returns the hashes tried per second
+func (s MiningStats) HashRate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(s.Hashes) / s.Elapsed.Seconds()
+}
+
+var (
+	miningStatsMu   sync.Mutex
+	lastMiningStats *MiningStats
+)
+
+// recordMiningStats keeps the stats of the latest proof-of-work search, in progress or finished
+func recordMiningStats(stats MiningStats) {
+	miningStatsMu.Lock()
+	defer miningStatsMu.Unlock()
+
+	lastMiningStats = &stats
+}
+
+// LastMiningStats returns the stats of the latest proof-of-work search of this process, if any
+func LastMiningStats() (MiningStats, bool) {
+	miningStatsMu.Lock()
+	defer miningStatsMu.Unlock()
+
+	if lastMiningStats == nil {
+		return MiningStats{}, false
+	}
+
+	return *lastMiningStats, true
+}
+
+// putMiningStats stores the stats of a mined block so later commands can report them
+func putMiningStats(tx StoreTx, stats MiningStats) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	enc := &codecWriter{}
+	enc.buf.WriteByte(codecVersion)
+	enc.writeInt(stats.Hashes)
+	enc.writeInt(int64(stats.Elapsed))
+	enc.writeInt(int64(stats.Nonce))
+	enc.writeBytes(stats.Hash)
+
+	return meta.Put([]byte(miningStatsKey), enc.buf.Bytes())
+}
+
+// StoredMiningStats returns the stats of the last block this node mined, or false if it mined none
+func (bc *Blockchain) StoredMiningStats() (MiningStats, bool, error) {
+	var stats MiningStats
+	found := false
+
+	err := bc.db.View(func(tx StoreTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		if meta == nil {
+			return nil
+		}
+		data := meta.Get([]byte(miningStatsKey))
+		if data == nil {
+			return nil
+		}
+		if len(data) == 0 || data[0] != codecVersion {
+			return fmt.Errorf("invalid mining stats data")
+		}
+
+		dec := &codecReader{data: data[1:]}
+		stats.Hashes = dec.readInt()
+		stats.Elapsed = time.Duration(dec.readInt())
+		stats.Nonce = int(dec.readInt())
+		stats.Hash = dec.readBytes()
+		if err := dec.finish(); err != nil {
+			return fmt.Errorf("invalid mining stats data: %s", err)
+		}
+		found = true
+
+		return nil
+	})
+
+	return stats, found, err
+}
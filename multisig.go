@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const multisigVersion = byte(0x04)
+
+// scriptHashVersion leads addresses paying to the hash of a multisig script
+const scriptHashVersion = byte(0x05)
+const maxMultisigKeys = 15
+
+// MultisigScript locks an output to M of the N listed public keys
+// Similar to Bitcoin's OP_CHECKMULTISIG output script
+type MultisigScript struct {
+	M       int      // Number of signatures required to spend
+	PubKeys [][]byte // Public keys allowed to sign (N = len(PubKeys))
+}
+
+// NewMultisigScript creates an M-of-N script from the given public keys
+func NewMultisigScript(m int, pubKeys [][]byte) (*MultisigScript, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxMultisigKeys {
+		return nil, fmt.Errorf("multisig needs between 1 and %d public keys", maxMultisigKeys)
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("multisig threshold must be between 1 and %d", len(pubKeys))
+	}
+
+	for i, pubKey := range pubKeys {
+		if len(pubKey) == 0 || len(pubKey) > 255 {
+			return nil, errors.New("multisig public key has invalid length")
+		}
+		for _, other := range pubKeys[:i] {
+			if bytes.Equal(pubKey, other) {
+				return nil, errors.New("multisig public keys must be distinct")
+			}
+		}
+	}
+
+	return &MultisigScript{m, pubKeys}, nil
+}
+
+// Serialize encodes the script as: M, N, then each key prefixed by its length
+func (s MultisigScript) Serialize() []byte {
+	var buff bytes.Buffer
+
+	buff.WriteByte(byte(s.M))
+	buff.WriteByte(byte(len(s.PubKeys)))
+	for _, pubKey := range s.PubKeys {
+		buff.WriteByte(byte(len(pubKey)))
+		buff.Write(pubKey)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeMultisigScript decodes a script produced by Serialize
+func DeserializeMultisigScript(data []byte) (*MultisigScript, error) {
+	if len(data) < 2 {
+		return nil, errors.New("multisig script is too short")
+	}
+
+	m := int(data[0])
+	n := int(data[1])
+	data = data[2:]
+
+	var pubKeys [][]byte
+	for i := 0; i < n; i++ {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, errors.New("multisig script is truncated")
+		}
+		keyLen := int(data[0])
+		pubKeys = append(pubKeys, data[1:1+keyLen])
+		data = data[1+keyLen:]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("multisig script has trailing data")
+	}
+
+	return NewMultisigScript(m, pubKeys)
+}
+
+// Hash returns the hash identifying the script, used like a pubkey hash for lookups
+func (s MultisigScript) Hash() []byte {
+	return HashPubKey(s.Serialize())
+}
+
+// Address returns the base58 address that pays to this script
+func (s MultisigScript) Address() string {
+	versionedPayload := append([]byte{activeNetwork.MultisigVersion}, s.Serialize()...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return string(Base58Encode(fullPayload))
+}
+
+// ScriptHashAddress returns the base58 address that pays to the hash of this script
+// Unlike Address it has the length of a pubkey hash address whatever the number of keys;
+// the script is only revealed, as the redeem script, by the inputs spending it
+// Similar to Bitcoin's P2SH addresses
+func (s MultisigScript) ScriptHashAddress() string {
+	return ScriptHashToAddress(s.Hash())
+}
+
+// ScriptHashToAddress returns the address on the active network paying to scriptHash
+func ScriptHashToAddress(scriptHash []byte) string {
+	versionedPayload := append([]byte{activeNetwork.ScriptHashVersion}, scriptHash...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return string(Base58Encode(fullPayload))
+}
+
+// KeyIndex returns the position of pubKey in the script, or -1 if it's not a signer
+func (s MultisigScript) KeyIndex(pubKey []byte) int {
+	for i, key := range s.PubKeys {
+		if bytes.Equal(key, pubKey) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// spentScript returns the multisig script an input spending out must satisfy: the output's own script,
+// or for a script hash output, the redeem script the input reveals in place of a public key
+// It returns false for single-key outputs, and for script hash ones whose input reveals no valid script
+func spentScript(out TXOutput, in TXInput) (*MultisigScript, bool) {
+	if out.IsMultisig() {
+		return &out.Multisig, true
+	}
+	if !out.ScriptHash {
+		return nil, false
+	}
+
+	script, err := DeserializeMultisigScript(in.PubKey)
+	if err != nil {
+		return nil, false
+	}
+
+	return script, true
+}
+
+// ParseMultisigAddress extracts the script from a multisig address
+func ParseMultisigAddress(address string) (*MultisigScript, error) {
+	payload := Base58Decode([]byte(address))
+	if len(payload) < 1+addressChecksumLen || payload[0] != activeNetwork.MultisigVersion {
+		return nil, errors.New("not a multisig address")
+	}
+
+	return DeserializeMultisigScript(payload[1 : len(payload)-addressChecksumLen])
+}
+
+// IsScriptHashAddress checks whether the address pays to the hash of a script
+func IsScriptHashAddress(address string) bool {
+	payload := Base58Decode([]byte(address))
+	return len(payload) > 0 && payload[0] == activeNetwork.ScriptHashVersion
+}
+
+// IsMultisigAddress checks whether the address pays to a multisig script
+func IsMultisigAddress(address string) bool {
+	payload := Base58Decode([]byte(address))
+	return len(payload) > 0 && payload[0] == activeNetwork.MultisigVersion
+}
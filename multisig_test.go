@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// multisigSpend returns an unsigned transaction spending a coinbase output locked to address, which pays to script,
+// and the previous transactions it spends. The input reveals the script, as both kinds of multisig output require
+func multisigSpend(t *testing.T, script *MultisigScript, address string) (*Transaction, map[string]Transaction) {
+	t.Helper()
+
+	prev := NewCoinbaseTX(address, "test", 0, 1)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): *prev}
+
+	tx := &Transaction{nil, []TXInput{{prev.ID, 0, nil, script.Serialize(), nil}}, []TXOutput{*NewTXOutput(prev.Vout[0].Value, string(NewWallet().GetAddress()))}, false, nil}
+	tx.ID = tx.Hash()
+
+	return tx, prevTXs
+}
+
+func TestVerifyMultisigSpend(t *testing.T) {
+	outsider := NewWallet()
+
+	tests := []struct {
+		name    string
+		m, n    int
+		signers []int // Indexes of the script's keys signing, -1 for a key outside the script
+		tamper  func(tx *Transaction)
+		valid   bool
+	}{
+		{"1-of-1", 1, 1, []int{0}, nil, true},
+		{"2-of-3, two signers", 2, 3, []int{0, 2}, nil, true},
+		{"2-of-3, all signers", 2, 3, []int{0, 1, 2}, nil, true},
+		{"2-of-3, one signer", 2, 3, []int{1}, nil, false},
+		{"3-of-3, two signers", 3, 3, []int{0, 1}, nil, false},
+		{"2-of-3, outsider as second signer", 2, 3, []int{0, -1}, nil, false},
+		{"2-of-3, unsigned", 2, 3, nil, func(tx *Transaction) { tx.Vin[0].Signatures = make([][]byte, 3) }, false},
+		{"2-of-3, signature copied to another key", 2, 3, []int{0}, func(tx *Transaction) {
+			tx.Vin[0].Signatures[1] = tx.Vin[0].Signatures[0]
+		}, false},
+		{"2-of-3, signatures swapped", 2, 3, []int{0, 1}, func(tx *Transaction) {
+			sigs := tx.Vin[0].Signatures
+			sigs[0], sigs[1] = sigs[1], sigs[0]
+		}, false},
+		{"2-of-3, extra signature slot", 2, 3, []int{0, 1}, func(tx *Transaction) {
+			tx.Vin[0].Signatures = append(tx.Vin[0].Signatures, tx.Vin[0].Signatures[0])
+		}, false},
+		{"2-of-3, signed output changed", 2, 3, []int{0, 1}, func(tx *Transaction) { tx.Vout[0].Value-- }, false},
+	}
+
+	for _, tt := range tests {
+		wallets := make([]*Wallet, tt.n)
+		pubKeys := make([][]byte, tt.n)
+		for i := range wallets {
+			wallets[i] = NewWallet()
+			pubKeys[i] = wallets[i].PublicKey
+		}
+		script, err := NewMultisigScript(tt.m, pubKeys)
+		if err != nil {
+			t.Fatalf("%s: NewMultisigScript: %s", tt.name, err)
+		}
+
+		for kind, address := range map[string]string{"bare": script.Address(), "script hash": script.ScriptHashAddress()} {
+			t.Run(tt.name+", "+kind, func(t *testing.T) {
+				tx, prevTXs := multisigSpend(t, script, address)
+				for _, signer := range tt.signers {
+					key := outsider
+					if signer >= 0 {
+						key = wallets[signer]
+					}
+					if err := tx.Sign(key.PrivateKey, prevTXs, defaultChainID); err != nil {
+						t.Fatalf("Sign: %s", err)
+					}
+				}
+				if tt.tamper != nil {
+					tt.tamper(tx)
+				}
+
+				err := tx.Verify(prevTXs, defaultChainID)
+				if tt.valid && err != nil {
+					t.Fatalf("Verify: %s", err)
+				}
+				if !tt.valid && !errors.Is(err, errBadSignature) {
+					t.Fatalf("Verify = %v, want %v", err, errBadSignature)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyScriptHashSpendChecksRedeemScript(t *testing.T) {
+	signer, other := NewWallet(), NewWallet()
+	script, err := NewMultisigScript(1, [][]byte{signer.PublicKey, other.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same keys with another threshold hash differently
+	stricter, err := NewMultisigScript(2, [][]byte{signer.PublicKey, other.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		reveal []byte
+	}{
+		{"other redeem script", stricter.Serialize()},
+		{"signer's public key", signer.PublicKey},
+		{"malformed script", script.Serialize()[:3]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, prevTXs := multisigSpend(t, script, script.ScriptHashAddress())
+			if err := tx.Sign(signer.PrivateKey, prevTXs, defaultChainID); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Verify(prevTXs, defaultChainID); err != nil {
+				t.Fatalf("Verify with the redeem script: %s", err)
+			}
+
+			tx.Vin[0].PubKey = tt.reveal
+			if err := tx.Verify(prevTXs, defaultChainID); !errors.Is(err, errBadSignature) {
+				t.Fatalf("Verify = %v, want %v", err, errBadSignature)
+			}
+		})
+	}
+}
+
+func TestScriptHashAddressIsDeterministic(t *testing.T) {
+	keys := [][]byte{NewWallet().PublicKey, NewWallet().PublicKey, NewWallet().PublicKey}
+	script, err := NewMultisigScript(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := script.ScriptHashAddress()
+
+	if !IsScriptHashAddress(address) || IsMultisigAddress(address) {
+		t.Fatalf("%s is not recognized as a script hash address", address)
+	}
+	payload := Base58Decode([]byte(address))
+	if len(payload) != len(Base58Decode(NewWallet().GetAddress())) {
+		t.Fatalf("script hash address decodes to %d bytes, want as many as a pubkey hash address", len(payload))
+	}
+	if got := payload[1 : len(payload)-addressChecksumLen]; !bytes.Equal(got, script.Hash()) {
+		t.Errorf("address pays to %x, want the script hash %x", got, script.Hash())
+	}
+
+	tests := []struct {
+		name     string
+		m        int
+		keys     [][]byte
+		wantSame bool
+	}{
+		{"same keys and order", 2, [][]byte{keys[0], keys[1], keys[2]}, true},
+		{"keys in another order", 2, [][]byte{keys[2], keys[0], keys[1]}, false},
+		{"another threshold", 3, keys, false},
+		{"a key fewer", 2, keys[:2], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other, err := NewMultisigScript(tt.m, tt.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := other.ScriptHashAddress(); (got == address) != tt.wantSame {
+				t.Fatalf("address %s, first script's %s; want them the same: %v", got, address, tt.wantSame)
+			}
+		})
+	}
+
+	// The redeem script stored in the wallet file gives back the same address
+	useDataDir(t)
+	wallets, _ := NewWallets("3000")
+	if stored := wallets.AddScript(script); stored != address {
+		t.Fatalf("wallet stored the script as %s, want %s", stored, address)
+	}
+	wallets.SaveToFile("3000")
+	reloaded, _ := NewWallets("3000")
+	redeem, ok := reloaded.GetScript(address)
+	if !ok || redeem.ScriptHashAddress() != address {
+		t.Fatalf("reloaded redeem script %v, %v; want one hashing to %s", redeem, ok, address)
+	}
+}
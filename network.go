@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Network holds the parameters that differ between networks, so test networks stay isolated from mainnet
+// Similar to Bitcoin's chainparams (CMainParams, CTestNetParams, CRegTestParams)
+type Network struct {
+	Name                string
+	FilePrefix          string   // Prepended to the names of the DB, wallet and peers files
+	AddressVersion      byte     // Leading byte of pubkey hash addresses
+	MultisigVersion     byte     // Leading byte of multisig addresses
+	ScriptHashVersion   byte     // Leading byte of script hash addresses (Bitcoin's P2SH)
+	TargetBits          int      // Default proof-of-work difficulty
+	HashAlgo            HashAlgo // Algorithm new blocks are hashed with; received blocks validate with their own
+	TargetBlockInterval int      // Average seconds between blocks that retargeting aims for (Bitcoin's nPowTargetSpacing)
+	RetargetInterval    int      // Blocks between difficulty retargets; 0 keeps every block at TargetBits
+	SeedNodes           []string // Peers dialed when no seeds are configured
+}
+
+// networks lists the selectable networks by name
+var networks = map[string]*Network{
+	"mainnet": {
+		Name:                "mainnet",
+		FilePrefix:          "",
+		AddressVersion:      version,
+		MultisigVersion:     multisigVersion,
+		ScriptHashVersion:   scriptHashVersion,
+		TargetBits:          defaultTargetBits,
+		HashAlgo:            hashSHA256,
+		TargetBlockInterval: 60,
+		RetargetInterval:    120,
+		SeedNodes:           []string{defaultSeedNode},
+	},
+	"testnet": {
+		Name:                "testnet",
+		FilePrefix:          "testnet_",
+		AddressVersion:      0x6f,
+		MultisigVersion:     0xc4,
+		ScriptHashVersion:   0xc5,
+		TargetBits:          12,
+		HashAlgo:            hashDoubleSHA256,
+		TargetBlockInterval: 30,
+		RetargetInterval:    60,
+		SeedNodes:           []string{"localhost:13000"},
+	},
+	"regtest": {
+		Name:                "regtest",
+		FilePrefix:          "regtest_",
+		AddressVersion:      0x3c,
+		MultisigVersion:     0x7a,
+		ScriptHashVersion:   0x7b,
+		TargetBits:          1, // Blocks are mined instantly
+		HashAlgo:            hashSHA256,
+		TargetBlockInterval: 1,
+		RetargetInterval:    0, // As on Bitcoin's regtest, the difficulty never moves
+		SeedNodes:           []string{"localhost:23000"},
+	},
+}
+
+// activeNetwork is the network this process runs on; set with -network or the NETWORK env var
+var activeNetwork = networks["mainnet"]
+
+// SelectNetwork makes the named network active and applies its default difficulty and hash algorithm
+func SelectNetwork(name string) error {
+	network, ok := networks[name]
+	if !ok {
+		return fmt.Errorf("unknown network %q (expected %s)", name, strings.Join(networkNames(), ", "))
+	}
+	hasher, err := hasherFor(network.HashAlgo)
+	if err != nil {
+		return err
+	}
+
+	activeNetwork = network
+	defaultHasher = hasher
+	return SetTargetBits(network.TargetBits)
+}
+
+// loadNetworkFromFlag selects the network given with -network, falling back to the NETWORK env var
+func loadNetworkFromFlag(flagValue string) {
+	name := flagValue
+	if name == "" {
+		name = os.Getenv("NETWORK")
+	}
+	if name == "" {
+		return
+	}
+
+	err := SelectNetwork(name)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// networkForVersion returns the network using version for its addresses, or nil if none does
+func networkForVersion(version byte) *Network {
+	for _, name := range networkNames() {
+		network := networks[name]
+		if version == network.AddressVersion || version == network.MultisigVersion || version == network.ScriptHashVersion {
+			return network
+		}
+	}
+
+	return nil
+}
+
+// networkNames returns the names of the selectable networks, sorted
+func networkNames() []string {
+	var names []string
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// useSelectedNetwork selects the named network for the test, restoring the network, hasher and target bits after it
+func useSelectedNetwork(t *testing.T, name string) {
+	t.Helper()
+
+	network, hasher, bits := activeNetwork, defaultHasher, targetBits
+	t.Cleanup(func() {
+		activeNetwork, defaultHasher = network, hasher
+		SetTargetBits(bits)
+	})
+	if err := SelectNetwork(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddressesStayOnTheirNetwork(t *testing.T) {
+	w := NewWallet()
+	addresses := make(map[string]string)
+	dbFiles := make(map[string]string)
+	for _, name := range networkNames() {
+		useSelectedNetwork(t, name)
+		addresses[name] = string(w.GetAddress())
+		if err := CheckAddress(addresses[name]); err != nil {
+			t.Fatalf("%s address invalid on %s: %s", name, name, err)
+		}
+		if targetBits != networks[name].TargetBits {
+			t.Fatalf("target bits %d on %s, want %d", targetBits, name, networks[name].TargetBits)
+		}
+		if other, ok := dbFiles[dataFileName(dbFile)]; ok {
+			t.Fatalf("%s and %s share the DB file %s", name, other, dataFileName(dbFile))
+		}
+		dbFiles[dataFileName(dbFile)] = name
+	}
+
+	for _, active := range networkNames() {
+		useSelectedNetwork(t, active)
+		for name, address := range addresses {
+			err := CheckAddress(address)
+			if name == active {
+				if err != nil {
+					t.Errorf("%s address invalid on its own network: %s", name, err)
+				}
+				continue
+			}
+			if want := "address is for " + name + ", not " + active; err == nil || err.Error() != want {
+				t.Errorf("%s address on %s: %v, want %q", name, active, err, want)
+			}
+		}
+	}
+
+	if err := SelectNetwork("simnet"); err == nil || !strings.Contains(err.Error(), "unknown network") {
+		t.Fatalf("SelectNetwork(simnet) = %v, want an unknown network error", err)
+	}
+}
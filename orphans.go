@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// maxOrphanBlocks caps how many blocks with unknown parents are held in memory
+const maxOrphanBlocks = 100
+
+// errOrphanBlock is returned by AddBlock for a block held until its parent arrives
+var errOrphanBlock = errors.New("parent block is unknown, holding block as orphan")
+
+// OrphanPool holds blocks received before their parent, keyed by the parent they wait for
+// When full, the oldest orphan is evicted
+// Similar to Bitcoin Core's mapOrphanBlocks
+type OrphanPool struct {
+	mu       sync.Mutex
+	blocks   map[string]*Block   // Orphans by hash
+	byParent map[string][]string // Orphan hashes by the hash of their missing parent
+	order    []string            // Orphan hashes, oldest first
+}
+
+// orphans is the node's orphan block pool
+var orphans = NewOrphanPool()
+
+// NewOrphanPool creates an empty OrphanPool
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{
+		blocks:   make(map[string]*Block),
+		byParent: make(map[string][]string),
+	}
+}
+
+// Add holds block until its parent arrives, evicting the oldest orphan if the pool is full
+func (op *OrphanPool) Add(block *Block) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	hash := hex.EncodeToString(block.Hash)
+	if _, ok := op.blocks[hash]; ok {
+		return
+	}
+
+	if len(op.order) >= maxOrphanBlocks {
+		op.remove(op.order[0])
+	}
+
+	parent := hex.EncodeToString(block.PrevBlockHash)
+	op.blocks[hash] = block
+	op.byParent[parent] = append(op.byParent[parent], hash)
+	op.order = append(op.order, hash)
+}
+
+// Has reports whether the block is held as an orphan
+func (op *OrphanPool) Has(blockHash []byte) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	_, ok := op.blocks[hex.EncodeToString(blockHash)]
+	return ok
+}
+
+// Len returns the number of orphans held
+func (op *OrphanPool) Len() int {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	return len(op.order)
+}
+
+// TakeChildren removes and returns the orphans waiting for parentHash
+func (op *OrphanPool) TakeChildren(parentHash []byte) []*Block {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	var children []*Block
+	for _, hash := range op.byParent[hex.EncodeToString(parentHash)] {
+		children = append(children, op.blocks[hash])
+	}
+	for _, child := range children {
+		op.remove(hex.EncodeToString(child.Hash))
+	}
+
+	return children
+}
+
+// remove drops an orphan from every index; the caller holds mu
+func (op *OrphanPool) remove(hash string) {
+	block, ok := op.blocks[hash]
+	if !ok {
+		return
+	}
+	delete(op.blocks, hash)
+
+	parent := hex.EncodeToString(block.PrevBlockHash)
+	var siblings []string
+	for _, h := range op.byParent[parent] {
+		if h != hash {
+			siblings = append(siblings, h)
+		}
+	}
+	if len(siblings) == 0 {
+		delete(op.byParent, parent)
+	} else {
+		op.byParent[parent] = siblings
+	}
+
+	for i, h := range op.order {
+		if h == hash {
+			op.order = append(op.order[:i], op.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// LoadPayments reads and validates a sendmany payments file
+// The file is JSON mapping recipient addresses to amounts, e.g. {"ADDRESS": 10, "ADDRESS2": 5}
+func LoadPayments(path string) (map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var payments map[string]int
+	err = json.Unmarshal(data, &payments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	_, err = PaymentsTotal(payments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return payments, nil
+}
+
+// PaymentsTotal checks that payments pays positive amounts to well-formed addresses, and returns their sum
+func PaymentsTotal(payments map[string]int) (int, error) {
+	if len(payments) == 0 {
+		return 0, errors.New("no recipients")
+	}
+
+	total := 0
+	for _, address := range paymentAddresses(payments) {
+		if err := CheckAddress(address); err != nil {
+			return 0, fmt.Errorf("recipient address %q is not valid: %s", address, err)
+		}
+		amount := payments[address]
+		if amount <= 0 {
+			return 0, fmt.Errorf("amount for %s must be positive, got %d", address, amount)
+		}
+
+		var err error
+		total, err = addValue(total, amount)
+		if err != nil {
+			return 0, fmt.Errorf("total amount: %s", err)
+		}
+	}
+
+	return total, nil
+}
+
+// paymentAddresses returns the recipients of payments, sorted so outputs come in a stable order
+func paymentAddresses(payments map[string]int) []string {
+	var addresses []string
+	for address := range payments {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
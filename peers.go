@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const peersFile = "peers_%s.dat"
+const defaultSeedNode = "localhost:3000"
+
+// maxPeerFailures is how many consecutive failed sends make a peer count as dead
+const maxPeerFailures = 3
+
+// pingInterval is how often every known peer is pinged to detect dead ones
+const pingInterval = 30 * time.Second
+
+// knownNodesMu guards knownNodes, peerFailures and peerInfos, which are shared by all connection handlers
+var knownNodesMu sync.Mutex
+
+// peerFailures counts consecutive failed sends per peer
+var peerFailures = make(map[string]int)
+
+// peerInfos holds the metadata of each known peer, keyed by address
+var peerInfos = make(map[string]*PeerInfo)
+
+// PeerInfo is what this node knows about a peer
+type PeerInfo struct {
+	Address    string
+	LastSeen   int64 // Unix time of the last message received from the peer (0 if never)
+	BestHeight int   // Height the peer reported in its last version message
+	Version    int   // Protocol version negotiated in the last version exchange (0 if none yet)
+}
+
+// peersPath is the file known peers are persisted to (empty disables persistence)
+var peersPath string
+
+// ParseSeedNodes splits a comma-separated list of HOST:PORT entries
+// Blank entries and duplicates are dropped, order is preserved
+func ParseSeedNodes(list string) []string {
+	var seeds []string
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || containsNode(seeds, entry) {
+			continue
+		}
+		seeds = append(seeds, entry)
+	}
+
+	return seeds
+}
+
+// ResolveSeedNodes picks the seed list from the -seeds flag, then SEED_NODES, then the network's default
+func ResolveSeedNodes(flagValue string) []string {
+	if seeds := ParseSeedNodes(flagValue); len(seeds) > 0 {
+		return seeds
+	}
+	if seeds := ParseSeedNodes(os.Getenv("SEED_NODES")); len(seeds) > 0 {
+		return seeds
+	}
+
+	return activeNetwork.SeedNodes
+}
+
+// initKnownNodes loads the persisted peers of nodeID and merges in the seeds
+func initKnownNodes(nodeID string, seeds []string) {
+	peersPath = dataFilePath(peersFile, nodeID)
+	persisted := LoadPeerInfos(peersPath)
+
+	knownNodesMu.Lock()
+	knownNodes = nil
+	peerInfos = make(map[string]*PeerInfo)
+	for i := range persisted {
+		peerInfos[persisted[i].Address] = &persisted[i]
+	}
+	knownNodesMu.Unlock()
+
+	for _, info := range persisted {
+		addKnownNode(info.Address)
+	}
+	for _, node := range seeds {
+		addKnownNode(node)
+	}
+}
+
+// addKnownNode adds addr to the known peers unless it's already known or is this node
+// Returns true if the peer was new
+func addKnownNode(addr string) bool {
+	knownNodesMu.Lock()
+	if addr == "" || addr == nodeAddress || containsNode(knownNodes, addr) {
+		knownNodesMu.Unlock()
+		return false
+	}
+	knownNodes = append(knownNodes, addr)
+	if peerInfos[addr] == nil {
+		peerInfos[addr] = &PeerInfo{Address: addr}
+	}
+	knownNodesMu.Unlock()
+
+	saveKnownNodes()
+	return true
+}
+
+// removeKnownNode drops addr from the known peers
+func removeKnownNode(addr string) {
+	knownNodesMu.Lock()
+	var updatedNodes []string
+	for _, node := range knownNodes {
+		if node != addr {
+			updatedNodes = append(updatedNodes, node)
+		}
+	}
+	knownNodes = updatedNodes
+	delete(peerFailures, addr)
+	delete(peerInfos, addr)
+	knownNodesMu.Unlock()
+
+	saveKnownNodes()
+}
+
+// recordPeerFailure notes a failed send to addr, pruning it after maxPeerFailures in a row
+// Returns true if the peer was removed
+func recordPeerFailure(addr string) bool {
+	knownNodesMu.Lock()
+	peerFailures[addr]++
+	failures := peerFailures[addr]
+	knownNodesMu.Unlock()
+
+	logger.Warnf("%s is not available (%d/%d consecutive failures)", addr, failures, maxPeerFailures)
+	if failures < maxPeerFailures {
+		return false
+	}
+
+	logger.Infof("Removing unreachable peer %s", addr)
+	removeKnownNode(addr)
+	return true
+}
+
+// recordPeerSuccess resets the failure count of addr after it was reached
+func recordPeerSuccess(addr string) {
+	knownNodesMu.Lock()
+	delete(peerFailures, addr)
+	knownNodesMu.Unlock()
+}
+
+// LivePeerCount returns the number of known peers whose last contact succeeded
+func LivePeerCount() int {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	live := 0
+	for _, node := range knownNodes {
+		if peerFailures[node] == 0 {
+			live++
+		}
+	}
+
+	return live
+}
+
+// pingPeers pings every known peer on each tick so dead ones get pruned even when idle
+func pingPeers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, node := range getKnownNodes() {
+			sendPing(node)
+		}
+		logger.Debugf("Live peers: %d of %d known", LivePeerCount(), len(getKnownNodes()))
+		saveKnownNodes()
+		syncer.CheckStalled()
+	}
+}
+
+// getKnownNodes returns a snapshot of the known peers
+func getKnownNodes() []string {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	return append([]string(nil), knownNodes...)
+}
+
+// touchPeer records that a message was just received from a known peer
+func touchPeer(addr string) {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	if info := peerInfos[addr]; info != nil {
+		info.LastSeen = time.Now().Unix()
+	}
+}
+
+// recordPeerVersion stores the best height a peer reported in its version message and the protocol version negotiated
+func recordPeerVersion(addr string, height, version int) {
+	knownNodesMu.Lock()
+	if info := peerInfos[addr]; info != nil {
+		info.LastSeen = time.Now().Unix()
+		info.BestHeight = height
+		info.Version = version
+	}
+	knownNodesMu.Unlock()
+
+	saveKnownNodes()
+}
+
+// peerVersion returns the protocol version negotiated with a peer, or 0 if none was yet
+func peerVersion(addr string) int {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	if info := peerInfos[addr]; info != nil {
+		return info.Version
+	}
+
+	return 0
+}
+
+// GetPeerInfos returns a snapshot of the metadata of all known peers
+func GetPeerInfos() []PeerInfo {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	var infos []PeerInfo
+	for _, node := range knownNodes {
+		if info := peerInfos[node]; info != nil {
+			infos = append(infos, *info)
+		}
+	}
+
+	return infos
+}
+
+// LoadPeerInfos reads the peers persisted at path, if the file exists
+func LoadPeerInfos(path string) []PeerInfo {
+	var infos []PeerInfo
+
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&infos)
+	if err != nil {
+		// Older peer files hold only the addresses
+		var nodes []string
+		decoder = gob.NewDecoder(bytes.NewReader(fileContent))
+		if decoder.Decode(&nodes) != nil {
+			logger.Warnf("Ignoring unreadable peers file %s: %s", path, err)
+			return nil
+		}
+		for _, node := range nodes {
+			infos = append(infos, PeerInfo{Address: node})
+		}
+	}
+
+	return infos
+}
+
+// saveKnownNodes persists the known peers and their metadata so they survive a restart
+func saveKnownNodes() {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	if peersPath == "" {
+		return
+	}
+
+	var infos []PeerInfo
+	for _, node := range knownNodes {
+		if info := peerInfos[node]; info != nil {
+			infos = append(infos, *info)
+		}
+	}
+
+	err := writeFileAtomic(peersPath, gobEncode(infos), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// containsNode checks whether addr is in nodes
+func containsNode(nodes []string, addr string) bool {
+	for _, node := range nodes {
+		if node == addr {
+			return true
+		}
+	}
+
+	return false
+}
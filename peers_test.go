@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useKnownNodes gives the test its own known peers, persisted to a temporary data dir, as the node nodeID at addr
+func useKnownNodes(t *testing.T, nodeID, addr string, seeds []string) {
+	t.Helper()
+
+	useDataDir(t)
+	savedNodes, savedInfos, savedFailures := knownNodes, peerInfos, peerFailures
+	savedPath, savedAddress := peersPath, nodeAddress
+	t.Cleanup(func() {
+		knownNodes, peerInfos, peerFailures = savedNodes, savedInfos, savedFailures
+		peersPath, nodeAddress = savedPath, savedAddress
+	})
+
+	nodeAddress = addr
+	peerFailures = make(map[string]int)
+	initKnownNodes(nodeID, seeds)
+}
+
+func TestParseSeedNodes(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"localhost:3000", []string{"localhost:3000"}},
+		{"a:1, b:2 ,a:1", []string{"a:1", "b:2"}},
+		{" , a:1,,", []string{"a:1"}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseSeedNodes(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSeedNodes(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestInitKnownNodesMergesSeeds(t *testing.T) {
+	useKnownNodes(t, "3000", "localhost:3000", []string{"a:1", "b:2"})
+	addKnownNode("c:3")
+
+	// A restart merges the persisted peers with seeds, some already known and one being the node itself
+	initKnownNodes("3000", ParseSeedNodes("b:2,localhost:3000,d:4,a:1"))
+
+	want := []string{"a:1", "b:2", "c:3", "d:4"}
+	if got := getKnownNodes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("known peers %q, want %q", got, want)
+	}
+	if persisted := LoadPeerInfos(peersPath); len(persisted) != len(want) {
+		t.Fatalf("%d peer(s) persisted, want %d", len(persisted), len(want))
+	}
+}
+
+func TestUnresponsivePeerIsPruned(t *testing.T) {
+	listener, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	peer := listener.Addr().String()
+	useKnownNodes(t, "3000", "localhost:3000", []string{peer, "127.0.0.1:1"})
+
+	sendPing(peer)
+	sendPing("127.0.0.1:1")
+	if LivePeerCount() != 1 {
+		t.Fatalf("%d live peer(s), want only the listening one", LivePeerCount())
+	}
+
+	// The peer stops responding; it's kept until it misses maxPeerFailures pings in a row
+	listener.Close()
+	for i := 1; i < maxPeerFailures; i++ {
+		sendPing(peer)
+	}
+	if !containsNode(getKnownNodes(), peer) {
+		t.Fatalf("peer pruned after %d failure(s)", maxPeerFailures-1)
+	}
+	sendPing(peer)
+	if containsNode(getKnownNodes(), peer) {
+		t.Fatalf("peer kept after %d failures", maxPeerFailures)
+	}
+	if persisted := LoadPeerInfos(peersPath); len(persisted) != 1 || persisted[0].Address != "127.0.0.1:1" {
+		t.Fatalf("persisted peers %+v, want only the other peer", persisted)
+	}
+}
+
+func TestPeerSuccessResetsFailures(t *testing.T) {
+	useKnownNodes(t, "3000", "localhost:3000", []string{"a:1"})
+
+	for i := 0; i < 2*maxPeerFailures; i++ {
+		if recordPeerFailure("a:1") {
+			t.Fatalf("peer pruned after failure %d, each following a success", i+1)
+		}
+		recordPeerSuccess("a:1")
+	}
+	if LivePeerCount() != 1 {
+		t.Fatal("peer not live after a successful send")
+	}
+}
+
+func TestPeerInfoListing(t *testing.T) {
+	useKnownNodes(t, "3000", "localhost:3000", []string{"a:1", "b:2"})
+	recordPeerVersion("a:1", 12, nodeVersion)
+	touchPeer("b:2")
+
+	infos := GetPeerInfos()
+	if len(infos) != 2 {
+		t.Fatalf("%d peer(s) listed, want 2", len(infos))
+	}
+	if a := infos[0]; a.Address != "a:1" || a.BestHeight != 12 || a.Version != nodeVersion || a.LastSeen == 0 {
+		t.Errorf("first peer %+v, want a:1 at height 12 seen just now", a)
+	}
+	if b := infos[1]; b.Address != "b:2" || b.BestHeight != 0 || b.Version != 0 || b.LastSeen == 0 {
+		t.Errorf("second peer %+v, want b:2 seen just now without a version", b)
+	}
+
+	out := captureOutput(t, func() { (&CLI{}).listPeers("3000") })
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || lines[0] != "2 known peer(s)" {
+		t.Fatalf("peers printed:\n%s", out)
+	}
+	if !strings.Contains(lines[1], "a:1") || !strings.Contains(lines[1], "height: 12") || strings.Contains(lines[1], "never") {
+		t.Errorf("first peer printed as %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "b:2") || !strings.Contains(lines[2], "protocol: unknown") {
+		t.Errorf("second peer printed as %q", lines[2])
+	}
+}
+
+func TestAddAndDisconnectNode(t *testing.T) {
+	listener, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	peer := listener.Addr().String()
+	useKnownNodes(t, "3000", "localhost:3000", nil)
+	bc, _ := newTestChain(t)
+
+	// nodeCommand builds the request the addnode and disconnectnode commands send
+	nodeCommand := func(command, address string) []byte {
+		return append(commandToBytes(command), gobEncode(addnode{address})...)
+	}
+
+	handleAddNode(nodeCommand("addnode", peer), bc)
+	if !containsNode(getKnownNodes(), peer) {
+		t.Fatalf("known peers %q after addnode, want %s", getKnownNodes(), peer)
+	}
+	if persisted := LoadPeerInfos(peersPath); len(persisted) != 1 || persisted[0].Address != peer {
+		t.Fatalf("persisted peers %+v, want %s", persisted, peer)
+	}
+
+	handleAddNode(nodeCommand("addnode", nodeAddress), bc)
+	if containsNode(getKnownNodes(), nodeAddress) {
+		t.Fatal("addnode added the node itself")
+	}
+
+	handleAddNode(nodeCommand("removenode", peer), bc)
+	if containsNode(getKnownNodes(), peer) {
+		t.Fatalf("known peers %q after disconnectnode, want %s dropped", getKnownNodes(), peer)
+	}
+	if persisted := LoadPeerInfos(peersPath); len(persisted) != 0 {
+		t.Fatalf("persisted peers %+v, want none", persisted)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3000}, true},
+		{&net.TCPAddr{IP: net.IPv6loopback, Port: 3000}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 3000}, false},
+		{&net.UnixAddr{Name: "/tmp/node.sock", Net: "unix"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := isLoopback(tt.addr); got != tt.want {
+			t.Errorf("isLoopback(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// minRelayFee is the lowest fee a transaction must pay to be accepted into the mempool
+// Override with the MIN_RELAY_FEE env var
+var minRelayFee = 1
+
+// dustThreshold is the smallest output value accepted into the mempool
+// Override with the DUST_THRESHOLD env var
+var dustThreshold = 1
+
+// loadPolicyFromEnv applies the relay policy overrides from the environment
+func loadPolicyFromEnv() {
+	if value := os.Getenv("MIN_RELAY_FEE"); value != "" {
+		fee, err := strconv.Atoi(value)
+		if err != nil || fee < 0 {
+			logger.Warnf("Ignoring invalid MIN_RELAY_FEE %q", value)
+		} else {
+			minRelayFee = fee
+		}
+	}
+
+	if value := os.Getenv("DUST_THRESHOLD"); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 0 {
+			logger.Warnf("Ignoring invalid DUST_THRESHOLD %q", value)
+		} else {
+			dustThreshold = threshold
+		}
+	}
+}
+
+// TransactionFee returns the fee paid by tx: the value of its inputs minus its outputs
+// Fails if the outputs are invalid or spend more than the inputs provide
+func (bc *Blockchain) TransactionFee(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		prevTx, err := bc.findPrevTransaction(vin.Txid)
+		if err != nil {
+			return 0, fmt.Errorf("input %s:%d: %s", hex.EncodeToString(vin.Txid), vin.Vout, err)
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return 0, fmt.Errorf("input %s:%d references a missing output", hex.EncodeToString(vin.Txid), vin.Vout)
+		}
+		inputValue, err = addValue(inputValue, prevTx.Vout[vin.Vout].Value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if inputValue < outputValue {
+		return 0, fmt.Errorf("outputs (%d) exceed inputs (%d)", outputValue, inputValue)
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// checkRelayPolicy rejects transactions that are too cheap, create dust outputs
+// or carry more than one data output
+func (bc *Blockchain) checkRelayPolicy(tx *Transaction) error {
+	dataOutputs := 0
+	for i, vout := range tx.Vout {
+		if vout.IsData() {
+			dataOutputs++
+			if dataOutputs > 1 {
+				return fmt.Errorf("output %d is a second data output", i)
+			}
+			continue
+		}
+		if vout.Value < dustThreshold {
+			return fmt.Errorf("output %d value %d is below the dust threshold %d", i, vout.Value, dustThreshold)
+		}
+	}
+
+	fee, err := bc.TransactionFee(tx)
+	if err != nil {
+		return err
+	}
+	if fee < minRelayFee {
+		return fmt.Errorf("transaction fee %d is below the minimum relay fee %d", fee, minRelayFee)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// withRelayPolicy sets the relay fee floor and dust threshold for the test
+func withRelayPolicy(t *testing.T, fee, dust int) {
+	t.Helper()
+
+	savedFee, savedDust := minRelayFee, dustThreshold
+	minRelayFee, dustThreshold = fee, dust
+	t.Cleanup(func() { minRelayFee, dustThreshold = savedFee, savedDust })
+}
+
+func TestCheckMempoolAcceptPolicy(t *testing.T) {
+	withRelayPolicy(t, 3, 2)
+
+	tests := []struct {
+		name    string
+		outputs []int // Values paid from the genesis coinbase, which holds subsidy
+		wantErr string
+	}{
+		{"below the fee floor", []int{subsidy - 2}, "below the minimum relay fee"},
+		{"at the fee floor", []int{subsidy - 3}, ""},
+		{"above the fee floor", []int{subsidy - 5}, ""},
+		{"dust output", []int{subsidy - 4, 1}, "below the dust threshold"},
+		{"output at the dust threshold", []int{subsidy - 5, 2}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			genesis, err := bc.GetBlock(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			coinbase := genesis.Transactions[0]
+
+			tx := &Transaction{nil, []TXInput{{coinbase.ID, 0, nil, w.PublicKey, nil}}, nil, false, nil}
+			for _, value := range tt.outputs {
+				tx.Vout = append(tx.Vout, *NewTXOutput(value, string(NewWallet().GetAddress())))
+			}
+			tx.ID = tx.Hash()
+			if err := bc.SignTransaction(tx, w.PrivateKey); err != nil {
+				t.Fatal(err)
+			}
+			tx.ID = tx.Hash()
+
+			_, err = bc.CheckMempoolAccept(tx)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("CheckMempoolAccept: %s", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("CheckMempoolAccept = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if err := bc.AddToMempool(tx); (err == nil) != (tt.wantErr == "") {
+				t.Fatalf("AddToMempool = %v, want it to match CheckMempoolAccept", err)
+			}
+		})
+	}
+}
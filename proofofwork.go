@@ -1,40 +1,102 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
+	"log"
 	"math"
 	"math/big"
+	"os"
+	"strconv"
+	"time"
 )
 
-// Difficulty target bits (similar to Bitcoin/Ethereum difficulty)
-// Lower value = harder difficulty
+// defaultTargetBits is the production (mainnet) difficulty (similar to Bitcoin/Ethereum difficulty)
+// Higher value = harder difficulty
 // In Geth, this is called "difficulty" and is dynamically adjusted
-const targetBits = 16
+const defaultTargetBits = 16
+
+// targetBits is the difficulty of the genesis block, of blocks from before blocks recorded their bits,
+// and of every block on networks that don't retarget (see nextTargetBitsInTx). Test networks lower it; override it with SetTargetBits or the POW_TARGET_BITS env var, e.g. 4 for instant mining in tests;
+// every node of a network must use the same value
+var targetBits = defaultTargetBits
+
+// SetTargetBits changes the starting difficulty of blocks mined and validated from now on
+func SetTargetBits(bits int) error {
+	if bits < 1 || bits > 255 {
+		return fmt.Errorf("target bits %d out of range 1-255", bits)
+	}
+	targetBits = bits
+
+	return nil
+}
+
+// loadTargetBitsFromEnv applies the POW_TARGET_BITS env var
+func loadTargetBitsFromEnv() {
+	value := os.Getenv("POW_TARGET_BITS")
+	if value == "" {
+		return
+	}
+
+	bits, err := strconv.Atoi(value)
+	if err == nil {
+		err = SetTargetBits(bits)
+	}
+	if err != nil {
+		logger.Warnf("Ignoring invalid POW_TARGET_BITS %q", value)
+		return
+	}
+	if bits != activeNetwork.TargetBits {
+		logger.Warnf("Using proof-of-work target of %d bits instead of %d", bits, activeNetwork.TargetBits)
+	}
+}
 
 // maxNonce is the maximum value for nonce to prevent infinite loops
 const maxNonce = math.MaxInt64
 
+// progressInterval is how many nonces are tried between progress reports
+const progressInterval = 100000
+
+// ProgressFunc is called by Run every progressInterval nonces and once more with the solution
+type ProgressFunc func(nonce int, hash []byte)
+
 // ProofOfWork represents the proof-of-work consensus mechanism
 // In Geth, this is part of the consensus.Engine interface
 type ProofOfWork struct {
-	block  *Block   // The block we're mining
-	target *big.Int // The target threshold (difficulty)
+	block    *Block       // The block we're mining
+	target   *big.Int     // The target threshold (difficulty)
+	Progress ProgressFunc // Reports mining progress; nil mines quietly
+	Stats    MiningStats  // Hashes tried and time spent by Run
 }
 
 // NewProofOfWork creates a new ProofOfWork instance
 // Similar to Geth's ethash.New() or clique.New()
 func NewProofOfWork(b *Block) *ProofOfWork {
-	// Create the target: 1 << (256 - targetBits)
+	// Create the target: 1 << (256 - bits), at the block's own target bits
 	// This creates a number with leading zeros
-	// Example: targetBits=16 means hash must start with 16 zero bits
+	// Example: 16 target bits means the hash must start with 16 zero bits
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target.Lsh(target, uint(256-b.TargetBits()))
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{b, target, defaultProgress(), MiningStats{}}
 	return pow
 }
 
+// defaultProgress logs mining progress at DEBUG level when stdout is a terminal
+// Piped or library use stays quiet
+func defaultProgress() ProgressFunc {
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	return logProgress
+}
+
+// logProgress is a ProgressFunc writing to the node's logger
+func logProgress(nonce int, hash []byte) {
+	logger.Debugf("Mining: nonce %d, hash %x", nonce, hash)
+}
+
 // prepareData prepares the data to be hashed
 // In Geth, this is similar to how block headers are serialized for hashing
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
@@ -45,42 +107,67 @@ func (pow *ProofOfWork) prepareData(nonce int) []byte {
 // Run performs the proof-of-work mining
 // This is the core mining loop - similar to Geth's ethash.Seal() method
 // Returns: nonce (the solution) and hash (the resulting block hash)
+// Stats counts the work as it goes, and is published for getmininginfo every progressInterval nonces
 func (pow *ProofOfWork) Run() (int, []byte) {
 	var hashInt big.Int
-	var hash [32]byte
+	var hash []byte
 	nonce := 0
 
-	fmt.Printf("Mining block with %d transaction(s)\n", len(pow.block.Transactions))
+	hasher, err := hasherFor(pow.block.HashAlgo)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if pow.Progress != nil {
+		logger.Debugf("Mining block with %d transaction(s)", len(pow.block.Transactions))
+	}
+	start := time.Now()
+	pow.Stats = MiningStats{}
+	counted := int64(0) // Hashes already added to powHashesTotal
 
 	// The mining loop - keep trying nonces until we find a valid hash
 	for nonce < maxNonce {
 		// Prepare data with current nonce
 		data := pow.prepareData(nonce)
 
-		// Calculate hash
-		hash = sha256.Sum256(data)
-
-		// Print progress every 100000 attempts (optional, for visualization)
-		if nonce%100000 == 0 {
-			fmt.Printf("\r%x", hash)
+		// Calculate hash with the block's algorithm
+		hash = hasher.Sum(data)
+		pow.Stats.Hashes++
+		pow.Stats.Nonce = nonce
+
+		// Report progress every progressInterval attempts
+		if nonce%progressInterval == 0 {
+			pow.Stats.Elapsed = time.Since(start)
+			recordMiningStats(pow.Stats)
+			powHashesTotal.Add(pow.Stats.Hashes - counted)
+			counted = pow.Stats.Hashes
+			if pow.Progress != nil {
+				pow.Progress(nonce, hash)
+			}
 		}
 
 		// Convert hash to big.Int for comparison
-		hashInt.SetBytes(hash[:])
+		hashInt.SetBytes(hash)
 
 		// Check if hash is less than target (i.e., has enough leading zeros)
 		// This is the "proof" - we found a nonce that produces a valid hash
 		if hashInt.Cmp(pow.target) == -1 {
-			fmt.Printf("\r%x\n", hash)
+			if pow.Progress != nil {
+				pow.Progress(nonce, hash)
+			}
 			break
 		} else {
 			// Try next nonce
 			nonce++
 		}
 	}
-	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	pow.Stats.Elapsed = time.Since(start)
+	pow.Stats.Hash = hash
+	recordMiningStats(pow.Stats)
+	powHashesTotal.Add(pow.Stats.Hashes - counted)
+
+	return nonce, hash
 }
 
 // Validate validates the proof-of-work
@@ -90,9 +177,13 @@ func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
 	// Recreate the hash using the block's nonce
+	// A block of an unknown algorithm has no hash, and is invalid
 	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	hash := hashWith(pow.block.HashAlgo, data)
+	if hash == nil {
+		return false
+	}
+	hashInt.SetBytes(hash)
 
 	// Check if the hash meets the difficulty requirement
 	isValid := hashInt.Cmp(pow.target) == -1
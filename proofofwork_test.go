@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// useLogger sends the node's log to a buffer at level for the test
+func useLogger(t *testing.T, level LogLevel) *bytes.Buffer {
+	t.Helper()
+
+	var out bytes.Buffer
+	saved := logger
+	logger = NewLogger(&out, level)
+	t.Cleanup(func() { logger = saved })
+
+	return &out
+}
+
+func TestProofOfWorkProgress(t *testing.T) {
+	bc, w := newTestChain(t)
+	log := useLogger(t, LevelDebug)
+	block := peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 0, 2))
+
+	// Off a terminal, as under go test, mining reports nothing
+	var pow *ProofOfWork
+	out := captureOutput(t, func() {
+		pow = NewProofOfWork(block)
+		pow.Run()
+	})
+	if pow.Progress != nil || out != "" || log.Len() != 0 {
+		t.Fatalf("quiet mining printed %q and logged %q", out, log.String())
+	}
+
+	var reports []int
+	pow = NewProofOfWork(block)
+	pow.Progress = func(nonce int, hash []byte) { reports = append(reports, nonce) }
+	nonce, _ := pow.Run()
+	if len(reports) < 2 || reports[0] != 0 || reports[len(reports)-1] != nonce {
+		t.Fatalf("progress reported at nonces %v, want 0 first and the solution %d last", reports, nonce)
+	}
+	if !bytes.Contains(log.Bytes(), []byte("Mining block with 1 transaction(s)")) {
+		t.Fatalf("logged %q, want the start of mining", log.String())
+	}
+}
+
+func TestProofOfWorkMeetsTarget(t *testing.T) {
+	bc, w := newTestChain(t)
+
+	for _, bits := range []int{1, 4, 8, 12} {
+		block := peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "", 0, 2))
+		block.Bits = bits
+
+		pow := NewProofOfWork(block)
+		nonce, hash := pow.Run()
+		block.Nonce, block.Hash = nonce, hash
+
+		var hashInt big.Int
+		hashInt.SetBytes(hash)
+		if zeros := 256 - hashInt.BitLen(); zeros < bits {
+			t.Fatalf("hash %x mined at %d bits has %d leading zero bits", hash, bits, zeros)
+		}
+		if !NewProofOfWork(block).Validate() {
+			t.Fatalf("block mined at %d bits fails validation", bits)
+		}
+
+		// The same work doesn't meet a target far above what was mined for
+		block.Bits = bits + 64
+		if NewProofOfWork(block).Validate() {
+			t.Fatalf("block mined at %d bits validates at %d", bits, block.Bits)
+		}
+	}
+}
+
+func TestTargetBitsFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", targetBits},
+		{"4", 4},
+		{"0", targetBits},
+		{"256", targetBits},
+		{"many", targetBits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			saved := targetBits
+			defer SetTargetBits(saved)
+			useLogger(t, LevelError)
+			t.Setenv("POW_TARGET_BITS", tt.value)
+
+			loadTargetBitsFromEnv()
+			if targetBits != tt.want {
+				t.Fatalf("target bits %d, want %d", targetBits, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "errors"
+
+// defaultPruneKeep is how many recent blocks keep their transactions when pruning
+const defaultPruneKeep = 100
+
+// errBlockPruned is returned for blocks whose transactions were discarded by pruning
+var errBlockPruned = errors.New("block body has been pruned")
+
+// Prune discards the transactions of every block more than keep blocks below the tip
+// Pruned blocks keep their header fields and merkle root, so their proof of work can still be checked;
+// balances and spending rely on the UTXO set, which doesn't need the discarded transactions
+// Returns how many blocks were pruned by this call
+// Similar to Bitcoin Core's -prune
+func (bc *Blockchain) Prune(keep int) (int, error) {
+	if keep < 1 {
+		return 0, errors.New("at least the tip block must be kept")
+	}
+	if !(UTXOSet{bc}).IsCurrent() {
+		return 0, errors.New("the UTXO set is not up to date with the tip")
+	}
+
+	hashes := bc.GetBlockHashes()
+	if len(hashes) <= keep {
+		return 0, nil
+	}
+
+	pruned := 0
+	err := bc.db.Update(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		for _, hash := range hashes[keep:] {
+			block, err := DecodeBlock(b.Get(hash))
+			if err != nil {
+				return err
+			}
+			if block.IsPruned() {
+				continue
+			}
+
+			err = b.Put(hash, EncodePrunedBlock(block))
+			if err != nil {
+				return err
+			}
+			bc.blocks.Remove(hash)
+			pruned++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
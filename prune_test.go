@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name       string
+		keeps      []int // Passed to successive Prune calls
+		wantPruned []int
+		wantErr    bool
+	}{
+		{"nothing kept", []int{0}, []int{0}, true},
+		{"more kept than the chain holds", []int{10}, []int{0}, false},
+		{"whole chain kept", []int{6}, []int{0}, false},
+		{"tip kept", []int{1}, []int{5}, false},
+		{"pruned again", []int{2, 2}, []int{4, 0}, false},
+		{"pruned deeper", []int{4, 2}, []int{2, 2}, false},
+		{"more kept than before", []int{2, 4}, []int{4, 0}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			if err := bc.AddBlocks(chainBlocks(t, bc, coinbaseBlocks(bc, address, 5)...)); err != nil {
+				t.Fatal(err)
+			}
+			balance, err := bc.Balance(HashPubKey(w.PublicKey), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			kept := len(bc.GetBlockHashes())
+			for i, keep := range tt.keeps {
+				pruned, err := bc.Prune(keep)
+				if (err != nil) != tt.wantErr || pruned != tt.wantPruned[i] {
+					t.Fatalf("Prune(%d) = %d, %v, want %d", keep, pruned, err, tt.wantPruned[i])
+				}
+				if err == nil && keep < kept {
+					kept = keep
+				}
+			}
+
+			// Every block keeps a valid header; only those below the kept ones lose their transactions
+			for i, hash := range bc.GetBlockHashes() {
+				block, err := bc.GetBlock(hash)
+				if wantPruned := i >= kept; errors.Is(err, errBlockPruned) != wantPruned || block.IsPruned() != wantPruned {
+					t.Fatalf("block %d below the tip: GetBlock error %v, want pruned %t", i, err, wantPruned)
+				}
+				if !NewProofOfWork(&block).Validate() {
+					t.Fatalf("block %d below the tip has an invalid header", i)
+				}
+			}
+
+			if got, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || got != balance {
+				t.Fatalf("balance %d (%v) after pruning, want %d", got, err, balance)
+			}
+			utxos := UTXOSet{bc}
+			if supply, err := utxos.TotalSupply(); err != nil || supply != subsidy*6 {
+				t.Fatalf("UTXO set supply %d (%v), want %d", supply, err, subsidy*6)
+			}
+
+			// The pruned chain still grows, spending outputs of pruned blocks
+			spend := spendCoinbase(t, bc, w, address, 1)
+			next := chainBlocks(t, bc, []*Transaction{NewCoinbaseTX(address, "", 1, 7), spend})
+			if err := bc.AddBlocks(next); err != nil {
+				t.Fatalf("AddBlocks on the pruned chain: %s", err)
+			}
+		})
+	}
+}
+
+func TestSignWithPrunedInputs(t *testing.T) {
+	bc, alice := newTestChain(t)
+	address := string(alice.GetAddress())
+	toBob := spendCoinbase(t, bc, alice, string(NewWallet().GetAddress()), 0)
+	blocks := coinbaseBlocks(bc, address, 4)
+	blocks[0] = append(blocks[0], toBob)
+	if err := bc.AddBlocks(chainBlocks(t, bc, blocks...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unspent output of a pruned block is still found in the UTXO set
+	prunedCoinbase := blocks[0][0]
+	tx := spendOutput(t, bc, alice, prunedCoinbase, 0, address, 1, false)
+	if err := bc.VerifyTransaction(tx); err != nil {
+		t.Fatalf("spend of a pruned unspent output: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		prevID []byte
+		vout   int
+	}{
+		{"spent output of a pruned block", toBob.Vin[0].Txid, 0},
+		{"output index past a pruned transaction's outputs", prunedCoinbase.ID, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{nil, []TXInput{{tt.prevID, tt.vout, nil, alice.PublicKey, nil}}, []TXOutput{*NewTXOutput(1, address)}, false, nil}
+			tx.ID = tx.Hash()
+			want := fmt.Sprintf("referenced input %x:%d not found", tt.prevID, tt.vout)
+
+			if err := bc.SignTransaction(tx, alice.PrivateKey); err == nil || err.Error() != want {
+				t.Fatalf("SignTransaction = %v, want %q", err, want)
+			}
+			if err := bc.VerifyTransaction(tx); err == nil {
+				t.Fatal("VerifyTransaction accepted the unsigned spend")
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// defaultMaxReorgDepth is how many blocks below the tip a received branch may fork by default
+const defaultMaxReorgDepth = 100
+
+// maxReorgDepth bounds how many blocks of the main chain a reorganization may disconnect; 0 disables the limit
+// Set with startnode -maxreorg
+var maxReorgDepth = defaultMaxReorgDepth
+
+// errReorgTooDeep is returned for blocks of a branch forking more than maxReorgDepth blocks below the tip
+var errReorgTooDeep = errors.New("reorganization too deep")
+
+// checkReorgDepth rejects a block at height whose branch forks off the main chain more than maxReorgDepth blocks
+// below the tip, so a long secretly mined chain can't rewrite settled history
+// Such a block would never become the tip without disconnecting that many blocks, so it's refused even on a side chain
+// Similar to Bitcoin Cash's finalization depth (-maxreorgdepth)
+func (bc *Blockchain) checkReorgDepth(block *Block, height int) error {
+	tip := bc.Tip()
+	if maxReorgDepth <= 0 || len(block.PrevBlockHash) == 0 || bytes.Equal(block.PrevBlockHash, tip) {
+		return nil
+	}
+
+	bestHeight := bc.GetBestHeight()
+	depth, err := bc.forkDepth(tip, bestHeight, block.PrevBlockHash, height-1, maxReorgDepth)
+	if err != nil {
+		return err
+	}
+	if depth > maxReorgDepth {
+		logger.Warnf("Rejecting block %x: its branch forks more than %d blocks below the tip %x; a reorganization that deep needs manual intervention", block.Hash, maxReorgDepth, tip)
+		return fmt.Errorf("%w: the branch forks more than %d blocks below the tip", errReorgTooDeep, maxReorgDepth)
+	}
+
+	return nil
+}
+
+// forkDepth returns how many blocks of the main chain, ending at tip at bestHeight, lie above the point
+// where the branch ending at branchTip (at branchHeight) forks off it
+// Only the top limit+1 blocks of the main chain are searched: a deeper fork point is reported as limit+1
+func (bc *Blockchain) forkDepth(tip []byte, bestHeight int, branchTip []byte, branchHeight, limit int) (int, error) {
+	mainChain := make(map[string]int)
+	bci := &BlockchainIterator{tip, bc.db, bc.blocks}
+	for height := bestHeight; height >= bestHeight-limit && len(bci.currentHash) > 0; height-- {
+		mainChain[string(bci.currentHash)] = height
+		bci.Next()
+	}
+
+	bci = &BlockchainIterator{branchTip, bc.db, bc.blocks}
+	for height := branchHeight; height >= bestHeight-limit; height-- {
+		if mainHeight, ok := mainChain[string(bci.currentHash)]; ok {
+			return bestHeight - mainHeight, nil
+		}
+		if len(bci.currentHash) == 0 {
+			return 0, errors.New("the branch has another genesis block")
+		}
+		bci.Next()
+	}
+
+	return limit + 1, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReorgToBranchSpendingTwiceIsRolledBack(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+	genesis := bc.Tip()
+
+	// Each spend of the genesis output is valid on its own; the branch carries both
+	first := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+	second := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 2)
+
+	main := peerBlock(t, bc, NewCoinbaseTX(address, "main", 0, 2))
+	if err := bc.AddBlock(main); err != nil {
+		t.Fatalf("AddBlock main: %s", err)
+	}
+	side := blockOn(t, bc, genesis, NewCoinbaseTX(address, "side", 1, 2), first)
+	if err := bc.AddBlock(side); err != nil {
+		t.Fatalf("AddBlock side: %s", err)
+	}
+	if !bytes.Equal(bc.Tip(), main.Hash) {
+		t.Fatal("a side-chain block of equal height became the tip")
+	}
+
+	bad := blockOn(t, bc, side.Hash, NewCoinbaseTX(address, "side", 2, 3), second)
+	if err := bc.AddBlock(bad); err == nil {
+		t.Fatal("branch spending an output twice was accepted")
+	}
+
+	if !bytes.Equal(bc.Tip(), main.Hash) {
+		t.Fatalf("tip %x after the rejected reorganization, want %x", bc.Tip(), main.Hash)
+	}
+	if height := bc.GetBestHeight(); height != 2 {
+		t.Fatalf("height %d after the rejected reorganization, want 2", height)
+	}
+	if bc.HasBlock(bad.Hash) {
+		t.Fatal("block completing the invalid branch is still stored")
+	}
+	if !(UTXOSet{bc}).IsCurrent() {
+		t.Fatal("UTXO set isn't current after the rollback")
+	}
+	if _, err := bc.CheckMempoolAccept(first); err != nil {
+		t.Fatalf("genesis output isn't spendable on the restored chain: %s", err)
+	}
+}
+
+func TestOrphanNeedsProofOfWork(t *testing.T) {
+	bc, w := newTestChain(t)
+	unknownParent := bytes.Repeat([]byte{0xab}, 32)
+	coinbase := NewCoinbaseTX(string(w.GetAddress()), "orphan", 0, 5)
+
+	mined := newBlockAt([]*Transaction{coinbase}, unknownParent, 1, bc.consensus, targetBits, bc.sealer)
+	if err := bc.AddBlock(mined); !errors.Is(err, errOrphanBlock) {
+		t.Fatalf("AddBlock of a mined orphan = %v, want %v", err, errOrphanBlock)
+	}
+
+	// A hash that matches the contents but doesn't meet the target
+	unmined := newBlockAt([]*Transaction{coinbase}, unknownParent, 2, bc.consensus, targetBits, InstantSealer{})
+	for bc.sealer.Verify(unmined) {
+		unmined.Nonce++
+		unmined.Hash = unmined.CalculateHash()
+	}
+	forged := newBlockAt([]*Transaction{coinbase}, unknownParent, 3, bc.consensus, targetBits, bc.sealer)
+	forged.Hash = bytes.Repeat([]byte{0}, 32)
+
+	for name, block := range map[string]*Block{"unmined": unmined, "forged hash": forged} {
+		err := bc.AddBlock(block)
+		if err == nil || errors.Is(err, errOrphanBlock) {
+			t.Fatalf("AddBlock of the %s orphan = %v, want it rejected", name, err)
+		}
+		if orphans.Has(block.Hash) {
+			t.Fatalf("%s orphan was kept", name)
+		}
+	}
+}
+
+// taggedCoinbases returns the transactions of n blocks from height first on, one coinbase each paying address,
+// told apart from other branches' by tag
+func taggedCoinbases(address, tag string, first, n int) [][]*Transaction {
+	txs := make([][]*Transaction, n)
+	for i := range txs {
+		txs[i] = []*Transaction{NewCoinbaseTX(address, tag, 0, first+i)}
+	}
+
+	return txs
+}
+
+func TestReorgFollowsLongestChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		main     int // Blocks on the genesis block, added first
+		side     int // Blocks of a branch forking at the genesis block, added next
+		maxReorg int
+		wantSide bool
+		wantErr  error
+	}{
+		{"shorter branch", 2, 1, 0, false, nil},
+		{"branch as long, first seen stays", 2, 2, 0, false, nil},
+		{"branch one block longer", 1, 2, 0, true, nil},
+		{"branch several blocks longer", 3, 5, 0, true, nil},
+		{"branch within the reorg limit", 2, 3, 2, true, nil},
+		{"branch forking below the reorg limit", 3, 4, 2, false, errReorgTooDeep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := maxReorgDepth
+			maxReorgDepth = tt.maxReorg
+			defer func() { maxReorgDepth = saved }()
+
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			genesis := bc.Tip()
+
+			main := chainBlocks(t, bc, taggedCoinbases(address, "main", 2, tt.main)...)
+			if err := bc.AddBlocks(main); err != nil {
+				t.Fatalf("AddBlocks main: %s", err)
+			}
+			side := branchBlocks(t, bc, genesis, taggedCoinbases(address, "side", 2, tt.side)...)
+			var err error
+			for _, block := range side {
+				if err = bc.AddBlock(block); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("adding the branch = %v, want %v", err, tt.wantErr)
+			}
+
+			want := main
+			if tt.wantSide {
+				want = side
+			}
+			if !bytes.Equal(bc.Tip(), want[len(want)-1].Hash) || bc.GetBestHeight() != len(want)+1 {
+				t.Fatalf("tip %x at height %d, want %x at %d", bc.Tip(), bc.GetBestHeight(), want[len(want)-1].Hash, len(want)+1)
+			}
+			utxos := UTXOSet{bc}
+			supply, err := utxos.TotalSupply()
+			if err != nil || !utxos.IsCurrent() || supply != subsidy*(len(want)+1) {
+				t.Fatalf("UTXO set supply %d (current %t, %v), want %d", supply, utxos.IsCurrent(), err, subsidy*(len(want)+1))
+			}
+		})
+	}
+}
+
+func TestReorgReturnsDisconnectedTransactions(t *testing.T) {
+	tests := []struct {
+		name      string
+		side      string // What the side branch does with the spend of the genesis coinbase
+		wantSpend bool   // Whether the spend is back in the mempool
+		wantChild bool   // Whether its child, mined a block later, is back in the mempool
+	}{
+		{"transactions only on the old branch", "", true, true},
+		{"parent on both branches", "includes", false, true},
+		{"input spent by the new branch", "conflicts", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			bob := NewWallet()
+			genesis := bc.Tip()
+			spend := spendCoinbase(t, bc, w, string(bob.GetAddress()), 1)
+			conflict := spendCoinbase(t, bc, w, address, 2)
+
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "main", 1, 2), spend)); err != nil {
+				t.Fatal(err)
+			}
+			child := spendOutput(t, bc, bob, spend, 0, address, 1, false)
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "main", 1, 3), child)); err != nil {
+				t.Fatal(err)
+			}
+
+			sideTxs := taggedCoinbases(address, "side", 2, 3)
+			switch tt.side {
+			case "includes":
+				sideTxs[0] = []*Transaction{NewCoinbaseTX(address, "side", 1, 2), spend}
+			case "conflicts":
+				sideTxs[0] = []*Transaction{NewCoinbaseTX(address, "side", 2, 2), conflict}
+			}
+			if err := bc.AddBlocks(branchBlocks(t, bc, genesis, sideTxs...)); err != nil {
+				t.Fatal(err)
+			}
+			if bc.GetBestHeight() != 4 {
+				t.Fatalf("height %d, want the side branch at 4", bc.GetBestHeight())
+			}
+
+			for _, c := range []struct {
+				tx   *Transaction
+				want bool
+			}{{spend, tt.wantSpend}, {child, tt.wantChild}} {
+				_, err := bc.findMempoolTransaction(c.tx.ID)
+				if inMempool := err == nil; inMempool != c.want {
+					t.Errorf("transaction %x in the mempool: %t, want %t", c.tx.ID, inMempool, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReorgDepthLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxReorg int
+		depth    int // Main chain blocks above the fork point, which the branch must disconnect
+		wantErr  error
+	}{
+		{"just within the limit", 3, 3, nil},
+		{"just beyond the limit", 3, 4, errReorgTooDeep},
+		{"limit disabled", 0, 4, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := maxReorgDepth
+			maxReorgDepth = tt.maxReorg
+			defer func() { maxReorgDepth = saved }()
+
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+			if err := bc.AddBlocks(chainBlocks(t, bc, taggedCoinbases(address, "main", 2, 5)...)); err != nil {
+				t.Fatal(err)
+			}
+			tip, best := bc.Tip(), bc.GetBestHeight()
+
+			// A branch one block longer than the part of the main chain it replaces
+			fork := bc.GetBlockHashes()[tt.depth]
+			branch := branchBlocks(t, bc, fork, taggedCoinbases(address, "side", best-tt.depth+1, tt.depth+1)...)
+			var err error
+			for _, block := range branch {
+				if err = bc.AddBlock(block); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("adding the branch = %v, want %v", err, tt.wantErr)
+			}
+
+			want := branch[len(branch)-1].Hash
+			if tt.wantErr != nil {
+				want = tip
+				if bc.HasBlock(branch[0].Hash) {
+					t.Fatal("block of a too deep branch was stored")
+				}
+			}
+			if !bytes.Equal(bc.Tip(), want) {
+				t.Fatalf("tip %x, want %x", bc.Tip(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "bytes"
+
+// Sealer seals mined blocks and verifies the seals of received ones
+// Similar to Geth's consensus.Engine, which hides ethash behind Seal() and VerifySeal()
+type Sealer interface {
+	// Seal finds the nonce and hash that make the block valid
+	Seal(block *Block) (int, []byte)
+	// Verify checks the block's nonce and hash
+	Verify(block *Block) bool
+}
+
+// PowSealer seals blocks with SHA-256 proof of work at the current target
+type PowSealer struct{}
+
+// Seal runs proof of work on the block
+func (PowSealer) Seal(block *Block) (int, []byte) {
+	return NewProofOfWork(block).Run()
+}
+
+// Verify checks the block's proof of work
+func (PowSealer) Verify(block *Block) bool {
+	return NewProofOfWork(block).Validate()
+}
+
+// InstantSealer seals blocks without any work: the nonce is 0 and the hash is just the block's hash
+// Meant for tests and simulations that need to build chains quickly
+// Similar to Geth's ethash.NewFaker()
+type InstantSealer struct{}
+
+// Seal hashes the block with a zero nonce
+func (InstantSealer) Seal(block *Block) (int, []byte) {
+	block.Nonce = 0
+
+	return 0, block.CalculateHash()
+}
+
+// Verify checks that the block's hash matches its contents
+func (InstantSealer) Verify(block *Block) bool {
+	return bytes.Equal(block.CalculateHash(), block.Hash)
+}
+
+// defaultSealer seals and verifies blocks of chains opened by this node
+var defaultSealer Sealer = PowSealer{}
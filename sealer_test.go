@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInstantSealer(t *testing.T) {
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+
+	bc.sealer = InstantSealer{}
+
+	for height := 2; height <= 4; height++ {
+		block := bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", 0, height)})
+		if block.Nonce != 0 || !bytes.Equal(block.Hash, block.CalculateHash()) {
+			t.Fatalf("block %d sealed with nonce %d, hash %x", height, block.Nonce, block.Hash)
+		}
+		if !bytes.Equal(bc.Tip(), block.Hash) {
+			t.Fatalf("mined block %d is not the tip", height)
+		}
+	}
+
+	received := peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 5))
+	if err := bc.AddBlock(received); err != nil {
+		t.Fatalf("AddBlock of an instantly sealed block: %s", err)
+	}
+
+	forged := peerBlock(t, bc, NewCoinbaseTX(address, "", 0, 6))
+	forged.Hash = bytes.Repeat([]byte{0}, len(forged.Hash))
+	if (InstantSealer{}).Verify(forged) || bc.AddBlock(forged) == nil {
+		t.Fatal("block with a hash not matching its contents accepted")
+	}
+	if bc.GetBestHeight() != 5 {
+		t.Fatalf("height %d, want 5", bc.GetBestHeight())
+	}
+}
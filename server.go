@@ -2,22 +2,42 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"strconv"
+	"time"
 )
 
 const protocol = "tcp"
-const nodeVersion = 1
+const nodeVersion = 2
+
+// minNodeVersion is the oldest protocol version this node can still exchange messages with
+const minNodeVersion = 1
 const commandLength = 12
 
+// dialTimeout bounds how long a send waits on an unresponsive peer
+const dialTimeout = 5 * time.Second
+
+// maxMessageSize bounds the size of a message, so a peer can't make this node allocate without limit
+// Blocks, the largest messages, are mined up to maxBlockTxSize bytes of transactions
+// Similar to Bitcoin's MAX_PROTOCOL_MESSAGE_LENGTH
+const maxMessageSize = 4 * 1024 * 1024
+
+// messageLengthSize is the size of the big-endian length every message is prefixed with
+const messageLengthSize = 4
+
+// errMessageTooLarge is returned for frames announcing more than maxMessageSize bytes
+var errMessageTooLarge = errors.New("message too large")
+
 var nodeAddress string
 var miningAddress string
-var knownNodes = []string{"localhost:3000"}
-var blocksInTransit = [][]byte{}
+var knownNodes []string
 
 // Renamed to avoid collision with 'version' constant in other files
 type versionMsg struct {
@@ -26,8 +46,29 @@ type versionMsg struct {
 	AddrFrom   string
 }
 
+type addr struct {
+	AddrList []string
+}
+
+type ping struct {
+	AddrFrom string
+}
+
 type getblocks struct {
 	AddrFrom string
+	From     []byte   // Requester's tip
+	Locator  [][]byte // Block locator of the requester's chain; the reply lists the blocks after the latest shared one
+}
+
+type getheaders struct {
+	AddrFrom string
+	From     []byte   // Requester's header tip
+	Locator  [][]byte // Block locator of the requester's header chain; the reply lists the headers after the latest shared one
+}
+
+type headers struct {
+	AddrFrom string
+	Headers  [][]byte // Codec-encoded block headers, oldest first
 }
 
 type inv struct {
@@ -40,6 +81,7 @@ type getdata struct {
 	AddrFrom string
 	Type     string
 	ID       []byte
+	Indexes  []int // For "blocktxn": the transactions of block ID wanted
 }
 
 type block struct {
@@ -52,11 +94,55 @@ type tx struct {
 	Transaction []byte
 }
 
-// StartServer starts a node
-func StartServer(nodeID, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+// addnode asks the node to connect to (addnode) or drop (removenode) a peer
+// Only accepted from the local machine, where the addnode and disconnectnode commands send it
+type addnode struct {
+	Address string
+}
+
+// ResolveListenAddress picks the address the node server binds to from the -listen flag, then LISTEN_ADDR,
+// then localhost with nodeID as the port
+func ResolveListenAddress(flagValue, nodeID string) (string, error) {
+	address := flagValue
+	if address == "" {
+		address = os.Getenv("LISTEN_ADDR")
+	}
+	if address == "" {
+		return fmt.Sprintf("localhost:%s", nodeID), nil
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("listen address %q is not HOST:PORT", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("listen address %q has an invalid port", address)
+	}
+
+	return address, nil
+}
+
+// localAddress returns the address the node at listenAddr is reached at from this machine,
+// replacing a wildcard host such as 0.0.0.0 with localhost
+func localAddress(listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// StartServer starts a node bound to listenAddr and dials the seed nodes for the initial version exchange
+// nodeID only names the node's files
+// A mining node with a positive mineInterval mines a block at that interval
+func StartServer(nodeID, listenAddr, minerAddress string, mineInterval time.Duration, seeds []string) {
+	nodeAddress = localAddress(listenAddr)
 	miningAddress = minerAddress
-	ln, err := net.Listen(protocol, nodeAddress)
+	ln, err := net.Listen(protocol, listenAddr)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -65,45 +151,103 @@ func StartServer(nodeID, minerAddress string) {
 	bc := NewBlockchain(minerAddress, nodeID)
 	defer bc.db.Close()
 
-	if nodeAddress != knownNodes[0] {
-		sendVersion(knownNodes[0], bc)
+	// The mempool outlives restarts, but blocks connected since may have spent its inputs
+	kept, dropped, err := bc.RevalidateMempool()
+	if err != nil {
+		logger.Errorf("Revalidating mempool: %s", err)
+	} else {
+		logger.Infof("Loaded %d mempool transaction(s), dropped %d no longer valid", kept, dropped)
 	}
 
-	fmt.Printf("Server listening on %s\n", nodeAddress)
+	initKnownNodes(nodeID, seeds)
+	for _, node := range getKnownNodes() {
+		sendVersion(node, bc)
+	}
+	go pingPeers(pingInterval)
+	if metricsAddr != "" {
+		go serveMetrics(metricsAddr, bc)
+	}
+	if minerAddress != "" && mineInterval > 0 {
+		quit := make(chan struct{})
+		defer close(quit)
+		go runMiner(bc, minerAddress, mineInterval, quit)
+	}
+
+	logger.Infof("Server listening on %s", listenAddr)
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Panic(err)
+			logger.Errorf("Accepting connection: %s", err)
+			continue
 		}
-		go handleConnection(conn, bc)
+		if err := inboundLimiter.acquire(conn.RemoteAddr(), time.Now()); err != nil {
+			logger.Warnf("Rejecting connection from %s: %s (%d inbound)", conn.RemoteAddr(), err, InboundConnections())
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer inboundLimiter.release()
+			handleConnection(conn, bc)
+		}()
 	}
 }
 
 func handleConnection(conn net.Conn, bc *Blockchain) {
-	request, err := ioutil.ReadAll(conn)
+	defer conn.Close()
+
+	// A peer holding a connection open without sending would keep its inbound slot forever
+	conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	request, err := readMessage(conn)
+	if errors.Is(err, errMessageTooLarge) {
+		logger.Warnf("Dropping message from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
 	if err != nil {
-		log.Panic(err)
+		logger.Errorf("Reading from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	if len(request) < commandLength {
+		logger.Warnf("Dropping short message from %s", conn.RemoteAddr())
+		return
 	}
 	command := bytesToCommand(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
+	logger.Debugf("Received %s command", command)
 
 	switch command {
+	case "addnode", "removenode":
+		if !isLoopback(conn.RemoteAddr()) {
+			logger.Warnf("Ignoring %s command from remote %s", command, conn.RemoteAddr())
+			return
+		}
+		handleAddNode(request, bc)
+	case "addr":
+		handleAddr(request, bc)
+	case "ping":
+		handlePing(request)
+	case "pong":
+		handlePong(request)
 	case "version":
 		handleVersion(request, bc)
 	case "getblocks":
 		handleGetBlocks(request, bc)
+	case "getheaders":
+		handleGetHeaders(request, bc)
+	case "headers":
+		handleHeaders(request, bc)
 	case "inv":
 		handleInv(request, bc)
 	case "getdata":
 		handleGetData(request, bc)
 	case "block":
 		handleBlock(request, bc)
+	case "cmpctblock":
+		handleCmpctBlock(request, bc)
+	case "blocktxn":
+		handleBlockTxn(request, bc)
 	default:
-		fmt.Println("Unknown command!")
+		logger.Warnf("Unknown command %q", command)
 	}
-
-	conn.Close()
 }
 
 func sendVersion(addr string, bc *Blockchain) {
@@ -115,9 +259,39 @@ func sendVersion(addr string, bc *Blockchain) {
 	sendData(addr, request)
 }
 
-func sendGetBlocks(address string) {
-	payload := gobEncode(getblocks{nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...)
+func sendAddr(address string) {
+	nodes := addr{append(getKnownNodes(), nodeAddress)}
+	payload := gobEncode(nodes)
+	request := append(commandToBytes("addr"), payload...)
+
+	sendData(address, request)
+}
+
+func sendPing(address string) {
+	payload := gobEncode(ping{nodeAddress})
+	request := append(commandToBytes("ping"), payload...)
+
+	sendData(address, request)
+}
+
+func sendPong(address string) {
+	payload := gobEncode(ping{nodeAddress})
+	request := append(commandToBytes("pong"), payload...)
+
+	sendData(address, request)
+}
+
+// sendGetHeaders asks for the headers following the latest block of locator the peer has
+func sendGetHeaders(address string, locator [][]byte) {
+	payload := gobEncode(getheaders{nodeAddress, locator[0], locator})
+	request := append(commandToBytes("getheaders"), payload...)
+
+	sendData(address, request)
+}
+
+func sendHeaders(address string, items [][]byte) {
+	payload := gobEncode(headers{nodeAddress, items})
+	request := append(commandToBytes("headers"), payload...)
 
 	sendData(address, request)
 }
@@ -131,14 +305,23 @@ func sendInv(address, kind string, items [][]byte) {
 }
 
 func sendGetData(address, kind string, id []byte) {
-	payload := gobEncode(getdata{nodeAddress, kind, id})
+	payload := gobEncode(getdata{nodeAddress, kind, id, nil})
+	request := append(commandToBytes("getdata"), payload...)
+
+	sendData(address, request)
+}
+
+// sendGetBlockTxn asks for the transactions at the given indexes of a compact block
+func sendGetBlockTxn(address string, blockHash []byte, indexes []int) {
+	payload := gobEncode(getdata{nodeAddress, "blocktxn", blockHash, indexes})
 	request := append(commandToBytes("getdata"), payload...)
 
 	sendData(address, request)
 }
 
 func sendBlock(address string, b *Block) {
-	data := block{nodeAddress, b.Serialize()}
+	// Sent uncompressed whatever this node stores, as older peers can't decode compressed blocks
+	data := block{nodeAddress, EncodeBlock(b)}
 	payload := gobEncode(data)
 	request := append(commandToBytes("block"), payload...)
 
@@ -146,27 +329,119 @@ func sendBlock(address string, b *Block) {
 }
 
 func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
 	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
+		recordPeerFailure(addr)
 
-		for _, node := range knownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-
-		knownNodes = updatedNodes
+		return
+	}
+	defer conn.Close()
 
+	err = conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	if err == nil {
+		err = writeMessage(conn, data)
+	}
+	if err != nil {
+		recordPeerFailure(addr)
 		return
 	}
+	recordPeerSuccess(addr)
+}
+
+// writeMessage writes a message framed with its length
+func writeMessage(w io.Writer, message []byte) error {
+	if len(message) > maxMessageSize {
+		return fmt.Errorf("%w: %d bytes", errMessageTooLarge, len(message))
+	}
+
+	frame := make([]byte, messageLengthSize, messageLengthSize+len(message))
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+	_, err := w.Write(append(frame, message...))
+
+	return err
+}
+
+// readMessage reads a message framed by writeMessage
+// The length is checked against maxMessageSize before anything is allocated for the message
+func readMessage(r io.Reader) ([]byte, error) {
+	var length [messageLengthSize]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes announced", errMessageTooLarge, size)
+	}
+
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// sendNodeCommand sends an addnode or removenode command for address to the node running locally at listenAddr
+// Fails if no node is listening
+func sendNodeCommand(listenAddr, command, address string) error {
+	target := localAddress(listenAddr)
+	conn, err := net.DialTimeout(protocol, target, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("no node is running at %s", target)
+	}
 	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	request := append(commandToBytes(command), gobEncode(addnode{address})...)
+	err = conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	if err == nil {
+		err = writeMessage(conn, request)
+	}
+
+	return err
+}
+
+// isLoopback reports whether a connection comes from the local machine
+func isLoopback(remote net.Addr) bool {
+	host, _, err := net.SplitHostPort(remote.String())
 	if err != nil {
-		log.Panic(err)
+		return false
 	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+func handleAddNode(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload addnode
+
+	command := bytesToCommand(request[:commandLength])
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", command, err)
+		return
+	}
+
+	if command == "removenode" {
+		if !nodeIsKnown(payload.Address) {
+			logger.Warnf("Not disconnecting %s: it isn't a known peer", payload.Address)
+			return
+		}
+		removeKnownNode(payload.Address)
+		logger.Infof("Disconnected peer %s", payload.Address)
+		return
+	}
+
+	if payload.Address == nodeAddress {
+		logger.Warnf("Not connecting to %s: it's this node", payload.Address)
+		return
+	}
+	addKnownNode(payload.Address)
+	logger.Infof("Connecting to peer %s", payload.Address)
+	sendVersion(payload.Address, bc)
 }
 
 func handleVersion(request []byte, bc *Blockchain) {
@@ -177,21 +452,97 @@ func handleVersion(request []byte, bc *Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+
+	// Peers too old to understand are dropped before anything else is exchanged
+	version, err := negotiateVersion(payload.Version)
+	if err != nil {
+		logger.Warnf("Disconnecting %s: %s", payload.AddrFrom, err)
+		if nodeIsKnown(payload.AddrFrom) {
+			removeKnownNode(payload.AddrFrom)
+		}
+		return
 	}
 
 	myBestHeight := bc.GetBestHeight()
 	foreignerBestHeight := payload.BestHeight
 
 	if myBestHeight < foreignerBestHeight {
-		sendGetBlocks(payload.AddrFrom)
+		syncer.Start(payload.AddrFrom, bc)
 	} else if myBestHeight > foreignerBestHeight {
 		sendVersion(payload.AddrFrom, bc)
 	}
 
-	if !nodeIsKnown(payload.AddrFrom) {
-		knownNodes = append(knownNodes, payload.AddrFrom)
+	// Tell a newly met peer about the rest of the network
+	if addKnownNode(payload.AddrFrom) {
+		sendAddr(payload.AddrFrom)
 	}
+	recordPeerVersion(payload.AddrFrom, foreignerBestHeight, version)
+}
+
+// negotiateVersion returns the protocol version to speak with a peer announcing theirs: the lower of both,
+// so a newer node falls back to the older peer's messages. Peers older than minNodeVersion are incompatible
+// Similar to Bitcoin's version handshake settling on min(nVersion, PROTOCOL_VERSION)
+func negotiateVersion(theirs int) (int, error) {
+	if theirs < minNodeVersion {
+		return 0, fmt.Errorf("protocol version %d is older than the minimum %d", theirs, minNodeVersion)
+	}
+
+	return min(theirs, nodeVersion), nil
+}
+
+func handleAddr(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload addr
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+
+	for _, node := range payload.AddrList {
+		if addKnownNode(node) {
+			sendVersion(node, bc)
+		}
+	}
+	logger.Debugf("There are %d known nodes now", len(getKnownNodes()))
+}
+
+func handlePing(request []byte) {
+	var buff bytes.Buffer
+	var payload ping
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+	touchPeer(payload.AddrFrom)
+
+	sendPong(payload.AddrFrom)
+}
+
+func handlePong(request []byte) {
+	var buff bytes.Buffer
+	var payload ping
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+	touchPeer(payload.AddrFrom)
+
+	recordPeerSuccess(payload.AddrFrom)
 }
 
 func handleGetBlocks(request []byte, bc *Blockchain) {
@@ -202,44 +553,103 @@ func handleGetBlocks(request []byte, bc *Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+	touchPeer(payload.AddrFrom)
+
+	locator := requestLocator(payload.From, payload.Locator)
+	if len(locator) > maxLocatorHashes {
+		logger.Warnf("Dropping getblocks from %s: locator of %d hashes", payload.AddrFrom, len(locator))
+		return
 	}
 
-	blocks := bc.GetBlockHashes()
+	// Reply with the next batch of blocks the requester is missing, oldest first
+	blocks := bc.blockHashesAfter(locator, maxInvItems)
 	sendInv(payload.AddrFrom, "block", blocks)
 }
 
-func handleInv(request []byte, bc *Blockchain) {
+func handleGetHeaders(request []byte, bc *Blockchain) {
 	var buff bytes.Buffer
-	var payload inv
+	var payload getheaders
 
 	buff.Write(request[commandLength:])
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
 	}
+	touchPeer(payload.AddrFrom)
 
-	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
-
-	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+	locator := requestLocator(payload.From, payload.Locator)
+	if len(locator) > maxLocatorHashes {
+		logger.Warnf("Dropping getheaders from %s: locator of %d hashes", payload.AddrFrom, len(locator))
+		return
+	}
 
-		// Reverse the list to download from Genesis to Tip
-		for i, j := 0, len(blocksInTransit)-1; i < j; i, j = i+1, j-1 {
-			blocksInTransit[i], blocksInTransit[j] = blocksInTransit[j], blocksInTransit[i]
+	// Reply with the next batch of headers the requester is missing, oldest first
+	var items [][]byte
+	for _, hash := range bc.blockHashesAfter(locator, maxHeaderItems) {
+		header, err := bc.GetBlockHeader(hash)
+		if err != nil {
+			logger.Errorf("Reading header %x: %s", hash, err)
+			return
 		}
+		items = append(items, EncodeBlockHeader(header))
+	}
+	sendHeaders(payload.AddrFrom, items)
+}
 
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+func handleHeaders(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload headers
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+	touchPeer(payload.AddrFrom)
+
+	if len(payload.Headers) > maxHeaderItems {
+		logger.Warnf("Dropping %d headers from %s: more than %d", len(payload.Headers), payload.AddrFrom, maxHeaderItems)
+		return
+	}
+
+	var items []BlockHeader
+	for _, data := range payload.Headers {
+		header, err := DecodeBlockHeader(data)
+		if err != nil {
+			logger.Errorf("Dropping headers from %s: %s", payload.AddrFrom, err)
+			return
 		}
-		blocksInTransit = newInTransit
+		items = append(items, header)
+	}
+	logger.Debugf("Received %d headers", len(items))
+
+	syncer.HandleHeaders(payload.AddrFrom, items, bc)
+}
+
+func handleInv(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload inv
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
+	}
+	touchPeer(payload.AddrFrom)
+
+	logger.Debugf("Received inventory with %d %s", len(payload.Items), payload.Type)
+
+	if payload.Type == "block" {
+		syncer.HandleInv(payload.AddrFrom, payload.Items, bc)
 	}
 }
 
@@ -251,8 +661,10 @@ func handleGetData(request []byte, bc *Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
 	}
+	touchPeer(payload.AddrFrom)
 
 	if payload.Type == "block" {
 		block, err := bc.GetBlock(payload.ID)
@@ -262,6 +674,9 @@ func handleGetData(request []byte, bc *Blockchain) {
 
 		sendBlock(payload.AddrFrom, &block)
 	}
+	if payload.Type == "blocktxn" {
+		serveBlockTxn(payload.AddrFrom, payload.ID, payload.Indexes, bc)
+	}
 }
 
 func handleBlock(request []byte, bc *Blockchain) {
@@ -272,35 +687,41 @@ func handleBlock(request []byte, bc *Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		logger.Errorf("Decoding %s message: %s", bytesToCommand(request[:commandLength]), err)
+		return
 	}
+	touchPeer(payload.AddrFrom)
 
-	blockData := payload.Block
-	block := DeserializeBlock(blockData)
-
-	fmt.Println("Received a new block!")
-	bc.AddBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
+	block, err := DecodeBlock(payload.Block)
+	if err != nil {
+		logger.Errorf("Dropping block from %s: %s", payload.AddrFrom, err)
+		return
+	}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+	processBlock(payload.AddrFrom, block, bc)
+}
 
-		blocksInTransit = blocksInTransit[1:]
+// processBlock adds a block received from a peer, asking it for the parent of an orphan
+func processBlock(from string, block *Block, bc *Blockchain) {
+	err := bc.AddBlock(block)
+	if errors.Is(err, errOrphanBlock) && (syncer.Expects(block.PrevBlockHash) || orphans.Has(block.PrevBlockHash)) {
+		// Bodies are downloaded in parallel; the parent is already on its way, or waiting on its own parent
+		logger.Debugf("Holding orphan block %x until parent %x arrives", block.Hash, block.PrevBlockHash)
+	} else if errors.Is(err, errOrphanBlock) {
+		// Ask the sender for the missing parent; the orphan is connected once it arrives
+		logger.Infof("Holding orphan block %x, requesting parent %x", block.Hash, block.PrevBlockHash)
+		sendGetData(from, "block", block.PrevBlockHash)
+	} else if err != nil {
+		logger.Errorf("Rejecting block %x: %s", block.Hash, err)
 	} else {
-		// UTXO reindex
+		logger.Infof("Added block %x", block.Hash)
 	}
+
+	syncer.HandleBlock(from, block.Hash, bc)
 }
 
 func nodeIsKnown(addr string) bool {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
-		}
-	}
-
-	return false
+	return containsNode(getKnownNodes(), addr)
 }
 
 func commandToBytes(command string) []byte {
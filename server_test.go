@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// lengthPrefix returns the frame header announcing a message of size bytes
+func lengthPrefix(size uint32) []byte {
+	header := make([]byte, messageLengthSize)
+	binary.BigEndian.PutUint32(header, size)
+
+	return header
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		want    []byte
+		wantErr error
+	}{
+		{"empty message", lengthPrefix(0), []byte{}, nil},
+		{"short message", append(lengthPrefix(3), "abc"...), []byte("abc"), nil},
+		{"trailing bytes left for the next frame", append(lengthPrefix(2), "abc"...), []byte("ab"), nil},
+		{"truncated length", []byte{0, 0}, nil, io.ErrUnexpectedEOF},
+		{"truncated message", append(lengthPrefix(5), "abc"...), nil, io.ErrUnexpectedEOF},
+		{"one byte over the limit", lengthPrefix(maxMessageSize + 1), nil, errMessageTooLarge},
+		{"largest length", lengthPrefix(0xffffffff), nil, errMessageTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readMessage(bytes.NewReader(tt.frame))
+			if !errors.Is(err, tt.wantErr) || !bytes.Equal(got, tt.want) {
+				t.Fatalf("readMessage = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	messages := [][]byte{[]byte("version"), {}, bytes.Repeat([]byte{7}, 1000)}
+	for _, message := range messages {
+		if err := writeMessage(&buf, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range messages {
+		if got, err := readMessage(&buf); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("readMessage = %d bytes, %v, want %d bytes", len(got), err, len(want))
+		}
+	}
+
+	if err := writeMessage(&buf, make([]byte, maxMessageSize+1)); !errors.Is(err, errMessageTooLarge) {
+		t.Fatalf("writeMessage of an over-limit message = %v, want %v", err, errMessageTooLarge)
+	}
+}
+
+func TestOversizedFrameClosesConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	done := make(chan struct{})
+	go func() {
+		handleConnection(server, nil)
+		close(done)
+	}()
+
+	// Only the length is sent: the node must give up on it rather than allocate and wait for 4 GB
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write(lengthPrefix(0xffffffff)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read after an oversized frame = %d, %v, want the connection closed", n, err)
+	}
+	<-done
+
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > maxMessageSize {
+		t.Fatalf("%d bytes allocated for a rejected frame", allocated)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		theirs  int
+		want    int
+		wantErr bool
+	}{
+		{"same version", nodeVersion, nodeVersion, false},
+		{"newer peer falls back to ours", nodeVersion + 1, nodeVersion, false},
+		{"older than the minimum", minNodeVersion - 1, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := negotiateVersion(tt.theirs)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("negotiateVersion(%d) = %d, %v; want %d, error %v", tt.theirs, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVersionHandshake(t *testing.T) {
+	const compatible, incompatible = "127.0.0.1:1", "127.0.0.1:2"
+	useKnownNodes(t, "3000", "localhost:3000", []string{incompatible})
+	bc, _ := newTestChain(t)
+
+	for _, peer := range []versionMsg{{nodeVersion + 1, 1, compatible}, {minNodeVersion - 1, 1, incompatible}} {
+		handleVersion(append(commandToBytes("version"), gobEncode(peer)...), bc)
+	}
+
+	if info := peerInfos[compatible]; info == nil || info.Version != nodeVersion || info.BestHeight != 1 {
+		t.Fatalf("compatible peer recorded as %+v, want version %d at height 1", info, nodeVersion)
+	}
+	if containsNode(getKnownNodes(), incompatible) {
+		t.Fatal("incompatible peer kept")
+	}
+}
+
+func TestResolveListenAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"default", "", "", "localhost:3000", false},
+		{"from env", "", "0.0.0.0:4000", "0.0.0.0:4000", false},
+		{"flag over env", "127.0.0.1:5000", "0.0.0.0:4000", "127.0.0.1:5000", false},
+		{"IPv6", "[::1]:5000", "", "[::1]:5000", false},
+		{"no port", "127.0.0.1", "", "", true},
+		{"port out of range", "127.0.0.1:70000", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_ADDR", tt.env)
+			got, err := ResolveListenAddress(tt.flag, "3000")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("ResolveListenAddress(%q) = %q, %v; want %q, error %v", tt.flag, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalAddress(t *testing.T) {
+	tests := []struct {
+		listenAddr string
+		want       string
+	}{
+		{"localhost:3000", "localhost:3000"},
+		{"192.168.1.5:3000", "192.168.1.5:3000"},
+		{"0.0.0.0:3000", "localhost:3000"},
+		{"[::]:3000", "localhost:3000"},
+		{":3000", "localhost:3000"},
+	}
+
+	for _, tt := range tests {
+		if got := localAddress(tt.listenAddr); got != tt.want {
+			t.Errorf("localAddress(%q) = %q, want %q", tt.listenAddr, got, tt.want)
+		}
+	}
+}
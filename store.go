@@ -0,0 +1,137 @@
+package main
+
+import (
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Store is the key-value storage behind a Blockchain: named buckets of keys and values, read and written in transactions
+// bbolt is the default; memoryStore keeps everything in memory
+// Similar to Geth's ethdb.Database
+type Store interface {
+	// View runs fn in a read-only transaction
+	View(fn func(tx StoreTx) error) error
+	// Update runs fn in a read-write transaction, committed if fn returns nil and rolled back otherwise
+	Update(fn func(tx StoreTx) error) error
+	// Path returns the file holding the data, or "" if it isn't kept in a file
+	Path() string
+	Close() error
+}
+
+// StoreTx is a transaction of a Store
+type StoreTx interface {
+	// Bucket returns the named bucket, or nil if it doesn't exist
+	Bucket(name []byte) StoreBucket
+	CreateBucket(name []byte) (StoreBucket, error)
+	CreateBucketIfNotExists(name []byte) (StoreBucket, error)
+	DeleteBucket(name []byte) error
+	Writable() bool
+}
+
+// StoreBucket is a bucket of keys and values within a StoreTx
+// Values returned by Get and cursors are only valid until the transaction ends
+type StoreBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// ForEach calls fn for every key and value, in key order, stopping at the first error
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() StoreCursor
+}
+
+// StoreCursor iterates over a bucket's keys in order; a nil key means the end
+type StoreCursor interface {
+	First() (key, value []byte)
+	Next() (key, value []byte)
+}
+
+// boltStore is a Store kept in a bbolt DB file
+type boltStore struct {
+	db *bbolt.DB
+}
+
+// openBoltStore opens, or creates, the bbolt DB at path
+// A read-only store can be shared with other read-only processes; opening one waits up to timeout
+// for a writer to release the file, failing with bbolt.ErrTimeout
+func openBoltStore(path string, readOnly bool, timeout time.Duration) (*boltStore, error) {
+	options := *bbolt.DefaultOptions
+	options.ReadOnly = readOnly
+	options.Timeout = timeout
+
+	db, err := bbolt.Open(path, 0600, &options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Path() string {
+	return s.db.Path()
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// boltTx adapts a bbolt transaction to StoreTx
+type boltTx struct {
+	tx *bbolt.Tx
+}
+
+func (t boltTx) Bucket(name []byte) StoreBucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucket(name []byte) (StoreBucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	return t.tx.DeleteBucket(name)
+}
+
+func (t boltTx) Writable() bool {
+	return t.tx.Writable()
+}
+
+// boltBucket adapts a bbolt bucket to StoreBucket
+type boltBucket struct {
+	*bbolt.Bucket
+}
+
+func (b boltBucket) Cursor() StoreCursor {
+	return b.Bucket.Cursor()
+}
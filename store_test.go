@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreBackends(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) Store
+	}{
+		{"bbolt", func(t *testing.T) Store {
+			store, err := openBoltStore(filepath.Join(t.TempDir(), "blockchain.db"), false, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+		{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+	}
+	errFailed := errors.New("failed")
+
+	for _, backend := range backends {
+		t.Run(backend.name+"/buckets", func(t *testing.T) {
+			store := backend.open(t)
+			defer store.Close()
+
+			err := store.Update(func(tx StoreTx) error {
+				b, err := tx.CreateBucket([]byte("b"))
+				if err != nil {
+					return err
+				}
+				for _, k := range []string{"c", "a", "b"} {
+					if err := b.Put([]byte(k), []byte(strings.ToUpper(k))); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A failed update leaves no trace
+			err = store.Update(func(tx StoreTx) error {
+				if err := tx.Bucket([]byte("b")).Put([]byte("d"), []byte("D")); err != nil {
+					return err
+				}
+				if _, err := tx.CreateBucket([]byte("discarded")); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			if err != errFailed {
+				t.Fatalf("Update = %v, want the error of its function", err)
+			}
+
+			err = store.View(func(tx StoreTx) error {
+				if tx.Writable() || tx.Bucket([]byte("discarded")) != nil || tx.Bucket([]byte("missing")) != nil {
+					t.Error("view is writable or holds buckets that weren't committed")
+				}
+				b := tx.Bucket([]byte("b"))
+				if b.Get([]byte("d")) != nil || string(b.Get([]byte("a"))) != "A" {
+					t.Errorf("values %q and %q, want nothing and %q", b.Get([]byte("d")), b.Get([]byte("a")), "A")
+				}
+				if err := b.Put([]byte("e"), []byte("E")); err == nil {
+					t.Error("Put succeeded in a read-only transaction")
+				}
+
+				var keys []string
+				c := b.Cursor()
+				for k, _ := c.First(); k != nil; k, _ = c.Next() {
+					keys = append(keys, string(k))
+				}
+				if strings.Join(keys, "") != "abc" {
+					t.Errorf("cursor keys %v, want them in order", keys)
+				}
+
+				visited := 0
+				err := b.ForEach(func(k, v []byte) error {
+					visited++
+					return errFailed
+				})
+				if err != errFailed || visited != 1 {
+					t.Errorf("ForEach = %v after %d key(s), want the first error", err, visited)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(func(tx StoreTx) error {
+				if _, err := tx.CreateBucket([]byte("b")); err == nil {
+					t.Error("created a bucket twice")
+				}
+				if err := tx.DeleteBucket([]byte("missing")); err == nil {
+					t.Error("deleted a missing bucket")
+				}
+				if err := tx.Bucket([]byte("b")).Put(nil, []byte("X")); err == nil {
+					t.Error("put an empty key")
+				}
+				if _, err := tx.CreateBucketIfNotExists([]byte("b")); err != nil {
+					t.Errorf("CreateBucketIfNotExists on an existing bucket: %s", err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store.Close()
+			if err := store.View(func(tx StoreTx) error { return nil }); err == nil {
+				t.Fatal("View succeeded on a closed store")
+			}
+		})
+
+		t.Run(backend.name+"/chain", func(t *testing.T) {
+			bc, alice := newTestChainWithStore(t, backend.open(t))
+			bob := NewWallet()
+			spend := spendCoinbase(t, bc, alice, string(bob.GetAddress()), 1)
+			if err := bc.AddToMempool(spend); err != nil {
+				t.Fatal(err)
+			}
+			block := peerBlock(t, bc, NewCoinbaseTX(string(alice.GetAddress()), "", 1, 2), spend)
+			if err := bc.AddBlock(block); err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.RemoveMinedFromMempool([]*Transaction{spend}); err != nil {
+				t.Fatal(err)
+			}
+
+			if bc.GetBestHeight() != 2 || len(bc.GetMempool()) != 0 {
+				t.Fatalf("height %d with %d mempool transaction(s), want 2 and none", bc.GetBestHeight(), len(bc.GetMempool()))
+			}
+			for w, want := range map[*Wallet]int{alice: subsidy + 1, bob: subsidy - 1} {
+				if balance, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != want {
+					t.Errorf("balance %d, %v, want %d", balance, err, want)
+				}
+			}
+			if hash, err := (TxIndex{bc}).BlockHash(spend.ID); err != nil || !bytes.Equal(hash, block.Hash) {
+				t.Fatalf("transaction index maps the spend to %x, %v, want %x", hash, err, block.Hash)
+			}
+		})
+	}
+}
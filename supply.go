@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// BlockSubsidy returns the newly created coins a block at the given height may pay its miner
+// The genesis block instead pays the allocations of the network's genesis file
+func BlockSubsidy(height int) int {
+	return subsidy
+}
+
+// TotalSupply returns the value of all unspent outputs, i.e. every coin in circulation
+func (u UTXOSet) TotalSupply() (int, error) {
+	total := 0
+
+	err := u.bc.db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			return errors.New("UTXO set does not exist")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			outs, _, err := decodeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			for _, out := range outs {
+				total, err = addValue(total, out.Value)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	return total, err
+}
+
+// outputLookup finds the output an input spends among those created before the block being validated,
+// or returns nil if there is none
+type outputLookup func(vin TXInput) *TXOutput
+
+// checkCoinbase fails unless the block's first transaction, and only that one, is a coinbase
+// paying no more than the block subsidy plus the fees of the block's other transactions
+// Similar to Bitcoin's "bad-cb-amount" check in ConnectBlock
+func checkCoinbase(block *Block, height int, prevOutput outputLookup) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return errors.New("first transaction is not a coinbase")
+	}
+
+	// Outputs created earlier in the block can be spent by later transactions
+	inBlock := make(map[string]*Transaction)
+	fees := 0
+	for i, tx := range block.Transactions {
+		if i > 0 {
+			if tx.IsCoinbase() {
+				return fmt.Errorf("transaction %x is a second coinbase", tx.ID)
+			}
+			fee, err := blockTransactionFee(tx, inBlock, prevOutput)
+			if err != nil {
+				return fmt.Errorf("transaction %x: %s", tx.ID, err)
+			}
+			fees, err = addValue(fees, fee)
+			if err != nil {
+				return err
+			}
+		}
+		inBlock[fmt.Sprintf("%x", tx.ID)] = tx
+	}
+
+	reward, err := block.Transactions[0].OutputValue()
+	if err != nil {
+		return fmt.Errorf("coinbase: %s", err)
+	}
+	allowed, err := addValue(BlockSubsidy(height), fees)
+	if err != nil {
+		allowed = math.MaxInt
+	}
+	if reward > allowed {
+		return fmt.Errorf("coinbase pays %d, more than the subsidy %d plus fees %d", reward, BlockSubsidy(height), fees)
+	}
+
+	return nil
+}
+
+// blockTransactionFee returns the fee paid by tx as part of a block, whose inputs may spend
+// outputs of the block's earlier transactions (inBlock) or of any block before it (prevOutput)
+func blockTransactionFee(tx *Transaction, inBlock map[string]*Transaction, prevOutput outputLookup) (int, error) {
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		var prevOut *TXOutput
+		if prevTx := inBlock[fmt.Sprintf("%x", vin.Txid)]; prevTx != nil {
+			if vin.Vout >= 0 && vin.Vout < len(prevTx.Vout) {
+				prevOut = &prevTx.Vout[vin.Vout]
+			}
+		} else {
+			prevOut = prevOutput(vin)
+		}
+		if prevOut == nil {
+			return 0, fmt.Errorf("input %x:%d spends an unknown output", vin.Txid, vin.Vout)
+		}
+
+		inputValue, err = addValue(inputValue, prevOut.Value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if inputValue < outputValue {
+		return 0, fmt.Errorf("outputs (%d) exceed inputs (%d)", outputValue, inputValue)
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// prevOutputLookup returns the outputLookup for a block about to be connected to a stored parent
+// The UTXO set can only answer for blocks built on the tip it reflects; others search the parent's ancestors
+func (bc *Blockchain) prevOutputLookup(block *Block) outputLookup {
+	utxos := UTXOSet{bc}
+	if bytes.Equal(block.PrevBlockHash, bc.Tip()) && utxos.IsCurrent() {
+		return func(vin TXInput) *TXOutput {
+			outs, err := utxos.FindOutputs(vin.Txid)
+			if err != nil {
+				return nil
+			}
+			if out, ok := outs[vin.Vout]; ok {
+				return &out
+			}
+			return nil
+		}
+	}
+
+	return func(vin TXInput) *TXOutput {
+		prevTx, err := bc.findTransactionBefore(block.PrevBlockHash, vin.Txid)
+		if err != nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return nil
+		}
+		return &prevTx.Vout[vin.Vout]
+	}
+}
+
+// utxoLookupInTx returns an outputLookup reading the UTXO set within tx,
+// for blocks connected in that same transaction
+func utxoLookupInTx(tx StoreTx) outputLookup {
+	return func(vin TXInput) *TXOutput {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get(vin.Txid)
+		if data == nil {
+			return nil
+		}
+		outs, _, err := decodeUTXOEntry(data)
+		if err != nil {
+			return nil
+		}
+		if out, ok := outs[vin.Vout]; ok {
+			return &out
+		}
+		return nil
+	}
+}
+
+// findTransactionBefore finds a transaction in the block blockHash or its ancestors,
+// which need not be on the main chain
+func (bc *Blockchain) findTransactionBefore(blockHash, txID []byte) (*Transaction, error) {
+	bci := &BlockchainIterator{blockHash, bc.db, bc.blocks}
+
+	for len(bci.currentHash) > 0 {
+		block := bci.Next()
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				return tx, nil
+			}
+		}
+	}
+
+	return nil, errors.New("Transaction is not found")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddBlockChecksCoinbase(t *testing.T) {
+	tests := []struct {
+		name     string
+		spendFee int // Fee of a payment in the block; 0 for a coinbase-only block
+		claimed  int // Fees the coinbase claims on top of the subsidy
+		second   bool
+		wantErr  string
+	}{
+		{"subsidy only", 0, 0, false, ""},
+		{"subsidy and fees", 2, 2, false, ""},
+		{"less than allowed", 2, 1, false, ""},
+		{"subsidy plus one", 0, 1, false, "more than the subsidy"},
+		{"subsidy, fees plus one", 2, 3, false, "more than the subsidy"},
+		{"second coinbase", 0, 0, true, "second coinbase"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+
+			txs := []*Transaction{NewCoinbaseTX(address, "", tt.claimed, 2)}
+			if tt.spendFee > 0 {
+				txs = append(txs, spendCoinbase(t, bc, w, address, tt.spendFee))
+			}
+			if tt.second {
+				txs = append(txs, NewCoinbaseTX(address, "second", 0, 2))
+			}
+
+			err := bc.AddBlock(peerBlock(t, bc, txs...))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("AddBlock: %s", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("AddBlock = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// maxInvItems caps the number of block hashes announced in one inv message
+const maxInvItems = 500
+
+// maxHeaderItems caps the number of headers sent in one headers message
+const maxHeaderItems = 2000
+
+// maxBlocksInFlight is how many block bodies are requested at once during sync
+const maxBlocksInFlight = 16
+
+// syncStallTimeout abandons a sync whose peer has stopped delivering headers or blocks
+const syncStallTimeout = 2 * time.Minute
+
+// syncState is a stage of the headers-first download state machine
+type syncState int
+
+const (
+	stateSynced         syncState = iota // Not downloading; the chain is as long as any peer reported
+	stateWaitingHeaders                  // Sent getheaders, waiting for the next batch of headers
+	stateDownloading                     // Fetching the bodies of the validated header chain
+)
+
+func (s syncState) String() string {
+	switch s {
+	case stateWaitingHeaders:
+		return "waiting-headers"
+	case stateDownloading:
+		return "downloading"
+	default:
+		return "synced"
+	}
+}
+
+// SyncManager drives headers-first sync from a single peer
+// It first downloads and validates the header chain (proof of work and linkage) in batches of up to
+// maxHeaderItems, then fetches the missing bodies, up to maxBlocksInFlight at a time.
+// Bodies may arrive out of order; the orphan pool holds them until their parents are connected
+// Similar to Geth's downloader, greatly simplified
+type SyncManager struct {
+	mu           sync.Mutex
+	state        syncState
+	peer         string   // Peer we're downloading from
+	pending      [][]byte // Hashes of bodies still to request, oldest first
+	inTransit    [][]byte // Hashes of bodies requested but not yet received
+	lastProgress time.Time
+}
+
+// syncer is the node's block download state machine
+var syncer = &SyncManager{}
+
+// IsSynced reports whether the node has finished downloading blocks from its peers
+// A node must not mine while it's still syncing, since its chain may be incomplete
+func (sm *SyncManager) IsSynced() bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.state == stateSynced
+}
+
+// State returns the current stage of the state machine
+func (sm *SyncManager) State() syncState {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.state
+}
+
+// Expects reports whether the body of a block has been requested, or is about to be
+func (sm *SyncManager) Expects(hash []byte) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return containsHash(sm.inTransit, hash) || containsHash(sm.pending, hash)
+}
+
+// Start begins syncing from peer unless a sync is already running
+func (sm *SyncManager) Start(peer string, bc *Blockchain) {
+	sm.mu.Lock()
+	if sm.state != stateSynced {
+		sm.mu.Unlock()
+		return
+	}
+	sm.state = stateWaitingHeaders
+	sm.peer = peer
+	sm.lastProgress = time.Now()
+	sm.mu.Unlock()
+
+	logger.Infof("Syncing headers from %s", peer)
+	sendGetHeaders(peer, bc.BlockLocator())
+}
+
+// HandleInv starts a sync with a peer announcing blocks we don't have
+func (sm *SyncManager) HandleInv(from string, hashes [][]byte, bc *Blockchain) {
+	for _, hash := range hashes {
+		if !bc.HasBlock(hash) {
+			sm.Start(from, bc)
+			return
+		}
+	}
+}
+
+// HandleHeaders stores a batch of headers from the sync peer and asks for the next one
+// Once a batch comes back short the header chain is complete and body download begins
+func (sm *SyncManager) HandleHeaders(from string, headers []BlockHeader, bc *Blockchain) {
+	sm.mu.Lock()
+	if sm.state != stateWaitingHeaders || from != sm.peer {
+		sm.mu.Unlock()
+		return
+	}
+	sm.lastProgress = time.Now()
+	sm.mu.Unlock()
+
+	_, err := bc.AddHeaders(headers)
+	if err != nil {
+		logger.Warnf("Rejecting headers from %s: %s", from, err)
+		sm.abort()
+		return
+	}
+
+	headerHeight, blockHeight := bc.SyncProgress()
+	logger.Infof("Sync progress: %d header(s), %d block(s)", headerHeight, blockHeight)
+
+	if len(headers) >= maxHeaderItems {
+		sendGetHeaders(from, [][]byte{headers[len(headers)-1].Hash})
+		return
+	}
+
+	missing, err := bc.MissingBodies()
+	if err != nil {
+		logger.Errorf("Listing missing blocks: %s", err)
+		sm.abort()
+		return
+	}
+
+	sm.mu.Lock()
+	sm.pending = missing
+	sm.inTransit = nil
+	sm.state = stateDownloading
+	sm.mu.Unlock()
+
+	sm.requestBodies(bc)
+}
+
+// HandleBlock marks a body as received and requests more to keep maxBlocksInFlight in transit
+func (sm *SyncManager) HandleBlock(from string, hash []byte, bc *Blockchain) {
+	sm.mu.Lock()
+	if sm.state != stateDownloading || from != sm.peer {
+		sm.mu.Unlock()
+		return
+	}
+
+	var remaining [][]byte
+	for _, h := range sm.inTransit {
+		if !bytes.Equal(h, hash) {
+			remaining = append(remaining, h)
+		}
+	}
+	sm.inTransit = remaining
+	sm.lastProgress = time.Now()
+	sm.mu.Unlock()
+
+	sm.requestBodies(bc)
+}
+
+// requestBodies fills the download window, and marks the node synced once every body has arrived
+func (sm *SyncManager) requestBodies(bc *Blockchain) {
+	sm.mu.Lock()
+	var requests [][]byte
+	for len(sm.pending) > 0 && len(sm.inTransit) < maxBlocksInFlight {
+		hash := sm.pending[0]
+		sm.pending = sm.pending[1:]
+		sm.inTransit = append(sm.inTransit, hash)
+		requests = append(requests, hash)
+	}
+	done := len(sm.pending) == 0 && len(sm.inTransit) == 0
+	peer := sm.peer
+	if done {
+		sm.state = stateSynced
+	}
+	sm.mu.Unlock()
+
+	for _, hash := range requests {
+		sendGetData(peer, "block", hash)
+	}
+	if done {
+		logger.Infof("Synced at height %d", bc.GetBestHeight())
+	}
+}
+
+// abort gives up on the current sync, so it can be retried with another peer
+func (sm *SyncManager) abort() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.state = stateSynced
+	sm.pending = nil
+	sm.inTransit = nil
+}
+
+// CheckStalled gives up on a sync whose peer stopped responding, so another peer can be used
+func (sm *SyncManager) CheckStalled() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state != stateSynced && time.Since(sm.lastProgress) > syncStallTimeout {
+		logger.Warnf("Sync from %s stalled, giving up", sm.peer)
+		sm.state = stateSynced
+		sm.pending = nil
+		sm.inTransit = nil
+	}
+}
+
+// containsHash reports whether hashes includes hash
+func containsHash(hashes [][]byte, hash []byte) bool {
+	for _, h := range hashes {
+		if bytes.Equal(h, hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blockHashesAfter returns up to limit hashes of the blocks following the latest block of locator on
+// the main chain, oldest first. If locator shares no block with it, the hashes start at the genesis block
+func (bc *Blockchain) blockHashesAfter(locator [][]byte, limit int) [][]byte {
+	hashes := bc.GetBlockHashes()
+
+	// GetBlockHashes lists tip first; walk it backwards to go from genesis to tip
+	start := len(hashes) - 1
+	if i := forkPoint(hashes, locator); i >= 0 {
+		start = i - 1
+	}
+
+	var batch [][]byte
+	for i := start; i >= 0 && len(batch) < limit; i-- {
+		batch = append(batch, hashes[i])
+	}
+
+	return batch
+}
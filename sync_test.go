@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+// mockPeer is a peer listening on a local port, whose chain is that of a test chain up to a stored block
+// followed by a branch of unstored blocks. The test reads the requests it receives and answers them
+type mockPeer struct {
+	addr     string
+	bc       *Blockchain
+	chain    [][]byte          // Hashes of the peer's chain, tip first, as GetBlockHashes lists them
+	branch   map[string]*Block // The peer's blocks that bc doesn't store, by hash
+	requests chan []byte
+}
+
+// newMockPeer starts a peer whose chain is bc's up to the stored block parent, followed by branch
+func newMockPeer(t *testing.T, bc *Blockchain, parent []byte, branch []*Block) *mockPeer {
+	t.Helper()
+
+	listener, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	p := &mockPeer{listener.Addr().String(), bc, nil, make(map[string]*Block), make(chan []byte, 1000)}
+	for i := len(branch) - 1; i >= 0; i-- {
+		p.chain = append(p.chain, branch[i].Hash)
+		p.branch[hex.EncodeToString(branch[i].Hash)] = branch[i]
+	}
+	for hash := parent; len(hash) > 0; {
+		p.chain = append(p.chain, hash)
+		header, err := bc.GetBlockHeader(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash = header.PrevBlockHash
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if message, err := readMessage(conn); err == nil {
+				p.requests <- message
+			}
+			conn.Close()
+		}
+	}()
+
+	return p
+}
+
+// nextRequest waits for the next message sent to the peer and decodes its payload into payload
+// It returns the message's command
+func (p *mockPeer) nextRequest(t *testing.T, payload interface{}) string {
+	t.Helper()
+
+	select {
+	case message := <-p.requests:
+		if err := gob.NewDecoder(bytes.NewReader(message[commandLength:])).Decode(payload); err != nil {
+			t.Fatal(err)
+		}
+		return bytesToCommand(message[:commandLength])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request reached the peer")
+		return ""
+	}
+}
+
+// block returns the peer's block hash
+func (p *mockPeer) block(t *testing.T, hash []byte) *Block {
+	t.Helper()
+
+	if block, ok := p.branch[hex.EncodeToString(hash)]; ok {
+		return block
+	}
+	block, err := p.bc.GetBlock(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &block
+}
+
+// headersAfter answers a getheaders request as handleGetHeaders does
+func (p *mockPeer) headersAfter(t *testing.T, request getheaders) []BlockHeader {
+	t.Helper()
+
+	start := len(p.chain) - 1
+	if i := forkPoint(p.chain, requestLocator(request.From, request.Locator)); i >= 0 {
+		start = i - 1
+	}
+
+	var items []BlockHeader
+	for i := start; i >= 0 && len(items) < maxHeaderItems; i-- {
+		items = append(items, p.block(t, p.chain[i]).Header())
+	}
+
+	return items
+}
+
+// useSyncer gives the test its own sync state machine and orphan pool
+func useSyncer(t *testing.T) {
+	t.Helper()
+
+	savedSyncer, savedOrphans := syncer, orphans
+	t.Cleanup(func() { syncer, orphans = savedSyncer, savedOrphans })
+	syncer, orphans = &SyncManager{}, NewOrphanPool()
+}
+
+func TestSyncFromGenesis(t *testing.T) {
+	useSyncer(t)
+	bc, w := newTestChain(t)
+	branch := chainBlocks(t, bc, coinbaseBlocks(bc, string(w.GetAddress()), maxBlocksInFlight+5)...)
+	peer := newMockPeer(t, bc, bc.Tip(), branch)
+
+	syncer.Start(peer.addr, bc)
+	if syncer.State() != stateWaitingHeaders || syncer.IsSynced() {
+		t.Fatalf("state %s after starting, want %s", syncer.State(), stateWaitingHeaders)
+	}
+
+	var request getheaders
+	if command := peer.nextRequest(t, &request); command != "getheaders" {
+		t.Fatalf("first request is %s, want getheaders", command)
+	}
+	syncer.HandleHeaders(peer.addr, peer.headersAfter(t, request), bc)
+	if syncer.State() != stateDownloading {
+		t.Fatalf("state %s after the headers, want %s", syncer.State(), stateDownloading)
+	}
+
+	// Answer the body requests in pairs, the later block first, so some arrive before their parent
+	requested := 0
+	for syncer.State() != stateSynced {
+		var hashes [][]byte
+		for len(hashes) < 2 && requested < len(branch) {
+			var request getdata
+			if command := peer.nextRequest(t, &request); command != "getdata" || request.Type != "block" {
+				t.Fatalf("request %s for %s, want getdata for a block", command, request.Type)
+			}
+			hashes = append(hashes, request.ID)
+			requested++
+		}
+		if len(hashes) == 0 {
+			t.Fatalf("every block delivered, yet state is %s", syncer.State())
+		}
+
+		for i := len(hashes) - 1; i >= 0; i-- {
+			processBlock(peer.addr, peer.block(t, hashes[i]), bc)
+		}
+		if inTransit := len(syncer.inTransit); inTransit > maxBlocksInFlight {
+			t.Fatalf("%d block(s) in transit, more than %d", inTransit, maxBlocksInFlight)
+		}
+	}
+
+	if requested != len(branch) || !bytes.Equal(bc.Tip(), branch[len(branch)-1].Hash) {
+		t.Fatalf("synced at height %d after %d request(s), want %d", bc.GetBestHeight(), requested, len(branch)+1)
+	}
+}
+
+func TestHeadersFirstSyncInBatches(t *testing.T) {
+	useSyncer(t)
+	// Blocks a second apart would retarget long before the second batch
+	useNetwork(t, 0, activeNetwork.TargetBlockInterval)
+	bc, w := newTestChain(t)
+	bc.sealer = InstantSealer{}
+	branch := chainBlocks(t, bc, coinbaseBlocks(bc, string(w.GetAddress()), maxHeaderItems+3)...)
+	peer := newMockPeer(t, bc, bc.Tip(), branch)
+
+	syncer.Start(peer.addr, bc)
+	var request getheaders
+	if command := peer.nextRequest(t, &request); command != "getheaders" {
+		t.Fatalf("first request is %s, want getheaders", command)
+	}
+
+	// A full batch asks for the headers after its last one
+	first := peer.headersAfter(t, request)
+	if len(first) != maxHeaderItems {
+		t.Fatalf("peer answered %d headers, want a full batch", len(first))
+	}
+	syncer.HandleHeaders(peer.addr, first, bc)
+	if command := peer.nextRequest(t, &request); command != "getheaders" || len(request.Locator) != 1 ||
+		!bytes.Equal(request.Locator[0], first[len(first)-1].Hash) {
+		t.Fatalf("after a full batch requested %s from %x", command, request.Locator)
+	}
+	if headerHeight, blockHeight := bc.SyncProgress(); headerHeight != maxHeaderItems+1 || blockHeight != 1 ||
+		syncer.State() != stateWaitingHeaders {
+		t.Fatalf("%d header(s) and %d block(s) in state %s after the first batch", headerHeight, blockHeight, syncer.State())
+	}
+
+	// The short batch completes the header chain, and the oldest bodies are requested
+	syncer.HandleHeaders(peer.addr, peer.headersAfter(t, request), bc)
+	if headerHeight, blockHeight := bc.SyncProgress(); headerHeight != len(branch)+1 || blockHeight != 1 ||
+		syncer.State() != stateDownloading {
+		t.Fatalf("%d header(s) and %d block(s) in state %s after the last batch", headerHeight, blockHeight, syncer.State())
+	}
+	if missing, err := bc.MissingBodies(); err != nil || len(missing) != len(branch) {
+		t.Fatalf("%d missing bodies, %v, want %d", len(missing), err, len(branch))
+	}
+	var oldest [][]byte
+	for _, block := range branch[:maxBlocksInFlight] {
+		oldest = append(oldest, block.Hash)
+	}
+	for i := 0; i < maxBlocksInFlight; i++ {
+		var body getdata
+		if command := peer.nextRequest(t, &body); command != "getdata" || !containsHash(oldest, body.ID) {
+			t.Fatalf("request %d is %s for %x, want one of the oldest bodies", i, command, body.ID)
+		}
+	}
+}
+
+func TestSyncRejectsBadHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      string                                    // Sender of the headers; the sync peer if empty
+		tamper    func(headers []BlockHeader) []BlockHeader // Applied to the peer's answer
+		wantState syncState
+	}{
+		{"tampered header", "", func(headers []BlockHeader) []BlockHeader {
+			headers[1].Nonce++
+			return headers
+		}, stateSynced},
+		{"not extending the chain", "", func(headers []BlockHeader) []BlockHeader {
+			return headers[1:]
+		}, stateSynced},
+		{"gap between headers", "", func(headers []BlockHeader) []BlockHeader {
+			return append(headers[:1], headers[2:]...)
+		}, stateSynced},
+		{"easier target", "", func(headers []BlockHeader) []BlockHeader {
+			headers[0].Difficulty--
+			headers[0].Hash = headers[0].CalculateHash()
+			return headers
+		}, stateSynced},
+		{"from another peer", "127.0.0.1:1", nil, stateWaitingHeaders},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSyncer(t)
+			bc, w := newTestChain(t)
+			branch := chainBlocks(t, bc, coinbaseBlocks(bc, string(w.GetAddress()), 3)...)
+			peer := newMockPeer(t, bc, bc.Tip(), branch)
+
+			syncer.Start(peer.addr, bc)
+			var request getheaders
+			peer.nextRequest(t, &request)
+
+			headers := peer.headersAfter(t, request)
+			if tt.tamper != nil {
+				headers = tt.tamper(headers)
+			}
+			from := tt.from
+			if from == "" {
+				from = peer.addr
+			}
+			syncer.HandleHeaders(from, headers, bc)
+
+			if syncer.State() != tt.wantState {
+				t.Fatalf("state %s, want %s", syncer.State(), tt.wantState)
+			}
+			if headerHeight, _ := bc.SyncProgress(); headerHeight != 1 {
+				t.Fatalf("header chain at height %d, want the headers rejected", headerHeight)
+			}
+			select {
+			case <-peer.requests:
+				t.Fatal("peer asked for more after rejected headers")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestSyncFromForkPoint(t *testing.T) {
+	useSyncer(t)
+	bc, w := newTestChain(t)
+	address := string(w.GetAddress())
+	if err := bc.AddBlocks(chainBlocks(t, bc, coinbaseBlocks(bc, address, 4)...)); err != nil {
+		t.Fatal(err)
+	}
+	fork := bc.Tip()
+	if err := bc.AddBlocks(chainBlocks(t, bc, coinbaseBlocks(bc, address, 2*locatorDenseEntries)...)); err != nil {
+		t.Fatal(err)
+	}
+	// The peer's branch forks below the blocks the locator lists one by one, and is longer than ours
+	branch := branchBlocks(t, bc, fork, coinbaseBlocks(bc, string(NewWallet().GetAddress()), 3*locatorDenseEntries)...)
+	peer := newMockPeer(t, bc, fork, branch)
+
+	syncer.Start(peer.addr, bc)
+	var request getheaders
+	if command := peer.nextRequest(t, &request); command != "getheaders" {
+		t.Fatalf("first request is %s, want getheaders", command)
+	}
+
+	// The locator runs from our tip to the genesis block, skipping ever more blocks
+	hashes := bc.GetBlockHashes()
+	locator := request.Locator
+	if !bytes.Equal(locator[0], hashes[0]) || !bytes.Equal(locator[len(locator)-1], hashes[len(hashes)-1]) {
+		t.Fatalf("locator runs from %x to %x, want from the tip to the genesis block", locator[0], locator[len(locator)-1])
+	}
+	if len(locator) >= len(hashes) {
+		t.Fatalf("locator lists %d of %d blocks, want it to skip some", len(locator), len(hashes))
+	}
+
+	// The peer answers from the latest locator block at or below the fork
+	forkDepth := forkPoint(hashes, [][]byte{fork})
+	var shared []byte
+	for _, hash := range locator {
+		if forkPoint(hashes, [][]byte{hash}) >= forkDepth {
+			shared = hash
+			break
+		}
+	}
+	headers := peer.headersAfter(t, request)
+	if len(headers) == 0 || !bytes.Equal(headers[0].PrevBlockHash, shared) {
+		t.Fatalf("peer answered from %x, want the shared block %x", headers[0].PrevBlockHash, shared)
+	}
+	if want := len(branch) + forkPoint(hashes, [][]byte{shared}) - forkDepth; len(headers) != want {
+		t.Fatalf("peer answered %d headers, want %d", len(headers), want)
+	}
+	if !bytes.Equal(headers[len(headers)-1].Hash, branch[len(branch)-1].Hash) {
+		t.Fatal("headers don't end at the peer's tip")
+	}
+
+	syncer.HandleHeaders(peer.addr, headers, bc)
+	if syncer.State() != stateDownloading {
+		t.Fatalf("state %s after the headers, want %s", syncer.State(), stateDownloading)
+	}
+}
@@ -2,27 +2,51 @@ package main
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/asn1"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
-	"os"
+	"strconv"
 	"strings"
 )
 
 const subsidy = 10 // Mining reward
 
+// errValueOverflow is returned when coin amounts don't fit in an int
+var errValueOverflow = errors.New("coin amount overflows")
+
+// sigScalarLen is the byte length of each of r and s in a P-256 signature
+const sigScalarLen = 32
+
+// pubKeyLen is the byte length of an uncompressed P-256 public key (X || Y, without prefix)
+const pubKeyLen = 2 * sigScalarLen
+
+// maxCoinbaseDataLen is the largest data a coinbase input may carry
+// Similar to Bitcoin's 100-byte coinbase script limit
+const maxCoinbaseDataLen = 100
+
 // Transaction represents a blockchain transaction
 // Similar to Geth's types.Transaction
 type Transaction struct {
 	ID   []byte
 	Vin  []TXInput
 	Vout []TXOutput
+
+	// Replaceable signals that a mempool transaction spending the same inputs with a higher fee may replace this one
+	// Similar to Bitcoin's opt-in replace-by-fee (BIP 125)
+	Replaceable bool
+
+	// Memo is an optional note for bookkeeping, at most maxMemoSize bytes
+	// It's covered by the ID and signatures but has no effect on validation
+	Memo []byte
 }
 
 // IsCoinbase checks whether the transaction is coinbase (mining reward)
@@ -30,20 +54,108 @@ func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// Serialize returns a serialized Transaction
+// OutputValue returns the total value of the outputs
+// Every output must carry a positive value (data outputs none) and the sum must not overflow
+func (tx *Transaction) OutputValue() (int, error) {
+	total := 0
+
+	for i, out := range tx.Vout {
+		if out.IsData() {
+			if out.Value != 0 || len(out.PubKeyHash) != 0 || out.IsMultisig() || out.ScriptHash || len(out.Data) > maxDataOutputSize {
+				return 0, fmt.Errorf("output %d is a malformed data output", i)
+			}
+			continue
+		}
+		if out.Value <= 0 {
+			return 0, fmt.Errorf("output %d has non-positive value %d", i, out.Value)
+		}
+
+		var err error
+		total, err = addValue(total, out.Value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return total, nil
+}
+
+// addValue adds two coin amounts, failing instead of wrapping around on overflow
+func addValue(a, b int) (int, error) {
+	if (b > 0 && a > math.MaxInt-b) || (b < 0 && a < math.MinInt-b) {
+		return 0, errValueOverflow
+	}
+
+	return a + b, nil
+}
+
+// ParseOutpoints parses a comma-separated list of TXID:VOUT outpoints
+// The result maps each transaction ID (in hex) to its output indexes, like FindSpendableOutputs
+func ParseOutpoints(list string) (map[string][]int, error) {
+	outpoints := make(map[string][]int)
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		txid, vout, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("outpoint %q is not in TXID:VOUT form", entry)
+		}
+		txID, err := hex.DecodeString(txid)
+		if err != nil || len(txID) != sha256.Size {
+			return nil, fmt.Errorf("outpoint %q has an invalid transaction ID", entry)
+		}
+		outIdx, err := strconv.Atoi(vout)
+		if err != nil || outIdx < 0 {
+			return nil, fmt.Errorf("outpoint %q has an invalid output index", entry)
+		}
+		txid = hex.EncodeToString(txID)
+		outpoints[txid] = append(outpoints[txid], outIdx)
+	}
+
+	return outpoints, nil
+}
+
+// sendTotal validates a payment and returns the value its inputs must cover
+func sendTotal(amount, fee int) (int, error) {
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount %d must be positive", amount)
+	}
+	if fee < 0 {
+		return 0, fmt.Errorf("fee %d must not be negative", fee)
+	}
+
+	total, err := addValue(amount, fee)
+	if err != nil {
+		return 0, fmt.Errorf("amount plus fee: %s", err)
+	}
+
+	return total, nil
+}
+
+// Serialize returns a serialized Transaction, encoded with the versioned codec
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	return EncodeTransaction(&tx)
+}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+// SerializedSize returns the number of bytes the Transaction takes when serialized
+// Similar to Bitcoin's GetSerializeSize, used for fee rates and block packing
+func (tx Transaction) SerializedSize() int {
+	return len(tx.Serialize())
+}
+
+// DeserializeTransaction deserializes a transaction from bytes (codec or legacy gob)
+func DeserializeTransaction(data []byte) Transaction {
+	tx, err := DecodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return encoded.Bytes()
+	return *tx
 }
 
-// Hash returns the hash of the Transaction
+// Hash returns the hash of the Transaction, which is its ID
+// It covers the canonical codec encoding of a copy with the ID cleared, signatures included,
+// so the same transaction always hashes to the same ID
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
@@ -55,37 +167,101 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
-// Sign signs each input of a Transaction
-// Similar to Geth's crypto.Sign()
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// Sign signs each input of a Transaction that privKey is able to unlock
+// Multisig inputs collect one signature per call, so an M-of-N spend calls Sign once per signer
+// Inputs spending a script hash output must already hold the redeem script in PubKey
+// The chain ID is part of the signed data, so the signatures are only valid on that chain
+// Fails if an input references an output missing from prevTXs
+// Similar to Geth's crypto.Sign() with an EIP-155 signer
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction, chainID int64) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
 	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return fmt.Errorf("referenced input %x:%d not found", vin.Txid, vin.Vout)
 		}
 	}
 
+	pubKey := encodePubKey(privKey.PublicKey)
+	pubKeyHash := HashPubKey(pubKey)
 	txCopy := tx.TrimmedCopy()
 
 	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
 		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		txCopy.Vin[inID].PubKey = prevOut.PubKeyHash
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+		dataToSign := signingHash(txCopy, chainID)
+		txCopy.Vin[inID].PubKey = nil
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
-		if err != nil {
-			log.Panic(err)
+		if script, ok := spentScript(prevOut, tx.Vin[inID]); ok {
+			keyIdx := script.KeyIndex(pubKey)
+			if keyIdx < 0 || !bytes.Equal(script.Hash(), prevOut.PubKeyHash) {
+				continue
+			}
+			if len(tx.Vin[inID].Signatures) != len(script.PubKeys) {
+				tx.Vin[inID].Signatures = make([][]byte, len(script.PubKeys))
+			}
+			tx.Vin[inID].Signatures[keyIdx] = signData(privKey, dataToSign)
+			continue
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+		if !bytes.Equal(prevOut.PubKeyHash, pubKeyHash) {
+			continue
+		}
+		tx.Vin[inID].Signature = signData(privKey, dataToSign)
+	}
+
+	return nil
+}
+
+// signingHash returns the digest signed for one input of a trimmed transaction copy: the SHA-256 of the chain ID
+// followed by the codec encoding of the copy. The ID is left out, as it changes once the signatures are added
+// Mixing in the chain ID prevents a transaction from being replayed on another chain
+// Similar to Bitcoin's SignatureHash
+func signingHash(txCopy Transaction, chainID int64) []byte {
+	txCopy.ID = nil
+
+	var buf bytes.Buffer
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], uint64(chainID))
+	buf.Write(id[:])
+	buf.Write(txCopy.Serialize())
+	hash := sha256.Sum256(buf.Bytes())
+
+	return hash[:]
+}
+
+// signData signs a 32-byte digest with privKey and returns the signature as r || s
+// The nonce is derived from the key and digest (RFC 6979), so signing the same digest twice gives the same signature
+// and a weak random source can't leak the key. S is normalized to the lower half of the curve order
+// so the signature is canonical
+// Similar to Bitcoin Core's CKey::Sign
+func signData(privKey ecdsa.PrivateKey, digest []byte) []byte {
+	der, err := privKey.Sign(nil, digest, crypto.SHA256)
+	if err != nil {
+		log.Panic(err)
+	}
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		log.Panic(err)
+	}
+	r, s := sig.R, sig.S
+
+	curveOrder := privKey.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(curveOrder, 1)) > 0 {
+		s.Sub(curveOrder, s)
 	}
+
+	// Pad both halves so the signature always splits evenly into r and s
+	signature := make([]byte, 2*sigScalarLen)
+	r.FillBytes(signature[:sigScalarLen])
+	s.FillBytes(signature[sigScalarLen:])
+
+	return signature
 }
 
 // String returns a human-readable representation of a transaction
@@ -93,6 +269,12 @@ func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.Replaceable {
+		lines = append(lines, "     Replaceable: yes")
+	}
+	if len(tx.Memo) > 0 {
+		lines = append(lines, fmt.Sprintf("     Memo: %q", tx.Memo))
+	}
 
 	for i, input := range tx.Vin {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
@@ -100,12 +282,25 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
 		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
 		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		for j, sig := range input.Signatures {
+			lines = append(lines, fmt.Sprintf("       Multisig %d: %x", j, sig))
+		}
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
+		if output.IsData() {
+			lines = append(lines, fmt.Sprintf("       Data:   %x", output.Data))
+			continue
+		}
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
 		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		if output.IsMultisig() {
+			lines = append(lines, fmt.Sprintf("       Multisig: %d-of-%d", output.Multisig.M, len(output.Multisig.PubKeys)))
+		}
+		if output.ScriptHash {
+			lines = append(lines, "       Script hash: yes")
+		}
 	}
 
 	return strings.Join(lines, "\n")
@@ -117,129 +312,572 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil, nil})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash, vout.Multisig, vout.Data, vout.ScriptHash})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.Replaceable, tx.Memo}
 
 	return txCopy
 }
 
-// Verify verifies signatures of Transaction inputs
+// ValidateInputs checks that every input carries a well-formed key and signatures of the expected sizes
+// It's cheap and needs no chain lookups, so junk inputs are rejected before anything else is done with them
+func (tx *Transaction) ValidateInputs() error {
+	if tx.IsCoinbase() {
+		in := tx.Vin[0]
+		if len(in.Signature) != 0 && len(in.Signature) != 8 {
+			return fmt.Errorf("coinbase height has %d bytes, want 8", len(in.Signature))
+		}
+		if len(in.PubKey) > maxCoinbaseDataLen {
+			return fmt.Errorf("coinbase data has %d bytes, more than %d", len(in.PubKey), maxCoinbaseDataLen)
+		}
+		return nil
+	}
+
+	for i, in := range tx.Vin {
+		if in.Signatures != nil {
+			// Multisig spend: PubKey is the script and each key has a signature slot
+			script, err := DeserializeMultisigScript(in.PubKey)
+			if err != nil {
+				return fmt.Errorf("input %d: %s", i, err)
+			}
+			if len(in.Signature) != 0 || len(in.Signatures) != len(script.PubKeys) {
+				return fmt.Errorf("input %d: malformed multisig signatures", i)
+			}
+			for _, sig := range in.Signatures {
+				if sig != nil && len(sig) != 2*sigScalarLen {
+					return fmt.Errorf("input %d: multisig signature has %d bytes, want %d", i, len(sig), 2*sigScalarLen)
+				}
+			}
+			continue
+		}
+
+		if len(in.PubKey) != pubKeyLen {
+			return fmt.Errorf("input %d: public key has %d bytes, want %d", i, len(in.PubKey), pubKeyLen)
+		}
+		x := new(big.Int).SetBytes(in.PubKey[:sigScalarLen])
+		y := new(big.Int).SetBytes(in.PubKey[sigScalarLen:])
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return fmt.Errorf("input %d: public key is not a P-256 point", i)
+		}
+		if len(in.Signature) != 2*sigScalarLen {
+			return fmt.Errorf("input %d: signature has %d bytes, want %d", i, len(in.Signature), 2*sigScalarLen)
+		}
+	}
+
+	return nil
+}
+
+// Failure classes of transaction inputs, wrapped in an InputError
+var (
+	errMissingPrevTx = errors.New("previous transaction not found")
+	errBadSignature  = errors.New("invalid signature")
+	errDoubleSpend   = errors.New("double spend")
+)
+
+// InputError reports which input of a transaction failed verification, and why
+type InputError struct {
+	Index int    // Position of the input in the transaction
+	Txid  []byte // Transaction of the spent output
+	Vout  int    // Index of the spent output
+	Err   error  // errMissingPrevTx, errBadSignature or errDoubleSpend, possibly wrapped with details
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("input %d (%x:%d): %s", e.Index, e.Txid, e.Vout, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// inputError returns an InputError for input inID of tx
+func (tx *Transaction) inputError(inID int, err error) *InputError {
+	vin := tx.Vin[inID]
+
+	return &InputError{inID, vin.Txid, vin.Vout, err}
+}
+
+// Verify verifies signatures of Transaction inputs for the given chain ID
+// Returns an InputError for the first input whose spent output isn't in prevTXs or whose signature is invalid
 // Similar to Geth's crypto.VerifySignature()
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+func (tx *Transaction) Verify(prevTXs map[string]Transaction, chainID int64) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	// A crafted input must not be able to crash the node, so reject instead of panicking
+	for inID, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil {
+			return tx.inputError(inID, errMissingPrevTx)
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return tx.inputError(inID, fmt.Errorf("%w: output doesn't exist", errMissingPrevTx))
 		}
 	}
 
 	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
 
 	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
 		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		txCopy.Vin[inID].PubKey = prevOut.PubKeyHash
+
+		dataToVerify := signingHash(txCopy, chainID)
+		txCopy.Vin[inID].PubKey = nil
+
+		// The input must reveal the key (or multisig script) the output is locked to
+		if !bytes.Equal(HashPubKey(vin.PubKey), prevOut.PubKeyHash) {
+			return tx.inputError(inID, fmt.Errorf("%w: the key doesn't match the output's pubkey hash", errBadSignature))
+		}
+
+		if script, ok := spentScript(prevOut, vin); ok {
+			if !verifyMultisig(*script, vin.Signatures, dataToVerify) {
+				return tx.inputError(inID, fmt.Errorf("%w: not enough valid multisig signatures", errBadSignature))
+			}
+			continue
+		}
+		if prevOut.ScriptHash {
+			return tx.inputError(inID, fmt.Errorf("%w: the input doesn't reveal a valid redeem script", errBadSignature))
+		}
+
+		if !verifySignature(vin.PubKey, vin.Signature, dataToVerify) {
+			return tx.inputError(inID, errBadSignature)
+		}
+	}
+
+	return nil
+}
+
+// checkValueConservation fails unless the outputs spent by tx's inputs, found in prevTXs,
+// are worth at least as much as tx's outputs, so no transaction creates coins
+// Coinbases are exempt: they create the block subsidy, and the genesis one the initial allocations
+func (tx *Transaction) checkValueConservation(prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return err
+	}
+
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return fmt.Errorf("referenced input %x:%d not found", vin.Txid, vin.Vout)
+		}
+		inputValue, err = addValue(inputValue, prevTx.Vout[vin.Vout].Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if inputValue < outputValue {
+		return fmt.Errorf("outputs (%d) exceed inputs (%d)", outputValue, inputValue)
+	}
+
+	return nil
+}
+
+// verifySignature checks an r || s signature of a digest against an X || Y public key
+func verifySignature(pubKey, signature, digest []byte) bool {
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	// Reject high-S signatures: (r, N-s) is also valid, which would make the transaction malleable
+	curve := elliptic.P256()
+	if s.Cmp(new(big.Int).Rsh(curve.Params().N, 1)) > 0 {
+		return false
+	}
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+	return ecdsa.Verify(&rawPubKey, digest, &r, &s)
+}
+
+// verifyMultisig checks that at least M signatures are valid for the script's keys
+// signatures[i] belongs to script.PubKeys[i]; unsigned slots are nil
+func verifyMultisig(script MultisigScript, signatures [][]byte, digest []byte) bool {
+	if len(signatures) > len(script.PubKeys) {
+		return false
+	}
+
+	valid := 0
+	for i, sig := range signatures {
+		if sig == nil {
+			continue
+		}
+		if !verifySignature(script.PubKeys[i], sig, digest) {
 			return false
 		}
-		txCopy.Vin[inID].PubKey = nil
+		valid++
 	}
 
-	return true
+	return valid >= script.M
 }
 
-// NewCoinbaseTX creates a new coinbase transaction (mining reward)
-func NewCoinbaseTX(to, data string) *Transaction {
+// NewCoinbaseTX creates a new coinbase transaction (mining reward plus the block's fees)
+// The height of the block it goes into is committed in the input's Signature field,
+// so coinbases paying the same address in different blocks still get distinct IDs
+// Similar to Bitcoin's BIP34
+func NewCoinbaseTX(to, data string, fees, height int) *Transaction {
 	if data == "" {
 		data = fmt.Sprintf("Reward to '%s'", to)
+		if len(data) > maxCoinbaseDataLen {
+			// Multisig addresses are too long to quote in full
+			data = data[:maxCoinbaseDataLen]
+		}
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	txin := TXInput{[]byte{}, -1, IntToHex(int64(height)), []byte(data), nil}
+	txout := NewTXOutput(BlockSubsidy(height)+fees, to)
+	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}, false, nil}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(from, to string, amount int, bc *Blockchain) *Transaction {
+// senderKeys returns the wallet keys that have to sign for from,
+// and the key (or multisig script) its inputs reveal
+func senderKeys(from string, wallets *Wallets) ([]Wallet, []byte, error) {
+	var signers []Wallet
+	if IsMultisigAddress(from) || IsScriptHashAddress(from) {
+		script, err := senderScript(from, wallets)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, pubKey := range script.PubKeys {
+			if wallet, ok := wallets.FindWalletByPubKey(pubKey); ok && len(signers) < script.M {
+				signers = append(signers, *wallet)
+			}
+		}
+		if len(signers) < script.M {
+			return nil, nil, errors.New("not enough keys in the wallet to sign for the multisig address")
+		}
+		return signers, script.Serialize(), nil
+	}
+
+	if wallets.IsWatchOnly(from) {
+		return nil, nil, fmt.Errorf("%s is watch-only: its private key isn't in the wallet, so it can't be spent from", from)
+	}
+	if wallets.Wallets[from] == nil {
+		return nil, nil, fmt.Errorf("no key for %s in the wallet", from)
+	}
+	wallet := wallets.GetWallet(from)
+
+	return append(signers, wallet), wallet.PublicKey, nil
+}
+
+// senderScript returns the multisig script of from: the one a multisig address embeds,
+// or the redeem script stored in the wallet for a script hash address
+func senderScript(from string, wallets *Wallets) (*MultisigScript, error) {
+	if IsMultisigAddress(from) {
+		return ParseMultisigAddress(from)
+	}
+
+	script, ok := wallets.GetScript(from)
+	if !ok {
+		return nil, fmt.Errorf("the redeem script of %s isn't in the wallet; add it with createmultisigwallet", from)
+	}
+
+	return script, nil
+}
+
+// newChangeAddress, passed as the change address of a payment, sends its change to a new key of the wallet
+const newChangeAddress = "new"
+
+// changeAddress returns the address the change of a payment from from goes to
+// changeTo overrides from: a wallet address checked by checkChangeAddress, or newChangeAddress.
+// Without it the change goes back to from, unless from is inactive
+// A new key is added to wallets whenever the change goes to a new address. It isn't saved to the wallet file:
+// callers save it once the payment is sent, so a dry run or a rejected payment leaves no key behind
+func changeAddress(from, changeTo string, wallets *Wallets) string {
+	if changeTo != "" && changeTo != newChangeAddress {
+		return changeTo
+	}
+	if changeTo == "" && !wallets.IsInactive(from) {
+		return from
+	}
+
+	address := wallets.CreateWallet()
+	if changeTo == "" {
+		logger.Infof("%s is inactive; sending the change to the new address %s", from, address)
+	} else {
+		logger.Infof("Sending the change to the new address %s", address)
+	}
+
+	return address
+}
+
+// checkChangeAddress fails unless changeTo is empty, newChangeAddress, or an active address
+// the wallet can spend from, so change sent there isn't lost
+func checkChangeAddress(changeTo string, wallets *Wallets) error {
+	if changeTo == "" || changeTo == newChangeAddress {
+		return nil
+	}
+	if err := CheckAddress(changeTo); err != nil {
+		return fmt.Errorf("change address %s is not valid: %s", changeTo, err)
+	}
+	if _, _, err := senderKeys(changeTo, wallets); err != nil {
+		return fmt.Errorf("change address %s can't be spent by this wallet: %s", changeTo, err)
+	}
+	if wallets.IsInactive(changeTo) {
+		return fmt.Errorf("change address %s is inactive", changeTo)
+	}
+
+	return nil
+}
+
+// NewUTXOTransactionMultiSource creates a transaction paying amount to to, and fee to the miner,
+// from the combined unspent outputs of several addresses
+// Addresses are drawn on in order until the payment is covered; the change goes to changeTo as for changeAddress,
+// by default back to the first one
+// Each input is signed with the key of the address it spends from
+func NewUTXOTransactionMultiSource(fromAddrs []string, to string, amount, fee int, changeTo string, bc *Blockchain, wallets *Wallets) (*Transaction, error) {
+	var inputs []TXInput
+	var signers []Wallet
+
+	required, err := sendTotal(amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChangeAddress(changeTo, wallets); err != nil {
+		return nil, err
+	}
+	if len(fromAddrs) == 0 {
+		return nil, errors.New("no source addresses")
+	}
+
+	acc := 0
+	for i, from := range fromAddrs {
+		if containsNode(fromAddrs[:i], from) {
+			return nil, fmt.Errorf("source address %s is listed twice", from)
+		}
+		if acc >= required {
+			break
+		}
+
+		keys, inputPubKey, err := senderKeys(from, wallets)
+		if err != nil {
+			return nil, err
+		}
+		found, validOutputs := bc.FindSpendableOutputs(HashPubKey(inputPubKey), required-acc)
+		if found == 0 {
+			continue
+		}
+		signers = append(signers, keys...)
+		acc, err = addValue(acc, found)
+		if err != nil {
+			return nil, err
+		}
+
+		for txid, outs := range validOutputs {
+			txID, err := hex.DecodeString(txid)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, out := range outs {
+				inputs = append(inputs, TXInput{txID, out, nil, inputPubKey, nil})
+			}
+		}
+	}
+
+	if acc < required {
+		return nil, fmt.Errorf("insufficient balance across the source addresses: have %d, need %d", acc, required)
+	}
+
+	outputs := []TXOutput{*NewTXOutput(amount, to)}
+	if acc > required {
+		outputs = append(outputs, *NewTXOutput(acc-required, changeAddress(fromAddrs[0], changeTo, wallets))) // a change
+	}
+
+	tx := Transaction{nil, inputs, outputs, false, nil}
+	tx.ID = tx.Hash()
+	for _, signer := range signers {
+		err := bc.SignTransaction(&tx, signer.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// NewUTXOTransactionMany creates a transaction paying each address in payments its amount, and fee to the miner
+// Outputs follow the sorted recipient addresses; the change goes back to from, unless it's inactive
+// Similar to Bitcoin's sendmany RPC
+func NewUTXOTransactionMany(from string, payments map[string]int, fee int, bc *Blockchain, wallets *Wallets) (*Transaction, error) {
 	var inputs []TXInput
 	var outputs []TXOutput
 
-	wallets, err := NewWallets(os.Getenv("NODE_ID"))
+	total, err := PaymentsTotal(payments)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	wallet := wallets.GetWallet(from)
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := bc.FindSpendableOutputs(pubKeyHash, amount)
+	required, err := sendTotal(total, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	signers, inputPubKey, err := senderKeys(from, wallets)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, validOutputs := bc.FindSpendableOutputs(HashPubKey(inputPubKey), required)
+	if acc < required {
+		return nil, fmt.Errorf("insufficient balance: have %d, need %d", acc, required)
+	}
+
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			return nil, err
+		}
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+		for _, out := range outs {
+			inputs = append(inputs, TXInput{txID, out, nil, inputPubKey, nil})
+		}
+	}
+
+	for _, to := range paymentAddresses(payments) {
+		outputs = append(outputs, *NewTXOutput(payments[to], to))
+	}
+	if acc > required {
+		outputs = append(outputs, *NewTXOutput(acc-required, changeAddress(from, "", wallets))) // a change
+	}
+
+	tx := Transaction{nil, inputs, outputs, false, nil}
+	tx.ID = tx.Hash()
+	for _, signer := range signers {
+		err := bc.SignTransaction(&tx, signer.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// SendOptions holds the optional parts of a payment made by NewUTXOTransaction
+// The zero value makes a plain payment with its change back to the sender
+type SendOptions struct {
+	Data        []byte           // Attached in a data output if non-empty
+	Memo        []byte           // Attached to the transaction itself if non-empty
+	Coins       map[string][]int // If set, exactly these outpoints are spent instead of letting the wallet pick
+	Replaceable bool             // Signals replace-by-fee; Coins may then be outputs spent by replaceable mempool transactions
+	ChangeTo    string           // Where the change goes, as for changeAddress; by default back to from
+}
+
+// NewUTXOTransaction creates a new transaction paying amount to to, and fee to the miner, with the options of opts
+// Fails without touching the chain if the payment is invalid or the sender can't cover it
+func NewUTXOTransaction(from, to string, amount, fee int, opts SendOptions, bc *Blockchain, wallets *Wallets) (*Transaction, error) {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	required, err := sendTotal(amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Memo) > maxMemoSize {
+		return nil, fmt.Errorf("memo is %d bytes, at most %d are allowed", len(opts.Memo), maxMemoSize)
+	}
+
+	signers, inputPubKey, err := senderKeys(from, wallets)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChangeAddress(opts.ChangeTo, wallets); err != nil {
+		return nil, err
+	}
+
+	pubKeyHash := HashPubKey(inputPubKey)
+	var acc int
+	var validOutputs map[string][]int
+	if opts.Coins != nil {
+		acc, err = bc.SelectOutputs(pubKeyHash, opts.Coins, opts.Replaceable)
+		if err != nil {
+			return nil, err
+		}
+		validOutputs = opts.Coins
+	} else {
+		acc, validOutputs = bc.FindSpendableOutputs(pubKeyHash, required)
+	}
+
+	if acc < required {
+		if opts.Coins != nil {
+			return nil, fmt.Errorf("selected inputs are worth %d, need %d", acc, required)
+		}
+		return nil, fmt.Errorf("insufficient balance: have %d, need %d", acc, required)
 	}
 
 	// Build a list of inputs
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
+			input := TXInput{txID, out, nil, inputPubKey, nil}
 			inputs = append(inputs, input)
 		}
 	}
 
 	// Build a list of outputs
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
+	if acc > required {
+		outputs = append(outputs, *NewTXOutput(acc-required, changeAddress(from, opts.ChangeTo, wallets))) // a change
+	}
+	if len(opts.Data) > 0 {
+		dataOut, err := NewDataOutput(opts.Data)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *dataOut)
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, inputs, outputs, opts.Replaceable, opts.Memo}
 	tx.ID = tx.Hash()
-	bc.SignTransaction(&tx, wallet.PrivateKey)
+	for _, signer := range signers {
+		err := bc.SignTransaction(&tx, signer.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// Recalculate Hash after signing so the ID includes the signature
-	// This ensures unique IDs even for identical transactions (since signatures are random)
+	// Recalculate Hash after signing so the ID commits to the signatures too
+	// Signing is deterministic, so the same payment signed twice gets the same ID; they're told apart by the outputs they spend
 	tx.ID = tx.Hash()
 
-	return &tx
+	return &tx, nil
 }
 
 // TXInput represents a transaction input
 type TXInput struct {
-	Txid      []byte // Transaction ID
-	Vout      int    // Output index
-	Signature []byte // Signature
-	PubKey    []byte // Public key
+	Txid       []byte   // Transaction ID
+	Vout       int      // Output index
+	Signature  []byte   // Signature
+	PubKey     []byte   // Public key (the serialized script for multisig spends)
+	Signatures [][]byte // Multisig signatures, one slot per script key (nil if unsigned)
 }
 
 // UsesKey checks whether the address initiated the transaction
@@ -251,25 +889,61 @@ func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
 
 // TXOutput represents a transaction output
 type TXOutput struct {
-	Value      int    // Value in coins
-	PubKeyHash []byte // Public key hash (address)
+	Value      int            // Value in coins
+	PubKeyHash []byte         // Public key hash (address), or the script hash for multisig
+	Multisig   MultisigScript // M-of-N lock (zero value for single-key outputs)
+	Data       []byte         // Embedded data; makes the output provably unspendable
+	ScriptHash bool           // Locked to the hash of a redeem script, which the spending input reveals
+}
+
+// maxDataOutputSize is the largest payload a data output may carry
+const maxDataOutputSize = 80
+
+// maxMemoSize is the largest memo a transaction can carry
+const maxMemoSize = 256
+
+// NewDataOutput creates an unspendable output carrying data and no value
+// Similar to Bitcoin's OP_RETURN outputs
+func NewDataOutput(data []byte) (*TXOutput, error) {
+	if len(data) == 0 || len(data) > maxDataOutputSize {
+		return nil, fmt.Errorf("data output must carry 1 to %d bytes, got %d", maxDataOutputSize, len(data))
+	}
+
+	return &TXOutput{0, nil, MultisigScript{}, data, false}, nil
+}
+
+// IsData checks whether the output only carries data and can never be spent
+func (out *TXOutput) IsData() bool {
+	return len(out.Data) > 0
 }
 
 // Lock signs the output
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	if IsMultisigAddress(string(address)) {
+		script, err := ParseMultisigAddress(string(address))
+		if err != nil {
+			log.Panic(err)
+		}
+		out.Multisig = *script
+	}
+	out.ScriptHash = IsScriptHashAddress(string(address))
+
+	out.PubKeyHash = AddressToPubKeyHash(string(address))
+}
+
+// IsMultisig checks whether the output requires multiple signatures to spend
+func (out *TXOutput) IsMultisig() bool {
+	return out.Multisig.M > 0
 }
 
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	return !out.IsData() && bytes.Equal(out.PubKeyHash, pubKeyHash)
 }
 
 // NewTXOutput create a new TXOutput
 func NewTXOutput(value int, address string) *TXOutput {
-	txo := &TXOutput{value, nil}
+	txo := &TXOutput{value, nil, MultisigScript{}, nil, false}
 	txo.Lock([]byte(address))
 
 	return txo
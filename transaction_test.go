@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// signedSpend returns a transaction paying amount to to from a coinbase output of w, signed for chainID,
+// and the previous transactions it spends
+func signedSpend(t testing.TB, w *Wallet, to string, amount int, chainID int64) (*Transaction, map[string]Transaction) {
+	t.Helper()
+
+	prev := NewCoinbaseTX(string(w.GetAddress()), "test", 0, 1)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): *prev}
+
+	tx := &Transaction{nil, []TXInput{{prev.ID, 0, nil, w.PublicKey, nil}}, []TXOutput{*NewTXOutput(amount, to)}, false, nil}
+	tx.ID = tx.Hash()
+	if err := tx.Sign(w.PrivateKey, prevTXs, chainID); err != nil {
+		t.Fatalf("Sign: %s", err)
+	}
+	tx.ID = tx.Hash()
+
+	return tx, prevTXs
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	sender := NewWallet()
+	recipient := string(NewWallet().GetAddress())
+	other := string(NewWallet().GetAddress())
+
+	tests := []struct {
+		name   string
+		tamper func(tx *Transaction)
+	}{
+		{"output value", func(tx *Transaction) { tx.Vout[0].Value++ }},
+		{"output recipient", func(tx *Transaction) { tx.Vout[0] = *NewTXOutput(tx.Vout[0].Value, other) }},
+		{"added output", func(tx *Transaction) { tx.Vout = append(tx.Vout, *NewTXOutput(1, other)) }},
+		{"input index", func(tx *Transaction) { tx.Vin[0].Vout = 1 }},
+		{"memo", func(tx *Transaction) { tx.Memo = []byte("changed") }},
+		{"replaceable", func(tx *Transaction) { tx.Replaceable = true }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, prevTXs := signedSpend(t, sender, recipient, 10, defaultChainID)
+			if err := tx.Verify(prevTXs, defaultChainID); err != nil {
+				t.Fatalf("untouched transaction: %s", err)
+			}
+
+			tt.tamper(tx)
+			// A changed input index references an output the coinbase lacks, which fails before the signature
+			if err := tx.Verify(prevTXs, defaultChainID); err == nil {
+				t.Fatal("tampered transaction verified")
+			}
+		})
+	}
+}
+
+func TestSignaturesCoverOutputs(t *testing.T) {
+	sender := NewWallet()
+	recipient := string(NewWallet().GetAddress())
+
+	tx1, _ := signedSpend(t, sender, recipient, 10, defaultChainID)
+	tx2, prevTXs := signedSpend(t, sender, recipient, 9, defaultChainID)
+	if bytes.Equal(tx1.Vin[0].Signature, tx2.Vin[0].Signature) {
+		t.Fatal("transactions with different outputs have the same signature")
+	}
+
+	// The signature of one payment must not authorize another from the same output
+	tx2.Vin[0].Signature = tx1.Vin[0].Signature
+	if err := tx2.Verify(prevTXs, defaultChainID); !errors.Is(err, errBadSignature) {
+		t.Fatalf("Verify with a signature of another transaction = %v, want %v", err, errBadSignature)
+	}
+}
+
+func TestSignIsDeterministic(t *testing.T) {
+	sender := NewWallet()
+	recipient := string(NewWallet().GetAddress())
+
+	tx1, prevTXs := signedSpend(t, sender, recipient, 10, defaultChainID)
+	tx2, _ := signedSpend(t, sender, recipient, 10, defaultChainID)
+	if !bytes.Equal(tx1.Vin[0].Signature, tx2.Vin[0].Signature) || !bytes.Equal(tx1.ID, tx2.ID) {
+		t.Fatalf("signing the same transaction twice gave signatures %x and %x", tx1.Vin[0].Signature, tx2.Vin[0].Signature)
+	}
+	for _, tx := range []*Transaction{tx1, tx2} {
+		if err := tx.Verify(prevTXs, defaultChainID); err != nil {
+			t.Fatalf("Verify: %s", err)
+		}
+	}
+}
+
+func TestVerifyRejectsReplayOnOtherChain(t *testing.T) {
+	sender := NewWallet()
+	recipient := string(NewWallet().GetAddress())
+	other := string(NewWallet().GetAddress())
+
+	tests := []struct {
+		name    string
+		chainID int64
+		tamper  func(tx *Transaction)
+		valid   bool
+	}{
+		{"same chain", 1, nil, true},
+		{"other chain", 2, nil, false},
+		{"negative chain ID", -1, nil, false},
+		{"same chain, redirected output", 1, func(tx *Transaction) { tx.Vout[0] = *NewTXOutput(tx.Vout[0].Value, other) }, false},
+		{"other chain, redirected output", 2, func(tx *Transaction) { tx.Vout[0] = *NewTXOutput(tx.Vout[0].Value, other) }, false},
+		{"other chain, raised value", 2, func(tx *Transaction) { tx.Vout[0].Value *= 2 }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, prevTXs := signedSpend(t, sender, recipient, 10, 1)
+			if tt.tamper != nil {
+				tt.tamper(tx)
+			}
+
+			err := tx.Verify(prevTXs, tt.chainID)
+			if tt.valid && err != nil {
+				t.Fatalf("Verify on chain %d: %s", tt.chainID, err)
+			}
+			if !tt.valid && !errors.Is(err, errBadSignature) {
+				t.Fatalf("Verify on chain %d = %v, want %v", tt.chainID, err, errBadSignature)
+			}
+		})
+	}
+}
+
+func TestSerializedSizeMatchesEncoding(t *testing.T) {
+	for name, tx := range codecTransactions(t) {
+		t.Run(name, func(t *testing.T) {
+			if size, encoded := tx.SerializedSize(), len(tx.Serialize()); size != encoded {
+				t.Fatalf("SerializedSize = %d, want the %d bytes of Serialize", size, encoded)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsMissingPrevTx(t *testing.T) {
+	sender := NewWallet()
+	tx, prevTXs := signedSpend(t, sender, string(NewWallet().GetAddress()), 10, defaultChainID)
+
+	tests := []struct {
+		name   string
+		tamper func(tx *Transaction)
+	}{
+		{"nonexistent transaction", func(tx *Transaction) { tx.Vin[0].Txid = bytes.Repeat([]byte{0xab}, 32) }},
+		{"nonexistent output", func(tx *Transaction) { tx.Vin[0].Vout = 5 }},
+		{"negative output index", func(tx *Transaction) { tx.Vin[0].Vout = -2 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := *tx
+			tampered.Vin = append([]TXInput{}, tx.Vin...)
+			tt.tamper(&tampered)
+
+			if err := tampered.Verify(prevTXs, defaultChainID); !errors.Is(err, errMissingPrevTx) {
+				t.Fatalf("Verify = %v, want %v", err, errMissingPrevTx)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsHighS(t *testing.T) {
+	tx, prevTXs := signedSpend(t, NewWallet(), string(NewWallet().GetAddress()), 10, defaultChainID)
+
+	// (r, N-s) verifies under plain ECDSA, but would let anyone change the transaction's ID
+	sig := tx.Vin[0].Signature
+	s := new(big.Int).SetBytes(sig[sigScalarLen:])
+	highS := new(big.Int).Sub(elliptic.P256().Params().N, s)
+	malleated := append([]byte{}, sig[:sigScalarLen]...)
+	malleated = append(malleated, highS.FillBytes(make([]byte, sigScalarLen))...)
+	tx.Vin[0].Signature = malleated
+
+	if err := tx.Verify(prevTXs, defaultChainID); !errors.Is(err, errBadSignature) {
+		t.Fatalf("Verify with a high-S signature = %v, want %v", err, errBadSignature)
+	}
+}
+
+func TestSendTotal(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount, fee int
+		want        int
+		wantErr     bool
+	}{
+		{"amount and fee", 10, 2, 12, false},
+		{"no fee", 10, 0, 10, false},
+		{"zero amount", 0, 1, 0, true},
+		{"negative amount", -5, 1, 0, true},
+		{"negative fee", 10, -1, 0, true},
+		{"overflow", math.MaxInt, 1, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sendTotal(tt.amount, tt.fee)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("sendTotal(%d, %d) = %d, %v, want %d (error %t)", tt.amount, tt.fee, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckValueConservation(t *testing.T) {
+	sender := NewWallet()
+
+	tests := []struct {
+		name    string
+		outputs []int  // Paid from a coinbase output holding subsidy
+		wantErr string // Empty for a valid transaction
+	}{
+		{"spends less than the input", []int{subsidy - 1}, ""},
+		{"spends the whole input", []int{subsidy}, ""},
+		{"negative output", []int{subsidy, -1}, "non-positive value"},
+		{"zero output", []int{0}, "non-positive value"},
+		{"output sum overflows", []int{math.MaxInt, 1}, errValueOverflow.Error()},
+		{"insufficient funds", []int{subsidy, 1}, "exceed inputs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, prevTXs := signedSpend(t, sender, string(sender.GetAddress()), 1, defaultChainID)
+			tx.Vout = nil
+			for _, value := range tt.outputs {
+				tx.Vout = append(tx.Vout, TXOutput{value, HashPubKey(sender.PublicKey), MultisigScript{}, nil, false})
+			}
+
+			err := tx.checkValueConservation(prevTXs)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("checkValueConservation: %s", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("checkValueConservation = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateInputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(in *TXInput)
+		wantErr string
+	}{
+		{"well-formed", func(in *TXInput) {}, ""},
+		{"oversized public key", func(in *TXInput) { in.PubKey = append(in.PubKey, 0) }, "public key has 65 bytes"},
+		{"truncated public key", func(in *TXInput) { in.PubKey = in.PubKey[:pubKeyLen-1] }, "public key has 63 bytes"},
+		{"public key off the curve", func(in *TXInput) { in.PubKey[pubKeyLen-1] ^= 1 }, "not a P-256 point"},
+		{"63-byte signature", func(in *TXInput) { in.Signature = in.Signature[:2*sigScalarLen-1] }, "signature has 63 bytes"},
+		{"65-byte signature", func(in *TXInput) { in.Signature = append(in.Signature, 0) }, "signature has 65 bytes"},
+		{"missing signature", func(in *TXInput) { in.Signature = nil }, "signature has 0 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, _ := signedSpend(t, NewWallet(), string(NewWallet().GetAddress()), 10, defaultChainID)
+			in := &tx.Vin[0]
+			in.PubKey = append([]byte{}, in.PubKey...)
+			in.Signature = append([]byte{}, in.Signature...)
+			tt.tamper(in)
+
+			err := tx.ValidateInputs()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ValidateInputs: %s", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("ValidateInputs = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewUTXOTransactionShortfall(t *testing.T) {
+	bc, w := newTestChain(t)
+	from := string(w.GetAddress())
+	wallets := Wallets{Wallets: map[string]*Wallet{from: w}}
+	genesis, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := map[string][]int{hex.EncodeToString(genesis.Transactions[0].ID): {0}}
+
+	tests := []struct {
+		name        string
+		amount, fee int
+		coins       map[string][]int
+		wantErr     string
+	}{
+		{"whole balance", subsidy - 1, 1, nil, ""},
+		{"fee over the balance", subsidy, 1, nil, "insufficient balance: have 10, need 11"},
+		{"amount over the balance", 3 * subsidy, 0, nil, "insufficient balance: have 10, need 30"},
+		{"selected inputs short", subsidy, 2, coinbase, "selected inputs are worth 10, need 12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := NewUTXOTransaction(from, string(NewWallet().GetAddress()), tt.amount, tt.fee, SendOptions{Coins: tt.coins}, bc, &wallets)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tx.Vout[0].Value != tt.amount || len(tx.Vout) != 1 {
+					t.Fatalf("outputs %+v, want only the payment", tx.Vout)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr || tx != nil {
+				t.Fatalf("NewUTXOTransaction = %v, %v, want error %q", tx, err, tt.wantErr)
+			}
+			if pool := bc.GetMempool(); len(pool) != 0 {
+				t.Fatalf("failed payment left %d mempool transaction(s)", len(pool))
+			}
+		})
+	}
+}
+
+func TestDataOutput(t *testing.T) {
+	bc, w := newTestChain(t)
+	from := string(w.GetAddress())
+	payee, miner := NewWallet(), NewWallet()
+	wallets := Wallets{Wallets: map[string]*Wallet{from: w}}
+
+	for _, size := range []int{0, maxDataOutputSize + 1} {
+		if _, err := NewDataOutput(make([]byte, size)); err == nil {
+			t.Errorf("NewDataOutput accepted %d bytes", size)
+		}
+	}
+
+	tx, err := NewUTXOTransaction(from, string(payee.GetAddress()), 5, 1, SendOptions{Data: []byte("hello")}, bc, &wallets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddToMempool(tx); err != nil {
+		t.Fatalf("AddToMempool: %s", err)
+	}
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(miner.GetAddress()), "", 1, 2), tx)); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mined := block.Transactions[1]
+	if len(mined.Vout) != 3 || !mined.Vout[2].IsData() || string(mined.Vout[2].Data) != "hello" {
+		t.Fatalf("mined outputs %+v, want the payment, the change and the data", mined.Vout)
+	}
+
+	for w, want := range map[*Wallet]int{w: subsidy - 6, payee: 5, miner: subsidy + 1} {
+		if balance, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != want {
+			t.Errorf("balance %d, %v, want %d", balance, err, want)
+		}
+	}
+	if supply, err := (UTXOSet{bc}).TotalSupply(); err != nil || supply != 2*subsidy {
+		t.Fatalf("TotalSupply = %d, %v, want %d", supply, err, 2*subsidy)
+	}
+	if outputs, _, err := (UTXOSet{bc}).CountOutputs(); err != nil || outputs != 3 {
+		t.Fatalf("%d unspent output(s), %v, want the data output left out of 4", outputs, err)
+	}
+}
+
+func TestCoinControl(t *testing.T) {
+	bc, w := newTestChain(t)
+	from := string(w.GetAddress())
+	wallets := Wallets{Wallets: map[string]*Wallet{from: w}}
+	other := NewWallet()
+	genesis, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, theirs := NewCoinbaseTX(from, "", 0, 1), NewCoinbaseTX(string(other.GetAddress()), "", 0, 2)
+	if err := bc.AddBlock(peerBlock(t, bc, first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(peerBlock(t, bc, theirs)); err != nil {
+		t.Fatal(err)
+	}
+	second := NewCoinbaseTX(from, "", 0, 3)
+	if err := bc.AddBlock(peerBlock(t, bc, second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddToMempool(spendOutput(t, bc, w, genesis.Transactions[0], 0, from, 1, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	coins, err := ParseOutpoints(fmt.Sprintf("%x:0", first.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewUTXOTransaction(from, string(NewWallet().GetAddress()), 3, 1, SendOptions{Coins: coins}, bc, &wallets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Vin) != 1 || !bytes.Equal(tx.Vin[0].Txid, first.ID) || tx.Vin[0].Vout != 0 {
+		t.Fatalf("inputs %+v, want only the selected output", tx.Vin)
+	}
+	if len(tx.Vout) != 2 || tx.Vout[1].Value != first.Vout[0].Value-4 {
+		t.Fatalf("outputs %+v, want the payment and the change", tx.Vout)
+	}
+	if err := bc.AddToMempool(tx); err != nil {
+		t.Fatalf("AddToMempool: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		list    string
+		wantErr string
+	}{
+		{"no output index", fmt.Sprintf("%x", first.ID), "is not in TXID:VOUT form"},
+		{"short transaction ID", "abcd:0", "has an invalid transaction ID"},
+		{"negative output index", fmt.Sprintf("%x:-1", first.ID), "has an invalid output index"},
+		{"unknown output", fmt.Sprintf("%x:1", theirs.ID), "is already spent or doesn't exist"},
+		{"unknown transaction", fmt.Sprintf("%x:0", make([]byte, 32)), "is already spent or doesn't exist"},
+		{"selected twice", fmt.Sprintf("%x:0, %x:0", second.ID, second.ID), "is selected twice"},
+		{"someone else's output", fmt.Sprintf("%x:0", theirs.ID), "doesn't belong to the sender"},
+		{"spent by the mempool", fmt.Sprintf("%x:0", first.ID), "is already spent by a mempool transaction"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coins, err := ParseOutpoints(tt.list)
+			if err == nil {
+				_, err = NewUTXOTransaction(from, string(NewWallet().GetAddress()), 3, 1, SendOptions{Coins: coins}, bc, &wallets)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if pool := bc.GetMempool(); len(pool) != 2 {
+		t.Fatalf("%d mempool transaction(s), want 2", len(pool))
+	}
+}
+
+func TestMultiSourceSend(t *testing.T) {
+	bc, alice := newTestChain(t)
+	bob, carol, miner := NewWallet(), NewWallet(), NewWallet()
+	from := []string{string(alice.GetAddress()), string(bob.GetAddress())}
+	wallets := Wallets{Wallets: map[string]*Wallet{from[0]: alice, from[1]: bob}}
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(from[1], "", 0, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewUTXOTransactionMultiSource(from, string(carol.GetAddress()), 2*subsidy, 1, "", bc, &wallets); err == nil {
+		t.Fatal("spent more than both addresses hold")
+	}
+	if _, err := NewUTXOTransactionMultiSource([]string{from[0], from[0]}, string(carol.GetAddress()), 15, 1, "", bc, &wallets); err == nil {
+		t.Fatal("accepted a source address listed twice")
+	}
+
+	tx, err := NewUTXOTransactionMultiSource(from, string(carol.GetAddress()), 15, 1, "", bc, &wallets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Vin) != 2 {
+		t.Fatalf("%d input(s), want one from each address", len(tx.Vin))
+	}
+	if err := bc.AddToMempool(tx); err != nil {
+		t.Fatalf("AddToMempool: %s", err)
+	}
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(miner.GetAddress()), "", 1, 2), tx)); err != nil {
+		t.Fatal(err)
+	}
+
+	for w, want := range map[*Wallet]int{alice: 2*subsidy - 16, bob: 0, carol: 15, miner: subsidy + 1} {
+		if balance, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != want {
+			t.Errorf("balance %d, %v, want %d", balance, err, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+)
+
+// txIndexBucket maps the ID of every transaction of the main chain to the hash of the block holding it
+const txIndexBucket = "txindex"
+
+// txIndexTipKey is the meta key recording the block the transaction index is up to date with
+const txIndexTipKey = "txindextip"
+
+// errTxNotIndexed is returned for transactions the index doesn't know, while it's up to date
+var errTxNotIndexed = errors.New("Transaction is not found")
+
+// TxIndex locates the transactions of the main chain without scanning its blocks
+// Like the UTXO set it follows the tip, and is rebuilt after a reorganization
+// Similar to Bitcoin's -txindex
+type TxIndex struct {
+	bc *Blockchain
+}
+
+// IsCurrent reports whether the index reflects the current tip
+func (t TxIndex) IsCurrent() bool {
+	indexed := t.IndexedTip()
+
+	return indexed != nil && bytes.Equal(indexed, t.bc.Tip())
+}
+
+// IndexedTip returns the block the index was last brought up to date with, or nil if it has never been built
+func (t TxIndex) IndexedTip() []byte {
+	var indexed []byte
+
+	err := t.bc.db.View(func(tx StoreTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		if meta == nil || tx.Bucket([]byte(txIndexBucket)) == nil {
+			return nil
+		}
+		if tip := meta.Get([]byte(txIndexTipKey)); tip != nil {
+			indexed = append([]byte{}, tip...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	return indexed
+}
+
+// Reindex rebuilds the index from the blocks, from genesis to tip
+// Transactions of pruned blocks are left out, as a scan can't find them either
+func (t TxIndex) Reindex() error {
+	blocks := t.bc.blocksFromGenesis()
+
+	return t.bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(txIndexBucket)) != nil {
+			if err := tx.DeleteBucket([]byte(txIndexBucket)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(txIndexBucket)); err != nil {
+			return err
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+		if err := meta.Delete([]byte(txIndexTipKey)); err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			if err := connectBlockTxIndex(tx, block); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// BlockHash returns the hash of the main chain block holding a transaction
+// It fails with errTxNotIndexed if the index is up to date and doesn't know the transaction,
+// and with another error if the index can't answer because it's missing or stale
+func (t TxIndex) BlockHash(txID []byte) ([]byte, error) {
+	var blockHash []byte
+	tip := t.bc.Tip()
+
+	err := t.bc.db.View(func(tx StoreTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		b := tx.Bucket([]byte(txIndexBucket))
+		if meta == nil || b == nil || !bytes.Equal(meta.Get([]byte(txIndexTipKey)), tip) {
+			return errors.New("transaction index is not up to date")
+		}
+
+		hash := b.Get(txID)
+		if hash == nil {
+			return errTxNotIndexed
+		}
+		blockHash = append([]byte{}, hash...)
+
+		return nil
+	})
+
+	return blockHash, err
+}
+
+// connectBlockTxIndex adds the transactions of a block to the index inside a DB update
+// The block must extend the block the index is up to date with; otherwise the index is left stale
+func connectBlockTxIndex(tx StoreTx, block *Block) error {
+	meta := tx.Bucket([]byte(metaBucket))
+	b := tx.Bucket([]byte(txIndexBucket))
+	if meta == nil || b == nil || !bytes.Equal(meta.Get([]byte(txIndexTipKey)), block.PrevBlockHash) {
+		return nil
+	}
+
+	for _, transaction := range block.Transactions {
+		if err := b.Put(transaction.ID, block.Hash); err != nil {
+			return err
+		}
+	}
+
+	return meta.Put([]byte(txIndexTipKey), block.Hash)
+}
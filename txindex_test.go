@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTxIndexMatchesScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, bc *Blockchain, w *Wallet) [][]byte // Returns the IDs of transactions to look up
+		indexed bool
+	}{
+		{"blocks extending the tip", func(t *testing.T, bc *Blockchain, w *Wallet) [][]byte {
+			spend := spendCoinbase(t, bc, w, string(NewWallet().GetAddress()), 1)
+			txs := coinbaseBlocks(bc, string(w.GetAddress()), 3)
+			txs[1] = append(txs[1], spend)
+			txs[1][0] = NewCoinbaseTX(string(w.GetAddress()), "", 1, 3)
+			if err := bc.AddBlocks(chainBlocks(t, bc, txs...)); err != nil {
+				t.Fatal(err)
+			}
+			return [][]byte{txs[0][0].ID, txs[1][0].ID, spend.ID, txs[2][0].ID}
+		}, true},
+		{"reorganization", func(t *testing.T, bc *Blockchain, w *Wallet) [][]byte {
+			genesis := bc.Tip()
+			main := peerBlock(t, bc, NewCoinbaseTX(string(w.GetAddress()), "main", 0, 2))
+			if err := bc.AddBlock(main); err != nil {
+				t.Fatal(err)
+			}
+			side := blockOn(t, bc, genesis, NewCoinbaseTX(string(w.GetAddress()), "side", 0, 2))
+			if err := bc.AddBlock(side); err != nil {
+				t.Fatal(err)
+			}
+			next := blockOn(t, bc, side.Hash, NewCoinbaseTX(string(w.GetAddress()), "side", 0, 3))
+			if err := bc.AddBlock(next); err != nil {
+				t.Fatal(err)
+			}
+			return [][]byte{main.Transactions[0].ID, side.Transactions[0].ID, next.Transactions[0].ID}
+		}, true},
+		{"index missing", func(t *testing.T, bc *Blockchain, w *Wallet) [][]byte {
+			blocks := chainBlocks(t, bc, coinbaseBlocks(bc, string(w.GetAddress()), 2)...)
+			if err := bc.AddBlocks(blocks); err != nil {
+				t.Fatal(err)
+			}
+			err := bc.db.Update(func(tx StoreTx) error {
+				return tx.DeleteBucket([]byte(txIndexBucket))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return [][]byte{blocks[0].Transactions[0].ID, blocks[1].Transactions[0].ID}
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			genesis, err := bc.GetBlock(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := append(tt.change(t, bc, w), genesis.Transactions[0].ID, []byte("unknown"))
+
+			if indexed := (TxIndex{bc}).IsCurrent(); indexed != tt.indexed {
+				t.Fatalf("index current: %t, want %t", indexed, tt.indexed)
+			}
+			for _, id := range ids {
+				tx, blockHash, err := bc.findChainTransaction(id)
+				scanned, scannedHash, scanErr := bc.scanChainTransaction(id)
+				if (err == nil) != (scanErr == nil) {
+					t.Fatalf("transaction %x: lookup error %v, scan error %v", id, err, scanErr)
+				}
+				if !bytes.Equal(tx.ID, scanned.ID) || !bytes.Equal(blockHash, scannedHash) {
+					t.Fatalf("transaction %x found in block %x, a scan finds it in %x", id, blockHash, scannedHash)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFindTransaction looks up the transaction of the oldest block of a 100-block chain, with and without the index
+func BenchmarkFindTransaction(b *testing.B) {
+	bc, w := newTestChain(b)
+	blocks := chainBlocks(b, bc, coinbaseBlocks(bc, string(w.GetAddress()), 100)...)
+	if err := bc.AddBlocks(blocks); err != nil {
+		b.Fatal(err)
+	}
+	id := blocks[0].Transactions[0].ID
+
+	for _, indexed := range []bool{true, false} {
+		b.Run(fmt.Sprintf("indexed %t", indexed), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var err error
+				if indexed {
+					_, _, err = bc.findChainTransaction(id)
+				} else {
+					_, _, err = bc.scanChainTransaction(id)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+)
+
+// typicalTxSize is about the size of a payment with one input and a change output, the size estimatefee assumes by default
+const typicalTxSize = 320
+
+// MempoolTxStats is a mempool transaction's fee and size, and those of its packages: the transaction with all its
+// mempool ancestors, which a block must include along with it, and with all its mempool descendants,
+// whose fees are lost if it's replaced. Counts, fees and sizes of a package include the transaction itself
+// Similar to Bitcoin's CTxMemPoolEntry ancestor and descendant state
+type MempoolTxStats struct {
+	Tx             *Transaction
+	Fee            int
+	Size           int
+	Ancestors      int
+	AncestorFee    int
+	AncestorSize   int
+	Descendants    int
+	DescendantFee  int
+	DescendantSize int
+}
+
+// AncestorFeeRate is the fee per byte a miner collects by including the transaction with its ancestors
+func (s *MempoolTxStats) AncestorFeeRate() float64 {
+	return feeRate(s.AncestorFee, s.AncestorSize)
+}
+
+// feeRate returns the fee per byte of fee paid for size bytes
+func feeRate(fee, size int) float64 {
+	if size <= 0 {
+		return 0
+	}
+
+	return float64(fee) / float64(size)
+}
+
+// packageAncestors returns tx and its ancestors in byID that aren't in included, in canonical order
+func packageAncestors(tx *Transaction, byID map[string]*Transaction, included map[string]bool) []*Transaction {
+	var members []*Transaction
+	seen := map[string]bool{hex.EncodeToString(tx.ID): true}
+
+	for queue := []*Transaction{tx}; len(queue) > 0; queue = queue[1:] {
+		members = append(members, queue[0])
+		for _, vin := range queue[0].Vin {
+			parent := hex.EncodeToString(vin.Txid)
+			if seen[parent] || included[parent] || byID[parent] == nil {
+				continue
+			}
+			seen[parent] = true
+			queue = append(queue, byID[parent])
+		}
+	}
+
+	return orderTransactions(members)
+}
+
+// mempoolPackageStats sums the ancestor and descendant packages of each transaction of pool, in pool order
+func mempoolPackageStats(pool []*Transaction, fees map[string]int) []*MempoolTxStats {
+	byID := make(map[string]*Transaction)
+	stats := make(map[string]*MempoolTxStats)
+	var ordered []*MempoolTxStats
+	for _, tx := range pool {
+		id := hex.EncodeToString(tx.ID)
+		byID[id] = tx
+		stats[id] = &MempoolTxStats{Tx: tx, Fee: fees[id], Size: tx.SerializedSize()}
+		ordered = append(ordered, stats[id])
+	}
+
+	// Each transaction is a descendant of every member of its ancestor package
+	for _, s := range ordered {
+		for _, member := range packageAncestors(s.Tx, byID, nil) {
+			ancestor := stats[hex.EncodeToString(member.ID)]
+			s.Ancestors++
+			s.AncestorFee += ancestor.Fee
+			s.AncestorSize += ancestor.Size
+			ancestor.Descendants++
+			ancestor.DescendantFee += s.Fee
+			ancestor.DescendantSize += s.Size
+		}
+	}
+
+	return ordered
+}
+
+// MempoolPackageStats returns the package stats of every mempool transaction
+// A transaction whose fee can't be worked out counts as paying none
+func (bc *Blockchain) MempoolPackageStats() []*MempoolTxStats {
+	pool := bc.GetMempool()
+	fees := make(map[string]int)
+	for _, tx := range pool {
+		if fee, err := bc.TransactionFee(tx); err == nil {
+			fees[hex.EncodeToString(tx.ID)] = fee
+		}
+	}
+
+	return mempoolPackageStats(pool, fees)
+}
+
+// packageEntry is a candidate during block assembly, with the totals of its package: the candidate
+// and its ancestors not selected yet. Including a package lowers the totals of the members' descendants
+// Similar to Bitcoin's CTxMemPoolModifiedEntry
+type packageEntry struct {
+	tx           *Transaction
+	id           string
+	order        int                     // Position among the candidates; ties in fee rate go to the earlier one
+	fee          int                     // Of the transaction alone
+	size         int                     // Of the transaction alone
+	ancestors    map[string]*Transaction // Ancestors not selected yet, by hex ID
+	ancestorFee  int                     // Of the transaction with its ancestors not selected yet
+	ancestorSize int                     // Of the transaction with its ancestors not selected yet
+	descendants  []*packageEntry         // Candidates spending the transaction's outputs, directly or not
+	version      int                     // Changes with the totals, outdating earlier copies in the queue
+	tooLarge     bool                    // The package didn't fit when its turn came
+}
+
+// queuedPackage is a copy of a package entry's fee rate in a packageQueue, valid while the entry's version is unchanged
+type queuedPackage struct {
+	entry   *packageEntry
+	rate    float64
+	version int
+}
+
+// packageQueue is a heap of packages, highest fee rate first and then earliest candidate first
+// An entry whose totals change is pushed again; popped copies whose version is outdated are skipped
+type packageQueue []queuedPackage
+
+func (q packageQueue) Len() int { return len(q) }
+
+func (q packageQueue) Less(i, j int) bool {
+	if q[i].rate != q[j].rate {
+		return q[i].rate > q[j].rate
+	}
+	return q[i].entry.order < q[j].entry.order
+}
+
+func (q packageQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *packageQueue) Push(x interface{}) { *q = append(*q, x.(queuedPackage)) }
+
+func (q *packageQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// push queues the entry at its current fee rate
+func (q *packageQueue) push(e *packageEntry) {
+	heap.Push(q, queuedPackage{e, feeRate(e.ancestorFee, e.ancestorSize), e.version})
+}
+
+// packageAssembly is a block filled by assemblePackages
+type packageAssembly struct {
+	selected []*Transaction           // Parents first
+	leftOut  []*Transaction           // In candidate order
+	entries  map[string]*packageEntry // Every candidate by hex ID; a left-out one's totals count only its unselected ancestors
+	included map[string]bool          // Hex IDs of the selected transactions
+}
+
+// assemblePackages fills a block of up to maxSize bytes from candidates, whose mempool parents must be candidates too
+// Ancestor totals are summed once; after each pick only the descendants of the included transactions are updated
+func assemblePackages(candidates []*Transaction, fees map[string]int, maxSize int) *packageAssembly {
+	entries := make(map[string]*packageEntry)
+	for i, tx := range candidates {
+		id := hex.EncodeToString(tx.ID)
+		size := tx.SerializedSize()
+		entries[id] = &packageEntry{tx: tx, id: id, order: i, fee: fees[id], size: size, ancestors: make(map[string]*Transaction), ancestorFee: fees[id], ancestorSize: size}
+	}
+
+	// Each transaction's ancestors are those of its parents, and the parents themselves
+	summed := make(map[string]bool)
+	var sumAncestors func(e *packageEntry)
+	sumAncestors = func(e *packageEntry) {
+		if summed[e.id] {
+			return
+		}
+		summed[e.id] = true
+		for _, vin := range e.tx.Vin {
+			parent := entries[hex.EncodeToString(vin.Txid)]
+			if parent == nil {
+				continue
+			}
+			sumAncestors(parent)
+			e.ancestors[parent.id] = parent.tx
+			for id, tx := range parent.ancestors {
+				e.ancestors[id] = tx
+			}
+		}
+	}
+
+	queue := make(packageQueue, 0, len(candidates))
+	for _, tx := range candidates {
+		e := entries[hex.EncodeToString(tx.ID)]
+		sumAncestors(e)
+		for id := range e.ancestors {
+			ancestor := entries[id]
+			e.ancestorFee += ancestor.fee
+			e.ancestorSize += ancestor.size
+			ancestor.descendants = append(ancestor.descendants, e)
+		}
+		queue = append(queue, queuedPackage{e, feeRate(e.ancestorFee, e.ancestorSize), e.version})
+	}
+	heap.Init(&queue)
+
+	included := make(map[string]bool)
+	assembly := &packageAssembly{entries: entries, included: included}
+	size := 0
+	for queue.Len() > 0 {
+		best := heap.Pop(&queue).(queuedPackage)
+		e := best.entry
+		if included[e.id] || e.tooLarge || best.version != e.version {
+			continue
+		}
+		if size+e.ancestorSize > maxSize {
+			e.tooLarge = true
+			continue
+		}
+
+		members := []*Transaction{e.tx}
+		for _, ancestor := range e.ancestors {
+			members = append(members, ancestor)
+		}
+		members = orderTransactions(members)
+		for _, tx := range members {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+		assembly.selected = append(assembly.selected, members...)
+		size += e.ancestorSize
+
+		// The included transactions no longer count towards the packages of their descendants
+		var updated []*packageEntry
+		seen := make(map[*packageEntry]bool)
+		for _, tx := range members {
+			member := entries[hex.EncodeToString(tx.ID)]
+			for _, d := range member.descendants {
+				if included[d.id] {
+					continue
+				}
+				delete(d.ancestors, member.id)
+				d.ancestorFee -= member.fee
+				d.ancestorSize -= member.size
+				d.version++
+				if !seen[d] {
+					seen[d] = true
+					updated = append(updated, d)
+				}
+			}
+		}
+		for _, d := range updated {
+			if !d.tooLarge {
+				queue.push(d)
+			}
+		}
+	}
+
+	for _, tx := range candidates {
+		if !included[hex.EncodeToString(tx.ID)] {
+			assembly.leftOut = append(assembly.leftOut, tx)
+		}
+	}
+
+	return assembly
+}
+
+// selectPackages fills a block of up to maxSize bytes from candidates, whose mempool parents must be candidates too
+// Each step takes the candidate whose package with its ancestors not yet selected pays the highest fee rate,
+// so a child paying a high fee pulls in the low-fee parent it spends. Ties go to the earlier candidate
+// Returns the selected transactions, parents first, and the candidates left out for lack of room
+// Similar to Bitcoin's ancestor feerate mining (BlockAssembler::addPackageTxs)
+func selectPackages(candidates []*Transaction, fees map[string]int, maxSize int) ([]*Transaction, []*Transaction) {
+	assembly := assemblePackages(candidates, fees, maxSize)
+
+	return assembly.selected, assembly.leftOut
+}
+
+// EstimateFee returns the fee a new transaction of size bytes should pay to be mined in the next block,
+// judged by the block selectMempoolTransactions would fill now: the minimum relay fee while the whole mempool fits,
+// otherwise enough to beat the best fee rate among the packages left out
+// With parent, the ID of a mempool transaction left out, the new transaction spends it and pays for it:
+// the fee lifts the parent's package together with the child above the cutoff, less what the package already pays
+// Similar to Bitcoin's estimatesmartfee, though it reads the mempool rather than the history of mined blocks
+func (bc *Blockchain) EstimateFee(size int, parent []byte) (int, error) {
+	candidates, fees, _ := bc.mempoolCandidates()
+
+	return estimatePackageFee(candidates, fees, maxBlockTxSize, size, parent)
+}
+
+// estimatePackageFee returns EstimateFee's estimate for a block of up to maxSize bytes filled from candidates
+func estimatePackageFee(candidates []*Transaction, fees map[string]int, maxSize, size int, parent []byte) (int, error) {
+	assembly := assemblePackages(candidates, fees, maxSize)
+
+	// The package being paid for doesn't compete with itself, nor do its other descendants
+	var bumped *packageEntry
+	if parent != nil {
+		bumped = assembly.entries[hex.EncodeToString(parent)]
+		if bumped == nil {
+			return 0, fmt.Errorf("transaction %x is not a mineable mempool transaction", parent)
+		}
+	}
+	inBumped := make(map[string]bool)
+	bumpedFee, bumpedSize := 0, 0
+	if bumped != nil && !assembly.included[bumped.id] {
+		inBumped[bumped.id] = true
+		for id := range bumped.ancestors {
+			inBumped[id] = true
+		}
+		bumpedFee, bumpedSize = bumped.ancestorFee, bumped.ancestorSize
+	}
+
+	// The totals assemblePackages leaves on the left-out entries are those of their packages on top of the block
+	cutoff := -1.0
+LeftOut:
+	for _, tx := range assembly.leftOut {
+		e := assembly.entries[hex.EncodeToString(tx.ID)]
+		if inBumped[e.id] {
+			continue
+		}
+		for id := range e.ancestors {
+			if inBumped[id] {
+				continue LeftOut
+			}
+		}
+		if rate := feeRate(e.ancestorFee, e.ancestorSize); rate > cutoff {
+			cutoff = rate
+		}
+	}
+	if cutoff < 0 {
+		return minRelayFee, nil
+	}
+
+	fee := int(cutoff*float64(bumpedSize+size)) + 1 - bumpedFee
+	if fee < minRelayFee {
+		fee = minRelayFee
+	}
+
+	return fee, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// packageSpec describes a mempool transaction for the package selection tests: the earlier specs it spends and its fee rate
+type packageSpec struct {
+	name    string
+	parents []string
+	rate    float64
+}
+
+// packageCandidates builds unsigned transactions from specs, in order, with fees paying their rates
+// Every transaction is padded to the same size, so rates and sizes are easy to reason about
+func packageCandidates(specs []packageSpec) ([]*Transaction, map[string]int, map[string]string) {
+	address := string(NewWallet().GetAddress())
+	byName := make(map[string]*Transaction)
+	fees := make(map[string]int)
+	names := make(map[string]string)
+	var txs []*Transaction
+
+	for _, spec := range specs {
+		var vin []TXInput
+		for _, parent := range spec.parents {
+			vin = append(vin, TXInput{byName[parent].ID, 0, nil, nil, nil})
+		}
+		for len(vin) < 3 {
+			vin = append(vin, TXInput{make([]byte, 32), len(vin), nil, nil, nil})
+		}
+		memo := []byte(fmt.Sprintf("%-16s", spec.name))
+		tx := &Transaction{nil, vin, []TXOutput{*NewTXOutput(1, address)}, false, memo}
+		tx.ID = tx.Hash()
+
+		id := hex.EncodeToString(tx.ID)
+		byName[spec.name] = tx
+		fees[id] = int(spec.rate * float64(tx.SerializedSize()))
+		names[id] = spec.name
+		txs = append(txs, tx)
+	}
+
+	return txs, fees, names
+}
+
+// txNames returns the spec names of txs
+func txNames(txs []*Transaction, names map[string]string) string {
+	var list []string
+	for _, tx := range txs {
+		list = append(list, names[hex.EncodeToString(tx.ID)])
+	}
+
+	return strings.Join(list, " ")
+}
+
+// sortWords returns the words of s in sorted order
+func sortWords(s string) string {
+	words := strings.Fields(s)
+	sort.Strings(words)
+
+	return strings.Join(words, " ")
+}
+
+func TestSelectPackages(t *testing.T) {
+	tests := []struct {
+		name         string
+		specs        []packageSpec
+		blockTxs     int // Room in the block, in transactions
+		wantSelected string
+		wantLeftOut  string
+	}{
+		{"child pays for its parent", []packageSpec{{"parent", nil, 0}, {"child", []string{"parent"}, 4}, {"other", nil, 1.5}}, 2, "parent child", "other"},
+		{"child pays for its grandparent", []packageSpec{{"grandparent", nil, 0}, {"parent", []string{"grandparent"}, 0}, {"child", []string{"parent"}, 9}, {"other", nil, 2}}, 3, "grandparent parent child", "other"},
+		{"child left out once its parent is in", []packageSpec{{"parent", nil, 4}, {"child", []string{"parent"}, 0.5}, {"other", nil, 1}}, 2, "parent other", "child"},
+		{"child of two parents", []packageSpec{{"a", nil, 1}, {"b", nil, 0}, {"child", []string{"a", "b"}, 5}, {"other", nil, 1.8}}, 3, "a b child", "other"},
+		{"parents fill the room their package didn't fit", []packageSpec{{"a", nil, 1}, {"b", nil, 0}, {"child", []string{"a", "b"}, 5}, {"other", nil, 2.5}}, 3, "other a b", "child"},
+		{"ties go to the earlier candidate", []packageSpec{{"first", nil, 1}, {"second", nil, 1}}, 1, "first", "second"},
+		{"package too large for the room left", []packageSpec{{"parent", nil, 0}, {"child", []string{"parent"}, 5}, {"other", nil, 1}}, 1, "other", "parent child"},
+		{"no room", []packageSpec{{"tx", nil, 1}}, 0, "", "tx"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txs, fees, names := packageCandidates(tt.specs)
+			selected, leftOut := selectPackages(txs, fees, tt.blockTxs*txs[0].SerializedSize())
+
+			// Parents of a package come in canonical order, so only the set and the parents-first order are checked
+			if got := txNames(selected, names); sortWords(got) != sortWords(tt.wantSelected) {
+				t.Errorf("selected %q, want %q", got, tt.wantSelected)
+			}
+			placed := make(map[string]bool)
+			for _, tx := range selected {
+				for _, vin := range tx.Vin {
+					if parent := hex.EncodeToString(vin.Txid); names[parent] != "" && !placed[parent] {
+						t.Errorf("%s selected before its parent %s", names[hex.EncodeToString(tx.ID)], names[parent])
+					}
+				}
+				placed[hex.EncodeToString(tx.ID)] = true
+			}
+			if got := txNames(leftOut, names); got != tt.wantLeftOut {
+				t.Errorf("left out %q, want %q", got, tt.wantLeftOut)
+			}
+		})
+	}
+}
+
+// recomputedSelection is selectPackages summing every candidate's package again at each step, as it once did
+func recomputedSelection(candidates []*Transaction, fees map[string]int, maxSize int) ([]*Transaction, map[string]bool) {
+	byID := make(map[string]*Transaction)
+	for _, tx := range candidates {
+		byID[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	var selected []*Transaction
+	included := make(map[string]bool)
+	tooLarge := make(map[string]bool)
+	size := 0
+	for {
+		var best []*Transaction
+		var bestID string
+		bestRate, bestSize := -1.0, 0
+		for _, tx := range candidates {
+			id := hex.EncodeToString(tx.ID)
+			if included[id] || tooLarge[id] {
+				continue
+			}
+			members := packageAncestors(tx, byID, included)
+			fee, pkgSize := recomputedTotals(members, fees)
+			if rate := feeRate(fee, pkgSize); rate > bestRate {
+				best, bestID, bestRate, bestSize = members, id, rate, pkgSize
+			}
+		}
+		if best == nil {
+			return selected, included
+		}
+		if size+bestSize > maxSize {
+			tooLarge[bestID] = true
+			continue
+		}
+		for _, tx := range best {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+		selected = append(selected, best...)
+		size += bestSize
+	}
+}
+
+// recomputedTotals sums the fees and sizes of members
+func recomputedTotals(members []*Transaction, fees map[string]int) (int, int) {
+	fee, size := 0, 0
+	for _, tx := range members {
+		fee += fees[hex.EncodeToString(tx.ID)]
+		size += tx.SerializedSize()
+	}
+
+	return fee, size
+}
+
+// recomputedFeeEstimate is estimatePackageFee summing the packages left out from scratch
+func recomputedFeeEstimate(candidates []*Transaction, fees map[string]int, maxSize, size int, parent []byte) int {
+	byID := make(map[string]*Transaction)
+	for _, tx := range candidates {
+		byID[hex.EncodeToString(tx.ID)] = tx
+	}
+	_, included := recomputedSelection(candidates, fees, maxSize)
+
+	var bumped []*Transaction
+	if parent != nil && !included[hex.EncodeToString(parent)] {
+		bumped = packageAncestors(byID[hex.EncodeToString(parent)], byID, included)
+	}
+	inBumped := make(map[string]bool)
+	for _, tx := range bumped {
+		inBumped[hex.EncodeToString(tx.ID)] = true
+	}
+
+	cutoff := -1.0
+LeftOut:
+	for _, tx := range candidates {
+		if included[hex.EncodeToString(tx.ID)] {
+			continue
+		}
+		members := packageAncestors(tx, byID, included)
+		for _, member := range members {
+			if inBumped[hex.EncodeToString(member.ID)] {
+				continue LeftOut
+			}
+		}
+		fee, pkgSize := recomputedTotals(members, fees)
+		cutoff = max(cutoff, feeRate(fee, pkgSize))
+	}
+	if cutoff < 0 {
+		return minRelayFee
+	}
+
+	bumpedFee, bumpedSize := recomputedTotals(bumped, fees)
+	return max(int(cutoff*float64(bumpedSize+size))+1-bumpedFee, minRelayFee)
+}
+
+// randomMempool returns n transactions, each spending up to two earlier ones, with random fee rates
+func randomMempool(rng *rand.Rand, n int) []packageSpec {
+	specs := make([]packageSpec, n)
+	for i := range specs {
+		specs[i].name = fmt.Sprintf("tx%d", i)
+		specs[i].rate = float64(rng.Intn(1000)) / 100
+		for j := 0; j < 2 && i > 0; j++ {
+			parent := specs[rng.Intn(i)].name
+			if rng.Intn(2) == 0 && !containsNode(specs[i].parents, parent) {
+				specs[i].parents = append(specs[i].parents, parent)
+			}
+		}
+	}
+
+	return specs
+}
+
+func TestSelectPackagesMatchesRecomputation(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for round := 0; round < 50; round++ {
+		txs, fees, names := packageCandidates(randomMempool(rng, 40))
+		maxSize := rng.Intn(40) * txs[0].SerializedSize()
+
+		selected, _ := selectPackages(txs, fees, maxSize)
+		want, _ := recomputedSelection(txs, fees, maxSize)
+		if got, want := txNames(selected, names), txNames(want, names); got != want {
+			t.Fatalf("round %d: selected %q, want %q", round, got, want)
+		}
+
+		parent := txs[rng.Intn(len(txs))].ID
+		for _, parent := range [][]byte{nil, parent} {
+			got, err := estimatePackageFee(txs, fees, maxSize, typicalTxSize, parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := recomputedFeeEstimate(txs, fees, maxSize, typicalTxSize, parent); got != want {
+				t.Fatalf("round %d: estimated fee %d with parent %x, want %d", round, got, parent, want)
+			}
+		}
+	}
+}
+
+func BenchmarkSelectPackages(b *testing.B) {
+	txs, fees, _ := packageCandidates(randomMempool(rand.New(rand.NewSource(1)), 500))
+	maxSize := 250 * txs[0].SerializedSize()
+
+	b.Run("incremental", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			selectPackages(txs, fees, maxSize)
+		}
+	})
+	b.Run("recomputed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			recomputedSelection(txs, fees, maxSize)
+		}
+	})
+}
+
+func TestMempoolPackages(t *testing.T) {
+	tests := []struct {
+		name      string
+		parentFee int
+		childFee  int // 0 for no child
+	}{
+		{"lone transaction", 2, 0},
+		{"child paying for its parent", 1, 6},
+		{"child paying less than its parent", 5, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := newTestChain(t)
+			address := string(w.GetAddress())
+
+			parent := spendCoinbase(t, bc, w, address, tt.parentFee)
+			if err := bc.AddToMempool(parent); err != nil {
+				t.Fatal(err)
+			}
+			pooled, fees := []*Transaction{parent}, []int{tt.parentFee}
+			if tt.childFee > 0 {
+				child := spendOutput(t, bc, w, parent, 0, address, tt.childFee, false)
+				if err := bc.AddToMempool(child); err != nil {
+					t.Fatal(err)
+				}
+				pooled, fees = append(pooled, child), append(fees, tt.childFee)
+			}
+
+			// The parent's descendant package and the last transaction's ancestor package are the whole mempool
+			totalFee, totalSize := 0, 0
+			for i, tx := range pooled {
+				totalFee += fees[i]
+				totalSize += tx.SerializedSize()
+			}
+			stats := make(map[string]*MempoolTxStats)
+			for _, s := range bc.MempoolPackageStats() {
+				stats[hex.EncodeToString(s.Tx.ID)] = s
+			}
+			if len(stats) != len(pooled) {
+				t.Fatalf("stats for %d transactions, want %d", len(stats), len(pooled))
+			}
+			first, last := stats[hex.EncodeToString(parent.ID)], stats[hex.EncodeToString(pooled[len(pooled)-1].ID)]
+			if first.Descendants != len(pooled) || first.DescendantFee != totalFee || first.DescendantSize != totalSize {
+				t.Errorf("parent descendants %d, fee %d, size %d, want %d, %d, %d", first.Descendants, first.DescendantFee, first.DescendantSize, len(pooled), totalFee, totalSize)
+			}
+			if last.Ancestors != len(pooled) || last.AncestorFee != totalFee || last.AncestorSize != totalSize {
+				t.Errorf("last ancestors %d, fee %d, size %d, want %d, %d, %d", last.Ancestors, last.AncestorFee, last.AncestorSize, len(pooled), totalFee, totalSize)
+			}
+
+			txs, fee, skipped := bc.selectMempoolTransactions()
+			if len(skipped) != 0 || fee != totalFee || len(txs) != len(pooled) || !bytes.Equal(txs[0].ID, parent.ID) {
+				t.Fatalf("selected %d transactions with fee %d (skipped %v), want %d, parent first, with fee %d", len(txs), fee, skipped, len(pooled), totalFee)
+			}
+
+			// While the whole mempool fits in a block, the minimum relay fee is enough, also to bump a transaction
+			for _, bumped := range append([][]byte{nil}, parent.ID) {
+				if estimate, err := bc.EstimateFee(typicalTxSize, bumped); err != nil || estimate != minRelayFee {
+					t.Fatalf("EstimateFee bumping %x = %d, %v, want %d", bumped, estimate, err, minRelayFee)
+				}
+			}
+			if _, err := bc.EstimateFee(typicalTxSize, NewWallet().PublicKey); err == nil {
+				t.Fatal("EstimateFee bumping a transaction not in the mempool succeeded")
+			}
+		})
+	}
+}
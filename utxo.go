@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// utxoBucket holds the unspent outputs of every transaction, keyed by transaction ID
+const utxoBucket = "chainstate"
+
+// utxoTipKey is the meta key recording the block the UTXO set is up to date with
+const utxoTipKey = "utxotip"
+
+// utxoEntryVersion is the leading byte of UTXO entries that record the height of their block
+// Entries written before heights were recorded start with codecVersion and decode with height 0
+const utxoEntryVersion = byte(0x02)
+
+// UTXOEntry is an unspent output along with its outpoint and confirmations
+type UTXOEntry struct {
+	TxID          []byte
+	Vout          int
+	Output        TXOutput
+	Confirmations int
+}
+
+// UTXOSet is an index of all unspent transaction outputs, kept in step with the chain tip
+// It answers balance and coin selection queries without scanning every block,
+// and keeps working after the blocks' transactions have been pruned
+// Similar to Geth's state.StateDB, which plays the same role for account balances
+type UTXOSet struct {
+	bc *Blockchain
+}
+
+// IsCurrent reports whether the UTXO set reflects the current tip
+// A stale set (e.g. in a DB written before the set existed) must be reindexed before use
+func (u UTXOSet) IsCurrent() bool {
+	indexed := u.IndexedTip()
+
+	return indexed != nil && bytes.Equal(indexed, u.bc.Tip())
+}
+
+// IndexedTip returns the block the UTXO set was last brought up to date with,
+// or nil if the set has never been built
+func (u UTXOSet) IndexedTip() []byte {
+	var indexed []byte
+
+	err := u.bc.db.View(func(tx StoreTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		if meta == nil || tx.Bucket([]byte(utxoBucket)) == nil {
+			return nil
+		}
+		if tip := meta.Get([]byte(utxoTipKey)); tip != nil {
+			indexed = append([]byte{}, tip...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	return indexed
+}
+
+// Reindex rebuilds the UTXO set from the blocks, from genesis to tip
+// Fails if any block of the chain has been pruned
+func (u UTXOSet) Reindex() error {
+	blocks := u.bc.blocksFromGenesis()
+
+	return u.bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(utxoBucket)) != nil {
+			if err := tx.DeleteBucket([]byte(utxoBucket)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(utxoBucket)); err != nil {
+			return err
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+		if err := meta.Delete([]byte(utxoTipKey)); err != nil {
+			return err
+		}
+
+		for height, block := range blocks {
+			if block.IsPruned() {
+				return fmt.Errorf("can't rebuild the UTXO set: block %x has been pruned", block.Hash)
+			}
+			if err := connectBlockUTXO(tx, block, height+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// FindSpendableOutputs finds unspent outputs locked with pubKeyHash worth at least amount
+// Outpoints ("txid:index") in exclude are skipped, e.g. those already spent by the mempool
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int, exclude map[string]bool) (int, map[string][]int) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+
+	err := u.bc.db.View(func(tx StoreTx) error {
+		c := tx.Bucket([]byte(utxoBucket)).Cursor()
+
+		for k, v := c.First(); k != nil && accumulated < amount; k, v = c.Next() {
+			txID := hex.EncodeToString(k)
+			outs, _, err := decodeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+
+			for _, outIdx := range sortedOutputIndexes(outs) {
+				out := outs[outIdx]
+				if !out.IsLockedWithKey(pubKeyHash) || accumulated >= amount || exclude[fmt.Sprintf("%s:%d", txID, outIdx)] {
+					continue
+				}
+
+				sum, err := addValue(accumulated, out.Value)
+				if err != nil {
+					// Already far beyond any requestable amount
+					sum = math.MaxInt
+				}
+				accumulated = sum
+				unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("Reading UTXO set: %s", err)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+// FindUTXO returns the unspent outputs locked with pubKeyHash
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
+	var UTXOs []TXOutput
+
+	err := u.bc.db.View(func(tx StoreTx) error {
+		c := tx.Bucket([]byte(utxoBucket)).Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, _, err := decodeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+
+			for _, outIdx := range sortedOutputIndexes(outs) {
+				out := outs[outIdx]
+				if out.IsLockedWithKey(pubKeyHash) {
+					UTXOs = append(UTXOs, out)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("Reading UTXO set: %s", err)
+	}
+
+	return UTXOs
+}
+
+// ListUnspent returns each unspent output locked with pubKeyHash, ordered by outpoint
+// Similar to Bitcoin's listunspent RPC
+func (u UTXOSet) ListUnspent(pubKeyHash []byte) ([]UTXOEntry, error) {
+	var entries []UTXOEntry
+	bestHeight := u.bc.GetBestHeight()
+
+	err := u.bc.db.View(func(tx StoreTx) error {
+		c := tx.Bucket([]byte(utxoBucket)).Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, height, err := decodeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+
+			for _, outIdx := range sortedOutputIndexes(outs) {
+				out := outs[outIdx]
+				if !out.IsLockedWithKey(pubKeyHash) {
+					continue
+				}
+
+				confirmations := 0
+				if height > 0 {
+					confirmations = bestHeight - height + 1
+				}
+				entries = append(entries, UTXOEntry{append([]byte{}, k...), outIdx, out, confirmations})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries written before heights were recorded: look the transaction up in the chain
+	for i := range entries {
+		if entries[i].Confirmations == 0 {
+			entries[i].Confirmations, _ = u.bc.GetTransactionConfirmations(entries[i].TxID)
+		}
+	}
+
+	return entries, nil
+}
+
+// FindOutputs returns the unspent outputs of a transaction, keyed by output index
+func (u UTXOSet) FindOutputs(txID []byte) (map[int]TXOutput, error) {
+	var outs map[int]TXOutput
+
+	err := u.bc.db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			return errors.New("UTXO set does not exist")
+		}
+
+		data := b.Get(txID)
+		if data == nil {
+			return errors.New("Transaction has no unspent outputs")
+		}
+
+		var err error
+		outs, _, err = decodeUTXOEntry(data)
+		return err
+	})
+
+	return outs, err
+}
+
+// CheckInputsUnspent fails if tx spends an outpoint twice, or one that isn't in the UTXO set, with an InputError
+// (already spent by an earlier block, or never created)
+// Outputs created by the pending transactions (the mempool) also count as unspent
+func (u UTXOSet) CheckInputsUnspent(tx *Transaction, pending []*Transaction) error {
+	seen := make(map[string]bool)
+	pendingOutputs := make(map[string]int)
+	for _, pendingTx := range pending {
+		pendingOutputs[hex.EncodeToString(pendingTx.ID)] = len(pendingTx.Vout)
+	}
+
+	for inID, vin := range tx.Vin {
+		outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+		if seen[outpoint] {
+			return tx.inputError(inID, fmt.Errorf("%w: the output is spent twice by this transaction", errDoubleSpend))
+		}
+		seen[outpoint] = true
+
+		if count, ok := pendingOutputs[hex.EncodeToString(vin.Txid)]; ok && vin.Vout >= 0 && vin.Vout < count {
+			continue
+		}
+		outs, err := u.FindOutputs(vin.Txid)
+		if _, ok := outs[vin.Vout]; err != nil || !ok {
+			return tx.inputError(inID, fmt.Errorf("%w: the output is already spent or doesn't exist", errDoubleSpend))
+		}
+	}
+
+	return nil
+}
+
+// CountOutputs returns the number of unspent outputs and of transactions holding them
+func (u UTXOSet) CountOutputs() (int, int, error) {
+	outputs, transactions := 0, 0
+
+	err := u.bc.db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			return errors.New("UTXO set does not exist")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			outs, _, err := decodeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			outputs += len(outs)
+			transactions++
+			return nil
+		})
+	})
+
+	return outputs, transactions, err
+}
+
+// findPrevTransaction returns the transaction an input spends from
+// Unspent outputs come from the UTXO set, so inputs of pruned blocks can still be signed and verified;
+// the returned transaction then only carries its unspent outputs
+// Parents that aren't confirmed yet are looked up in the mempool
+func (bc *Blockchain) findPrevTransaction(txID []byte) (Transaction, error) {
+	utxos := UTXOSet{bc}
+	if utxos.IsCurrent() {
+		if outs, err := utxos.FindOutputs(txID); err == nil && len(outs) > 0 {
+			vout := make([]TXOutput, sortedOutputIndexes(outs)[len(outs)-1]+1)
+			for outIdx, out := range outs {
+				vout[outIdx] = out
+			}
+
+			return Transaction{txID, nil, vout, false, nil}, nil
+		}
+	}
+
+	tx, _, err := bc.findChainTransaction(txID)
+	if err != nil {
+		// An unconfirmed parent; mining places the spending transaction after it
+		if pooled, poolErr := bc.findMempoolTransaction(txID); poolErr == nil {
+			return pooled, nil
+		}
+	}
+
+	return tx, err
+}
+
+// connectBlockUTXO applies a block at the given height to the UTXO set inside a DB update
+// The block must extend the block the set is up to date with; otherwise the set is left stale
+func connectBlockUTXO(tx StoreTx, block *Block, height int) error {
+	meta := tx.Bucket([]byte(metaBucket))
+	b := tx.Bucket([]byte(utxoBucket))
+	if meta == nil || b == nil || !bytes.Equal(meta.Get([]byte(utxoTipKey)), block.PrevBlockHash) {
+		return nil
+	}
+
+	for _, transaction := range block.Transactions {
+		if !transaction.IsCoinbase() {
+			for _, vin := range transaction.Vin {
+				data := b.Get(vin.Txid)
+				if data == nil {
+					return fmt.Errorf("input %x:%d spends a missing output", vin.Txid, vin.Vout)
+				}
+				outs, outsHeight, err := decodeUTXOEntry(data)
+				if err != nil {
+					return err
+				}
+				if _, ok := outs[vin.Vout]; !ok {
+					return fmt.Errorf("input %x:%d spends an already spent output", vin.Txid, vin.Vout)
+				}
+
+				delete(outs, vin.Vout)
+				if len(outs) == 0 {
+					err = b.Delete(vin.Txid)
+				} else {
+					err = b.Put(vin.Txid, encodeUTXOEntry(outs, outsHeight))
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		outs := make(map[int]TXOutput)
+		for outIdx, out := range transaction.Vout {
+			if out.IsData() {
+				// Data outputs can never be spent, so they never enter the set
+				continue
+			}
+			outs[outIdx] = out
+		}
+		if len(outs) == 0 {
+			continue
+		}
+		// Overwriting a transaction's unspent outputs with those of another of the same ID would destroy them
+		// Similar to Bitcoin's BIP 30
+		if b.Get(transaction.ID) != nil {
+			return fmt.Errorf("transaction %x has the ID of a transaction with unspent outputs", transaction.ID)
+		}
+		if err := b.Put(transaction.ID, encodeUTXOEntry(outs, height)); err != nil {
+			return err
+		}
+	}
+
+	return meta.Put([]byte(utxoTipKey), block.Hash)
+}
+
+// encodeUTXOEntry encodes the unspent outputs of a transaction and the height of its block,
+// with the outputs ordered by index
+func encodeUTXOEntry(outs map[int]TXOutput, height int) []byte {
+	enc := &codecWriter{}
+	enc.buf.WriteByte(utxoEntryVersion)
+
+	enc.writeInt(int64(height))
+	enc.writeLen(len(outs))
+	for _, outIdx := range sortedOutputIndexes(outs) {
+		enc.writeInt(int64(outIdx))
+		enc.writeOutput(outs[outIdx])
+	}
+
+	return enc.buf.Bytes()
+}
+
+// decodeUTXOEntry decodes an entry written by encodeUTXOEntry, returning the outputs and block height
+// The height of entries written before heights were recorded is 0
+func decodeUTXOEntry(data []byte) (map[int]TXOutput, int, error) {
+	if len(data) == 0 || (data[0] != utxoEntryVersion && data[0] != codecVersion) {
+		return nil, 0, errors.New("invalid UTXO entry")
+	}
+
+	dec := &codecReader{data: data[1:]}
+	outs := make(map[int]TXOutput)
+
+	height := 0
+	if data[0] == utxoEntryVersion {
+		height = int(dec.readInt())
+	}
+	count := dec.readLen()
+	for i := 0; i < count && dec.err == nil; i++ {
+		outIdx := int(dec.readInt())
+		outs[outIdx] = dec.readOutput()
+	}
+
+	if err := dec.finish(); err != nil {
+		return nil, 0, fmt.Errorf("invalid UTXO entry: %s", err)
+	}
+
+	return outs, height, nil
+}
+
+// sortedOutputIndexes returns the output indexes of an entry in ascending order
+func sortedOutputIndexes(outs map[int]TXOutput) []int {
+	indexes := make([]int, 0, len(outs))
+	for outIdx := range outs {
+		indexes = append(indexes, outIdx)
+	}
+	sort.Ints(indexes)
+
+	return indexes
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReindexRepairsCorruptedSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(tx StoreTx) error
+	}{
+		{"entries deleted", func(tx StoreTx) error {
+			b := tx.Bucket([]byte(utxoBucket))
+			var keys [][]byte
+			b.ForEach(func(k, v []byte) error {
+				keys = append(keys, append([]byte{}, k...))
+				return nil
+			})
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"unreadable entry", func(tx StoreTx) error {
+			return tx.Bucket([]byte(utxoBucket)).Put([]byte("not a transaction ID"), []byte("garbage"))
+		}},
+		{"bucket dropped", func(tx StoreTx) error {
+			return tx.DeleteBucket([]byte(utxoBucket))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, alice := newTestChain(t)
+			bob, miner := NewWallet(), NewWallet()
+			toBob := spendCoinbase(t, bc, alice, string(bob.GetAddress()), 1)
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(string(miner.GetAddress()), "", 1, 2), toBob)); err != nil {
+				t.Fatal(err)
+			}
+			want := map[*Wallet]int{alice: 0, bob: subsidy - 1, miner: subsidy + 1}
+
+			if err := bc.db.Update(tt.corrupt); err != nil {
+				t.Fatal(err)
+			}
+			if err := (UTXOSet{bc}).Reindex(); err != nil {
+				t.Fatalf("Reindex: %s", err)
+			}
+
+			if !(UTXOSet{bc}).IsCurrent() {
+				t.Fatal("UTXO set not current after reindexing")
+			}
+			for w, balance := range want {
+				if got, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || got != balance {
+					t.Errorf("balance %d, %v, want %d", got, err, balance)
+				}
+			}
+			if outputs, transactions, err := (UTXOSet{bc}).CountOutputs(); err != nil || outputs != 2 || transactions != 2 {
+				t.Errorf("CountOutputs = %d, %d, %v, want 2 outputs in 2 transactions", outputs, transactions, err)
+			}
+		})
+	}
+}
+
+// splitOutput returns a transaction of w spending the first output of prev into outputs of values paying to, signed on bc
+func splitOutput(t *testing.T, bc *Blockchain, w *Wallet, prev *Transaction, to string, values ...int) *Transaction {
+	t.Helper()
+
+	tx := &Transaction{nil, []TXInput{{prev.ID, 0, nil, w.PublicKey, nil}}, nil, false, nil}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, *NewTXOutput(value, to))
+	}
+	tx.ID = tx.Hash()
+	if err := bc.SignTransaction(tx, w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestListUnspentSumsToBalance(t *testing.T) {
+	bc, alice := newTestChain(t)
+	address := string(alice.GetAddress())
+	genesis, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := NewCoinbaseTX(address, "", 0, 1)
+	if err := bc.AddBlock(peerBlock(t, bc, first)); err != nil {
+		t.Fatal(err)
+	}
+	prev := genesis.Transactions[0]
+	split := splitOutput(t, bc, alice, prev, address, 3, prev.Vout[0].Value-3-1)
+	second := NewCoinbaseTX(address, "", 1, 2)
+	if err := bc.AddBlock(peerBlock(t, bc, second, split)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := (UTXOSet{bc}).ListUnspent(HashPubKey(alice.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		fmt.Sprintf("%x:0", first.ID):  2,
+		fmt.Sprintf("%x:0", second.ID): 1,
+		fmt.Sprintf("%x:0", split.ID):  1,
+		fmt.Sprintf("%x:1", split.ID):  1,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d unspent outputs, want %d", len(entries), len(want))
+	}
+	total := 0
+	for i, entry := range entries {
+		outpoint := fmt.Sprintf("%x:%d", entry.TxID, entry.Vout)
+		if confirmations, ok := want[outpoint]; !ok || entry.Confirmations != confirmations {
+			t.Errorf("%s has %d confirmations, want %d", outpoint, entry.Confirmations, want[outpoint])
+		}
+		if i > 0 && fmt.Sprintf("%x:%d", entries[i-1].TxID, entries[i-1].Vout) >= outpoint {
+			t.Errorf("%s listed after %x:%d", outpoint, entries[i-1].TxID, entries[i-1].Vout)
+		}
+		total += entry.Output.Value
+	}
+
+	balance, err := bc.Balance(HashPubKey(alice.PublicKey), 1)
+	if err != nil || total != balance {
+		t.Fatalf("outputs total %d, balance %d, %v", total, balance, err)
+	}
+	if want := prev.Vout[0].Value + first.Vout[0].Value + second.Vout[0].Value - 1; total != want {
+		t.Fatalf("outputs total %d, want %d", total, want)
+	}
+}
+
+func TestStaleIndexesRebuiltOnOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantLog func(indexed, tip []byte) string
+		corrupt func(tx StoreTx) error
+	}{
+		{"block written by an older binary", func(indexed, tip []byte) string {
+			return fmt.Sprintf("UTXO set is indexed at block %x but the tip is %x; rebuilding", indexed, tip)
+		}, nil},
+		{"UTXO set never built", func(indexed, tip []byte) string {
+			return "Rebuilding UTXO set"
+		}, func(tx StoreTx) error {
+			return tx.DeleteBucket([]byte(utxoBucket))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			bc, w := newTestChainWithStore(t, store)
+			address := string(w.GetAddress())
+			indexed := bc.Tip()
+
+			// Store a block paying w the way a binary without the indexes would: only the block and the tip
+			coinbase := NewCoinbaseTX(address, "", 0, 2)
+			block := peerBlock(t, bc, coinbase)
+			err := bc.db.Update(func(tx StoreTx) error {
+				b := tx.Bucket([]byte(blocksBucket))
+				if err := b.Put(block.Hash, block.Serialize()); err != nil {
+					return err
+				}
+				if err := b.Put([]byte("l"), block.Hash); err != nil {
+					return err
+				}
+				if tt.corrupt != nil {
+					return tt.corrupt(tx)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			log := useLogger(t, LevelInfo)
+			reopened, err := NewBlockchainWithStore(store, DefaultGenesis(address), defaultChainID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.wantLog(indexed, block.Hash); !strings.Contains(log.String(), want) {
+				t.Fatalf("log:\n%s\nwant %q", log, want)
+			}
+
+			if !(UTXOSet{reopened}).IsCurrent() || !(TxIndex{reopened}).IsCurrent() {
+				t.Fatal("indexes not current after opening")
+			}
+			if balance, err := reopened.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != 2*subsidy {
+				t.Fatalf("balance %d, %v, want %d", balance, err, 2*subsidy)
+			}
+			if hash, err := (TxIndex{reopened}).BlockHash(coinbase.ID); err != nil || !bytes.Equal(hash, block.Hash) {
+				t.Fatalf("transaction index maps the new coinbase to %x, %v, want %x", hash, err, block.Hash)
+			}
+		})
+	}
+}
+
+func TestUTXOStats(t *testing.T) {
+	tests := []struct {
+		name        string
+		stale       bool
+		wantScanned bool
+	}{
+		{"current UTXO set", false, false},
+		{"stale UTXO set", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, alice := newTestChain(t)
+			address := string(alice.GetAddress())
+			genesis, err := bc.GetBlock(bc.Tip())
+			if err != nil {
+				t.Fatal(err)
+			}
+			split := splitOutput(t, bc, alice, genesis.Transactions[0], address, dustThreshold, 3, subsidy-dustThreshold-3-1)
+			if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(address, "", 1, 2), split)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.stale {
+				err := bc.db.Update(func(tx StoreTx) error {
+					return tx.Bucket([]byte(metaBucket)).Delete([]byte(utxoTipKey))
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stats, err := bc.UTXOStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Outputs != 4 || stats.Transactions != 2 || stats.TotalValue != 2*subsidy || stats.Scanned != tt.wantScanned {
+				t.Fatalf("%d outputs in %d transactions worth %d, scanned %t; want 4 in 2 worth %d, scanned %t",
+					stats.Outputs, stats.Transactions, stats.TotalValue, stats.Scanned, 2*subsidy, tt.wantScanned)
+			}
+			want := map[string][2]int{
+				"dust":  {1, dustThreshold},
+				"small": {2, subsidy - dustThreshold - 1},
+				"large": {1, subsidy + 1},
+			}
+			for _, class := range stats.Classes {
+				if got := [2]int{class.Outputs, class.Value}; got != want[class.Name] {
+					t.Errorf("%s: %d output(s) worth %d, want %d worth %d", class.Name, got[0], got[1], want[class.Name][0], want[class.Name][1])
+				}
+			}
+		})
+	}
+}
+
+func TestBalanceMinConf(t *testing.T) {
+	bc, w := newTestChain(t)
+	alice, bob := string(w.GetAddress()), NewWallet()
+	spend := spendCoinbase(t, bc, w, string(bob.GetAddress()), 1)
+	if err := bc.AddBlocks(chainBlocks(t, bc, coinbaseBlocks(bc, alice, 2)...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddToMempool(spend); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice has coinbases with 3, 2 and 1 confirmations, the first spent by the mempool; bob receives it unconfirmed
+	tests := []struct {
+		name       string
+		pubKeyHash []byte
+		minConf    int
+		want       int
+	}{
+		{"unconfirmed spend left out", HashPubKey(w.PublicKey), 0, 2 * subsidy},
+		{"confirmed", HashPubKey(w.PublicKey), 1, 3 * subsidy},
+		{"two confirmations", HashPubKey(w.PublicKey), 2, 2 * subsidy},
+		{"three confirmations", HashPubKey(w.PublicKey), 3, subsidy},
+		{"more confirmations than blocks", HashPubKey(w.PublicKey), 4, 0},
+		{"unconfirmed output counted", HashPubKey(bob.PublicKey), 0, subsidy - 1},
+		{"unconfirmed output not counted", HashPubKey(bob.PublicKey), 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if balance, err := bc.Balance(tt.pubKeyHash, tt.minConf); err != nil || balance != tt.want {
+				t.Fatalf("balance %d, %v, want %d", balance, err, tt.want)
+			}
+		})
+	}
+
+	// Without an up-to-date UTXO set only the default minconf is answered, from the blocks
+	if err := bc.db.Update(func(tx StoreTx) error { return tx.DeleteBucket([]byte(utxoBucket)) }); err != nil {
+		t.Fatal(err)
+	}
+	if balance, err := bc.Balance(HashPubKey(w.PublicKey), 1); err != nil || balance != 3*subsidy {
+		t.Fatalf("balance without a UTXO set %d, %v, want %d", balance, err, 3*subsidy)
+	}
+	if _, err := bc.Balance(HashPubKey(w.PublicKey), 2); err == nil {
+		t.Fatal("minconf 2 answered without a UTXO set")
+	}
+}
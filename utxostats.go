@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// UTXOValueClass is a range of output values in UTXOStats, with the outputs falling into it
+type UTXOValueClass struct {
+	Name    string
+	Min     int // Smallest value of the class
+	Max     int // Largest value of the class, or 0 for no limit
+	Outputs int
+	Value   int
+}
+
+// UTXOStats summarizes the unspent outputs, i.e. the state a node must keep to validate new transactions
+// Similar to Bitcoin's gettxoutsetinfo RPC
+type UTXOStats struct {
+	Outputs      int
+	Transactions int // Transactions with at least one unspent output
+	TotalValue   int
+	Classes      []UTXOValueClass // Dust, small and large outputs
+	Scanned      bool             // Computed from the blocks, as the UTXO set was stale
+}
+
+// newUTXOValueClasses returns the value classes of UTXOStats, empty
+// Dust is what the relay policy barely accepts, and large outputs are worth at least a block subsidy
+func newUTXOValueClasses() []UTXOValueClass {
+	return []UTXOValueClass{
+		{"dust", 0, dustThreshold, 0, 0},
+		{"small", dustThreshold + 1, subsidy - 1, 0, 0},
+		{"large", subsidy, 0, 0, 0},
+	}
+}
+
+// add counts an unspent output
+func (s *UTXOStats) add(out TXOutput) error {
+	var err error
+	s.TotalValue, err = addValue(s.TotalValue, out.Value)
+	if err != nil {
+		return err
+	}
+	s.Outputs++
+
+	for i := range s.Classes {
+		class := &s.Classes[i]
+		if out.Value >= class.Min && (class.Max == 0 || out.Value <= class.Max) {
+			class.Outputs++
+			class.Value += out.Value
+			break
+		}
+	}
+
+	return nil
+}
+
+// UTXOStats computes the statistics of the UTXO set
+// A stale set (e.g. opened read-only behind the tip) is bypassed by replaying the blocks instead
+func (bc *Blockchain) UTXOStats() (UTXOStats, error) {
+	stats := UTXOStats{Classes: newUTXOValueClasses()}
+
+	utxos := UTXOSet{bc}
+	if !utxos.IsCurrent() {
+		stats.Scanned = true
+		err := bc.scanUTXOStats(&stats)
+		return stats, err
+	}
+
+	err := bc.db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			return errors.New("UTXO set does not exist")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			outs, _, err := decodeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			stats.Transactions++
+			for _, out := range outs {
+				if err := stats.add(out); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	return stats, err
+}
+
+// scanUTXOStats fills stats from the outputs left unspent by the blocks, from genesis to tip
+// Fails if any block of the chain has been pruned
+func (bc *Blockchain) scanUTXOStats(stats *UTXOStats) error {
+	unspent := make(map[string]map[int]TXOutput)
+
+	for _, block := range bc.blocksFromGenesis() {
+		if block.IsPruned() {
+			return fmt.Errorf("can't scan the chain: block %x has been pruned", block.Hash)
+		}
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for _, vin := range tx.Vin {
+					outs := unspent[hex.EncodeToString(vin.Txid)]
+					delete(outs, vin.Vout)
+					if len(outs) == 0 {
+						delete(unspent, hex.EncodeToString(vin.Txid))
+					}
+				}
+			}
+
+			outs := make(map[int]TXOutput)
+			for outIdx, out := range tx.Vout {
+				if !out.IsData() {
+					outs[outIdx] = out
+				}
+			}
+			if len(outs) > 0 {
+				unspent[hex.EncodeToString(tx.ID)] = outs
+			}
+		}
+	}
+
+	for _, outs := range unspent {
+		stats.Transactions++
+		for _, out := range outs {
+			if err := stats.add(out); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
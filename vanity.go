@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxVanityAttempts bounds the keys generated by a vanity search, across all workers
+const maxVanityAttempts = 10000000
+
+// vanityWarnAttempts is the expected number of attempts above which a search is reported as slow
+const vanityWarnAttempts = 1000000
+
+// VanityAttempts estimates how many keys must be generated to find an address starting with prefix
+// The first character is mostly fixed by the version byte, so only the following ones count
+func VanityAttempts(prefix string) float64 {
+	if len(prefix) <= 1 {
+		return 1
+	}
+
+	return math.Pow(float64(len(b58Alphabet)), float64(len(prefix)-1))
+}
+
+// FindVanityWallet generates keys on workers goroutines until one's address starts with prefix
+// Gives up after maxAttempts keys; returns the wallet and the number of keys generated
+// Similar to Bitcoin's vanitygen
+func FindVanityWallet(prefix string, workers, maxAttempts int) (*Wallet, int, error) {
+	if prefix == "" {
+		return nil, 0, fmt.Errorf("prefix must not be empty")
+	}
+	for _, c := range []byte(prefix) {
+		if bytes.IndexByte(b58Alphabet, c) < 0 {
+			return nil, 0, fmt.Errorf("%q is not a base58 character", c)
+		}
+	}
+	if workers < 1 {
+		return nil, 0, fmt.Errorf("workers %d must be positive", workers)
+	}
+
+	var attempts int64
+	var found *Wallet
+	var once sync.Once
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if atomic.AddInt64(&attempts, 1) > int64(maxAttempts) {
+					return
+				}
+
+				wallet := NewWallet()
+				if strings.HasPrefix(string(wallet.GetAddress()), prefix) {
+					once.Do(func() {
+						found = wallet
+						close(done)
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tried := int(atomic.LoadInt64(&attempts))
+	if tried > maxAttempts {
+		tried = maxAttempts
+	}
+	if found == nil {
+		return nil, tried, fmt.Errorf("no address starting with %q after %d attempts", prefix, tried)
+	}
+
+	return found, tried, nil
+}
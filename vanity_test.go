@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindVanityWallet(t *testing.T) {
+	first := string(NewWallet().GetAddress()[:1])
+	for _, prefix := range []string{first, first + "A"} {
+		w, attempts, err := FindVanityWallet(prefix, 4, 100000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(w.GetAddress()), prefix) || attempts < 1 {
+			t.Fatalf("found %s after %d attempts, want a %q prefix", w.GetAddress(), attempts, prefix)
+		}
+		if prefix == first && attempts > 4 {
+			t.Fatalf("%d attempts for the prefix every address has, want one per worker at most", attempts)
+		}
+	}
+	if got, want := VanityAttempts(first+"A"), float64(len(b58Alphabet)); VanityAttempts(first) != 1 || got != want {
+		t.Fatalf("VanityAttempts = %v for 1 character and %v for 2, want 1 and %v", VanityAttempts(first), got, want)
+	}
+
+	tests := []struct {
+		name    string
+		prefix  string
+		workers int
+		wantErr string
+	}{
+		{"empty prefix", "", 1, "must not be empty"},
+		{"not base58", first + "0", 1, "is not a base58 character"},
+		{"no workers", first, 0, "must be positive"},
+		{"gives up", "z", 2, "no address starting with \"z\" after 50 attempts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _, err := FindVanityWallet(tt.prefix, tt.workers, 50)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || w != nil {
+				t.Fatalf("FindVanityWallet = %v, %v, want error %q", w, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"log"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
@@ -13,6 +17,12 @@ import (
 const version = byte(0x00)
 const addressChecksumLen = 4
 
+// privKeyVersion prefixes exported private keys, like Bitcoin's WIF
+const privKeyVersion = byte(0x80)
+
+// privKeyLen is the byte length of a P-256 private scalar
+const privKeyLen = 32
+
 // Wallet stores private and public keys
 // Similar to Geth's accounts.Account
 type Wallet struct {
@@ -31,15 +41,53 @@ func NewWallet() *Wallet {
 // GetAddress returns wallet address
 // Similar to Geth's crypto.PubkeyToAddress()
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return []byte(PubKeyHashToAddress(HashPubKey(w.PublicKey)))
+}
+
+// ExportPrivateKey returns the private key as base58check: version, 32-byte scalar, checksum
+// Similar to Bitcoin's WIF (dumpprivkey)
+func (w Wallet) ExportPrivateKey() string {
+	scalar := make([]byte, privKeyLen)
+	w.PrivateKey.D.FillBytes(scalar)
+
+	versionedPayload := append([]byte{privKeyVersion}, scalar...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return string(Base58Encode(fullPayload))
+}
+
+// ImportPrivateKey rebuilds a Wallet from a key written by ExportPrivateKey
+// Similar to Geth's crypto.ToECDSA()
+func ImportPrivateKey(encoded string) (*Wallet, error) {
+	for _, c := range []byte(encoded) {
+		if bytes.IndexByte(b58Alphabet, c) < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+	}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
-	checksum := checksum(versionedPayload)
+	payload := Base58Decode([]byte(encoded))
+	if len(payload) != 1+privKeyLen+addressChecksumLen {
+		return nil, errors.New("invalid private key length")
+	}
+	if payload[0] != privKeyVersion {
+		return nil, fmt.Errorf("unknown private key version %#x", payload[0])
+	}
+	versionedPayload := payload[:len(payload)-addressChecksumLen]
+	if !bytesEqual(payload[len(payload)-addressChecksumLen:], checksum(versionedPayload)) {
+		return nil, errors.New("private key checksum mismatch")
+	}
 
-	fullPayload := append(versionedPayload, checksum...)
-	address := Base58Encode(fullPayload)
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(versionedPayload[1:])
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+
+	private := ecdsa.PrivateKey{D: d}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(versionedPayload[1:])
 
-	return address
+	return &Wallet{private, encodePubKey(private.PublicKey)}, nil
 }
 
 // HashPubKey hashes public key
@@ -56,15 +104,54 @@ func HashPubKey(pubKey []byte) []byte {
 	return publicRIPEMD160
 }
 
+// AddressToPubKeyHash returns the hash that outputs paying to address are locked with
+// For multisig addresses this is the hash of the script
+func AddressToPubKeyHash(address string) []byte {
+	if IsMultisigAddress(address) {
+		script, err := ParseMultisigAddress(address)
+		if err != nil {
+			log.Panic(err)
+		}
+		return script.Hash()
+	}
+
+	pubKeyHash := Base58Decode([]byte(address))
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+}
+
+// PubKeyHashToAddress returns the address on the active network paying to pubKeyHash
+func PubKeyHashToAddress(pubKeyHash []byte) string {
+	versionedPayload := append([]byte{activeNetwork.AddressVersion}, pubKeyHash...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return string(Base58Encode(fullPayload))
+}
+
 // ValidateAddress check if address is valid
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	return CheckAddress(address) == nil
+}
 
-	return bytesEqual(actualChecksum, targetChecksum)
+// CheckAddress reports why address isn't valid on the active network, or nil if it is
+// The checksum must match and the version byte must be one of the network's
+func CheckAddress(address string) error {
+	payload := Base58Decode([]byte(address))
+	if len(payload) < 1+addressChecksumLen {
+		return errors.New("address is too short")
+	}
+	actualChecksum := payload[len(payload)-addressChecksumLen:]
+	version := payload[0]
+	if !bytesEqual(actualChecksum, checksum(payload[:len(payload)-addressChecksumLen])) {
+		return errors.New("address checksum mismatch")
+	}
+
+	if version == activeNetwork.AddressVersion || version == activeNetwork.MultisigVersion || version == activeNetwork.ScriptHashVersion {
+		return nil
+	}
+	if network := networkForVersion(version); network != nil {
+		return fmt.Errorf("address is for %s, not %s", network.Name, activeNetwork.Name)
+	}
+	return fmt.Errorf("unknown address version %#02x", version)
 }
 
 // checksum generates a checksum for a payload
@@ -82,9 +169,18 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
 
-	return *private, pubKey
+	return *private, encodePubKey(private.PublicKey)
+}
+
+// encodePubKey returns the X || Y encoding of a public key
+// Both coordinates are padded, so the key always splits evenly into X and Y
+func encodePubKey(pub ecdsa.PublicKey) []byte {
+	pubKey := make([]byte, pubKeyLen)
+	pub.X.FillBytes(pubKey[:sigScalarLen])
+	pub.Y.FillBytes(pubKey[sigScalarLen:])
+
+	return pubKey
 }
 
 // bytesEqual compares two byte slices
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// encodePrivKeyPayload base58check-encodes version and scalar as ExportPrivateKey does
+func encodePrivKeyPayload(version byte, scalar []byte) string {
+	versionedPayload := append([]byte{version}, scalar...)
+
+	return string(Base58Encode(append(versionedPayload, checksum(versionedPayload)...)))
+}
+
+func TestImportExportedPrivateKey(t *testing.T) {
+	w := NewWallet()
+	exported := w.ExportPrivateKey()
+
+	imported, err := ImportPrivateKey(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.PrivateKey.D.Cmp(w.PrivateKey.D) != 0 || !bytes.Equal(imported.PublicKey, w.PublicKey) ||
+		!bytes.Equal(imported.GetAddress(), w.GetAddress()) {
+		t.Fatal("imported key differs from the exported one")
+	}
+
+	// The imported key signs for the original's coins
+	tx, prevTXs := signedSpend(t, imported, string(NewWallet().GetAddress()), 10, defaultChainID)
+	if err := tx.Verify(prevTXs, defaultChainID); err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+}
+
+func TestImportCorruptedPrivateKey(t *testing.T) {
+	exported := NewWallet().ExportPrivateKey()
+	scalar := make([]byte, privKeyLen)
+	NewWallet().PrivateKey.D.FillBytes(scalar)
+
+	// Changing one character of the key keeps it base58
+	last := exported[len(exported)-1]
+	changed := byte('2')
+	if last == changed {
+		changed = '3'
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr string
+	}{
+		{"changed character", exported[:len(exported)-1] + string(changed), "checksum mismatch"},
+		{"truncated", exported[:len(exported)-4], "invalid private key length"},
+		{"not base58", "0" + exported[1:], "invalid base58 character"},
+		{"address version", encodePrivKeyPayload(version, scalar), "unknown private key version"},
+		{"zero scalar", encodePrivKeyPayload(privKeyVersion, make([]byte, privKeyLen)), "out of range"},
+		{"empty", "", "invalid private key length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ImportPrivateKey(tt.key)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || w != nil {
+				t.Fatalf("ImportPrivateKey = %v, %v, want error %q", w, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	w := NewWallet()
+	address := string(w.GetAddress())
+	pubKeyHash := HashPubKey(w.PublicKey)
+	changed := byte('2')
+	if address[len(address)-1] == changed {
+		changed = '3'
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		wantErr string
+	}{
+		{"valid", address, ""},
+		{"wrong version", encodePrivKeyPayload(0x42, pubKeyHash), "unknown address version 0x42"},
+		{"other network", encodePrivKeyPayload(networks["testnet"].AddressVersion, pubKeyHash), "address is for testnet, not mainnet"},
+		{"changed character", address[:len(address)-1] + string(changed), "address checksum mismatch"},
+		{"too short", "1", "address is too short"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckAddress(tt.address)
+			if tt.wantErr == "" {
+				if err != nil || !ValidateAddress(tt.address) {
+					t.Fatalf("CheckAddress = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr || ValidateAddress(tt.address) {
+				t.Fatalf("CheckAddress = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	fields := outputFields(captureOutput(t, func() { (&CLI{}).decodeAddress(encodePrivKeyPayload(0x42, pubKeyHash)) }))
+	want := map[string]string{
+		"Version":    "0x42 (unknown network)",
+		"PubKeyHash": hex.EncodeToString(pubKeyHash),
+		"Valid":      "no (unknown address version 0x42)",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("decodeaddress %s: %q, want %q", name, fields[name], value)
+		}
+	}
+}
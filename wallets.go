@@ -17,13 +17,31 @@ const walletFile = "wallets_%s.dat"
 // Wallets stores a collection of wallets
 // Similar to Geth's accounts.Manager
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets   map[string]*Wallet
+	Labels    map[string]string // Optional human-readable names, keyed by address
+	WatchOnly map[string]bool   // Addresses tracked without a private key
+	Scripts   map[string][]byte // Serialized redeem scripts of script hash addresses, keyed by address
+	Inactive  map[string]bool   // Addresses no longer used for change, e.g. after their key leaked
+}
+
+// walletsFileData is the on-disk layout of the wallet file
+// Files written before labels existed contain only the Keys map
+type walletsFileData struct {
+	Keys      map[string][]byte
+	Labels    map[string]string
+	WatchOnly []string
+	Scripts   map[string][]byte
+	Inactive  []string
 }
 
 // NewWallets creates Wallets and fills it from a file if it exists
 func NewWallets(nodeID string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Labels = make(map[string]string)
+	wallets.WatchOnly = make(map[string]bool)
+	wallets.Scripts = make(map[string][]byte)
+	wallets.Inactive = make(map[string]bool)
 
 	err := wallets.LoadFromFile(nodeID)
 
@@ -40,6 +58,17 @@ func (ws *Wallets) CreateWallet() string {
 	return address
 }
 
+// AddWallet stores an existing Wallet and returns its address
+// A watch-only entry for the address is replaced by the wallet
+func (ws *Wallets) AddWallet(wallet *Wallet) string {
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+
+	ws.Wallets[address] = wallet
+	delete(ws.WatchOnly, address)
+
+	return address
+}
+
 // GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []string {
 	var addresses []string
@@ -51,14 +80,140 @@ func (ws *Wallets) GetAddresses() []string {
 	return addresses
 }
 
+// AddWatchOnly tracks an address whose private key isn't in the wallet
+// Its balance and history can be queried, but it can't be spent from
+// Similar to Bitcoin Core's importaddress
+func (ws *Wallets) AddWatchOnly(address string) error {
+	if err := CheckAddress(address); err != nil {
+		return err
+	}
+	if _, ok := ws.Wallets[address]; ok {
+		return fmt.Errorf("Address %s is already in the wallet file with its key", address)
+	}
+
+	ws.WatchOnly[address] = true
+	return nil
+}
+
+// IsWatchOnly reports whether address is tracked without a private key
+func (ws Wallets) IsWatchOnly(address string) bool {
+	return ws.WatchOnly[address]
+}
+
+// GetWatchOnlyAddresses returns the addresses tracked without a private key
+func (ws *Wallets) GetWatchOnlyAddresses() []string {
+	var addresses []string
+
+	for address := range ws.WatchOnly {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// AddScript stores the redeem script of a script hash address and returns the address
+// Funds sent to the address can then be spent with the keys of the script
+func (ws *Wallets) AddScript(script *MultisigScript) string {
+	address := script.ScriptHashAddress()
+	ws.Scripts[address] = script.Serialize()
+
+	return address
+}
+
+// GetScript returns the redeem script of a script hash address, if it is stored in Wallets
+func (ws Wallets) GetScript(address string) (*MultisigScript, bool) {
+	data, ok := ws.Scripts[address]
+	if !ok {
+		return nil, false
+	}
+	script, err := DeserializeMultisigScript(data)
+	if err != nil {
+		return nil, false
+	}
+
+	return script, true
+}
+
+// GetScriptAddresses returns the script hash addresses whose redeem scripts are stored
+func (ws *Wallets) GetScriptAddresses() []string {
+	var addresses []string
+
+	for address := range ws.Scripts {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// SetInactive marks an address of the wallet file inactive, or active again
+// An inactive address keeps its funds, history and balance, but change is no longer sent to it
+func (ws *Wallets) SetInactive(address string, inactive bool) error {
+	if _, ok := ws.Wallets[address]; !ok && !ws.WatchOnly[address] && ws.Scripts[address] == nil {
+		return fmt.Errorf("Address %s is not in the wallet file", address)
+	}
+
+	if inactive {
+		ws.Inactive[address] = true
+	} else {
+		delete(ws.Inactive, address)
+	}
+
+	return nil
+}
+
+// IsInactive reports whether address has been marked inactive
+func (ws Wallets) IsInactive(address string) bool {
+	return ws.Inactive[address]
+}
+
+// GetInactiveAddresses returns the addresses marked inactive
+func (ws *Wallets) GetInactiveAddresses() []string {
+	var addresses []string
+
+	for address := range ws.Inactive {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
 // GetWallet returns a Wallet by its address
 func (ws Wallets) GetWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
+// FindWalletByPubKey returns the wallet owning pubKey, if it is stored in Wallets
+func (ws Wallets) FindWalletByPubKey(pubKey []byte) (*Wallet, bool) {
+	address := fmt.Sprintf("%s", Wallet{PublicKey: pubKey}.GetAddress())
+	wallet, ok := ws.Wallets[address]
+
+	return wallet, ok
+}
+
+// SetLabel attaches a label to a wallet address, replacing any previous one
+// An empty label removes it
+func (ws *Wallets) SetLabel(address, label string) error {
+	if _, ok := ws.Wallets[address]; !ok && !ws.WatchOnly[address] && ws.Scripts[address] == nil {
+		return fmt.Errorf("Address %s is not in the wallet file", address)
+	}
+
+	if label == "" {
+		delete(ws.Labels, address)
+	} else {
+		ws.Labels[address] = label
+	}
+
+	return nil
+}
+
+// GetLabel returns the label of an address, or an empty string if it has none
+func (ws Wallets) GetLabel(address string) string {
+	return ws.Labels[address]
+}
+
 // LoadFromFile loads wallets from the file
 func (ws *Wallets) LoadFromFile(nodeID string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := dataFilePath(walletFile, nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -68,11 +223,30 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		log.Panic(err)
 	}
 
-	var walletsData map[string][]byte
+	var fileData walletsFileData
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&walletsData)
+	err = decoder.Decode(&fileData)
 	if err != nil {
-		log.Panic(err)
+		// Fall back to the legacy layout: a bare map of serialized keys without labels
+		decoder = gob.NewDecoder(bytes.NewReader(fileContent))
+		err = decoder.Decode(&fileData.Keys)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	walletsData := fileData.Keys
+
+	for address, label := range fileData.Labels {
+		ws.Labels[address] = label
+	}
+	for _, address := range fileData.WatchOnly {
+		ws.WatchOnly[address] = true
+	}
+	for address, script := range fileData.Scripts {
+		ws.Scripts[address] = script
+	}
+	for _, address := range fileData.Inactive {
+		ws.Inactive[address] = true
 	}
 
 	// Reconstruct wallets from serialized data
@@ -81,9 +255,9 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		curve := elliptic.P256()
 		privKey := new(ecdsa.PrivateKey)
 		privKey.PublicKey.Curve = curve
-		privKey.D = new(big.Int).SetBytes(data[:32])
+		privKey.D = new(big.Int).SetBytes(data[:privKeyLen])
 
-		pubKey := data[32:]
+		pubKey := data[privKeyLen:]
 		privKey.PublicKey.X = new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
 		privKey.PublicKey.Y = new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
 
@@ -94,28 +268,31 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 	return nil
 }
 
-// SaveToFile saves wallets to a file
+// SaveToFile saves wallets to a file readable only by its owner, replacing the mode of an existing file
 func (ws Wallets) SaveToFile(nodeID string) {
 	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := dataFilePath(walletFile, nodeID)
 
 	// Create a map to store serializable wallet data
 	walletsData := make(map[string][]byte)
 
 	for address, wallet := range ws.Wallets {
 		// Serialize wallet as: privateKey bytes + publicKey bytes
-		privKeyBytes := wallet.PrivateKey.D.Bytes()
+		// The key is padded to 32 bytes, since loading splits the data at a fixed offset
+		privKeyBytes := make([]byte, privKeyLen)
+		wallet.PrivateKey.D.FillBytes(privKeyBytes)
 		data := append(privKeyBytes, wallet.PublicKey...)
 		walletsData[address] = data
 	}
 
 	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(walletsData)
+	err := encoder.Encode(walletsFileData{walletsData, ws.Labels, ws.GetWatchOnlyAddresses(), ws.Scripts, ws.GetInactiveAddresses()})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	// The file holds private keys, so only the owner may read it
+	err = writeFileAtomic(walletFile, content.Bytes(), 0600)
 	if err != nil {
 		log.Panic(err)
 	}
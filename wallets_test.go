@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// useDataDir points the node's files at a temporary directory for the test
+func useDataDir(t *testing.T) {
+	t.Helper()
+
+	saved := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = saved })
+}
+
+func TestSaveToFileIsPrivate(t *testing.T) {
+	useDataDir(t)
+	path := dataFilePath(walletFile, "3000")
+
+	// A wallet file saved by an older version stays readable by others until it's saved again
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wallets := Wallets{Wallets: make(map[string]*Wallet)}
+	wallets.CreateWallet()
+	wallets.SaveToFile("3000")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("wallet file mode %o, want 600", perm)
+	}
+}
+
+func TestChangeKeyIsNotSavedWithThePayment(t *testing.T) {
+	useDataDir(t)
+	bc, w := newTestChain(t)
+	from := string(w.GetAddress())
+
+	for _, changeTo := range []string{newChangeAddress, ""} {
+		wallets := Wallets{Wallets: map[string]*Wallet{from: w}, Inactive: make(map[string]bool)}
+		if changeTo == "" {
+			// The change of an inactive address goes to a new key too
+			wallets.Inactive[from] = true
+		}
+
+		tx, err := NewUTXOTransaction(from, string(NewWallet().GetAddress()), 1, 1, SendOptions{ChangeTo: changeTo}, bc, &wallets)
+		if err != nil {
+			t.Fatalf("NewUTXOTransaction with change to %q: %s", changeTo, err)
+		}
+
+		added := newAddresses(&wallets, []string{from})
+		if len(added) != 1 || !bytes.Equal(tx.Vout[1].PubKeyHash, AddressToPubKeyHash(added[0])) {
+			t.Fatalf("change to %q went to %x, want the one new key of %v", changeTo, tx.Vout[1].PubKeyHash, added)
+		}
+		if _, err := os.Stat(dataFilePath(walletFile, os.Getenv("NODE_ID"))); !os.IsNotExist(err) {
+			t.Fatalf("wallet file written while building a payment with change to %q", changeTo)
+		}
+	}
+}
+
+func TestWatchOnlyAddress(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	bob, carol := string(NewWallet().GetAddress()), NewWallet()
+	if err := bc.AddBlock(peerBlock(t, bc, NewCoinbaseTX(bob, "", 0, 2))); err != nil {
+		t.Fatal(err)
+	}
+	bc.db.Close()
+
+	if out := captureOutput(t, func() { (&CLI{}).importAddress(bob, "", "3000") }); !strings.Contains(out, "Watching address "+bob) {
+		t.Fatalf("importaddress printed %q", out)
+	}
+	carolHash := hex.EncodeToString(HashPubKey(carol.PublicKey))
+	if out, ok := runCLI(t, "importaddress", "-pubkeyhash", carolHash); !ok || !strings.Contains(out, string(carol.GetAddress())) {
+		t.Fatalf("importaddress -pubkeyhash succeeded %v with output %q", ok, out)
+	}
+	if out, ok := runCLI(t, "importaddress", "-address", string(w.GetAddress())); ok || !strings.Contains(out, "already in the wallet file") {
+		t.Fatalf("importing an address with its key succeeded %v with output %q", ok, out)
+	}
+
+	wallets, err := NewWallets("3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wallets.IsWatchOnly(bob) || !wallets.IsWatchOnly(string(carol.GetAddress())) || wallets.IsWatchOnly(string(w.GetAddress())) {
+		t.Fatalf("watch-only addresses %v, want %s and %s", wallets.GetWatchOnlyAddresses(), bob, carol.GetAddress())
+	}
+
+	if out, ok := runCLI(t, "getbalance", "-address", bob); !ok || !strings.Contains(out, fmt.Sprintf("Balance of '%s': %d", bob, subsidy)) {
+		t.Fatalf("getbalance succeeded %v with output %q", ok, out)
+	}
+	if out, ok := runCLI(t, "send", "-from", bob, "-to", string(w.GetAddress()), "-amount", "1"); ok || !strings.Contains(out, "is watch-only") {
+		t.Fatalf("spending from a watch-only address succeeded %v with output %q", ok, out)
+	}
+}
+
+func TestInactiveAddress(t *testing.T) {
+	bc, w := newDiskTestChain(t, "3000")
+	alice, bob := string(w.GetAddress()), string(NewWallet().GetAddress())
+	bc.db.Close()
+
+	if out, ok := runCLI(t, "deactivateaddress", "-address", alice); !ok || !strings.Contains(out, alice+" is now inactive") {
+		t.Fatalf("deactivateaddress succeeded %v with output %q", ok, out)
+	}
+	if out, ok := runCLI(t, "send", "-from", alice, "-to", bob, "-amount", "3", "-fee", "1", "-changeaddr", alice); ok || !strings.Contains(out, "is inactive") {
+		t.Fatalf("sending change to an inactive address succeeded %v with output %q", ok, out)
+	}
+	if out, ok := runCLI(t, "send", "-from", alice, "-to", bob, "-amount", "3", "-fee", "1"); !ok {
+		t.Fatalf("send failed with output %q", out)
+	}
+
+	// The change went to a new key instead, and alice's confirmed coins still count in her balance
+	wallets, err := NewWallets("3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := newAddresses(wallets, []string{alice})
+	if len(added) != 1 {
+		t.Fatalf("wallet addresses %v, want alice's and one for the change", wallets.GetAddresses())
+	}
+	bc = OpenBlockchainReadOnly("3000")
+	pool := bc.GetMempool()
+	bc.db.Close()
+	if len(pool) != 1 || len(pool[0].Vout) != 2 || !pool[0].Vout[1].IsLockedWithKey(AddressToPubKeyHash(added[0])) {
+		t.Fatalf("mempool %v, want a payment with its change to %s", pool, added[0])
+	}
+	if out, ok := runCLI(t, "getbalance", "-address", alice); !ok || !strings.Contains(out, fmt.Sprintf("Balance of '%s': %d", alice, subsidy)) {
+		t.Fatalf("getbalance succeeded %v with output %q", ok, out)
+	}
+
+	listed := captureOutput(t, func() { (&CLI{}).listAddresses(false, "3000") })
+	if !strings.Contains(listed, alice+"\t(inactive)") || !strings.Contains(listed, added[0]) {
+		t.Fatalf("listaddresses printed %q, want alice marked inactive and the change address", listed)
+	}
+	if active := captureOutput(t, func() { (&CLI{}).listAddresses(true, "3000") }); strings.Contains(active, alice) || !strings.Contains(active, added[0]) {
+		t.Fatalf("listaddresses -active printed %q, want only the change address", active)
+	}
+
+	if out, ok := runCLI(t, "activateaddress", "-address", alice); !ok || !strings.Contains(out, alice+" is active again") {
+		t.Fatalf("activateaddress succeeded %v with output %q", ok, out)
+	}
+	if wallets, _ := NewWallets("3000"); wallets.IsInactive(alice) {
+		t.Fatal("alice still inactive")
+	}
+}
+
+func TestGetNewAddressesArePersisted(t *testing.T) {
+	useDataDir(t)
+
+	var printed []string
+	for i := 0; i < 3; i++ {
+		out, ok := runCLI(t, "getnewaddress", "-label", fmt.Sprintf("key %d", i))
+		address := strings.TrimSpace(out)
+		if !ok || strings.Contains(address, "\n") || !ValidateAddress(address) {
+			t.Fatalf("getnewaddress succeeded %v with output %q, want only an address", ok, out)
+		}
+		printed = append(printed, address)
+	}
+
+	wallets, err := NewWallets("3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wallets.GetAddresses()) != len(printed) {
+		t.Fatalf("wallet file holds %d address(es), want %d", len(wallets.GetAddresses()), len(printed))
+	}
+	for i, address := range printed {
+		if w, ok := wallets.Wallets[address]; !ok || string(w.GetAddress()) != address {
+			t.Errorf("address %s not saved with its key", address)
+		}
+		if label := wallets.GetLabel(address); label != fmt.Sprintf("key %d", i) {
+			t.Errorf("address %s has label %q, want %q", address, label, fmt.Sprintf("key %d", i))
+		}
+	}
+}